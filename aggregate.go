@@ -0,0 +1,424 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// aggFunc is an aggregate function.
+type aggFunc struct {
+	minArgs, maxArgs int
+	star             bool // whether the function may be called with *
+
+	// new returns an aggregator computing the function, comparing
+	// TEXT values with the collation coll of its first argument.
+	new func(coll collation) aggregator
+}
+
+// aggregator computes the value of an aggregate function over the rows
+// of a group.
+type aggregator interface {
+	step(args []interface{}) error
+	value() (interface{}, error)
+}
+
+// aggFuncs holds the aggregate functions, by lower case name.
+var aggFuncs = map[string]*aggFunc{
+	"count": {maxArgs: 1, star: true, new: func(collation) aggregator { return new(count) }},
+	"sum":   {minArgs: 1, maxArgs: 1, new: func(collation) aggregator { return &sum{kind: "sum"} }},
+	"total": {minArgs: 1, maxArgs: 1, new: func(collation) aggregator { return &sum{kind: "total"} }},
+	"avg":   {minArgs: 1, maxArgs: 1, new: func(collation) aggregator { return &sum{kind: "avg"} }},
+	"min": {minArgs: 1, maxArgs: 1, new: func(coll collation) aggregator {
+		return &minMax{coll: coll}
+	}},
+	"max": {minArgs: 1, maxArgs: 1, new: func(coll collation) aggregator {
+		return &minMax{max: true, coll: coll}
+	}},
+	"group_concat": {minArgs: 1, maxArgs: 2, new: func(collation) aggregator { return new(groupConcat) }},
+}
+
+// check checks the arguments of the call x of the function.
+func (fn *aggFunc) check(x *funcExpr) error {
+	n := len(x.args)
+	if x.star && !fn.star || n < fn.minArgs || n > fn.maxArgs {
+		return fmt.Errorf("sqlite3: wrong number of arguments to function %s()", x.name)
+	}
+	if x.distinct && n != 1 {
+		return errors.New("sqlite3: DISTINCT aggregates must have exactly one argument")
+	}
+	return nil
+}
+
+// newAggregator returns an aggregator computing the aggregate function
+// call x.
+func newAggregator(x *funcExpr) aggregator {
+	agg := x.agg.new(x.coll)
+	if _, ok := agg.(*minMax); x.distinct && !ok {
+		agg = &distinct{aggregator: agg, seen: rowSet{colls: []collation{x.coll}}}
+	}
+	return agg
+}
+
+// count is the count aggregate function: the number of rows, or of non
+// NULL values.
+type count int64
+
+func (c *count) step(args []interface{}) error {
+	if len(args) == 0 || args[0] != nil {
+		*c++
+	}
+	return nil
+}
+
+func (c *count) value() (interface{}, error) {
+	return int64(*c), nil
+}
+
+// sum is the sum, total or avg aggregate function. Like SQLite, sum adds
+// integers exactly until a REAL value, or an overflow, switches it to a
+// compensated floating point sum.
+type sum struct {
+	kind     string // "sum", "total" or "avg"
+	n        int64  // number of non-NULL values
+	i        int64  // sum of the integers, until approx is set
+	r, err   float64
+	approx   bool // whether the sum is the floating point one
+	overflow bool // whether the integer sum overflowed
+}
+
+func (s *sum) step(args []interface{}) error {
+	v := args[0]
+	if v == nil {
+		return nil
+	}
+	s.n++
+	switch w := v.(type) {
+	case string:
+		if n, ok := parseNumeric(w); ok {
+			v = n
+		}
+	}
+	i, isInt := v.(int64)
+	switch {
+	case s.approx && isInt:
+		s.addInt(i)
+	case s.approx:
+		s.add(toFloat(v))
+	case !isInt:
+		s.init()
+		s.add(toFloat(v))
+	default:
+		x := s.i + i
+		if (x > s.i) == (i > 0) {
+			s.i = x
+			break
+		}
+		s.overflow = true
+		s.init()
+		s.addInt(i)
+	}
+	return nil
+}
+
+// init switches the sum to floating point.
+func (s *sum) init() {
+	s.approx = true
+	s.r, s.err = 0, 0
+	s.addInt(s.i)
+}
+
+// add adds r to the floating point sum, with the Kahan-Babuska-Neumaier
+// algorithm.
+func (s *sum) add(r float64) {
+	t := s.r + r
+	if math.Abs(s.r) > math.Abs(r) {
+		s.err += (s.r - t) + r
+	} else {
+		s.err += (r - t) + s.r
+	}
+	s.r = t
+}
+
+// addInt adds i to the floating point sum, in two parts when i cannot be
+// represented exactly as a float64.
+func (s *sum) addInt(i int64) {
+	const exact = 1 << 52
+	if i <= -exact || i >= exact {
+		sm := i % 16384
+		s.add(float64(i - sm))
+		s.add(float64(sm))
+		return
+	}
+	s.add(float64(i))
+}
+
+// float returns the sum as a float.
+func (s *sum) float() float64 {
+	if !s.approx {
+		return float64(s.i)
+	}
+	if math.IsInf(s.err, 0) || math.IsNaN(s.err) {
+		return s.r
+	}
+	return s.r + s.err
+}
+
+func (s *sum) value() (interface{}, error) {
+	switch {
+	case s.kind == "total":
+		return s.float(), nil
+	case s.n == 0:
+		return nil, nil
+	case s.kind == "avg":
+		return s.float() / float64(s.n), nil
+	case s.overflow:
+		return nil, errors.New("sqlite3: integer overflow")
+	case s.approx:
+		return s.float(), nil
+	}
+	return s.i, nil
+}
+
+// minMax is the min or max aggregate function.
+type minMax struct {
+	max  bool
+	coll collation
+	v    interface{}
+	skip bool // whether the last step kept v
+}
+
+func (m *minMax) step(args []interface{}) error {
+	v := args[0]
+	m.skip = m.v != nil
+	if v == nil || m.skip && m.kept(v) {
+		return nil
+	}
+	m.v, m.skip = v, false
+	return nil
+}
+
+// kept reports whether v does not replace the current value: ties keep
+// the first value.
+func (m *minMax) kept(v interface{}) bool {
+	c := compareCollated(v, m.v, m.coll)
+	if m.max {
+		return c <= 0
+	}
+	return c >= 0
+}
+
+func (m *minMax) value() (interface{}, error) {
+	return m.v, nil
+}
+
+// groupConcat is the group_concat aggregate function: the concatenation
+// of the non NULL values, separated by commas or by the values of the
+// second argument.
+type groupConcat struct {
+	b     strings.Builder
+	found bool
+}
+
+func (g *groupConcat) step(args []interface{}) error {
+	if args[0] == nil {
+		return nil
+	}
+	if g.found {
+		sep := ","
+		if len(args) > 1 {
+			sep = ""
+			if args[1] != nil {
+				sep = textValue(args[1])
+			}
+		}
+		g.b.WriteString(sep)
+	}
+	g.b.WriteString(textValue(args[0]))
+	g.found = true
+	return nil
+}
+
+func (g *groupConcat) value() (interface{}, error) {
+	if !g.found {
+		return nil, nil
+	}
+	return g.b.String(), nil
+}
+
+// distinct computes an aggregate function over the distinct values of
+// its argument.
+type distinct struct {
+	aggregator
+	seen rowSet
+}
+
+func (d *distinct) step(args []interface{}) error {
+	if args[0] != nil && !d.seen.add(args) {
+		return nil
+	}
+	return d.aggregator.step(args)
+}
+
+// group is a group of rows of an aggregate query.
+type group struct {
+	keys []interface{} // values of the GROUP BY terms
+	aggs []aggregator
+
+	// row holds the values of the columns used outside of aggregate
+	// functions. Like SQLite, those are the values of the first row of
+	// the group or, if the query uses min or max, of the last row not
+	// skipped by the last of these functions.
+	row []interface{}
+}
+
+// grouping collects the groups of rows of an aggregate query, ordered
+// by their keys.
+type grouping struct {
+	plan   *selectPlan
+	groups []*group
+}
+
+// add adds the row of e to its group.
+func (g *grouping) add(e *env) error {
+	plan := g.plan
+	keys, err := evalAll(e, plan.groupBy)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(g.groups), func(i int) bool {
+		return compareRows(g.groups[i].keys, keys, plan.groupColls) >= 0
+	})
+	if i == len(g.groups) || compareRows(g.groups[i].keys, keys, plan.groupColls) != 0 {
+		g.groups = append(g.groups, nil)
+		copy(g.groups[i+1:], g.groups[i:])
+		g.groups[i] = g.newGroup(keys, append([]interface{}(nil), e.row...))
+	}
+
+	grp := g.groups[i]
+	load := false
+	for j, x := range plan.aggs {
+		args, err := evalAll(e, x.args)
+		if err != nil {
+			return err
+		}
+		if err := grp.aggs[j].step(args); err != nil {
+			return err
+		}
+		if m, ok := grp.aggs[j].(*minMax); ok {
+			load = !m.skip
+		}
+	}
+	if load {
+		copy(grp.row, e.row)
+	}
+	return nil
+}
+
+// newGroup returns a new group with the given keys and row.
+func (g *grouping) newGroup(keys, row []interface{}) *group {
+	grp := &group{keys: keys, row: row}
+	for _, x := range g.plan.aggs {
+		grp.aggs = append(grp.aggs, newAggregator(x))
+	}
+	return grp
+}
+
+// each calls f for each group, in order, after storing its row and the
+// values of its aggregate functions in e. Without a GROUP BY clause, the
+// rows form a single group, even if there are none.
+func (g *grouping) each(e *env, f func() error) error {
+	if len(g.groups) == 0 && len(g.plan.groupBy) == 0 {
+		g.groups = append(g.groups, g.newGroup(nil, make([]interface{}, len(e.row))))
+	}
+	e.aggs = make([]interface{}, len(g.plan.aggs))
+	for _, grp := range g.groups {
+		for i, agg := range grp.aggs {
+			v, err := agg.value()
+			if err != nil {
+				return err
+			}
+			e.aggs[i] = v
+		}
+		e.row = grp.row
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowSet is a set of rows of values, compared with a collation per
+// column.
+type rowSet struct {
+	colls []collation
+	rows  [][]interface{} // sorted rows
+}
+
+// add adds the row vals to the set, and reports whether it was not
+// already in the set.
+func (s *rowSet) add(vals []interface{}) bool {
+	i := sort.Search(len(s.rows), func(i int) bool {
+		return compareRows(s.rows[i], vals, s.colls) >= 0
+	})
+	if i < len(s.rows) && compareRows(s.rows[i], vals, s.colls) == 0 {
+		return false
+	}
+	s.rows = append(s.rows, nil)
+	copy(s.rows[i+1:], s.rows[i:])
+	s.rows[i] = vals
+	return true
+}
+
+// put adds the row vals to the set, replacing the equal row if it was
+// already in the set.
+func (s *rowSet) put(vals []interface{}) {
+	i := sort.Search(len(s.rows), func(i int) bool {
+		return compareRows(s.rows[i], vals, s.colls) >= 0
+	})
+	if i < len(s.rows) && compareRows(s.rows[i], vals, s.colls) == 0 {
+		s.rows[i] = vals
+		return
+	}
+	s.rows = append(s.rows, nil)
+	copy(s.rows[i+1:], s.rows[i:])
+	s.rows[i] = vals
+}
+
+// has reports whether the row vals is in the set.
+func (s *rowSet) has(vals []interface{}) bool {
+	i := sort.Search(len(s.rows), func(i int) bool {
+		return compareRows(s.rows[i], vals, s.colls) >= 0
+	})
+	return i < len(s.rows) && compareRows(s.rows[i], vals, s.colls) == 0
+}
+
+// compareRows compares the rows of values a and b, column by column,
+// with the collations colls.
+func compareRows(a, b []interface{}, colls []collation) int {
+	for i := range a {
+		if c := compareCollated(a[i], b[i], colls[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// firstAggregate returns the first call of an aggregate function of the
+// expression x, if any.
+func firstAggregate(x expr) *funcExpr {
+	var found *funcExpr
+	walkExpr(x, func(x expr) bool {
+		if f, ok := x.(*funcExpr); ok && f.agg != nil {
+			found = f
+		}
+		return found == nil
+	})
+	return found
+}
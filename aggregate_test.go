@@ -0,0 +1,162 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import "testing"
+
+func TestQueryAggregate(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select count(*), count(note), count(city), count() from people`,
+			want: `300|270|250|300
+`,
+		},
+		{
+			query: `select sum(age), total(age), avg(age), min(age), max(age) from people`,
+			want: `12363|12363.0|43.6855123674912|0|89
+`,
+		},
+		{
+			query: `select sum(note), total(note), avg(note), min(note) from people`,
+			want: `1875.0|1875.0|6.94444444444444|-3
+`,
+		},
+		{
+			query: `select city, count(*), sum(age), min(name), max(name) from people group by city`,
+			want: `NULL|50|2281|Bob1|Judy8
+Berlin|50|2050|alice11|ivan9
+Lyon|50|1973|Bob0|Judy7
+Paris|50|1998|alice12|ivan7
+Tokyo|50|2114|alice1|ivan8
+paris|50|1947|Bob11|Judy9
+`,
+		},
+		{
+			query: `select city c, count(*) n from people group by c having n > 40 and city is not null order by n desc, c`,
+			want: `Berlin|50
+Lyon|50
+Paris|50
+Tokyo|50
+paris|50
+`,
+		},
+		{
+			query: `select city collate nocase, count(*) from people group by 1`,
+			want: `NULL|50
+Berlin|50
+Lyon|50
+paris|100
+Tokyo|50
+`,
+		},
+		{
+			query: `select count(distinct city), count(distinct city collate nocase), count(distinct active) from people`,
+			want: `5|4|2
+`,
+		},
+		{
+			query: `select group_concat(id), group_concat(name, ' ') from people where id < 5`,
+			want: `1,2,3,4|Bob0 carol0 Dave0 eve0
+`,
+		},
+		{
+			query: `select active, group_concat(distinct city) from people group by active`,
+			want: `0|Tokyo,paris,Berlin,Lyon,Paris
+1|Lyon,Berlin,paris,Tokyo,Paris
+`,
+		},
+		{
+			query: `select distinct city from people`,
+			want: `Lyon
+Berlin
+paris
+Tokyo
+NULL
+Paris
+`,
+		},
+		{
+			query: `select distinct active, city from people order by 2 desc, 1 limit 4`,
+			want: `0|paris
+1|paris
+0|Tokyo
+1|Tokyo
+`,
+		},
+		{
+			query: `select count(*), name, id from people where id > 5`,
+			want: `295|grace0|6
+`,
+		},
+		{
+			query: `select min(age), max(age), id from people group by city`,
+			want: `5|89|77
+2|86|38
+1|85|25
+0|84|12
+4|88|64
+3|87|141
+`,
+		},
+		{
+			query: `select sum(age), total(age), avg(age), group_concat(age), min(age), count(*), name from people where 0`,
+			want: `NULL|0.0|NULL|NULL|NULL|0|NULL
+`,
+		},
+		{
+			query: `select count(*) from people where 0 group by city`,
+			want:  "",
+		},
+		{
+			query: `select count(*)`,
+			want: `1
+`,
+		},
+		{
+			query: `select age / 10 as decade, count(*) from people group by decade having decade > 6`,
+			want: `7|32
+8|29
+`,
+		},
+		{
+			query: `select count(*) from people having count(*) > 1000`,
+			want:  "",
+		},
+		{
+			query: `select sum('3'), sum('3.0'), sum('abc'), total('1e2') from people where id = 1`,
+			want: `3|3.0|0.0|100.0
+`,
+		},
+		{
+			query: `select sum(9223372036854775807), total(9223372036854775807), avg(9223372036854775807) from people where id < 2`,
+			want: `9223372036854775807|9.22337203685478e+18|9.22337203685478e+18
+`,
+		},
+		{
+			query: `select total(9223372036854775807), avg(9223372036854775807) from people where id < 3`,
+			want: `1.84467440737096e+19|9.22337203685478e+18
+`,
+		},
+		{
+			query: `select sum(height), avg(height) from people`,
+			want: `48401.0|174.73285198556
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
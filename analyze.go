@@ -0,0 +1,375 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/binary"
+)
+
+// Analysis reports how the pages of a database file are used.
+type Analysis struct {
+	PageSize int // page size in bytes
+	Pages    int // number of pages of the database
+
+	FreelistPages      int // number of free pages, including trunk pages
+	FreelistTrunkPages int // number of freelist trunk pages
+	PointerMapPages    int // number of pointer map pages (auto-vacuum databases)
+	LockBytePages      int // 1 if the database holds the lock-byte page
+
+	// Btrees holds the statistics of each b-tree, starting with the
+	// sqlite_master table, then in schema order.
+	Btrees []BtreeStats
+}
+
+// Unaccounted returns the number of pages that neither belong to a
+// b-tree, nor to the freelist, nor are pointer map or lock-byte pages.
+// A non-zero value denotes a corrupted file.
+func (a *Analysis) Unaccounted() int {
+	n := a.Pages - a.FreelistPages - a.PointerMapPages - a.LockBytePages
+	for _, bt := range a.Btrees {
+		n -= bt.Pages()
+	}
+	return n
+}
+
+// BtreeStats holds the space usage statistics of a table or index b-tree.
+type BtreeStats struct {
+	Name      string // name of the table or index
+	TableName string // name of the table the b-tree belongs to
+	RootPage  int    // root page of the b-tree
+	Index     bool   // whether the b-tree is an index b-tree, as are WITHOUT ROWID tables
+
+	Depth         int   // number of levels of the b-tree
+	InteriorPages int   // number of interior pages
+	LeafPages     int   // number of leaf pages
+	OverflowPages int   // number of overflow pages
+	Entries       int64 // number of rows of a table, or of keys of an index
+	Cells         int64 // number of cells, including those of interior pages
+	Overflowed    int64 // number of cells whose payload spills to overflow pages
+	MaxFanout     int   // maximum number of children of an interior page
+	Children      int64 // number of child pointers of interior pages
+
+	Bytes           int64 // total size of the pages of the b-tree
+	PayloadBytes    int64 // payload bytes, including those on overflow pages
+	OverheadBytes   int64 // page headers, cell pointers, cell headers and reserved bytes
+	UnusedBytes     int64 // unused bytes, including free blocks and fragments
+	FreeBlocks      int   // number of free blocks
+	FreeBlockBytes  int64 // bytes within free blocks
+	FragmentedBytes int64 // fragmented free bytes
+}
+
+// Pages returns the number of pages of the b-tree.
+func (st *BtreeStats) Pages() int {
+	return st.InteriorPages + st.LeafPages + st.OverflowPages
+}
+
+// AvgFanout returns the average number of children of interior pages.
+func (st *BtreeStats) AvgFanout() float64 {
+	if st.InteriorPages == 0 {
+		return 0
+	}
+	return float64(st.Children) / float64(st.InteriorPages)
+}
+
+// Fill returns the fraction of the b-tree bytes that are in use.
+func (st *BtreeStats) Fill() float64 {
+	if st.Bytes == 0 {
+		return 0
+	}
+	return float64(st.Bytes-st.UnusedBytes) / float64(st.Bytes)
+}
+
+// Analyze walks all the b-trees and the freelist of the database and
+// reports how its pages are used.
+func (db *DbFile) Analyze() (*Analysis, error) {
+	a := &Analysis{
+		PageSize: db.PageSize(),
+		Pages:    db.NumPage(),
+	}
+
+	objs := []SchemaObject{{
+		Type:      "table",
+		Name:      "sqlite_master",
+		TableName: "sqlite_master",
+		RootPage:  1,
+	}}
+	for _, obj := range db.schema {
+		if obj.RootPage > 0 {
+			objs = append(objs, obj)
+		}
+	}
+
+	for _, obj := range objs {
+		st := BtreeStats{
+			Name:      obj.Name,
+			TableName: obj.TableName,
+			RootPage:  obj.RootPage,
+		}
+		page, err := db.pager.Page(obj.RootPage)
+		if err != nil {
+			return nil, err
+		}
+		bt, err := newBtree(page, db)
+		if err != nil {
+			return nil, err
+		}
+		st.Index = !bt.isTable()
+		err = st.visit(newBtreeWalk(bt.ID()), 0, bt)
+		if err != nil {
+			return nil, err
+		}
+		a.Btrees = append(a.Btrees, st)
+	}
+
+	var err error
+	a.FreelistPages, a.FreelistTrunkPages, err = db.freelist()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.header.AutoVacuum != 0 {
+		a.PointerMapPages = db.pointerMapPages()
+	}
+	if db.NumPage() >= db.lockBytePage() {
+		a.LockBytePages = 1
+	}
+
+	return a, nil
+}
+
+// visit accumulates the statistics of the b-tree page bt, at the given
+// depth, and of its children.
+func (st *BtreeStats) visit(w *btreeWalk, depth int, bt *btreeTable) error {
+	if depth+1 > st.Depth {
+		st.Depth = depth + 1
+	}
+
+	var (
+		pagesz   = bt.page.PageSize()
+		usable   = bt.usable()
+		ncells   = bt.NumCell()
+		reserved = pagesz - usable
+		hdrEnd   = bt.hdrAddr() + bt.hdrSize() + 2*ncells
+	)
+
+	st.Bytes += int64(pagesz)
+	switch {
+	case bt.isLeaf():
+		st.LeafPages++
+	default:
+		st.InteriorPages++
+		st.Children += int64(ncells + 1)
+		st.MaxFanout = max(st.MaxFanout, ncells+1)
+	}
+	st.OverheadBytes += int64(reserved + hdrEnd)
+
+	// free space between the cell pointer array and the cell content.
+	content := bt.CellsAddr()
+	if content == 0 {
+		content = 65536
+	}
+	if content < hdrEnd || content > usable {
+		return corruptf(bt.ID(), -1, bt.hdrAddr()+5, "invalid cell content offset (%d)", content)
+	}
+	st.UnusedBytes += int64(content - hdrEnd)
+
+	nfree, free, err := bt.freeBlocks()
+	if err != nil {
+		return err
+	}
+	st.FreeBlocks += nfree
+	st.FreeBlockBytes += int64(free)
+	st.FragmentedBytes += int64(bt.raw.NFreeBytes)
+	st.UnusedBytes += int64(free) + int64(bt.raw.NFreeBytes)
+
+	for i := 0; i < ncells; i++ {
+		ext, err := bt.cellExtent(i)
+		if err != nil {
+			return err
+		}
+		st.Cells++
+		if bt.isLeaf() || !bt.isTable() {
+			st.Entries++
+		}
+		st.PayloadBytes += int64(ext.payload)
+		st.OverheadBytes += int64(ext.size - ext.local)
+		if ext.payload > ext.local {
+			st.Overflowed++
+			spill := ext.payload - ext.local
+			n := (spill + usable - 5) / (usable - 4)
+			st.OverflowPages += n
+			st.Bytes += int64(n * pagesz)
+			st.OverheadBytes += int64(n * (4 + reserved))
+			st.UnusedBytes += int64(n*(usable-4) - spill)
+		}
+	}
+
+	if bt.isLeaf() {
+		return nil
+	}
+	children, err := bt.children(w, depth)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err := st.visit(w, depth+1, child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cellExtent describes the space used by a cell.
+type cellExtent struct {
+	size     int   // size of the cell within the page
+	local    int   // payload bytes stored within the page
+	payload  int   // total payload size
+	overflow int32 // first overflow page
+}
+
+// cellExtent returns the space used by the cell icell, without reading
+// its overflow pages.
+func (btree *btreeTable) cellExtent(icell int) (cellExtent, error) {
+	var ext cellExtent
+	addr := int(btree.addrs[icell])
+	r := btree.page.reader(addr)
+	id := btree.ID()
+
+	if !btree.isLeaf() {
+		if len(r.Bytes()) < 4 {
+			return ext, corruptf(id, icell, addr, "truncated cell")
+		}
+		r.pos += 4 // left child page
+	}
+
+	if btree.Kind() != BTreeInteriorTableKind {
+		sz, n := r.Varint()
+		if n <= 0 || sz < 0 || sz > int64(btree.db.NumPage())*int64(btree.usable()) {
+			return ext, corruptf(id, icell, r.Pos(), "invalid payload size")
+		}
+		ext.payload = int(sz)
+		ext.local = btree.localSize(ext.payload)
+	}
+	if btree.isTable() {
+		_, n := r.Varint()
+		if n <= 0 {
+			return ext, corruptf(id, icell, r.Pos(), "error decoding rowid: n=%d", n)
+		}
+	}
+
+	ext.size = r.Pos() - addr + ext.local
+	if ext.local < ext.payload {
+		ext.size += 4 // overflow page number
+	}
+	if addr+ext.size > btree.usable() {
+		return ext, corruptf(id, icell, addr, "cell extends past the end of the page (%d bytes)", ext.size)
+	}
+	if ext.local < ext.payload {
+		ext.overflow = int32(binary.BigEndian.Uint32(btree.page.buf[addr+ext.size-4:]))
+	}
+	return ext, nil
+}
+
+// freeBlocks returns the number of free blocks of the page and their
+// total size.
+func (btree *btreeTable) freeBlocks() (int, int, error) {
+	var (
+		buf  = btree.page.buf[:btree.usable()]
+		addr = btree.FreeBlockAddr()
+		n    = 0
+		size = 0
+	)
+	for addr != 0 {
+		if addr+4 > len(buf) {
+			return 0, 0, corruptf(btree.ID(), -1, addr, "free block out of page")
+		}
+		next := int(binary.BigEndian.Uint16(buf[addr:]))
+		sz := int(binary.BigEndian.Uint16(buf[addr+2:]))
+		if sz < 4 || addr+sz > len(buf) {
+			return 0, 0, corruptf(btree.ID(), -1, addr+2, "invalid free block size (%d)", sz)
+		}
+		if next != 0 && next <= addr+sz {
+			// free blocks are sorted by address, which also prevents cycles.
+			return 0, 0, corruptf(btree.ID(), -1, addr, "invalid next free block (%d)", next)
+		}
+		n++
+		size += sz
+		addr = next
+	}
+	return n, size, nil
+}
+
+// freelist returns the number of pages of the freelist and the number
+// of its trunk pages.
+func (db *DbFile) freelist() (int, int, error) {
+	var (
+		pgno   = int(db.header.FreePage)
+		ntrunk = 0
+		nleaf  = 0
+		seen   = make(map[int]bool)
+	)
+	for pgno != 0 {
+		if pgno < 1 || pgno > db.NumPage() {
+			return 0, 0, corruptf(0, -1, -1, "invalid freelist trunk page number (%d)", pgno)
+		}
+		if seen[pgno] {
+			return 0, 0, corruptf(pgno, -1, 0, "freelist cycle through page %d", pgno)
+		}
+		seen[pgno] = true
+
+		page, err := db.pager.Page(pgno)
+		if err != nil {
+			return 0, 0, err
+		}
+		next := int(binary.BigEndian.Uint32(page.buf[0:]))
+		n := int(binary.BigEndian.Uint32(page.buf[4:]))
+		if n > db.maxFreelistLeaves() {
+			return 0, 0, corruptf(pgno, -1, 4, "invalid number of freelist leaf pages (%d)", n)
+		}
+		ntrunk++
+		nleaf += n
+		pgno = next
+	}
+	return ntrunk + nleaf, ntrunk, nil
+}
+
+// maxFreelistLeaves returns the maximum number of leaf pages listed by a
+// freelist trunk page: the page numbers fill its usable space after the
+// next trunk page and the number of leaf pages.
+func (db *DbFile) maxFreelistLeaves() int {
+	usable := db.PageSize() - int(db.header.NReserved)
+	return usable/4 - 2
+}
+
+// pointerMapPages returns the number of pointer map pages of an
+// auto-vacuum database.
+func (db *DbFile) pointerMapPages() int {
+	n := 0
+	for pgno := 2; pgno <= db.NumPage(); pgno++ {
+		if db.pointerMapPage(pgno) == pgno {
+			n++
+		}
+	}
+	return n
+}
+
+// pointerMapPage returns the number of the pointer map page holding the
+// entry of page pgno, in auto-vacuum databases.
+func (db *DbFile) pointerMapPage(pgno int) int {
+	if db.header.AutoVacuum == 0 || pgno < 2 {
+		return 0
+	}
+	// the first pointer map page is page 2, and each pointer map page
+	// describes the following usable/5 pages.
+	usable := db.PageSize() - int(db.header.NReserved)
+	per := usable/5 + 1
+	ptrmap := (pgno-2)/per*per + 2
+	if ptrmap == db.lockBytePage() {
+		// the lock-byte page is never a pointer map page.
+		ptrmap++
+	}
+	return ptrmap
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	type btree struct {
+		name                     string
+		index                    bool
+		interior, leaf, overflow int
+		cells                    int64
+		payload, unused          int64
+	}
+	for _, tc := range []struct {
+		fname             string
+		pages, free, ptrs int
+		btrees            []btree
+	}{
+		// expected values are those of the dbstat virtual table.
+		{
+			fname: "testdata/large.sqlite",
+			pages: 178,
+			btrees: []btree{
+				{"sqlite_master", false, 0, 1, 0, 2, 164, 744},
+				{"items", false, 1, 78, 45, 3077, 107967, 2676},
+				{"items_name", true, 3, 50, 0, 3000, 41872, 2768},
+			},
+		},
+		{
+			fname: "testdata/fragmented.sqlite",
+			pages: 84,
+			free:  31,
+			ptrs:  1,
+			btrees: []btree{
+				{"sqlite_master", false, 0, 1, 0, 3, 192, 712},
+				{"t", false, 1, 23, 10, 321, 25037, 7939},
+				{"t_name", true, 1, 11, 0, 299, 4399, 6852},
+				{"kv", true, 1, 4, 0, 200, 2958, 1506},
+			},
+		},
+	} {
+		t.Run(tc.fname, func(t *testing.T) {
+			db, err := Open(tc.fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			a, err := db.Analyze()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Pages != tc.pages || a.FreelistPages != tc.free || a.PointerMapPages != tc.ptrs {
+				t.Fatalf("invalid pages: got=(%d, free=%d, ptrmap=%d), want=(%d, free=%d, ptrmap=%d)",
+					a.Pages, a.FreelistPages, a.PointerMapPages, tc.pages, tc.free, tc.ptrs,
+				)
+			}
+			if n := a.Unaccounted(); n != 0 {
+				t.Fatalf("unaccounted pages: %d", n)
+			}
+			if len(a.Btrees) != len(tc.btrees) {
+				t.Fatalf("invalid number of b-trees: got=%d, want=%d", len(a.Btrees), len(tc.btrees))
+			}
+			for i, want := range tc.btrees {
+				st := a.Btrees[i]
+				got := btree{
+					st.Name, st.Index,
+					st.InteriorPages, st.LeafPages, st.OverflowPages,
+					st.Cells, st.PayloadBytes, st.UnusedBytes,
+				}
+				if got != want {
+					t.Fatalf("invalid b-tree stats:\ngot= %+v\nwant=%+v", got, want)
+				}
+				if sum := st.PayloadBytes + st.OverheadBytes + st.UnusedBytes; sum != st.Bytes {
+					t.Fatalf("%s: payload+overhead+unused=%d, want %d bytes", st.Name, sum, st.Bytes)
+				}
+				if st.Bytes != int64(st.Pages()*a.PageSize) {
+					t.Fatalf("%s: invalid number of bytes: %d", st.Name, st.Bytes)
+				}
+			}
+		})
+	}
+}
+
+// freelistDB returns cksumvfs.sqlite, whose pages have 8 reserved bytes,
+// with an additional freelist trunk page listing n leaf pages.
+func freelistDB(t *testing.T, n int) *DbFile {
+	raw, err := ioutil.ReadFile("testdata/cksumvfs.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pagesz = 1024
+	npages := len(raw) / pagesz
+	trunk := make([]byte, pagesz)
+	binary.BigEndian.PutUint32(trunk[4:], uint32(n))
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint32(trunk[8+4*i:], uint32(npages))
+	}
+	raw = append(raw, trunk...)
+	binary.BigEndian.PutUint32(raw[28:], uint32(npages+1)) // database size
+	binary.BigEndian.PutUint32(raw[32:], uint32(npages+1)) // first freelist trunk page
+	binary.BigEndian.PutUint32(raw[36:], uint32(n+1))      // number of freelist pages
+
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAnalyzeFreelistReserved(t *testing.T) {
+	// the page numbers of a trunk page fill its usable space: 1016 bytes.
+	db := freelistDB(t, 1016/4-2)
+	defer db.Close()
+	if _, _, err := db.freelist(); err != nil {
+		t.Fatalf("valid freelist trunk page: %v", err)
+	}
+
+	db = freelistDB(t, 1016/4-1)
+	defer db.Close()
+	_, _, err := db.freelist()
+	if _, ok := err.(*CorruptError); !ok || !strings.Contains(err.Error(), "freelist leaf pages") {
+		t.Fatalf("got err=%v, want an invalid number of freelist leaf pages", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// attachment is a database attached to another one.
+type attachment struct {
+	name string
+	db   *DbFile
+}
+
+// Attach attaches the database other to db under the schema name name,
+// so that the queries of db may use its tables: as name.table, or as
+// table if neither db nor a database attached before other has a table
+// with the same name.
+//
+// Attach fails if name is main, temp, or the name of an attached
+// database.
+func (db *DbFile) Attach(name string, other *DbFile) error {
+	if other == nil {
+		return errors.New("sqlite3: Attach called with a nil database")
+	}
+	db.attachMu.Lock()
+	defer db.attachMu.Unlock()
+	if strings.EqualFold(name, "main") || strings.EqualFold(name, "temp") || db.attachment(name) != nil {
+		return fmt.Errorf("sqlite3: database %s is already in use", name)
+	}
+	db.attached = append(db.attached, attachment{name: name, db: other})
+	return nil
+}
+
+// Detach detaches the database attached to db under the schema name
+// name. The attached database is not closed.
+func (db *DbFile) Detach(name string) error {
+	db.attachMu.Lock()
+	defer db.attachMu.Unlock()
+	for i, a := range db.attached {
+		if strings.EqualFold(a.name, name) {
+			db.attached = append(db.attached[:i:i], db.attached[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("sqlite3: no such database: %s", name)
+}
+
+// attachment returns the database attached under the schema name name,
+// or nil. The caller must hold db.attachMu.
+func (db *DbFile) attachment(name string) *DbFile {
+	for _, a := range db.attached {
+		if strings.EqualFold(a.name, name) {
+			return a.db
+		}
+	}
+	return nil
+}
+
+// lookupTable returns the table name of the schema schema, main or an
+// attached database, or, if schema is empty, the first table with that
+// name of db and then of the attached databases.
+func (db *DbFile) lookupTable(schema, name string) (*Table, error) {
+	db.attachMu.RLock()
+	defer db.attachMu.RUnlock()
+
+	var t *Table
+	switch {
+	case schema == "":
+		t = db.Table(name)
+		for i := 0; t == nil && i < len(db.attached); i++ {
+			t = db.attached[i].db.Table(name)
+		}
+	case strings.EqualFold(schema, "main"):
+		t = db.Table(name)
+	default:
+		if other := db.attachment(schema); other != nil {
+			t = other.Table(name)
+		}
+	}
+	if t == nil {
+		if schema != "" {
+			name = schema + "." + name
+		}
+		return nil, fmt.Errorf("sqlite3: no such table: %s", name)
+	}
+	return t, nil
+}
+
+// lookupView returns the view name of the schema schema, main or an
+// attached database, or, if schema is empty, the first view with that
+// name of db and then of the attached databases, or nil if there is no
+// such view.
+func (db *DbFile) lookupView(schema, name string) *View {
+	db.attachMu.RLock()
+	defer db.attachMu.RUnlock()
+
+	switch {
+	case schema == "":
+		if v := db.View(name); v != nil {
+			return v
+		}
+		for _, a := range db.attached {
+			if v := a.db.View(name); v != nil {
+				return v
+			}
+		}
+	case strings.EqualFold(schema, "main"):
+		return db.View(name)
+	default:
+		if other := db.attachment(schema); other != nil {
+			return other.View(name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttach(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, name := range []string{"query", "index"} {
+		other, err := Open("testdata/" + name + ".sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		if err := db.Attach(name[:1], other); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select u.id, p.name, p.city from urls u join q.people p on p.id = u.id where u.id < 4`,
+			want:  "1|Bob0|Lyon\n2|carol0|Berlin\n3|Dave0|paris\n",
+		},
+		{
+			query: `select count(*) from people`,
+			want:  "300\n",
+		},
+		{
+			query: `select count(*) from main.tags`,
+			want:  "40\n",
+		},
+		{
+			query: `select count(*) from I.tags`,
+			want:  "6\n",
+		},
+		{
+			query: `select p.id, c.name from people p join i.items c on c.id = p.id * 10 where p.id < 3`,
+			want:  "1|Anvil\n2|Anvil\n",
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+
+	// tables of db shadow the ones of the attached databases.
+	if got := queryString(t, db, `select count(*) from tags`); got != "40\n" {
+		t.Errorf("tags: got %q, want 40 rows", got)
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{db.Attach("Q", db), "database Q is already in use"},
+		{db.Attach("main", db), "database main is already in use"},
+		{db.Attach("x", nil), "nil database"},
+		{db.Detach("nope"), "no such database: nope"},
+	} {
+		if tc.err == nil || !strings.Contains(tc.err.Error(), tc.want) {
+			t.Errorf("got error %v, want %q", tc.err, tc.want)
+		}
+	}
+
+	if err := db.Detach("q"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{`select * from q.people`, `select * from people`} {
+		if _, err := db.Query(query); err == nil || !strings.Contains(err.Error(), "no such table") {
+			t.Errorf("%s: got error %v after Detach", query, err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Blob reads a TEXT or BLOB value of a table row.
+//
+// The overflow pages holding the value are only read when the
+// corresponding bytes are, and are not kept in the page cache: reading a
+// value does not require loading it all in memory.
+//
+// ReadAt may be called concurrently; Read and Seek may not.
+type Blob struct {
+	db     *DbFile
+	local  []byte // payload bytes stored within the b-tree page
+	size   int64  // total payload size
+	usable int    // usable size of the pages
+	cell   int    // page holding the cell of the payload
+
+	beg    int64 // offset of the value within the payload
+	length int64 // size of the value
+	pos    int64 // offset of the next Read within the value
+
+	mu    sync.Mutex
+	chain []int32 // overflow pages discovered so far
+	next  int32   // overflow page following the last discovered one
+	last  page    // last overflow page read
+}
+
+// OpenBlob opens the TEXT or BLOB value of column in the row of the
+// table with the given rowid.
+//
+// Text values are read as stored, in the database text encoding.
+func (t *Table) OpenBlob(rowid int64, column string) (*Blob, error) {
+	if t.withoutRowid {
+		return nil, fmt.Errorf("sqlite3: cannot open value of WITHOUT ROWID table %q", t.name)
+	}
+	icol := t.colIndex(column)
+	switch {
+	case icol < 0:
+		return nil, fmt.Errorf("sqlite3: no such column %q in table %q", column, t.name)
+	case icol == t.rowid:
+		return nil, fmt.Errorf("sqlite3: cannot open value of INTEGER PRIMARY KEY column %q", column)
+	}
+
+	page, err := t.db.pager.Page(t.pageid)
+	if err != nil {
+		return nil, err
+	}
+	root, err := newBtreeTable(page, t.db)
+	if err != nil {
+		return nil, err
+	}
+	leaf, icell, err := root.findRowid(rowid)
+	if err != nil {
+		return nil, err
+	}
+	if icell < 0 {
+		return nil, fmt.Errorf("sqlite3: no row %d in table %q", rowid, t.name)
+	}
+
+	b, err := leaf.openPayload(icell)
+	if err != nil {
+		return nil, err
+	}
+	err = b.locate(icol)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: cannot open column %q of row %d: %w", column, rowid, err)
+	}
+	return b, nil
+}
+
+// openPayload returns a Blob spanning the whole payload of the cell icell
+// of a leaf table b-tree page.
+func (btree *btreeTable) openPayload(icell int) (*Blob, error) {
+	var (
+		id   = btree.ID()
+		addr = int(btree.addrs[icell])
+		r    = btree.page.reader(addr)
+	)
+	sz, n := r.Varint()
+	if n <= 0 || sz < 0 || sz > int64(btree.db.NumPage())*int64(btree.usable()) {
+		return nil, corruptf(id, icell, addr, "invalid payload size")
+	}
+	if _, n := r.Varint(); n <= 0 {
+		return nil, corruptf(id, icell, r.Pos(), "error decoding rowid: n=%d", n)
+	}
+
+	local := btree.localSize(int(sz))
+	end := r.Pos() + local
+	if int64(local) < sz {
+		end += 4
+	}
+	if end > btree.usable() {
+		return nil, corruptf(id, icell, addr, "cell extends past the end of the page")
+	}
+
+	b := &Blob{
+		db:     btree.db,
+		local:  btree.page.buf[r.Pos() : r.Pos()+local : r.Pos()+local],
+		size:   sz,
+		usable: btree.usable(),
+		cell:   id,
+		length: sz,
+	}
+	if int64(local) < sz {
+		b.next = int32(binary.BigEndian.Uint32(btree.page.buf[end-4:]))
+	}
+	return b, nil
+}
+
+// locate restricts the Blob to the value of the column icol of the
+// record held by the payload.
+func (b *Blob) locate(icol int) error {
+	var buf [9]byte
+	n, err := b.readPayload(buf[:min(len(buf), int(b.size))], 0)
+	if err != nil {
+		return err
+	}
+	hdrsz, nhdr := varint(buf[:n])
+	if nhdr <= 0 || hdrsz < int64(nhdr) || hdrsz > b.size {
+		return corruptf(b.cell, -1, -1, "invalid record header size (%d)", hdrsz)
+	}
+
+	hdr := make([]byte, hdrsz)
+	if _, err := b.readPayload(hdr, 0); err != nil {
+		return err
+	}
+
+	off := hdrsz
+	for i, pos := 0, nhdr; ; i++ {
+		if pos >= len(hdr) {
+			// records written before the column was added hold no
+			// value for it.
+			return errors.New("value is NULL")
+		}
+		v, n := varint(hdr[pos:])
+		if n <= 0 {
+			return corruptf(b.cell, -1, -1, "error decoding record header type (n=%d)", n)
+		}
+		pos += n
+		st := SerialType(v)
+		if st.NBytes() < 0 {
+			return corruptf(b.cell, -1, -1, "invalid serial type (%d)", v)
+		}
+		if i < icol {
+			off += int64(st.NBytes())
+			continue
+		}
+		switch {
+		case st == StNull:
+			return errors.New("value is NULL")
+		case st == StFloat:
+			return errors.New("value is a REAL")
+		case !st.IsBlob() && !st.IsText():
+			return errors.New("value is an INTEGER")
+		}
+		if off+int64(st.NBytes()) > b.size {
+			return corruptf(b.cell, -1, -1, "%v value past the end of the record", st)
+		}
+		b.beg = off
+		b.length = int64(st.NBytes())
+		return nil
+	}
+}
+
+// Size returns the size of the value in bytes.
+func (b *Blob) Size() int64 {
+	return b.length
+}
+
+// Read implements io.Reader.
+func (b *Blob) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.pos)
+	b.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.length
+	default:
+		return b.pos, fmt.Errorf("sqlite3: invalid whence (%d)", whence)
+	}
+	if offset < 0 {
+		return b.pos, fmt.Errorf("sqlite3: negative position (%d)", offset)
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("sqlite3: negative offset (%d)", off)
+	}
+	if off >= b.length {
+		return 0, io.EOF
+	}
+	var err error
+	if int64(len(p)) > b.length-off {
+		p = p[:b.length-off]
+		err = io.EOF
+	}
+	n, e := b.readPayload(p, b.beg+off)
+	if e != nil {
+		return n, e
+	}
+	return n, err
+}
+
+// readPayload reads len(p) bytes of the payload starting at offset off,
+// which must lie within the payload.
+func (b *Blob) readPayload(p []byte, off int64) (int, error) {
+	n := 0
+	if off < int64(len(b.local)) {
+		n = copy(p, b.local[off:])
+		off += int64(n)
+	}
+	if n == len(p) {
+		return n, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// each overflow page holds usable-4 bytes of the payload.
+	per := int64(b.usable - 4)
+	for n < len(p) {
+		k := (off - int64(len(b.local))) / per
+		page, err := b.overflowPage(int(k))
+		if err != nil {
+			return n, err
+		}
+		beg := 4 + (off-int64(len(b.local)))%per
+		m := copy(p[n:], page.buf[beg:4+per])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// overflowPage returns the k-th overflow page of the payload, following
+// the overflow chain as needed.
+func (b *Blob) overflowPage(k int) (page, error) {
+	for len(b.chain) <= k {
+		prev := b.cell
+		if len(b.chain) > 0 {
+			prev = int(b.chain[len(b.chain)-1])
+		}
+		pgno := b.next
+		if pgno < 1 || int(pgno) > b.db.NumPage() {
+			return page{}, corruptf(prev, -1, 0, "invalid overflow page number (%d)", pgno)
+		}
+		pg, err := b.db.pager.read(int(pgno))
+		if err != nil {
+			return pg, err
+		}
+		b.chain = append(b.chain, pgno)
+		b.next = int32(binary.BigEndian.Uint32(pg.buf))
+		b.last = pg
+
+		// the payload spans a known number of overflow pages.
+		per := int64(b.usable - 4)
+		npages := (b.size - int64(len(b.local)) + per - 1) / per
+		switch {
+		case int64(len(b.chain)) == npages && b.next != 0:
+			return page{}, corruptf(int(pgno), -1, 0, "read all %d bytes but still have overflow page %d", b.size, b.next)
+		case int64(len(b.chain)) < npages && b.next == 0:
+			return page{}, corruptf(int(pgno), -1, 0, "ran out of overflow pages")
+		}
+	}
+
+	pgno := int(b.chain[k])
+	if b.last.id == pgno {
+		return b.last, nil
+	}
+	pg, err := b.db.pager.read(pgno)
+	if err != nil {
+		return pg, err
+	}
+	b.last = pg
+	return pg, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpenBlob(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/blob.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tbl := db.Table("files")
+
+	type row struct {
+		rowid  int64
+		values []interface{}
+	}
+	var rows []row
+	err = db.VisitTableRecords("files", func(rowid *int64, rec Record) error {
+		rows = append(rows, row{*rowid, rec.Values})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range rows {
+		for icol, name := range []string{"id", "name", "data", "extra"} {
+			var v interface{}
+			if icol < len(row.values) {
+				v = row.values[icol]
+			}
+			var want []byte
+			switch v := v.(type) {
+			case string:
+				want = []byte(v)
+			case []byte:
+				want = v
+			}
+
+			b, err := tbl.OpenBlob(row.rowid, name)
+			if want == nil {
+				if err == nil {
+					t.Errorf("row %d: column %s: expected an error for value %v", row.rowid, name, v)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("row %d: column %s: %v", row.rowid, name, err)
+				continue
+			}
+			if b.Size() != int64(len(want)) {
+				t.Errorf("row %d: column %s: size = %d, want %d", row.rowid, name, b.Size(), len(want))
+			}
+			got, err := ioutil.ReadAll(b)
+			if err != nil {
+				t.Errorf("row %d: column %s: %v", row.rowid, name, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("row %d: column %s: invalid content", row.rowid, name)
+			}
+		}
+	}
+
+	for _, rowid := range []int64{-3, 0, 1, 1801, 2001} {
+		if _, err := tbl.OpenBlob(rowid, "data"); err == nil {
+			t.Errorf("row %d: expected an error", rowid)
+		}
+	}
+	if _, err := tbl.OpenBlob(3, "nosuchcolumn"); err == nil {
+		t.Errorf("expected an error for an unknown column")
+	}
+}
+
+func TestBlobReadAt(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/blob.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	b, err := db.Table("files").OpenBlob(300, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		t.Fatal(err)
+	}
+	// only the pages of the b-tree path to the row are cached.
+	if n := len(db.pager.pages); n > 3 {
+		t.Errorf("overflow pages were cached: %d cached pages", n)
+	}
+
+	var want []byte
+	err = db.VisitTableRecords("files", func(rowid *int64, rec Record) error {
+		if *rowid == 300 {
+			want = rec.Values[2].([]byte)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = db.Table("files").OpenBlob(300, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 100000 {
+		t.Fatalf("size = %d, want 100000", b.Size())
+	}
+
+	// read backwards, across overflow page boundaries.
+	for _, off := range []int64{99990, 50000, 1020, 900, 0} {
+		buf := make([]byte, 2000)
+		n, err := b.ReadAt(buf, off)
+		wantN := min(len(buf), int(b.Size()-off))
+		if n != wantN || (err != nil && err != io.EOF) || (n < len(buf) && err != io.EOF) {
+			t.Errorf("ReadAt(%d) = %d, %v; want %d", off, n, err, wantN)
+			continue
+		}
+		if !bytes.Equal(buf[:n], want[off:off+int64(n)]) {
+			t.Errorf("ReadAt(%d): invalid content", off)
+		}
+	}
+
+	if _, err := b.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[len(want)-10:]) {
+		t.Errorf("invalid content after Seek")
+	}
+	if n, err := b.ReadAt(make([]byte, 1), b.Size()); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+}
+
+func TestBlobCorrupt(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/blob.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.Table("files").OpenBlob(300, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ReadAt(make([]byte, 1), 5000)
+	if len(b.chain) < 2 {
+		t.Fatalf("overflow chain not followed")
+	}
+	db.Close()
+
+	// loop the second overflow page onto the first one.
+	pgsz := int64(db.PageSize())
+	off := (int64(b.chain[1]) - 1) * pgsz
+	copy(raw[off:], []byte{byte(b.chain[0] >> 24), byte(b.chain[0] >> 16), byte(b.chain[0] >> 8), byte(b.chain[0])})
+
+	db, err = OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	b, err = db.Table("files").OpenBlob(300, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(b)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
@@ -7,7 +7,8 @@ package sqlite3
 import (
 	"fmt"
 	"io"
-	"strings"
+	"math"
+	"sort"
 
 	"github.com/gonuts/binary"
 )
@@ -16,10 +17,10 @@ type btheader struct {
 	raw struct {
 		Kind PageKind // b-tree page kind
 
-		FreeBlockOffset int16 // byte offset into the page of the first free block
-		NCells          int16 // number of cells on this page
-		CellsOffset     int16 // offset into first byte of the cell content area
-		NFreeBytes      int8  // number of fragmented free bytes within cell area content
+		FreeBlockOffset uint16 // byte offset into the page of the first free block
+		NCells          uint16 // number of cells on this page
+		CellsOffset     uint16 // offset into first byte of the cell content area
+		NFreeBytes      uint8  // number of fragmented free bytes within cell area content
 	}
 }
 
@@ -42,24 +43,45 @@ func (bt *btheader) CellsAddr() int {
 type btreeTable struct {
 	btheader
 	db      *DbFile
-	pointer int32   // right most pointer (only valid for interior pages
-	page    page    // page backing this b-tree leaf
-	addrs   []int16 // cell addresses
+	pointer int32    // right most pointer (only valid for interior pages
+	page    page     // page backing this b-tree leaf
+	addrs   []uint16 // cell addresses
+	pages   *int64   // counter of the pages read by traversals, if any
 }
 
 func newBtreeTable(page page, db *DbFile) (*btreeTable, error) {
+	btree, err := newBtree(page, db)
+	if err != nil {
+		return nil, err
+	}
+	if !btree.isTable() {
+		return nil, corruptf(page.ID(), -1, btree.hdrAddr(), "invalid table b-tree page kind (%v)", btree.Kind())
+	}
+	return btree, nil
+}
+
+// newBtree returns the b-tree page, of any kind, held by page.
+func newBtree(page page, db *DbFile) (*btreeTable, error) {
 	var hdr btheader
+	r := page.reader(0)
 	if page.ID() == 1 {
 		// drop first 100-bytes (global file header)
-		_, err := page.Seek(100, 0)
+		_, err := r.Seek(100, 0)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := page.Decode(&hdr.raw)
+	err := r.Decode(&hdr.raw)
 	if err != nil {
-		return nil, err
+		return nil, corruptf(page.ID(), -1, r.Pos(), "truncated b-tree page header")
+	}
+
+	switch hdr.Kind() {
+	case BTreeInteriorTableKind, BTreeLeafTableKind,
+		BTreeInteriorIndexKind, BTreeLeafIndexKind:
+	default:
+		return nil, corruptf(page.ID(), -1, r.Pos()-8, "invalid b-tree page kind (%v)", hdr.Kind())
 	}
 
 	btree := &btreeTable{
@@ -68,14 +90,14 @@ func newBtreeTable(page page, db *DbFile) (*btreeTable, error) {
 		page:     page,
 	}
 
-	if btree.Kind() == BTreeInteriorTableKind {
-		err = btree.page.Decode(&btree.pointer)
+	if !btree.isLeaf() {
+		err = r.Decode(&btree.pointer)
 		if err != nil {
-			return nil, err
+			return nil, corruptf(page.ID(), -1, r.Pos(), "truncated b-tree page header")
 		}
 	}
 
-	err = btree.init()
+	err = btree.init(r)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +105,63 @@ func newBtreeTable(page page, db *DbFile) (*btreeTable, error) {
 	return btree, err
 }
 
+// count adds a page read to the counter of the b-tree, if any.
+func (btree *btreeTable) count() {
+	if btree.pages != nil {
+		*btree.pages++
+	}
+}
+
+// isTable reports whether the page belongs to a table b-tree.
+func (bt btheader) isTable() bool {
+	return bt.Kind()&intKeyKind != 0
+}
+
+// isLeaf reports whether the page is a leaf page.
+func (bt btheader) isLeaf() bool {
+	return bt.Kind()&leafKind != 0
+}
+
+// hdrAddr returns the offset of the b-tree page header within the page.
+func (btree *btreeTable) hdrAddr() int {
+	if btree.ID() == 1 {
+		return 100
+	}
+	return 0
+}
+
+// hdrSize returns the size of the b-tree page header.
+func (btree *btreeTable) hdrSize() int {
+	if btree.isLeaf() {
+		return 8
+	}
+	return 12
+}
+
+// usable returns the usable size of the pages.
+func (btree *btreeTable) usable() int {
+	return btree.page.PageSize() - int(btree.db.header.NReserved)
+}
+
+// localSize returns the number of bytes of a payload of size P stored
+// within the b-tree page, the rest spilling to overflow pages.
+func (btree *btreeTable) localSize(P int) int {
+	U := btree.usable()
+	X := U - 35 // maximum local payload of table leaves
+	if !btree.isTable() {
+		X = ((U - 12) * 64 / 255) - 23
+	}
+	if P <= X {
+		return P
+	}
+	M := ((U - 12) * 32 / 255) - 23
+	K := M + ((P - M) % (U - 4))
+	if K > X {
+		return M
+	}
+	return K
+}
+
 func (btree *btreeTable) ID() int {
 	return btree.page.ID()
 }
@@ -91,20 +170,27 @@ func (btree *btreeTable) Size() int {
 	return len(btree.page.buf)
 }
 
-func (btree *btreeTable) init() error {
+func (btree *btreeTable) init(r *pageReader) error {
 	var err error
 	if btree.addrs != nil {
 		return nil
 	}
 
-	cells := make([]int16, btree.NumCell())
+	if 2*btree.NumCell() > len(r.Bytes()) {
+		return corruptf(btree.ID(), -1, r.Pos(), "too many cells (%d)", btree.NumCell())
+	}
+
+	cells := make([]uint16, btree.NumCell())
 	for icell, addr := range cells {
-		// fmt.Printf("   cell= %d/%d... (%d)\n", icell+1, len(cells), btree.page.Pos())
-		err = btree.page.Decode(&addr)
+		// fmt.Printf("   cell= %d/%d... (%d)\n", icell+1, len(cells), r.Pos())
+		err = r.Decode(&addr)
 		if err != nil {
-			return err
+			return corruptf(btree.ID(), icell, r.Pos(), "truncated cell pointer array")
 		}
-		// fmt.Printf("   cell= %d/%d... (%d) => %d\n", icell+1, len(cells), btree.page.Pos(), addr)
+		if int(addr) >= btree.Size() {
+			return corruptf(btree.ID(), icell, r.Pos()-2, "cell address out of page (%d)", addr)
+		}
+		// fmt.Printf("   cell= %d/%d... (%d) => %d\n", icell+1, len(cells), r.Pos(), addr)
 		cells[icell] = addr
 	}
 
@@ -112,127 +198,51 @@ func (btree *btreeTable) init() error {
 	return err
 }
 
+// decodeRecord decodes the record held by payload.
+// Offsets of corruption errors are relative to the start of the payload.
 func (btree *btreeTable) decodeRecord(payload []byte) (Record, error) {
-	var rec Record
-
-	// decode record
-	recbuf := payload[:]
-	rhdrsz, n := varint(recbuf)
-	if n <= 0 {
-		return rec, fmt.Errorf("sqlite3: error decoding record header (n=%d)", n)
-	}
-	recbuf = recbuf[n:]
-
-	rec.Header.Len = int(rhdrsz) - n
-	for ii := 0; ii < rec.Header.Len; {
-		v, n := varint(recbuf)
-		// fmt.Printf("ii=%d nn=%d len=%d\n", ii, n, rec.Header.Len)
-		if n <= 0 {
-			return rec, fmt.Errorf("sqlite3: error decoding record header type (n=%d)", n)
-		}
-		recbuf = recbuf[n:]
-		ii += int(n)
-		rec.Header.Types = append(rec.Header.Types, SerialType(v))
-	}
-	rec.Body = recbuf[:]
-	//copy(rec.Body, recbuf)
-
-	// fmt.Printf(">>> record: %#v (body=%d)\n", rec.Header, len(rec.Body))
-	for _, st := range rec.Header.Types {
-		var v interface{}
-		switch st {
-		case StInt8:
-			recbuf, v = readStInt8(recbuf)
-
-		case StInt16:
-			recbuf, v = readStInt16(recbuf)
-
-		case StInt24:
-			recbuf, v = readStInt24(recbuf)
-
-		case StInt32:
-			recbuf, v = readStInt32(recbuf)
-
-		case StInt48:
-			recbuf, v = readStInt48(recbuf)
-
-		case StInt64:
-			recbuf, v = readStInt64(recbuf)
-
-		case StFloat:
-			var vv float64
-			n, err := unmarshal(recbuf, &vv)
-			if err != nil {
-				panic(err)
-			}
-			recbuf = recbuf[int(n):]
-			v = vv
-
-		case StC0:
-			v = 0
-
-		case StC1:
-			v = 1
-
-		default:
-			if st.IsBlob() {
-				vv := make([]byte, st.NBytes())
-				n := copy(vv, recbuf)
-				recbuf = recbuf[int(n):]
-				v = vv
-			}
-			if st.IsText() {
-				vv := make([]byte, st.NBytes())
-				n := copy(vv, recbuf)
-				recbuf = recbuf[int(n):]
-				// FIXME(sbinet)
-				// handle db string encoding
-				switch btree.db.header.DbEncoding {
-				case 1:
-					s := string(vv)
-					idx := strings.Index(s, "\x00")
-					if idx >= 0 {
-						s = s[:idx]
-					}
-					v = s
-				default:
-					panic("utf-16 not supported")
-				}
-			}
-		}
-
-		rec.Values = append(rec.Values, v)
-	}
-	// fmt.Printf(">>> record: %#v (body=%d)\n", rec.Values, len(rec.Body))
-
-	return rec, nil
+	return decodeRecord(payload, btree.db.header.DbEncoding, btree.db.zeroCopy, nil)
 }
 
 func (btree *btreeTable) loadCell(icell int) (cellInfo, error) {
+	return btree.loadCellPayload(icell, true)
+}
+
+// loadCellPayload loads the cell icell. Unless overflow is set, only the
+// part of the payload stored within the page is read.
+func (btree *btreeTable) loadCellPayload(icell int, overflow bool) (cellInfo, error) {
+	if icell < 0 || icell >= len(btree.addrs) {
+		return cellInfo{}, fmt.Errorf("sqlite3: cell index out of range (%d)", icell)
+	}
 	addr := btree.addrs[icell]
-	if _, err := btree.page.Seek(int64(addr), 0); err != nil {
-		return cellInfo{}, err
+	r := btree.page.reader(int(addr))
+	cell, err := btree.parseCell(r, overflow)
+	if err != nil {
+		if e, ok := err.(*CorruptError); ok && e.Page == btree.ID() {
+			e.Cell = icell
+		}
+		return cell, err
 	}
-	return btree.parseCell()
+	cell.Cell = icell
+	return cell, nil
 }
 
-func (btree *btreeTable) parseCell() (cellInfo, error) {
+func (btree *btreeTable) parseCell(r *pageReader, overflow bool) (cellInfo, error) {
 	var cell cellInfo
 	var err error
 
+	id := btree.ID()
 	switch btree.Kind() {
-	case BTreeInteriorIndexKind:
-		panic("not implemented")
 	case BTreeInteriorTableKind:
 		var pgno int32 // page number of left child
-		err = btree.page.Decode(&pgno)
+		err = r.Decode(&pgno)
 		if err != nil {
-			return cell, fmt.Errorf("sqlite3: error decoding page number: %v", err)
+			return cell, corruptf(btree.ID(), -1, r.Pos(), "error decoding page number: %v", err)
 		}
 
-		rowid, nrow := btree.page.Varint()
+		rowid, nrow := r.Varint()
 		if nrow <= 0 {
-			return cell, fmt.Errorf("sqlite3: error decoding rowid: n=%d", nrow)
+			return cell, corruptf(btree.ID(), -1, r.Pos(), "error decoding rowid: n=%d", nrow)
 		}
 
 		signedRowid := int64(rowid)
@@ -243,109 +253,162 @@ func (btree *btreeTable) parseCell() (cellInfo, error) {
 		}
 		// fmt.Printf(">>> cell: %#v\n", cell)
 
-	case BTreeLeafIndexKind:
-		panic("not implemented")
 	case BTreeLeafTableKind:
-		sz, nsz := btree.page.Varint()
+		sz, nsz := r.Varint()
 		if nsz <= 0 {
-			return cell, fmt.Errorf("sqlite3: error decoding cell size: n=%d", nsz)
+			return cell, corruptf(id, -1, r.Pos(), "error decoding cell size: n=%d", nsz)
 		}
 
-		rowid, nrow := btree.page.Varint()
+		rowid, nrow := r.Varint()
 		if nrow <= 0 {
-			return cell, fmt.Errorf("sqlite3: error decoding rowid: n=%d", nrow)
+			return cell, corruptf(id, -1, r.Pos(), "error decoding rowid: n=%d", nrow)
 		}
 
 		signedRowid := int64(rowid)
+		cell.RowID = &signedRowid
+
+		err = btree.readPayload(r, &cell, sz, r.Pos()-nrow-nsz, overflow)
 
-		// sz is the total payload size.
-		// check if all of it is in the b-tree leaf page or
-		// if it spilled over to other pages
-		P := int(sz)
-		U := btree.page.PageSize() - int(btree.db.header.NReserved)
-		X := U - 35
-		localsz := P
-		overflowsz := 0
-		if P > X {
-			M := int(((U - 12) * 32 / 255) - 23)
-			K := M + ((P - M) % (U - 4))
-			localsz = K
-			if K > X {
-				localsz = M
+	case BTreeInteriorIndexKind, BTreeLeafIndexKind:
+		if btree.Kind() == BTreeInteriorIndexKind {
+			err = r.Decode(&cell.LeftChildPage)
+			if err != nil {
+				return cell, corruptf(id, -1, r.Pos(), "error decoding page number: %v", err)
 			}
-			overflowsz = P - localsz
 		}
 
-		// FIXME(sbinet): only create a new payload []byte when non-local
-		// ie: when there is an overflow page
-		payload := make([]byte, localsz, localsz)
-		_, err := io.ReadFull(&btree.page, payload)
-		if err != nil {
-			return cell, err
+		sz, nsz := r.Varint()
+		if nsz <= 0 {
+			return cell, corruptf(id, -1, r.Pos(), "error decoding cell size: n=%d", nsz)
 		}
 
-		cell = cellInfo{
-			RowID:   &signedRowid,
-			Payload: payload,
-		}
+		err = btree.readPayload(r, &cell, sz, r.Pos()-nsz, overflow)
 
-		if localsz != P {
-			err = btree.page.Decode(&cell.OverflowPage)
-			if err != nil {
-				return cell, err
-			}
+	default:
+		return cell, corruptf(id, -1, -1, "invalid b-tree page kind (%v)", btree.Kind())
+	}
+	return cell, err
+}
 
-			overflow, err := btree.readOverflow(cell.OverflowPage, overflowsz)
-			if err != nil {
-				return cell, err
-			}
-			cell.Payload = append(cell.Payload, overflow...)
+// readPayload reads the payload of size sz of a cell, starting at the
+// current position of r, and, if overflow is set, following its overflow
+// pages if any.
+// addr is the offset of the payload size within the page.
+func (btree *btreeTable) readPayload(r *pageReader, cell *cellInfo, sz int64, addr int, overflow bool) error {
+	id := btree.ID()
+	if sz < 0 || sz > int64(btree.db.NumPage())*int64(btree.usable()) {
+		// the payload can not be larger than the whole database.
+		return corruptf(id, -1, addr, "invalid payload size (%d)", sz)
+	}
+
+	// sz is the total payload size.
+	// check if all of it is in the b-tree page or if it spilled over
+	// to other pages
+	P := int(sz)
+	localsz := btree.localSize(P)
+
+	addr = r.Pos()
+	if len(r.Bytes()) < localsz {
+		return corruptf(id, -1, addr, "truncated cell payload (%d bytes)", localsz)
+	}
+
+	var payload []byte
+	switch {
+	case btree.db.zeroCopy && (localsz == P || !overflow):
+		// the whole payload read is local: refer to the page directly.
+		payload = r.Bytes()[:localsz:localsz]
+	default:
+		payload = make([]byte, localsz, localsz)
+		_, err := io.ReadFull(r, payload)
+		if err != nil {
+			return corruptf(id, -1, addr, "error reading cell payload: %v", err)
 		}
+	}
 
-		if len(cell.Payload) != int(sz) {
-			panic(fmt.Errorf("read %d payload bytes instead of %d", len(cell.Payload), sz))
+	cell.Payload = payload
+	cell.Page = id
+	cell.PayloadAddr = addr
+	cell.LocalSize = localsz
+	cell.PayloadSize = P
+
+	if localsz != P {
+		err := r.Decode(&cell.OverflowPage)
+		if err != nil {
+			return corruptf(id, -1, r.Pos(), "error decoding overflow page number: %v", err)
+		}
+		if !overflow {
+			return nil
 		}
 
-		// fmt.Printf(" => size=%d rowid=%d overflow=%d (bytes: %d %d) [%d]\n",
-		// 	cell.Len,
-		// 	cell.RowID,
-		// 	cell.OverflowPage,
-		// 	nsz, nrow,
-		// 	btree.page.Pos(),
-		// )
-		// fmt.Printf(" => %x (%d|%d)\n", string(cell.Payload), len(cell.Payload), localsz)
+		overflow, err := btree.readOverflow(cell.OverflowPage, P-localsz)
+		if err != nil {
+			return err
+		}
+		cell.Payload = append(cell.Payload, overflow...)
+	}
 
+	if len(cell.Payload) != P {
+		return corruptf(id, -1, addr, "read %d payload bytes instead of %d", len(cell.Payload), sz)
 	}
-	return cell, err
+	return nil
 }
 
 // readOverflow reads `size` overflow page bytes, starting at page
 // `pageNum`, following the linked list of overflow pages as
 // necessary.
 func (btree *btreeTable) readOverflow(pageNum int32, size int) ([]byte, error) {
+	return btree.readOverflowPrefix(pageNum, size, size)
+}
+
+// readOverflowPrefix reads the first `prefix` of the `size` overflow page bytes
+// starting at page `pageNum`, only loading the overflow pages holding
+// them.
+func (btree *btreeTable) readOverflowPrefix(pageNum int32, size, prefix int) ([]byte, error) {
 	usable := btree.page.PageSize() - int(btree.db.header.NReserved)
-	sizeLeft := size
+	sizeLeft := prefix
+
+	if size < 0 || size > btree.db.NumPage()*(usable-4) {
+		return nil, corruptf(btree.ID(), -1, -1, "invalid overflow size (%d)", size)
+	}
+	if prefix < 0 || prefix > size {
+		return nil, corruptf(btree.ID(), -1, -1, "invalid overflow prefix size (%d)", prefix)
+	}
 
-	result := make([]byte, 0, size)
+	result := make([]byte, 0, prefix)
 
+	prev := btree.ID()
+	seen := make(map[int32]bool)
 	for pageNum != 0 {
 		if sizeLeft == 0 {
-			return nil, fmt.Errorf("read all %d bytes but still have overflow page %d", size, pageNum)
+			if prefix < size {
+				break
+			}
+			return nil, corruptf(prev, -1, 0, "read all %d bytes but still have overflow page %d", size, pageNum)
+		}
+		if pageNum < 0 || int(pageNum) > btree.db.NumPage() {
+			return nil, corruptf(prev, -1, 0, "invalid overflow page number (%d)", pageNum)
 		}
+		if seen[pageNum] {
+			return nil, corruptf(prev, -1, 0, "overflow chain cycle through page %d", pageNum)
+		}
+		seen[pageNum] = true
 		page, err := btree.db.pager.Page(int(pageNum))
 		if err != nil {
 			return nil, err
 		}
+		btree.count()
+		prev = page.ID()
 
-		err = page.Decode(&pageNum)
+		r := page.reader(0)
+		err = r.Decode(&pageNum)
 		if err != nil {
-			return nil, err
+			return nil, corruptf(prev, -1, 0, "error decoding next overflow page number: %v", err)
 		}
 
 		buf := make([]byte, min(sizeLeft, usable-4))
-		n, err := page.Read(buf)
+		n, err := r.Read(buf)
 		if err != nil {
-			return nil, err
+			return nil, corruptf(prev, -1, 4, "error reading overflow content: %v", err)
 		}
 
 		result = append(result, buf...)
@@ -353,38 +416,162 @@ func (btree *btreeTable) readOverflow(pageNum int32, size int) ([]byte, error) {
 	}
 
 	if sizeLeft != 0 {
-		return nil, fmt.Errorf("ran out of overflow pages with %d of %d bytes left unread", sizeLeft, size)
+		return nil, corruptf(prev, -1, -1, "ran out of overflow pages with %d of %d bytes left unread", sizeLeft, size)
 	}
 
-	if len(result) != size {
-		panic(fmt.Errorf("read %d overflow bytes instead of %d", len(result), size))
+	if len(result) != prefix {
+		return nil, corruptf(prev, -1, -1, "read %d overflow bytes instead of %d", len(result), prefix)
 	}
 	return result, nil
 }
 
+// maxBtreeDepth is the maximum depth of a b-tree, as enforced by SQLite.
+const maxBtreeDepth = 20
+
+// btreeWalk tracks the pages visited during a b-tree traversal, to
+// detect cycles in the child pointers of corrupted files.
+type btreeWalk struct {
+	seen  map[int]bool
+	local bool // whether to only read the local part of cell payloads
+}
+
+func newBtreeWalk(root int) *btreeWalk {
+	return &btreeWalk{seen: map[int]bool{root: true}}
+}
+
+// child loads the child b-tree at page pgno, referenced by the cell
+// icell (or by the right-most pointer if icell is -1) of btree.
+func (btree *btreeTable) child(w *btreeWalk, depth, icell int, pgno int32) (*btreeTable, error) {
+	if pgno < 1 || int(pgno) > btree.db.NumPage() {
+		return nil, corruptf(btree.ID(), icell, -1, "invalid child page number (%d)", pgno)
+	}
+	if depth >= maxBtreeDepth {
+		return nil, corruptf(btree.ID(), icell, -1, "b-tree too deep (depth=%d)", depth)
+	}
+	if w.seen[int(pgno)] {
+		return nil, corruptf(btree.ID(), icell, -1, "b-tree cycle through page %d", pgno)
+	}
+	w.seen[int(pgno)] = true
+
+	page, err := btree.db.pager.Page(int(pgno))
+	if err != nil {
+		return nil, err
+	}
+	child, err := newBtree(page, btree.db)
+	if err != nil {
+		return nil, err
+	}
+	child.pages = btree.pages
+	child.count()
+	if child.isTable() != btree.isTable() {
+		return nil, corruptf(child.ID(), -1, child.hdrAddr(), "child page kind (%v) does not match parent page kind (%v)", child.Kind(), btree.Kind())
+	}
+	return child, nil
+}
+
+// cellRowid returns the rowid of the cell icell of a table b-tree page
+// and, for interior pages, its left child page, without reading the
+// cell payload.
+func (btree *btreeTable) cellRowid(icell int) (int64, int32, error) {
+	var (
+		addr  = int(btree.addrs[icell])
+		r     = btree.page.reader(addr)
+		child int32
+	)
+	switch btree.Kind() {
+	case BTreeInteriorTableKind:
+		if err := r.Decode(&child); err != nil {
+			return 0, 0, corruptf(btree.ID(), icell, addr, "error decoding page number: %v", err)
+		}
+	case BTreeLeafTableKind:
+		if _, n := r.Varint(); n <= 0 {
+			return 0, 0, corruptf(btree.ID(), icell, addr, "error decoding cell size: n=%d", n)
+		}
+	default:
+		return 0, 0, corruptf(btree.ID(), -1, btree.hdrAddr(), "invalid table b-tree page kind (%v)", btree.Kind())
+	}
+	rowid, n := r.Varint()
+	if n <= 0 {
+		return 0, 0, corruptf(btree.ID(), icell, r.Pos(), "error decoding rowid: n=%d", n)
+	}
+	return rowid, child, nil
+}
+
+// findRowid searches the table b-tree rooted at btree for the row with
+// the given rowid, and returns the leaf page and the index of the cell
+// holding it. The cell index is -1 if there is no such row.
+func (btree *btreeTable) findRowid(rowid int64) (*btreeTable, int, error) {
+	w := newBtreeWalk(btree.ID())
+	btree.count()
+	for depth := 0; ; depth++ {
+		// find the first cell whose rowid is greater than or equal to
+		// rowid: interior cells hold the largest rowid of their left
+		// child.
+		var err error
+		n := btree.NumCell()
+		i := sort.Search(n, func(i int) bool {
+			if err != nil {
+				return true
+			}
+			var key int64
+			key, _, err = btree.cellRowid(i)
+			return key >= rowid
+		})
+		if err != nil {
+			return nil, -1, err
+		}
+
+		if btree.isLeaf() {
+			if i < n {
+				key, _, err := btree.cellRowid(i)
+				if err != nil {
+					return nil, -1, err
+				}
+				if key == rowid {
+					return btree, i, nil
+				}
+			}
+			return btree, -1, nil
+		}
+
+		pgno, icell := btree.pointer, -1
+		if i < n {
+			_, pgno, err = btree.cellRowid(i)
+			if err != nil {
+				return nil, -1, err
+			}
+			icell = i
+		}
+		btree, err = btree.child(w, depth+1, icell, pgno)
+		if err != nil {
+			return nil, -1, err
+		}
+	}
+}
+
 // Perform inorder traversal of all cells in the btree and its
 // children, passing each raw cell to the visitor function `f`.
 func (btree *btreeTable) visitRawInorder(f func(cellInfo) error) error {
+	return btree.visitRaw(newBtreeWalk(btree.ID()), 0, f)
+}
+
+func (btree *btreeTable) visitRaw(w *btreeWalk, depth int, f func(cellInfo) error) error {
+	// interior index pages hold keys as well.
 	btreeHasData := btree.Kind() != BTreeInteriorTableKind
 
 	for i := 0; i < btree.NumCell(); i++ {
-		cell, err := btree.loadCell(i)
+		cell, err := btree.loadCellPayload(i, !w.local)
 		if err != nil {
 			return err
 		}
 
 		if cell.LeftChildPage != 0 {
-			page, err := btree.db.pager.Page(int(cell.LeftChildPage))
-			if err != nil {
-				return err
-			}
-
-			childBtree, err := newBtreeTable(page, btree.db)
+			childBtree, err := btree.child(w, depth+1, i, cell.LeftChildPage)
 			if err != nil {
 				return err
 			}
 
-			if err := childBtree.visitRawInorder(f); err != nil {
+			if err := childBtree.visitRaw(w, depth+1, f); err != nil {
 				return err
 			}
 		}
@@ -399,17 +586,12 @@ func (btree *btreeTable) visitRawInorder(f func(cellInfo) error) error {
 	}
 
 	if btree.pointer != 0 {
-		page, err := btree.db.pager.Page(int(btree.pointer))
-		if err != nil {
-			return err
-		}
-
-		childBtree, err := newBtreeTable(page, btree.db)
+		childBtree, err := btree.child(w, depth+1, -1, btree.pointer)
 		if err != nil {
 			return err
 		}
 
-		if err := childBtree.visitRawInorder(f); err != nil {
+		if err := childBtree.visitRaw(w, depth+1, f); err != nil {
 			return err
 		}
 	}
@@ -421,72 +603,144 @@ func (btree *btreeTable) visitRawInorder(f func(cellInfo) error) error {
 // children, passing the record-decoded payload of each cell to the
 // visitor function `f`.
 func (btree *btreeTable) visitRecordsInorder(f func(*int64, Record) error) error {
-	return btree.visitRawInorder(func(ci cellInfo) error {
-		if len(ci.Payload) == 0 {
-			return nil
-		}
-		if rec, err := btree.decodeRecord(ci.Payload); err != nil {
+	return btree.visitRecords(nil, f)
+}
+
+// visitRecords performs an inorder traversal of the btree, passing the
+// record-decoded payload of each cell to f.
+// If want is not nil, only the values of the record whose index is set
+// in want are decoded, and overflow pages are only read when they hold
+// some of them.
+func (btree *btreeTable) visitRecords(want []bool, f func(*int64, Record) error) error {
+	w := newBtreeWalk(btree.ID())
+	w.local = want != nil
+	btree.count()
+	return btree.visitRaw(w, 0, func(ci cellInfo) error {
+		return btree.visitCell(ci, want, f)
+	})
+}
+
+// visitCell passes the record-decoded payload of the cell ci to f, like
+// visitRecords does.
+func (btree *btreeTable) visitCell(ci cellInfo, want []bool, f func(*int64, Record) error) error {
+	if len(ci.Payload) == 0 {
+		return nil
+	}
+	payload := ci.Payload
+	if want != nil {
+		var err error
+		payload, err = btree.projectedPayload(ci, want)
+		if err != nil {
 			return err
-		} else {
-			return f(ci.RowID, rec)
 		}
-	})
+	}
+	rec, err := decodeRecord(payload, btree.db.header.DbEncoding, btree.db.zeroCopy, want)
+	if err != nil {
+		return ci.locate(err)
+	}
+	return f(ci.RowID, rec)
+}
+
+// projectedPayload returns the prefix of the payload of the cell ci,
+// read with its local part only, holding the record header and the
+// values set in want.
+func (btree *btreeTable) projectedPayload(ci cellInfo, want []bool) ([]byte, error) {
+	payload := ci.Payload
+	if len(payload) == ci.PayloadSize {
+		return payload, nil
+	}
+
+	end := ci.PayloadSize
+	if hdrsz, n := varint(payload); n > 0 && hdrsz >= int64(n) && hdrsz <= int64(len(payload)) {
+		end = recordEnd(payload[n:hdrsz], int(hdrsz), want)
+	}
+	end = min(end, ci.PayloadSize)
+	if end <= len(payload) {
+		return payload, nil
+	}
+
+	overflow, err := btree.readOverflowPrefix(ci.OverflowPage, ci.PayloadSize-len(payload), end-len(payload))
+	if err != nil {
+		return nil, err
+	}
+	return append(payload[:len(payload):len(payload)], overflow...), nil
+}
+
+// recordEnd returns the offset past the last value set in want, of the
+// record whose serial types are held by hdr and whose values start at
+// offset off. It returns a huge offset for invalid serial types.
+func recordEnd(hdr []byte, off int, want []bool) int {
+	end := off
+	for i := 0; len(hdr) > 0; i++ {
+		v, n := varint(hdr)
+		nbytes := SerialType(v).NBytes()
+		if n <= 0 || nbytes < 0 {
+			return math.MaxInt
+		}
+		hdr = hdr[n:]
+		off += nbytes
+		if i < len(want) && want[i] {
+			end = off
+		}
+	}
+	return end
 }
 
-func readStInt8(buf []byte) ([]byte, int8) {
+func readStInt8(buf []byte) ([]byte, int8, error) {
 	var v int8
 	n, err := unmarshal(buf, &v)
 	if err != nil {
-		panic(err)
+		return buf, v, err
 	}
-	return buf[int(n):], v
+	return buf[int(n):], v, nil
 }
 
-func readStInt16(buf []byte) ([]byte, int16) {
+func readStInt16(buf []byte) ([]byte, int16, error) {
 	var v int16
 	n, err := unmarshal(buf, &v)
 	if err != nil {
-		panic(err)
+		return buf, v, err
 	}
-	return buf[int(n):], v
+	return buf[int(n):], v, nil
 }
 
-func readStInt24(buf []byte) ([]byte, uint32) {
+func readStInt24(buf []byte) ([]byte, uint32, error) {
 	bs := make([]byte, 4)
 	if n := copy(bs[1:], buf); n != 3 {
-		panic(fmt.Sprintf("read %d bytes", n))
+		return buf, 0, io.ErrUnexpectedEOF
 	}
 	if bs[1]&0x80 > 0 {
 		bs[0] = 0xff
 	}
-	return buf[3:], binary.BigEndian.Uint32(bs)
+	return buf[3:], binary.BigEndian.Uint32(bs), nil
 }
 
-func readStInt32(buf []byte) ([]byte, int32) {
+func readStInt32(buf []byte) ([]byte, int32, error) {
 	var v int32
 	n, err := unmarshal(buf, &v)
 	if err != nil {
-		panic(err)
+		return buf, v, err
 	}
-	return buf[int(n):], v
+	return buf[int(n):], v, nil
 }
 
-func readStInt48(buf []byte) ([]byte, uint64) {
+func readStInt48(buf []byte) ([]byte, uint64, error) {
 	bs := make([]byte, 8)
 	if n := copy(bs[2:], buf); n != 6 {
-		panic(fmt.Sprintf("read %d bytes", n))
+		return buf, 0, io.ErrUnexpectedEOF
 	}
 	if bs[2]&0x80 > 0 {
 		bs[0] = 0xff
+		bs[1] = 0xff
 	}
-	return buf[6:], binary.BigEndian.Uint64(bs)
+	return buf[6:], binary.BigEndian.Uint64(bs), nil
 }
 
-func readStInt64(buf []byte) ([]byte, int64) {
+func readStInt64(buf []byte) ([]byte, int64, error) {
 	var v int64
 	n, err := unmarshal(buf, &v)
 	if err != nil {
-		panic(err)
+		return buf, v, err
 	}
-	return buf[int(n):], v
+	return buf[int(n):], v, nil
 }
@@ -1,12 +1,18 @@
 package sqlite3
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
 
 func TestBTree__readStInt8(t *testing.T) {
-	rbuf, res := readStInt8([]byte{0x7f})
+	rbuf, res, err := readStInt8([]byte{0x7f})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -17,7 +23,10 @@ func TestBTree__readStInt8(t *testing.T) {
 }
 
 func TestBTree__readStInt16(t *testing.T) {
-	rbuf, res := readStInt16([]byte{0x7f, 0xff})
+	rbuf, res, err := readStInt16([]byte{0x7f, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -28,7 +37,10 @@ func TestBTree__readStInt16(t *testing.T) {
 }
 
 func TestBTree__readStInt24(t *testing.T) {
-	rbuf, res := readStInt24([]byte{0x7f, 0xff, 0xff})
+	rbuf, res, err := readStInt24([]byte{0x7f, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -39,7 +51,10 @@ func TestBTree__readStInt24(t *testing.T) {
 }
 
 func TestBTree__readStInt32(t *testing.T) {
-	rbuf, res := readStInt32([]byte{0x7f, 0xff, 0xff, 0xff})
+	rbuf, res, err := readStInt32([]byte{0x7f, 0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -50,7 +65,10 @@ func TestBTree__readStInt32(t *testing.T) {
 }
 
 func TestBTree__readStInt48(t *testing.T) {
-	rbuf, res := readStInt48([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff})
+	rbuf, res, err := readStInt48([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -61,7 +79,10 @@ func TestBTree__readStInt48(t *testing.T) {
 }
 
 func TestBTree__readStInt64(t *testing.T) {
-	rbuf, res := readStInt64([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	rbuf, res, err := readStInt64([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rbuf) != 0 {
 		t.Errorf("len(rbuf)=%d", len(rbuf))
 	}
@@ -119,3 +140,162 @@ func TestBTree__decodeRecord(t *testing.T) {
 		}
 	}
 }
+
+func TestBTree__readStIntTruncated(t *testing.T) {
+	for _, read := range []func([]byte) error{
+		func(buf []byte) error { _, _, err := readStInt8(buf); return err },
+		func(buf []byte) error { _, _, err := readStInt16(buf); return err },
+		func(buf []byte) error { _, _, err := readStInt24(buf); return err },
+		func(buf []byte) error { _, _, err := readStInt32(buf); return err },
+		func(buf []byte) error { _, _, err := readStInt48(buf); return err },
+		func(buf []byte) error { _, _, err := readStInt64(buf); return err },
+	} {
+		if err := read(nil); err == nil {
+			t.Errorf("expected an error")
+		}
+	}
+
+	_, v, err := readStInt48([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xfe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := toInt64(v); got != -2 {
+		t.Errorf("got %d, expected %d", got, -2)
+	}
+}
+
+func TestBTree__decodeRecordCorrupt(t *testing.T) {
+	bt := &btreeTable{db: &DbFile{header: dbHeader{DbEncoding: 1}}}
+	for _, payload := range [][]byte{
+		{0x05},                   // header larger than payload
+		{0x02, 0x01},             // truncated StInt8
+		{0x02, 0x06, 0x01, 0x02}, // truncated StInt64
+		{0x02, 0x07, 0x01},       // truncated StFloat
+		{0x02, 0x0a},             // reserved serial type
+		{0x02, 0x21, 'a', 'b'},   // truncated text
+		{0x03, 0x81},             // truncated serial type varint
+	} {
+		_, err := bt.decodeRecord(payload)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("payload %v: invalid error: %v", payload, err)
+		}
+	}
+}
+
+func TestBTree__corruptPage(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/test-2.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// page 3 (tbl2) gets an invalid b-tree page kind.
+	raw[2*1024] = 0x03
+
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.VisitTableRecords("tbl2", func(*int64, Record) error { return nil })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("invalid error: %v", err)
+	}
+	var cerr *CorruptError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("invalid error type: %T", err)
+	}
+	if cerr.Page != 3 {
+		t.Fatalf("invalid page: got=%d, want=%d", cerr.Page, 3)
+	}
+
+	if got, want := PageKind(0x03).String(), "PageKind(0x03)"; got != want {
+		t.Fatalf("invalid page kind: got=%q, want=%q", got, want)
+	}
+	if got, want := SerialType(10).String(), "SerialType(10)"; got != want {
+		t.Fatalf("invalid serial type: got=%q, want=%q", got, want)
+	}
+}
+
+func TestBTree__cycles(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/large.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := db.Tables()[0]
+	page, err := db.pager.Page(table.pageid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt, err := newBtreeTable(page, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bt.Kind() != BTreeInteriorTableKind {
+		t.Fatalf("invalid root page kind: %v", bt.Kind())
+	}
+	overflow := int32(0)
+	err = bt.visitRawInorder(func(cell cellInfo) error {
+		if overflow == 0 {
+			overflow = cell.OverflowPage
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overflow == 0 {
+		t.Fatalf("no overflow page")
+	}
+	db.Close()
+
+	putU32 := func(buf []byte, v int32) {
+		buf[0] = byte(v >> 24)
+		buf[1] = byte(v >> 16)
+		buf[2] = byte(v >> 8)
+		buf[3] = byte(v)
+	}
+
+	for _, test := range []struct {
+		name    string
+		corrupt func(buf []byte)
+	}{
+		{
+			name: "btree",
+			corrupt: func(buf []byte) {
+				// right-most pointer of the root page points to itself.
+				off := (table.pageid-1)*db.PageSize() + 8
+				putU32(buf[off:], int32(table.pageid))
+			},
+		},
+		{
+			name: "overflow",
+			corrupt: func(buf []byte) {
+				// first overflow page points to itself.
+				off := int(overflow-1) * db.PageSize()
+				putU32(buf[off:], overflow)
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := append([]byte(nil), raw...)
+			test.corrupt(buf)
+
+			db, err := OpenFrom(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			err = db.VisitTableRecords(table.Name(), func(*int64, Record) error { return nil })
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("invalid error: %v", err)
+			}
+		})
+	}
+}
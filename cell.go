@@ -10,4 +10,29 @@ type cellInfo struct {
 	RowID         *int64
 	Payload       []byte
 	OverflowPage  int32
+
+	Page        int // page number holding the cell
+	Cell        int // index of the cell within its page
+	PayloadAddr int // offset of the payload within the page
+	LocalSize   int // number of payload bytes stored within the page
+	PayloadSize int // total number of payload bytes
+}
+
+// locate fills in the page, cell index and page offset of a corruption
+// error whose offset is relative to the start of the cell payload.
+func (ci cellInfo) locate(err error) error {
+	e, ok := err.(*CorruptError)
+	if !ok || e.Page != 0 {
+		return err
+	}
+	e.Page = ci.Page
+	e.Cell = ci.Cell
+	switch {
+	case e.Offset >= 0 && e.Offset < ci.LocalSize:
+		e.Offset += ci.PayloadAddr
+	default:
+		// offset lies within an overflow page.
+		e.Offset = -1
+	}
+	return e
 }
@@ -0,0 +1,86 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// cksumReserve is the number of reserved bytes at the end of each page
+// used by the SQLite checksum VFS (cksumvfs) to store a page checksum.
+const cksumReserve = 8
+
+// checksum computes the cksumvfs checksum of buf.
+// The length of buf must be a multiple of 8.
+func checksum(buf []byte) [cksumReserve]byte {
+	var s1, s2 uint32
+	for i := 0; i+8 <= len(buf); i += 8 {
+		s1 += binary.LittleEndian.Uint32(buf[i:]) + s2
+		s2 += binary.LittleEndian.Uint32(buf[i+4:]) + s1
+	}
+
+	var sum [cksumReserve]byte
+	binary.LittleEndian.PutUint32(sum[:4], s1)
+	binary.LittleEndian.PutUint32(sum[4:], s2)
+	return sum
+}
+
+// verifyChecksum checks the cksumvfs checksum stored in the reserved
+// bytes of the i-th page.
+func verifyChecksum(i int, buf []byte) error {
+	n := len(buf) - cksumReserve
+	sum := checksum(buf[:n])
+	if !bytes.Equal(sum[:], buf[n:]) {
+		return corruptf(i, -1, n, "checksum mismatch (got=%x, want=%x)", buf[n:], sum)
+	}
+	return nil
+}
+
+// hasChecksums returns an error if the database does not use the
+// reserved space of its pages to store cksumvfs checksums.
+func (db *DbFile) hasChecksums() error {
+	if n := int(db.header.NReserved); n != cksumReserve {
+		return fmt.Errorf("sqlite3: database has no checksums (%d reserved bytes)", n)
+	}
+	return nil
+}
+
+// VerifyChecksums verifies the cksumvfs checksums of all the pages of
+// the database and returns the numbers of the pages whose checksum does
+// not match their content.
+//
+// VerifyChecksums returns an error if the database has not been written
+// with checksums, ie: if it does not reserve 8 bytes at the end of each
+// page.
+func (db *DbFile) VerifyChecksums() ([]int, error) {
+	err := db.hasChecksums()
+	if err != nil {
+		return nil, err
+	}
+
+	var bad []int
+	for i := 1; i <= db.NumPage(); i++ {
+		if i == db.lockBytePage() {
+			continue
+		}
+		buf, err := db.pager.load(i)
+		if err != nil {
+			return bad, err
+		}
+		if verifyChecksum(i, buf) != nil {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
+
+// lockBytePage returns the page number of the lock-byte page, the page
+// holding the bytes at offsets 1073741824 through 1073742335 of the file.
+// The lock-byte page never holds any data.
+func (db *DbFile) lockBytePage() int {
+	return 1073741824/db.PageSize() + 1
+}
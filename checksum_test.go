@@ -0,0 +1,84 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	const fname = "testdata/cksumvfs.sqlite"
+
+	for _, opts := range [][]Option{
+		{WithChecksums()},
+		{WithChecksums(), WithMmap()},
+	} {
+		db, err := Open(fname, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		err = db.VisitTableRecords("notes", func(*int64, Record) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 200 {
+			t.Fatalf("invalid number of rows: got=%d, want=%d", n, 200)
+		}
+
+		bad, err := db.VerifyChecksums()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bad) != 0 {
+			t.Fatalf("invalid checksums for pages %v", bad)
+		}
+		db.Close()
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pagesz = 1024
+	for _, i := range []int{2, 5} {
+		raw[(i-1)*pagesz+pagesz/2] ^= 0xff
+	}
+
+	db, err := OpenFrom(bytes.NewReader(raw), WithChecksums())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bad, err := db.VerifyChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 5}; !reflect.DeepEqual(bad, want) {
+		t.Fatalf("invalid bad pages: got=%v, want=%v", bad, want)
+	}
+
+	err = db.VisitTableRecords("notes", func(*int64, Record) error { return nil })
+	var cerr *CorruptError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("invalid error: %v", err)
+	}
+	if cerr.Page != 2 && cerr.Page != 5 {
+		t.Fatalf("invalid corrupted page: %d", cerr.Page)
+	}
+
+	_, err = Open("testdata/test-1.sqlite", WithChecksums())
+	if err == nil {
+		t.Fatalf("expected an error opening a database without checksums")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/go-sqlite/sqlite3"
+)
+
+// analyze writes a report of the space used by each table and index of
+// the named database.
+func analyze(w io.Writer, fname string) error {
+	db, err := sqlite3.Open(fname)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", fname, err)
+	}
+	defer db.Close()
+
+	a, err := db.Analyze()
+	if err != nil {
+		return fmt.Errorf("error analyzing %q: %w", fname, err)
+	}
+
+	pct := func(n, total int) string {
+		if total == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "file:\t%s\n", fname)
+	fmt.Fprintf(tw, "page size:\t%d\n", a.PageSize)
+	fmt.Fprintf(tw, "pages:\t%d\n", a.Pages)
+	fmt.Fprintf(tw, "freelist pages:\t%d\t(%s, %d trunk pages)\n", a.FreelistPages, pct(a.FreelistPages, a.Pages), a.FreelistTrunkPages)
+	fmt.Fprintf(tw, "pointer map pages:\t%d\n", a.PointerMapPages)
+	fmt.Fprintf(tw, "lock-byte pages:\t%d\n", a.LockBytePages)
+	if n := a.Unaccounted(); n != 0 {
+		fmt.Fprintf(tw, "unaccounted pages:\t%d\n", n)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "NAME\tKIND\tDEPTH\tPAGES\tINTERIOR\tLEAF\tOVERFLOW\tENTRIES\tFANOUT\tFILL\tPAYLOAD\tOVERHEAD\tUNUSED\tFREEBLOCKS\tFRAGMENTED\t")
+	for _, bt := range a.Btrees {
+		kind := "table"
+		if bt.Index {
+			kind = "index"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f\t%.1f%%\t%d\t%d\t%d\t%d\t%d\t\n",
+			bt.Name, kind, bt.Depth,
+			bt.Pages(), bt.InteriorPages, bt.LeafPages, bt.OverflowPages,
+			bt.Entries, bt.AvgFanout(), 100*bt.Fill(),
+			bt.PayloadBytes, bt.OverheadBytes, bt.UnusedBytes,
+			bt.FreeBlocks, bt.FragmentedBytes,
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-sqlite/sqlite3"
+)
+
+// diff writes the differences between the old and new named databases,
+// as text or, with the json format, as a JSON document.
+func diff(w io.Writer, oname, nname, format, blob string) error {
+	enc, err := blobEncoding(blob)
+	if err != nil {
+		return err
+	}
+	exp := &exporter{format: format, blob: enc}
+
+	a, err := sqlite3.Open(oname)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", oname, err)
+	}
+	defer a.Close()
+
+	b, err := sqlite3.Open(nname)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", nname, err)
+	}
+	defer b.Close()
+
+	d, err := sqlite3.Diff(a, b)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	switch exp.format {
+	case "", "text":
+		err = diffText(bw, oname, nname, d)
+	case "json":
+		err = exp.diffJSON(bw, d)
+	default:
+		return fmt.Errorf("invalid diff format %q", exp.format)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+var changeMarks = map[sqlite3.ChangeKind]string{
+	sqlite3.Inserted: "+",
+	sqlite3.Deleted:  "-",
+	sqlite3.Modified: "~",
+}
+
+// diffText writes the differences as lines prefixed with +, - or ~ for
+// inserted, deleted and modified objects and rows. Values are written as
+// SQL literals.
+func diffText(w *bufio.Writer, oname, nname string, d *sqlite3.DbDiff) error {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oname, nname)
+
+	for _, c := range d.Schema {
+		fmt.Fprintf(w, "%s %s %s\n", changeMarks[c.Kind], c.Type, c.Name)
+		if c.Kind == sqlite3.Modified {
+			fmt.Fprintf(w, "    - %s\n", c.OldSQL)
+			fmt.Fprintf(w, "    + %s\n", c.NewSQL)
+		}
+	}
+
+	literals := func(vs []interface{}) (string, error) {
+		s := make([]string, len(vs))
+		for i, v := range vs {
+			lit, err := sqlLiteral(v)
+			if err != nil {
+				return "", err
+			}
+			s[i] = lit
+		}
+		return strings.Join(s, ", "), nil
+	}
+
+	for _, t := range d.Tables {
+		fmt.Fprintf(w, "table %s (%s):\n", t.Name, strings.Join(t.Key, ", "))
+		for _, row := range t.Rows {
+			key, err := literals(row.Key)
+			if err != nil {
+				return err
+			}
+			switch row.Kind {
+			case sqlite3.Inserted, sqlite3.Deleted:
+				vs := row.New
+				if row.Kind == sqlite3.Deleted {
+					vs = row.Old
+				}
+				values, err := literals(vs)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s %s: (%s)\n", changeMarks[row.Kind], key, values)
+			case sqlite3.Modified:
+				fmt.Fprintf(w, "~ %s:", key)
+				for i, c := range row.Changes {
+					old, err := sqlLiteral(c.Old)
+					if err != nil {
+						return err
+					}
+					cur, err := sqlLiteral(c.New)
+					if err != nil {
+						return err
+					}
+					sep := " "
+					if i > 0 {
+						sep = ", "
+					}
+					fmt.Fprintf(w, "%s%s: %s -> %s", sep, c.Column, old, cur)
+				}
+				w.WriteString("\n")
+			}
+		}
+	}
+	return nil
+}
+
+type jsonDiff struct {
+	Schema []jsonSchemaChange `json:"schema"`
+	Tables []jsonTableDiff    `json:"tables"`
+}
+
+type jsonSchemaChange struct {
+	Change string `json:"change"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	OldSQL string `json:"old_sql,omitempty"`
+	NewSQL string `json:"new_sql,omitempty"`
+}
+
+type jsonTableDiff struct {
+	Name    string          `json:"name"`
+	Key     []string        `json:"key"`
+	Columns []string        `json:"columns"`
+	Rows    []jsonRowChange `json:"rows"`
+}
+
+type jsonRowChange struct {
+	Change  string                      `json:"change"`
+	Key     []json.RawMessage           `json:"key"`
+	Old     []json.RawMessage           `json:"old,omitempty"`
+	New     []json.RawMessage           `json:"new,omitempty"`
+	Changes map[string]jsonColumnChange `json:"changes,omitempty"`
+}
+
+type jsonColumnChange struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+// diffJSON writes the differences as a JSON document. Values are
+// encoded as in JSON exports.
+func (exp *exporter) diffJSON(w *bufio.Writer, d *sqlite3.DbDiff) error {
+	values := func(vs []interface{}) ([]json.RawMessage, error) {
+		if vs == nil {
+			return nil, nil
+		}
+		raw := make([]json.RawMessage, len(vs))
+		for i, v := range vs {
+			s, err := exp.jsonValue(v)
+			if err != nil {
+				return nil, err
+			}
+			raw[i] = json.RawMessage(s)
+		}
+		return raw, nil
+	}
+
+	out := jsonDiff{
+		Schema: []jsonSchemaChange{},
+		Tables: []jsonTableDiff{},
+	}
+	for _, c := range d.Schema {
+		out.Schema = append(out.Schema, jsonSchemaChange{
+			Change: c.Kind.String(),
+			Type:   c.Type,
+			Name:   c.Name,
+			OldSQL: c.OldSQL,
+			NewSQL: c.NewSQL,
+		})
+	}
+	for _, t := range d.Tables {
+		td := jsonTableDiff{
+			Name:    t.Name,
+			Key:     t.Key,
+			Columns: t.Columns,
+			Rows:    []jsonRowChange{},
+		}
+		for _, row := range t.Rows {
+			var (
+				jr  = jsonRowChange{Change: row.Kind.String()}
+				err error
+			)
+			if jr.Key, err = values(row.Key); err != nil {
+				return err
+			}
+			if jr.Old, err = values(row.Old); err != nil {
+				return err
+			}
+			if jr.New, err = values(row.New); err != nil {
+				return err
+			}
+			for _, c := range row.Changes {
+				if jr.Changes == nil {
+					jr.Changes = make(map[string]jsonColumnChange)
+				}
+				old, err := exp.jsonValue(c.Old)
+				if err != nil {
+					return err
+				}
+				cur, err := exp.jsonValue(c.New)
+				if err != nil {
+					return err
+				}
+				jr.Changes[c.Column] = jsonColumnChange{
+					Old: json.RawMessage(old),
+					New: json.RawMessage(cur),
+				}
+			}
+			td.Rows = append(td.Rows, jr)
+		}
+		out.Tables = append(out.Tables, td)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
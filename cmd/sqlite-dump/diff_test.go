@@ -0,0 +1,90 @@
+// Copyright 2018 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	const (
+		a = "../../testdata/diff-a.sqlite"
+		b = "../../testdata/diff-b.sqlite"
+	)
+
+	var buf bytes.Buffer
+	err := diff(&buf, a, b, "", "hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- ../../testdata/diff-a.sqlite
++++ ../../testdata/diff-b.sqlite
+- index users_email
+- table gone
+~ view top
+    - CREATE VIEW top as select * from users where score > 10
+    + CREATE VIEW top as select * from users where score > 5
++ table added
+table users (rowid):
+~ 1: avatar: X'0102' -> X'0103'
+~ 2: email: 'bob@example.com' -> 'bob@example.org', score: 3.0 -> 4.0
+- 4: (4, 'dave', 'dave@example.com', 1.0, NULL)
++ 5: (5, 'eve', 'eve@example.com', 7.0, NULL)
+table tags (tag, lang):
+- 'blue', 'en': ('blue', 'en', 'Blue')
++ 'green', 'en': ('green', 'en', 'Green')
+~ 'red', 'fr': label: 'Rouge' -> 'Rouge!'
+table gone (rowid):
+- 1: (1)
+- 2: (2)
+table added (rowid):
++ 1: ('new')
+`
+	if got := buf.String(); got != want {
+		t.Errorf("text diff:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	err = diff(&buf, a, b, "json", "hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Schema []struct {
+			Change, Type, Name string
+		}
+		Tables []struct {
+			Name string
+			Rows []struct {
+				Change  string
+				Key     []interface{}
+				Changes map[string]struct{ Old, New interface{} }
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON diff: %v\n%s", err, buf.String())
+	}
+	if len(doc.Schema) != 4 || len(doc.Tables) != 4 {
+		t.Fatalf("invalid JSON diff:\n%s", buf.String())
+	}
+	row := doc.Tables[0].Rows[0]
+	blob := func(v interface{}) interface{} {
+		if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+			return m["$blob"]
+		}
+		return nil
+	}
+	if row.Change != "modified" || blob(row.Changes["avatar"].Old) != "0102" || blob(row.Changes["avatar"].New) != "0103" {
+		t.Errorf("invalid JSON row change: %+v", row)
+	}
+
+	err = diff(&buf, a, b, "csv", "hex")
+	if err == nil {
+		t.Errorf("expected an error for the csv format")
+	}
+}
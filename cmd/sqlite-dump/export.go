@@ -0,0 +1,485 @@
+// Copyright 2018 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-sqlite/sqlite3"
+)
+
+// exporter exports the content of a database in a given format.
+type exporter struct {
+	format string // csv, json, jsonl or sql
+	table  string // name of the table to export
+	blob   func([]byte) string
+}
+
+func newExporter(format, table, blob string) (*exporter, error) {
+	exp := exporter{format: format, table: table}
+	switch format {
+	case "csv", "json", "jsonl":
+		if table == "" {
+			return nil, fmt.Errorf("-format=%s requires a -table", format)
+		}
+	case "sql":
+	default:
+		return nil, fmt.Errorf("invalid export format %q", format)
+	}
+
+	var err error
+	exp.blob, err = blobEncoding(blob)
+	if err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
+
+// blobEncoding returns the function encoding BLOBs in CSV and JSON.
+func blobEncoding(name string) (func([]byte) string, error) {
+	switch name {
+	case "base64":
+		return base64.StdEncoding.EncodeToString, nil
+	case "hex":
+		return hex.EncodeToString, nil
+	}
+	return nil, fmt.Errorf("invalid BLOB encoding %q", name)
+}
+
+func (exp *exporter) export(w io.Writer, db *sqlite3.DbFile) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch exp.format {
+	case "csv":
+		err = exp.exportCSV(bw, db)
+	case "json":
+		err = exp.exportJSON(bw, db, false)
+	case "jsonl":
+		err = exp.exportJSON(bw, db, true)
+	case "sql":
+		err = exp.exportSQL(bw, db)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// source is a table or a view whose rows are exported.
+type source struct {
+	name  string
+	cols  []string       // names of the columns
+	table *sqlite3.Table // nil for views
+}
+
+// lookup returns the table or the view with the given name.
+func lookup(db *sqlite3.DbFile, name string) (*source, error) {
+	if t := db.Table(name); t != nil {
+		src := &source{name: t.Name(), table: t}
+		for _, col := range t.Columns() {
+			src.cols = append(src.cols, col.Name())
+		}
+		return src, nil
+	}
+	if v := db.View(name); v != nil {
+		cols, err := v.Columns()
+		if err != nil {
+			return nil, err
+		}
+		return &source{name: v.Name(), cols: cols}, nil
+	}
+	return nil, fmt.Errorf("unknown table %q", name)
+}
+
+// visitRows calls f with the values of each row of the table or view src.
+// Values are normalized to nil, int64, float64, string or []byte, with
+// the integers of REAL columns converted to float64 like SQLite does, and
+// the rowid is substituted for the INTEGER PRIMARY KEY column of tables.
+func visitRows(db *sqlite3.DbFile, src *source, f func(vs []interface{}) error) error {
+	if src.table == nil {
+		return db.VisitTableRecords(src.name, func(_ *int64, rec sqlite3.Record) error {
+			vs := make([]interface{}, len(rec.Values))
+			for i := range rec.Values {
+				vs[i] = normalize(rec.Values[i])
+			}
+			return f(vs)
+		})
+	}
+
+	cols := src.table.Columns()
+	ncols := len(cols)
+	alias := src.table.RowidAlias()
+	isReal := make([]bool, ncols)
+	for i := range cols {
+		isReal[i] = cols[i].Affinity() == "REAL"
+	}
+	return db.VisitTableRecords(src.name, func(rowid *int64, rec sqlite3.Record) error {
+		n := len(rec.Values)
+		if n < ncols {
+			// rows written before an ALTER TABLE ADD COLUMN
+			// hold the default values of the added columns.
+			n = ncols
+		}
+		vs := make([]interface{}, n)
+		for i := range rec.Values {
+			vs[i] = normalize(rec.Values[i])
+		}
+		for i := len(rec.Values); i < ncols; i++ {
+			vs[i] = cols[i].Default()
+		}
+		if alias >= 0 && alias < n && vs[alias] == nil && rowid != nil {
+			vs[alias] = *rowid
+		}
+		for i, v := range vs {
+			if iv, ok := v.(int64); ok && i < ncols && isReal[i] {
+				vs[i] = float64(iv)
+			}
+		}
+		return f(vs)
+	})
+}
+
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case uint32:
+		// 24-bit integers are sign-extended to 32 bits.
+		return int64(int32(v))
+	case int32:
+		return int64(v)
+	case uint64:
+		// 48-bit integers are sign-extended to 64 bits.
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return v
+}
+
+// exportCSV writes the rows of the table or view as CSV, with a header
+// line. NULL is written as an empty field and the empty string as "".
+// CSV has no types: BLOBs are written as their encoded bytes, which read
+// back as TEXT.
+func (exp *exporter) exportCSV(w *bufio.Writer, db *sqlite3.DbFile) error {
+	src, err := lookup(db, exp.table)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range src.cols {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(csvQuote(name, false))
+	}
+	w.WriteString("\n")
+
+	return visitRows(db, src, func(vs []interface{}) error {
+		for i, v := range vs {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			switch v := v.(type) {
+			case nil:
+			case int64:
+				w.WriteString(strconv.FormatInt(v, 10))
+			case float64:
+				w.WriteString(formatFloat(v))
+			case string:
+				w.WriteString(csvQuote(v, true))
+			case []byte:
+				w.WriteString(exp.blob(v))
+			default:
+				return fmt.Errorf("invalid value type %T", v)
+			}
+		}
+		_, err := w.WriteString("\n")
+		return err
+	})
+}
+
+// csvQuote quotes s if needed. Empty strings are quoted if empty is true.
+func csvQuote(s string, empty bool) string {
+	if (s == "" && empty) || strings.ContainsAny(s, "\",\r\n") ||
+		strings.HasPrefix(s, " ") || strings.HasSuffix(s, " ") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+// exportJSON writes the rows of the table or view as JSON objects keyed by
+// column names, either as a JSON array or, if lines is true, one object
+// per line.
+// Infinite floats, which JSON can not represent, are written as 9e999 and
+// -9e999, like the SQLite JSON functions do.
+func (exp *exporter) exportJSON(w *bufio.Writer, db *sqlite3.DbFile, lines bool) error {
+	src, err := lookup(db, exp.table)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, name := range src.cols {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, string(key))
+	}
+
+	if !lines {
+		w.WriteString("[")
+	}
+	nrows := 0
+	err = visitRows(db, src, func(vs []interface{}) error {
+		if !lines && nrows > 0 {
+			w.WriteString(",")
+		}
+		if !lines {
+			w.WriteString("\n")
+		}
+		nrows++
+
+		w.WriteString("{")
+		for i, v := range vs {
+			if i >= len(keys) {
+				break
+			}
+			if i > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(keys[i])
+			w.WriteString(":")
+			s, err := exp.jsonValue(v)
+			if err != nil {
+				return err
+			}
+			w.WriteString(s)
+		}
+		w.WriteString("}")
+		if lines {
+			w.WriteString("\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !lines {
+		if nrows > 0 {
+			w.WriteString("\n")
+		}
+		w.WriteString("]\n")
+	}
+	return nil
+}
+
+// jsonValue returns the JSON encoding of the value v. BLOBs are encoded
+// as {"$blob":"..."} objects holding their encoded bytes, so that they are
+// not read back as TEXT, and infinite values as out-of-range numbers.
+func (exp *exporter) jsonValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "null", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, +1):
+			return "9e999", nil
+		case math.IsInf(v, -1):
+			return "-9e999", nil
+		}
+		return formatFloat(v), nil
+	case string:
+		s, err := json.Marshal(v)
+		return string(s), err
+	case []byte:
+		s, err := json.Marshal(exp.blob(v))
+		return `{"$blob":` + string(s) + `}`, err
+	}
+	return "", fmt.Errorf("invalid value type %T", v)
+}
+
+// exportSQL writes the schema and content of the database (or of the
+// selected table) as SQL statements, like the .dump command of the
+// sqlite3 shell.
+func (exp *exporter) exportSQL(w *bufio.Writer, db *sqlite3.DbFile) error {
+	schema := db.Schema()
+
+	selected := func(obj sqlite3.SchemaObject) bool {
+		return exp.table == "" || strings.EqualFold(obj.TableName, exp.table)
+	}
+
+	w.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	// tables in schema order, with sqlite_sequence last.
+	var (
+		analyzed = false
+		seq      *sqlite3.SchemaObject
+	)
+	for i, obj := range schema {
+		if obj.Type != "table" || obj.SQL == "" || !selected(obj) {
+			continue
+		}
+		switch {
+		case obj.Name == "sqlite_sequence":
+			seq = &schema[i]
+			continue
+		case strings.HasPrefix(obj.Name, "sqlite_stat"):
+			if !analyzed {
+				w.WriteString("ANALYZE sqlite_master;\n")
+				analyzed = true
+			}
+		case strings.HasPrefix(obj.Name, "sqlite_"):
+			continue
+		default:
+			w.WriteString(obj.SQL + ";\n")
+		}
+
+		if obj.RootPage == 0 {
+			// virtual tables have no content.
+			continue
+		}
+		err := exp.insertRows(w, db, obj.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if seq != nil {
+		w.WriteString("DELETE FROM sqlite_sequence;\n")
+		err := exp.insertRows(w, db, seq.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range schema {
+		if obj.Type == "table" || obj.SQL == "" || !selected(obj) {
+			continue
+		}
+		w.WriteString(obj.SQL + ";\n")
+	}
+	_, err := w.WriteString("COMMIT;\n")
+	return err
+}
+
+// insertRows writes an INSERT statement for each row of the named table.
+func (exp *exporter) insertRows(w *bufio.Writer, db *sqlite3.DbFile, name string) error {
+	src, err := lookup(db, name)
+	if err != nil {
+		return err
+	}
+	prefix := "INSERT INTO " + quoteIdent(name) + " VALUES("
+	return visitRows(db, src, func(vs []interface{}) error {
+		w.WriteString(prefix)
+		for i, v := range vs {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			s, err := sqlLiteral(v)
+			if err != nil {
+				return err
+			}
+			w.WriteString(s)
+		}
+		_, err := w.WriteString(");\n")
+		return err
+	})
+}
+
+// sqlLiteral returns the SQL literal of the value v.
+func sqlLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, +1):
+			return "1e999", nil
+		case math.IsInf(v, -1):
+			return "-1e999", nil
+		}
+		return formatFloat(v), nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	}
+	return "", fmt.Errorf("invalid value type %T", v)
+}
+
+// formatFloat formats v with the shortest representation that round-trips,
+// always including a decimal point or an exponent so that it is read back
+// as a REAL. Infinite values are formatted as Inf and -Inf.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// quoteIdent quotes the identifier s if it is not a plain identifier.
+func quoteIdent(s string) string {
+	plain := s != "" && !sqlKeywords[strings.ToUpper(s)]
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			plain = false
+		}
+	}
+	if plain {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+var sqlKeywords = make(map[string]bool)
+
+func init() {
+	for _, kw := range strings.Fields(`
+		ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE
+		COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED
+		DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT
+		EXCLUDE EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST FOLLOWING FOR
+		FOREIGN FROM FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE
+		IMMEDIATE IN INDEX INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT
+		INTO IS ISNULL JOIN KEY LAST LEFT LIKE LIMIT MATCH MATERIALIZED
+		NATURAL NO NOT NOTHING NOTNULL NULL NULLS OF OFFSET ON OR ORDER
+		OTHERS OUTER OVER PARTITION PLAN PRAGMA PRECEDING PRIMARY QUERY RAISE
+		RANGE RECURSIVE REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE
+		RESTRICT RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET TABLE
+		TEMP TEMPORARY THEN TIES TO TRANSACTION TRIGGER UNBOUNDED UNION UNIQUE
+		UPDATE USING VACUUM VALUES VIEW VIRTUAL WHEN WHERE WINDOW WITH WITHOUT
+	`) {
+		sqlKeywords[kw] = true
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	for _, tc := range []struct {
+		fname  string
+		format string
+		table  string
+		want   string
+	}{
+		{
+			fname:  "../../testdata/test-2.sqlite",
+			format: "sql",
+			want: `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE tbl1(one varchar(10), two smallint);
+INSERT INTO tbl1 VALUES('hello!',10);
+INSERT INTO tbl1 VALUES('goodbye',20);
+CREATE TABLE tbl2(
+ f1 varchar(30) primary key,
+ f2 text,
+ f3 real
+);
+COMMIT;
+`,
+		},
+		{
+			fname:  "../../testdata/test-1.sqlite",
+			format: "csv",
+			table:  "tbl1",
+			want:   "one,two\nhello!,10\ngoodbye,20\n",
+		},
+		{
+			fname:  "../../testdata/test-1.sqlite",
+			format: "jsonl",
+			table:  "tbl1",
+			want:   "{\"one\":\"hello!\",\"two\":10}\n{\"one\":\"goodbye\",\"two\":20}\n",
+		},
+		{
+			fname:  "../../testdata/test-1.sqlite",
+			format: "json",
+			table:  "tbl1",
+			want:   "[\n{\"one\":\"hello!\",\"two\":10},\n{\"one\":\"goodbye\",\"two\":20}\n]\n",
+		},
+		{
+			fname:  "../../testdata/diff-a.sqlite",
+			format: "jsonl",
+			table:  "users",
+			want: `{"id":1,"name":"alice","email":"alice@example.com","score":12.5,"avatar":{"$blob":"AQI="}}
+{"id":2,"name":"bob","email":"bob@example.com","score":3.0,"avatar":null}
+{"id":3,"name":"carol","email":null,"score":20.0,"avatar":{"$blob":"/w=="}}
+{"id":4,"name":"dave","email":"dave@example.com","score":1.0,"avatar":null}
+`,
+		},
+		{
+			fname:  "../../testdata/diff-a.sqlite",
+			format: "csv",
+			table:  "top",
+			want:   "id,name,email,score,avatar\n1,alice,alice@example.com,12.5,AQI=\n3,carol,,20.0,/w==\n",
+		},
+		{
+			fname:  "../../testdata/diff-a.sqlite",
+			format: "jsonl",
+			table:  "top",
+			want: `{"id":1,"name":"alice","email":"alice@example.com","score":12.5,"avatar":{"$blob":"AQI="}}
+{"id":3,"name":"carol","email":null,"score":20.0,"avatar":{"$blob":"/w=="}}
+`,
+		},
+	} {
+		t.Run(tc.format+":"+tc.table, func(t *testing.T) {
+			exp, err := newExporter(tc.format, tc.table, "base64")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = export(&buf, tc.fname, exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Fatalf("invalid output:\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCSVQuote(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want string
+	}{
+		{"", `""`},
+		{"a", "a"},
+		{"a,b", `"a,b"`},
+		{`a"b`, `"a""b"`},
+		{"a\nb", "\"a\nb\""},
+		{" a", `" a"`},
+	} {
+		if got := csvQuote(tc.s, true); got != tc.want {
+			t.Errorf("csvQuote(%q): got=%s, want=%s", tc.s, got, tc.want)
+		}
+	}
+}
@@ -18,13 +18,44 @@
 //  sqlite3: cols: 2
 //  sqlite3: col[0]: "one"
 //  sqlite3: col[1]: "two"
+//
+// With the -format flag, sqlite-dump exports the content of a table or a
+// view as CSV, JSON or JSON Lines, or of the whole database as SQL
+// statements that rebuild it:
+//
+//  $> sqlite-dump -format=csv -table=tbl1 ./testdata/test-1.sqlite
+//  one,two
+//  hello!,10
+//  goodbye,20
+//
+//  $> sqlite-dump -format=sql ./testdata/test-1.sqlite | sqlite3 new.sqlite
+//
+// BLOBs are encoded in base64 or, with -blob=hex, in hexadecimal. JSON
+// exports write them as {"$blob":"..."} objects, but CSV has no types: a
+// BLOB can not be told apart from TEXT holding its encoding.
+//
+// The analyze command reports the space used by each table and index:
+//
+//  $> sqlite-dump analyze ./testdata/test-1.sqlite
+//
+// The page command identifies and decodes a page, and shows an annotated
+// hexdump of its content:
+//
+//  $> sqlite-dump page 2 ./testdata/test-1.sqlite
+//
+// The diff command reports the schema and row changes between two
+// databases, as text or, with -format=json, as JSON:
+//
+//  $> sqlite-dump diff old.sqlite new.sqlite
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/go-sqlite/sqlite3"
 )
@@ -33,11 +64,18 @@ func main() {
 	log.SetPrefix("sqlite3: ")
 	log.SetFlags(0)
 
-	flag.Parse()
+	var (
+		format = flag.String("format", "", "export format (csv, json, jsonl or sql)")
+		table  = flag.String("table", "", "name of the table or view to export")
+		blob   = flag.String("blob", "base64", "encoding of BLOBs in CSV and JSON (base64 or hex)")
+	)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr,
 			`Usage: sqlite-dump [options] file1 [file2 [...]]
+       sqlite-dump analyze file1 [file2 [...]]
+       sqlite-dump page N file
+       sqlite-dump [-format=json] diff old new
 
 Ex:
 
@@ -53,22 +91,115 @@ Ex:
  sqlite3: col[0]: "one"
  sqlite3: col[1]: "two"
 
+ $> sqlite-dump -format=csv -table=tbl1 ./testdata/test-1.sqlite
+ one,two
+ hello!,10
+ goodbye,20
+
+BLOBs are written in JSON as {"$blob":"..."} objects. In CSV, they can not
+be told apart from TEXT holding their encoding.
+
 Options:
 `,
 		)
+		flag.PrintDefaults()
 	}
 
+	flag.Parse()
+
 	if flag.NArg() < 1 {
 		flag.Usage()
-		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	if flag.Arg(0) == "analyze" {
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		for i, fname := range flag.Args()[1:] {
+			if i > 0 {
+				fmt.Println()
+			}
+			err := analyze(os.Stdout, fname)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	if flag.Arg(0) == "page" {
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		n, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("invalid page number %q: %v", flag.Arg(1), err)
+		}
+		err = inspect(os.Stdout, flag.Arg(2), n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "diff" {
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err := diff(os.Stdout, flag.Arg(1), flag.Arg(2), *format, *blob)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *format != "" {
+		exp, err := newExporter(*format, *table, *blob)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, fname := range flag.Args() {
+			err := export(os.Stdout, fname, exp)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
 	for _, fname := range flag.Args() {
 		process(fname)
 	}
 }
 
+func inspect(w io.Writer, fname string, n int) error {
+	f, err := sqlite3.Open(fname)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", fname, err)
+	}
+	defer f.Close()
+
+	info, err := f.InspectPage(n)
+	if err != nil {
+		return err
+	}
+	return info.Dump(w)
+}
+
+func export(w io.Writer, fname string, exp *exporter) error {
+	f, err := sqlite3.Open(fname)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", fname, err)
+	}
+	defer f.Close()
+
+	return exp.export(w, f)
+}
+
 func process(fname string) {
 	log.Printf("opening %q...", fname)
 	f, err := sqlite3.Open(fname)
@@ -0,0 +1,289 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// PageCodec transforms the content of the pages of a database file,
+// eg: to encrypt or decrypt them.
+//
+// PageCodec values are used concurrently by multiple goroutines.
+type PageCodec interface {
+	// Decode returns the content of the i-th page, given its raw
+	// content as read from the file.
+	Decode(i int, raw []byte) ([]byte, error)
+
+	// Encode returns the raw content of the i-th page, as it should
+	// be written to the file.
+	Encode(i int, page []byte) ([]byte, error)
+}
+
+// codecPageSizer is implemented by page codecs that know the page size
+// of the database: the page size recorded in the file header may not be
+// readable before the first page has been decoded.
+type codecPageSizer interface {
+	pageSize() int
+}
+
+// WithCodec requests all the pages read from the database file to be
+// decoded with c.
+func WithCodec(c PageCodec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithSQLCipher requests the database file to be decrypted as a
+// SQLCipher 4 database, with the default SQLCipher 4 settings and the
+// provided passphrase.
+func WithSQLCipher(passphrase string) Option {
+	return WithCodec(&SQLCipher{Passphrase: passphrase})
+}
+
+// ErrSQLCipherAuth is returned when a page of a SQLCipher database fails
+// its HMAC check: the passphrase is wrong or the page has been tampered
+// with.
+var ErrSQLCipherAuth = errors.New("sqlite3: sqlcipher: HMAC check failed")
+
+const (
+	sqlcipherSaltSize   = 16
+	sqlcipherKeySize    = 32
+	sqlcipherIVSize     = aes.BlockSize
+	sqlcipherHMACSize   = sha512.Size
+	sqlcipherReserve    = sqlcipherIVSize + sqlcipherHMACSize // already a multiple of the AES block size
+	sqlcipherPageSize   = 4096
+	sqlcipherKDFIter    = 256000
+	sqlcipherFastIter   = 2
+	sqlcipherHMACMask   = 0x3a
+	sqlcipherHeaderSize = 16 // the salt replaces the magic header string on page 1
+)
+
+// SQLCipher is a PageCodec for databases encrypted by SQLCipher 4.
+//
+// Each page is encrypted with AES-256-CBC, using a random IV stored in
+// the reserved space at the end of the page, next to a HMAC-SHA512 of
+// the encrypted content.
+// The encryption key is derived from the passphrase with PBKDF2-HMAC-SHA512,
+// salted with the first 16 bytes of the file.
+//
+// A SQLCipher value holds the keys of a single database file.
+type SQLCipher struct {
+	Passphrase string
+	PageSize   int // page size of the database, 4096 if zero
+	KDFIter    int // number of PBKDF2 iterations, 256000 if zero
+
+	// Salt is the KDF salt used to encode the first page of a new
+	// database. When decoding, the salt is read from the first page.
+	// A random salt is generated when encoding if Salt is nil.
+	Salt []byte
+
+	// Rand is the source of the salt and IVs generated when encoding.
+	// crypto/rand.Reader is used if Rand is nil.
+	Rand io.Reader
+
+	mu   sync.Mutex
+	salt []byte // salt used to derive key and hkey
+	key  []byte // AES-256 encryption key
+	hkey []byte // HMAC-SHA512 key
+}
+
+func (c *SQLCipher) pageSize() int {
+	if c.PageSize == 0 {
+		return sqlcipherPageSize
+	}
+	return c.PageSize
+}
+
+func (c *SQLCipher) rand() io.Reader {
+	if c.Rand == nil {
+		return rand.Reader
+	}
+	return c.Rand
+}
+
+// keys returns the encryption and HMAC keys derived from the provided salt.
+func (c *SQLCipher) keys(salt []byte) (key, hkey []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case salt == nil && c.salt == nil:
+		return nil, nil, fmt.Errorf("sqlite3: sqlcipher: first page not decoded yet")
+	case salt == nil, bytes.Equal(salt, c.salt):
+		return c.key, c.hkey, nil
+	}
+
+	iter := c.KDFIter
+	if iter == 0 {
+		iter = sqlcipherKDFIter
+	}
+
+	key = pbkdf2([]byte(c.Passphrase), salt, iter, sqlcipherKeySize, sha512.New)
+
+	hsalt := make([]byte, len(salt))
+	for i, v := range salt {
+		hsalt[i] = v ^ sqlcipherHMACMask
+	}
+	hkey = pbkdf2(key, hsalt, sqlcipherFastIter, sqlcipherKeySize, sha512.New)
+
+	c.salt = append([]byte(nil), salt...)
+	c.key = key
+	c.hkey = hkey
+	return key, hkey, nil
+}
+
+// mac computes the HMAC of the encrypted content and IV of the i-th page.
+func (c *SQLCipher) mac(hkey []byte, i int, data []byte) []byte {
+	var pgno [4]byte
+	binary.LittleEndian.PutUint32(pgno[:], uint32(i))
+
+	h := hmac.New(sha512.New, hkey)
+	h.Write(data)
+	h.Write(pgno[:])
+	return h.Sum(nil)
+}
+
+// Decode decrypts the raw content of the i-th page.
+func (c *SQLCipher) Decode(i int, raw []byte) ([]byte, error) {
+	if len(raw) != c.pageSize() {
+		return nil, fmt.Errorf("sqlite3: sqlcipher: invalid page size (%d)", len(raw))
+	}
+
+	var (
+		salt []byte
+		beg  = 0
+		end  = len(raw) - sqlcipherReserve
+	)
+	if i == 1 {
+		salt = raw[:sqlcipherSaltSize]
+		beg = sqlcipherHeaderSize
+	}
+
+	key, hkey, err := c.keys(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		iv   = raw[end : end+sqlcipherIVSize]
+		sum  = raw[end+sqlcipherIVSize : end+sqlcipherIVSize+sqlcipherHMACSize]
+		want = c.mac(hkey, i, raw[beg:end+sqlcipherIVSize])
+	)
+	if !hmac.Equal(sum, want) {
+		return nil, ErrSQLCipherAuth
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]byte, len(raw))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(page[beg:end], raw[beg:end])
+	copy(page[end:], raw[end:])
+	if i == 1 {
+		copy(page, sqlite3Magic)
+	}
+	return page, nil
+}
+
+// Encode encrypts the content of the i-th page.
+func (c *SQLCipher) Encode(i int, page []byte) ([]byte, error) {
+	if len(page) != c.pageSize() {
+		return nil, fmt.Errorf("sqlite3: sqlcipher: invalid page size (%d)", len(page))
+	}
+
+	var (
+		salt []byte
+		beg  = 0
+		end  = len(page) - sqlcipherReserve
+		raw  = make([]byte, len(page))
+	)
+	if i == 1 {
+		salt = c.Salt
+		if salt == nil {
+			salt = make([]byte, sqlcipherSaltSize)
+			if _, err := io.ReadFull(c.rand(), salt); err != nil {
+				return nil, err
+			}
+		}
+		if len(salt) != sqlcipherSaltSize {
+			return nil, fmt.Errorf("sqlite3: sqlcipher: invalid salt size (%d)", len(salt))
+		}
+		copy(raw, salt)
+		beg = sqlcipherHeaderSize
+	}
+
+	key, hkey, err := c.keys(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the reserved space is filled with random bytes, the IV first.
+	if _, err := io.ReadFull(c.rand(), raw[end:]); err != nil {
+		return nil, err
+	}
+	iv := raw[end : end+sqlcipherIVSize]
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(raw[beg:end], page[beg:end])
+
+	sum := c.mac(hkey, i, raw[beg:end+sqlcipherIVSize])
+	copy(raw[end+sqlcipherIVSize:], sum)
+	return raw, nil
+}
+
+// pbkdf2 derives a key of the given length from password and salt, as
+// described in RFC 8018.
+func pbkdf2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var (
+		size   = prf.Size()
+		nblock = (keyLen + size - 1) / size
+		key    = make([]byte, 0, nblock*size)
+		buf    [4]byte
+		u      = make([]byte, size)
+	)
+	for block := 1; block <= nblock; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		u = prf.Sum(u[:0])
+
+		t := append([]byte(nil), u...)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+var (
+	_ PageCodec      = (*SQLCipher)(nil)
+	_ codecPageSizer = (*SQLCipher)(nil)
+)
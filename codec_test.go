@@ -0,0 +1,108 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// encryptSQLCipher encrypts the plain database raw with SQLCipher,
+// deterministically.
+func encryptSQLCipher(t *testing.T, raw []byte, passphrase string) []byte {
+	c := &SQLCipher{
+		Passphrase: passphrase,
+		Salt:       []byte("0123456789abcdef"),
+		Rand:       rand.New(rand.NewSource(1234)),
+	}
+	pagesz := c.pageSize()
+	out := make([]byte, 0, len(raw))
+	for i := 0; i*pagesz < len(raw); i++ {
+		page, err := c.Encode(i+1, raw[i*pagesz:(i+1)*pagesz])
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, page...)
+	}
+	return out
+}
+
+func TestSQLCipher(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/sqlcipher-plain.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := encryptSQLCipher(t, raw, "s3cr3t")
+	if !bytes.Equal(enc, encryptSQLCipher(t, raw, "s3cr3t")) {
+		t.Fatalf("encryption is not deterministic")
+	}
+	if bytes.Contains(enc, []byte("secret-001")) {
+		t.Fatalf("database not encrypted")
+	}
+
+	dump := func(db *DbFile) []Record {
+		var recs []Record
+		err := db.VisitTableRecords("secrets", func(_ *int64, rec Record) error {
+			recs = append(recs, rec)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recs
+	}
+
+	ref, err := OpenFrom(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Close()
+
+	db, err := OpenFrom(bytes.NewReader(enc), WithSQLCipher("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got, want := dump(db), dump(ref); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid decrypted records")
+	}
+	if got, want := len(dump(db)), 300; got != want {
+		t.Fatalf("invalid number of records: got=%d, want=%d", got, want)
+	}
+
+	_, err = OpenFrom(bytes.NewReader(enc), WithSQLCipher("wrong"))
+	if !errors.Is(err, ErrSQLCipherAuth) {
+		t.Fatalf("invalid error with wrong passphrase: %v", err)
+	}
+
+	// tamper with the last page.
+	enc[len(enc)-1024] ^= 0xff
+	db, err = OpenFrom(bytes.NewReader(enc), WithSQLCipher("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.VisitTableRecords("secrets", func(*int64, Record) error { return nil })
+	if !errors.Is(err, ErrSQLCipherAuth) {
+		t.Fatalf("invalid error with tampered page: %v", err)
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 6070 inputs, with HMAC-SHA512 as the pseudo-random function.
+	got := pbkdf2([]byte("password"), []byte("salt"), 2, 64, sha512.New)
+	want, _ := hex.DecodeString("e1d9c16aa681708a45f5c7c4e215ceb66e011a2e9f0040713f18aefdb866d53cf76cab2868a39b9f7840edce4fef5a82be67335c77a6068e04112754f27ccf4e")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid key:\ngot= %x\nwant=%x", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// collation compares two TEXT values.
+type collation func(a, b string) int
+
+var collations = struct {
+	sync.RWMutex
+	m map[string]collation
+}{
+	m: map[string]collation{
+		"NOCASE": nocase,
+		"RTRIM":  rtrim,
+	},
+}
+
+// RegisterCollation makes the collation cmp available under the given
+// name, for the indexes and columns of database schemas and the COLLATE
+// operators of queries. Names are case insensitive.
+//
+// cmp compares its arguments like strings.Compare. It may be called
+// concurrently from multiple goroutines.
+//
+// RegisterCollation replaces any collation previously registered with
+// the same name. It panics if cmp is nil or if name is the name of one of
+// the built-in collations: BINARY, NOCASE and RTRIM.
+func RegisterCollation(name string, cmp func(a, b string) int) {
+	if cmp == nil {
+		panic("sqlite3: RegisterCollation collation is nil")
+	}
+	key := strings.ToUpper(name)
+	switch key {
+	case "BINARY", "NOCASE", "RTRIM":
+		panic("sqlite3: RegisterCollation called for built-in collation " + key)
+	}
+	collations.Lock()
+	defer collations.Unlock()
+	collations.m[key] = cmp
+}
+
+// lookupCollation returns the collation with the given name, or nil for
+// the default BINARY collation.
+func lookupCollation(name string) (collation, error) {
+	if name == "" || strings.EqualFold(name, "BINARY") {
+		return nil, nil
+	}
+	collations.RLock()
+	defer collations.RUnlock()
+	coll, ok := collations.m[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("sqlite3: no such collation sequence: %s", name)
+	}
+	return coll, nil
+}
+
+// nocase compares a and b with the upper case ASCII letters folded to
+// lower case, like the NOCASE collation of SQLite.
+func nocase(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		ca, cb := foldASCII(rune(a[i])), foldASCII(rune(b[i]))
+		if ca != cb {
+			return int(ca) - int(cb)
+		}
+	}
+	return len(a) - len(b)
+}
+
+// rtrim compares a and b ignoring their trailing spaces, like the RTRIM
+// collation of SQLite.
+func rtrim(a, b string) int {
+	return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+}
+
+// exprCollation returns the name of the collation of the expression x,
+// and whether it is given by a COLLATE operator: the one of the leftmost
+// COLLATE operator of x, or the one of the column x, if any.
+func exprCollation(x expr) (string, bool) {
+	for {
+		switch y := x.(type) {
+		case *collateExpr:
+			return y.name, true
+		case *colRef:
+			return y.coll, false
+		case *castExpr:
+			x = y.x
+			continue
+		case *unaryExpr:
+			if y.op == "+" {
+				x = y.x
+				continue
+			}
+		}
+		// COLLATE operators apply to the expressions using their
+		// operand.
+		var (
+			name  string
+			found bool
+		)
+		walkExpr(x, func(x expr) bool {
+			if c, ok := x.(*collateExpr); ok && !found {
+				name, found = c.name, true
+			}
+			return !found
+		})
+		return name, found
+	}
+}
+
+// compareCollation returns the name of the collation of the comparison
+// of the expressions x and y: the one given by a COLLATE operator of x,
+// or else of y, or else the collation of x, or else the one of y.
+func compareCollation(x, y expr) string {
+	cx, explicit := exprCollation(x)
+	if explicit {
+		return cx
+	}
+	cy, explicit := exprCollation(y)
+	switch {
+	case explicit:
+		return cy
+	case cx != "":
+		return cx
+	}
+	return cy
+}
+
+// bindCollations sets the collations of the comparisons of the
+// expression x.
+func bindCollations(x expr) error {
+	var err error
+	walkExpr(x, func(x expr) bool {
+		lookup := func(a, b expr) collation {
+			var coll collation
+			if err == nil {
+				coll, err = lookupCollation(compareCollation(a, b))
+			}
+			return coll
+		}
+		switch x := x.(type) {
+		case *binaryExpr:
+			switch x.op {
+			case "AND", "OR", "+", "-", "*", "/", "%", "&", "|", "<<", ">>", "||":
+			default:
+				x.coll = lookup(x.x, x.y)
+			}
+		case *betweenExpr:
+			x.colls = [2]collation{lookup(x.x, x.lo), lookup(x.x, x.hi)}
+		case *inExpr:
+			if len(x.list) == 1 {
+				// like SQLite, x IN (y) is x = y.
+				x.coll = lookup(x.x, x.list[0])
+				break
+			}
+			if err == nil {
+				name, _ := exprCollation(x.x)
+				x.coll, err = lookupCollation(name)
+			}
+		case *caseExpr:
+			if x.x != nil {
+				for i := range x.whens {
+					x.whens[i].coll = lookup(x.x, x.whens[i].cond)
+				}
+			}
+		case *funcExpr:
+			// like SQLite, the collation of the arguments is the
+			// one of the first argument having one.
+			var name string
+			for _, arg := range x.args {
+				if name, _ = exprCollation(arg); name != "" {
+					break
+				}
+			}
+			if err == nil {
+				x.coll, err = lookupCollation(name)
+			}
+		case *collateExpr:
+			if err == nil {
+				_, err = lookupCollation(x.name)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
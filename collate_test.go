@@ -0,0 +1,154 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollations(t *testing.T) {
+	sign := func(n int) int {
+		switch {
+		case n < 0:
+			return -1
+		case n > 0:
+			return 1
+		}
+		return 0
+	}
+	for _, tc := range []struct {
+		coll collation
+		name string
+		a, b string
+		want int
+	}{
+		{nocase, "nocase", "abc", "ABC", 0},
+		{nocase, "nocase", "a", "B", -1},
+		{nocase, "nocase", "[", "a", -1}, // [ sorts after A but before a
+		{nocase, "nocase", "ä", "Ä", 1},
+		{nocase, "nocase", "ab", "AB ", -1},
+		{rtrim, "rtrim", "abc  ", "abc", 0},
+		{rtrim, "rtrim", "abc ", "abc\t", -1},
+		{rtrim, "rtrim", " a", "a", -1},
+		{rtrim, "rtrim", "a", "A", 1},
+	} {
+		if got := sign(tc.coll(tc.a, tc.b)); got != tc.want {
+			t.Errorf("%s(%q, %q): got %d, want %d", tc.name, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestQueryCollate(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select 'abc' = 'ABC', 'abc' = 'ABC' collate nocase, 'abc  ' = 'abc' collate rtrim, 'b' collate nocase between 'A' and 'C', 'x ' collate rtrim in ('x', 'y')`,
+			want:  "0|1|1|1|1\n",
+		},
+		{
+			query: `select case 'ABC' collate nocase when 'abc' then 1 else 0 end, 'a' < 'B', 'a' < 'B' collate nocase`,
+			want:  "1|0|1\n",
+		},
+		{
+			query: `select id, city from people where city = 'PARIS' collate nocase order by id limit 3`,
+			want:  "3|paris\n6|Paris\n9|paris\n",
+		},
+		{
+			query: `select id, city from people where city collate nocase in ('PARIS', 'lyon') and id < 12`,
+			want:  "1|Lyon\n3|paris\n6|Paris\n7|Lyon\n9|paris\n",
+		},
+		{
+			query: `select city from people where id < 10 order by city collate nocase desc, id`,
+			want:  "Tokyo\nparis\nParis\nparis\nLyon\nLyon\nBerlin\nBerlin\nNULL\n",
+		},
+		{
+			query: `select name from people where name collate nocase < 'bob1' order by name collate nocase, id limit 4`,
+			want:  "alice1\nalice10\nalice11\nalice12\n",
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterCollation(t *testing.T) {
+	db, err := Open("testdata/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	seek := func(lo, hi []interface{}) ([]int64, error) {
+		var got []int64
+		err := db.Index("words_rev").Range(lo, hi, func(rowid *int64, rec Record) error {
+			got = append(got, *rowid)
+			return nil
+		})
+		return got, err
+	}
+	_, err = seek([]interface{}{"apple"}, []interface{}{"apple"})
+	if err == nil || !strings.Contains(err.Error(), "no such collation sequence: reverse") {
+		t.Errorf("unregistered collation: got error %v", err)
+	}
+
+	RegisterCollation("Reverse", func(a, b string) int {
+		return strings.Compare(b, a)
+	})
+	got, err := seek([]interface{}{"apple"}, []interface{}{"apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 15, 29, 43, 57}; !reflect.DeepEqual(got, want) {
+		t.Errorf("seek: got %v, want %v", got, want)
+	}
+	got, err = seek([]interface{}{"zeta"}, []interface{}{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 || got[0] != 13 || got[19] != 64 {
+		t.Errorf("range: got %v", got)
+	}
+
+	// queries use the index with the collation of the comparison.
+	const query = `select id from words where w collate reverse between 'zeta' and 'b'`
+	want := queryString(t, db, `select id from words where w collate reverse between 'zeta' and 'b' order by w collate reverse, id`)
+	if got := queryString(t, db, query); got != want {
+		t.Errorf("%s:\ngot:\n%s\nwant:\n%s", query, got, want)
+	}
+	stmt, _, err := parseSelect(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := db.compileSelect(stmt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := plan.sources[0].lookup; l == nil || l.index == nil || l.index.name != "words_rev" {
+		t.Errorf("%s: no lookup of words_rev", query)
+	}
+
+	for _, name := range []string{"binary", "NOCASE", "rtrim"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterCollation(%q) did not panic", name)
+				}
+			}()
+			RegisterCollation(name, strings.Compare)
+		}()
+	}
+}
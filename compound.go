@@ -0,0 +1,305 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// queryPlan is a compiled query: a SELECT statement, a compound SELECT
+// statement or a VALUES clause.
+type queryPlan interface {
+	// columns returns the names of the result columns.
+	columns() []string
+
+	// results returns the expressions of the result columns of the
+	// first SELECT statement, which give their affinities and
+	// collations.
+	results() []expr
+
+	// run runs the query, calling emit with the values of each result
+	// row.
+	run(emit func([]interface{}) error) error
+}
+
+// compileQuery compiles the SELECT statement stmt, which may be a
+// compound SELECT or a VALUES clause, and have a WITH clause. The common
+// table expressions of with are visible to the statement.
+func (db *DbFile) compileQuery(stmt *selectStmt, with *withScope) (queryPlan, error) {
+	if len(stmt.with) > 0 {
+		with = newWithScope(stmt.with, with)
+	}
+	if len(stmt.compound) == 0 && stmt.values == nil {
+		return db.compileSelect(stmt, with)
+	}
+	return db.compileCompound(stmt, with)
+}
+
+// compileCore compiles the SELECT statement or VALUES clause stmt, a
+// term of a compound SELECT.
+func (db *DbFile) compileCore(stmt *selectStmt, with *withScope) (queryPlan, error) {
+	if stmt.values != nil {
+		return compileValues(stmt.values)
+	}
+	return db.compileSelect(stmt, with)
+}
+
+// compoundPlan is a compiled compound SELECT statement.
+type compoundPlan struct {
+	names []string
+	terms []queryPlan // plans of the SELECT statements, in order
+	ops   []string    // compound operator before each term but the first
+	colls []collation // collations of the result columns
+
+	slots  []expr // result columns, as references to the slots of the rows
+	order  []sortKey
+	limit  int64 // maximum number of rows, or -1
+	offset int64 // number of rows skipped
+}
+
+// compileCompound compiles the compound SELECT statement stmt.
+func (db *DbFile) compileCompound(stmt *selectStmt, with *withScope) (*compoundPlan, error) {
+	plan := &compoundPlan{}
+	for i, core := range stmt.cores() {
+		term, err := db.compileCore(core, with)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			plan.names = term.columns()
+		} else {
+			op := stmt.compound[i-1].op
+			if len(term.columns()) != len(plan.names) {
+				return nil, fmt.Errorf("sqlite3: SELECTs to the left and right of %s do not have the same number of result columns", op)
+			}
+			plan.ops = append(plan.ops, op)
+		}
+		plan.terms = append(plan.terms, term)
+	}
+
+	var err error
+	results := plan.results()
+	if plan.colls, err = exprCollations(results); err != nil {
+		return nil, err
+	}
+	plan.slots = resultSlots(plan.names, results)
+	if plan.order, err = resultOrder(stmt.orderBy, plan.slots); err != nil {
+		return nil, err
+	}
+	plan.limit, plan.offset, err = limitOffset(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (plan *compoundPlan) columns() []string {
+	return plan.names
+}
+
+func (plan *compoundPlan) results() []expr {
+	return plan.terms[0].results()
+}
+
+// resultSlots returns references to the result columns of a query, with
+// the given names and expressions, stored in the slots of rows.
+func resultSlots(names []string, results []expr) []expr {
+	slots := make([]expr, len(names))
+	for i, name := range names {
+		coll, _ := exprCollation(results[i])
+		slots[i] = &colRef{name: name, slot: i, aff: exprAffinity(results[i]), coll: coll}
+	}
+	return slots
+}
+
+// resultOrder returns the keys of the ORDER BY clause terms of a
+// compound SELECT, whose terms are the numbers or the names of the
+// result columns slots.
+func resultOrder(terms []orderingTerm, slots []expr) ([]sortKey, error) {
+	var keys []sortKey
+	for i, term := range terms {
+		x := term.expr
+		if c, ok := x.(*collateExpr); ok {
+			x = c.x
+		}
+		k := -1
+		switch x := x.(type) {
+		case *literal:
+			n, ok := x.v.(int64)
+			if !ok {
+				break
+			}
+			if n < 1 || n > int64(len(slots)) {
+				return nil, fmt.Errorf("sqlite3: %s ORDER BY term out of range - should be between 1 and %d", ordinal(i+1), len(slots))
+			}
+			k = int(n - 1)
+		case *colRef:
+			for j, slot := range slots {
+				if x.table == "" && strings.EqualFold(slot.(*colRef).name, x.name) {
+					k = j
+					break
+				}
+			}
+		}
+		if k < 0 {
+			return nil, fmt.Errorf("sqlite3: %s ORDER BY term does not match any column in the result set", ordinal(i+1))
+		}
+		key, err := newSortKey(term, slots[k])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// run runs the compound SELECT. The terms are combined from left to
+// right: the rows of the terms following the last operator other than
+// UNION ALL are produced while the terms run, and the other ones once
+// their set is known. Like SQLite, the rows of a UNION, INTERSECT or
+// EXCEPT operator are sorted.
+func (plan *compoundPlan) run(emit func([]interface{}) error) error {
+	if plan.limit == 0 {
+		return nil
+	}
+	out := &output{
+		exprs: plan.slots,
+		order: plan.order,
+		limit: plan.limit,
+		emit:  emit,
+		skip:  plan.offset,
+	}
+	e := &env{}
+	add := func(vals []interface{}) error {
+		e.row = vals
+		return out.add(e)
+	}
+
+	last := -1
+	for i, op := range plan.ops {
+		if op != "UNION ALL" {
+			last = i + 1
+		}
+	}
+	var (
+		rows [][]interface{}
+		err  error
+	)
+	if last >= 0 {
+		rows, err = collectRows(plan.terms[0])
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= last && err == nil; i++ {
+			rows, err = plan.combine(plan.ops[i-1], rows, plan.terms[i])
+		}
+	}
+	for _, row := range rows {
+		if err == nil {
+			err = add(row)
+		}
+	}
+	for i := last + 1; i < len(plan.terms) && err == nil; i++ {
+		err = plan.terms[i].run(add)
+	}
+	switch {
+	case err == errLimitReached:
+		return nil
+	case err != nil:
+		return err
+	}
+	return out.flush()
+}
+
+// combine returns the rows of the compound operator op applied to the
+// rows and to the ones of the term.
+func (plan *compoundPlan) combine(op string, rows [][]interface{}, term queryPlan) ([][]interface{}, error) {
+	right, err := collectRows(term)
+	if err != nil || op == "UNION ALL" {
+		return append(rows, right...), err
+	}
+	var (
+		left  = &rowSet{colls: plan.colls}
+		other = &rowSet{colls: plan.colls}
+	)
+	// like SQLite, the last of equal rows is kept: 1 UNION 1.0 is 1.0.
+	for _, row := range rows {
+		left.put(row)
+	}
+	for _, row := range right {
+		if op == "UNION" {
+			left.put(row)
+			continue
+		}
+		other.add(row)
+	}
+	if op == "UNION" {
+		return left.rows, nil
+	}
+	var kept [][]interface{}
+	for _, row := range left.rows {
+		if other.has(row) == (op == "INTERSECT") {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+// collectRows runs the query plan and returns its rows.
+func collectRows(plan queryPlan) ([][]interface{}, error) {
+	var rows [][]interface{}
+	err := plan.run(func(vals []interface{}) error {
+		rows = append(rows, vals)
+		return nil
+	})
+	return rows, err
+}
+
+// valuesPlan is a compiled VALUES clause.
+type valuesPlan struct {
+	names []string
+	rows  [][]expr
+}
+
+// compileValues compiles the rows of a VALUES clause. Its columns are
+// named column1, column2...
+func compileValues(rows [][]expr) (*valuesPlan, error) {
+	plan := &valuesPlan{rows: rows}
+	for i := range rows[0] {
+		plan.names = append(plan.names, fmt.Sprintf("column%d", i+1))
+	}
+	s := scope{aggErr: aggMisuse}
+	for _, row := range rows {
+		for _, x := range row {
+			if err := s.bind(x); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+func (plan *valuesPlan) columns() []string {
+	return plan.names
+}
+
+func (plan *valuesPlan) results() []expr {
+	return plan.rows[0]
+}
+
+func (plan *valuesPlan) run(emit func([]interface{}) error) error {
+	e := &env{}
+	for _, row := range plan.rows {
+		vals, err := evalAll(e, row)
+		if err != nil {
+			return err
+		}
+		if err := emit(vals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryCompound(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select url from visits where id < 20 union select id from urls where id < 10`,
+			want: `1
+2
+3
+4
+5
+6
+7
+8
+9
+16
+19
+20
+21
+25
+40
+54
+64
+70
+73
+76
+83
+85
+98
+142
+152
+160
+180
+`,
+		},
+		{
+			query: `select url from visits where id < 20 intersect select id from urls where id < 50 order by 1 desc`,
+			want: `40
+25
+21
+20
+19
+16
+`,
+		},
+		{
+			query: `select url from visits except select id from urls where id > 5 limit 3 offset 1`,
+			want: `2
+3
+4
+`,
+		},
+		{
+			// the last of equal rows is kept by UNION, and the left one
+			// by INTERSECT and EXCEPT.
+			query: `select 1 union select 1.0 union all select 2 union select 2.0`,
+			want: `1.0
+2.0
+`,
+		},
+		{
+			query: `select 1.0 union select 1`,
+			want: `1
+`,
+		},
+		{
+			query: `values (1), (1.0) intersect select 1`,
+			want: `1.0
+`,
+		},
+		{
+			query: `values (1.0), (1) except select 2`,
+			want: `1
+`,
+		},
+		{
+			query: `select 3 as a union all select 1 union all select 2 order by a`,
+			want: `1
+2
+3
+`,
+		},
+		{
+			query: `values (1, 'a'), (2, 'b') union all select id, url from urls where id < 3`,
+			want: `1|a
+2|b
+1|https://golang.org/page1
+2|https://sqlite.org/page2
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQueryCompoundErrors(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`select 1 union select 1, 2`, "SELECTs to the left and right of UNION do not have the same number of result columns"},
+		{`select 1 order by 1 union select 2`, "ORDER BY clause should come after UNION not before"},
+		{`select 3 as a union select 1 order by b`, "1st ORDER BY term does not match any column in the result set"},
+		{`select 1 union select 2 order by 3`, "1st ORDER BY term out of range - should be between 1 and 1"},
+		{`values (1, 2), (3)`, "all VALUES must have the same number of terms"},
+	} {
+		_, err := db.Query(tc.query)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,890 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The date and time functions follow the date.c file of SQLite, whose
+// julian day numbers are stored in milliseconds.
+
+const (
+	msPerDay  = 86400000
+	unixEpoch = 210866760000000 // julian day number of 1970-01-01, in milliseconds
+	maxJD     = 464269060799999 // julian day number of 9999-12-31 23:59:59.999
+)
+
+// dateTime is a date and time value being computed.
+type dateTime struct {
+	jd       int64 // julian day number, in milliseconds
+	y, mo, d int   // year, month and day
+	h, mi    int   // hour and minutes
+	s        float64
+	tz       int // time zone offset, in minutes
+	validJD  bool
+	validYMD bool
+	validHMS bool
+	nFloor   int  // days to subtract for the floor modifier
+	rawS     bool // whether s holds the raw numeric time value
+	isError  bool
+	subsec   bool // whether to format fractions of seconds
+	isUTC    bool
+	isLocal  bool
+}
+
+// getDigits parses the fixed width fields of the string s, described by
+// format: for each field, its number of digits, its minimum value, a
+// letter giving its maximum value and the character following it, or 0
+// for the last one. getDigits returns the values of the fields parsed,
+// until the first one that is invalid.
+func getDigits(s, format string) []int {
+	var vals []int
+	for len(format) >= 3 {
+		n := int(format[0] - '0')
+		lo := int(format[1] - '0')
+		hi := [...]int{12, 14, 24, 31, 59, 14712}[format[2]-'a']
+		var sep byte
+		if len(format) > 3 {
+			sep = format[3]
+		}
+		if len(s) < n {
+			break
+		}
+		v := 0
+		for _, c := range []byte(s[:n]) {
+			if !isDigit(c) {
+				return vals
+			}
+			v = v*10 + int(c-'0')
+		}
+		s = s[n:]
+		if v < lo || v > hi || sep != 0 && (s == "" || s[0] != sep) {
+			break
+		}
+		vals = append(vals, v)
+		if sep == 0 {
+			break
+		}
+		s = s[1:]
+		format = format[4:]
+	}
+	return vals
+}
+
+// parseTimezone parses the time zone suffix of a date and time, and
+// reports whether it is invalid.
+func (p *dateTime) parseTimezone(s string) bool {
+	s = strings.TrimLeft(s, " \t\n\f\r\v")
+	p.tz = 0
+	if s == "" {
+		return false
+	}
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+	case '+':
+	case 'Z', 'z':
+		p.isLocal, p.isUTC = false, true
+		return strings.TrimLeft(s[1:], " \t\n\f\r\v") != ""
+	default:
+		return true
+	}
+	v := getDigits(s[1:], "20b:20e")
+	if len(v) != 2 {
+		return true
+	}
+	p.tz = sign * (v[1] + v[0]*60)
+	return strings.TrimLeft(s[6:], " \t\n\f\r\v") != ""
+}
+
+// parseHMS parses a time of the form HH:MM[:SS[.SSS]] followed by an
+// optional time zone, and reports whether it is invalid.
+func (p *dateTime) parseHMS(s string) bool {
+	v := getDigits(s, "20c:20e")
+	if len(v) != 2 {
+		return true
+	}
+	h, m := v[0], v[1]
+	s = s[5:]
+	var sec float64
+	if s != "" && s[0] == ':' {
+		v := getDigits(s[1:], "20e")
+		if len(v) != 1 {
+			return true
+		}
+		sec = float64(v[0])
+		s = s[3:]
+		if len(s) > 1 && s[0] == '.' && isDigit(s[1]) {
+			ms, scale := 0.0, 1.0
+			s = s[1:]
+			for s != "" && isDigit(s[0]) {
+				ms = ms*10 + float64(s[0]-'0')
+				scale *= 10
+				s = s[1:]
+			}
+			ms /= scale
+			if ms > 0.999 {
+				ms = 0.999
+			}
+			sec += ms
+		}
+	}
+	p.validJD, p.rawS, p.validHMS = false, false, true
+	p.h, p.mi, p.s = h, m, sec
+	return p.parseTimezone(s)
+}
+
+// computeJD computes the julian day number from the date and time.
+func (p *dateTime) computeJD() {
+	if p.validJD {
+		return
+	}
+	y, m, d := 2000, 1, 1
+	if p.validYMD {
+		y, m, d = p.y, p.mo, p.d
+	}
+	if y < -4713 || y > 9999 || p.rawS {
+		p.setError()
+		return
+	}
+	if m <= 2 {
+		y--
+		m += 12
+	}
+	a := (y + 4800) / 100
+	b := 38 - a + a/4
+	x1 := 36525 * (y + 4716) / 100
+	x2 := 306001 * (m + 1) / 10000
+	p.jd = int64((float64(x1+x2+d+b) - 1524.5) * msPerDay)
+	p.validJD = true
+	if p.validHMS {
+		p.jd += int64(p.h*3600000+p.mi*60000) + int64(p.s*1000+0.5)
+		if p.tz != 0 {
+			p.jd -= int64(p.tz) * 60000
+			p.validYMD, p.validHMS = false, false
+			p.tz = 0
+			p.isUTC, p.isLocal = true, false
+		}
+	}
+}
+
+// computeFloor computes the number of days the date goes past the end
+// of its month, for the floor modifier.
+func (p *dateTime) computeFloor() {
+	switch {
+	case p.d <= 28:
+		p.nFloor = 0
+	case 1<<uint(p.mo)&0x15aa != 0:
+		p.nFloor = 0
+	case p.mo != 2:
+		p.nFloor = 0
+		if p.d == 31 {
+			p.nFloor = 1
+		}
+	case p.y%4 != 0 || p.y%100 == 0 && p.y%400 != 0:
+		p.nFloor = p.d - 28
+	default:
+		p.nFloor = p.d - 29
+	}
+}
+
+// parseYMD parses a date of the form YYYY-MM-DD, followed by an optional
+// time, and reports whether it is invalid.
+func (p *dateTime) parseYMD(s string) bool {
+	neg := false
+	if s != "" && s[0] == '-' {
+		s, neg = s[1:], true
+	}
+	v := getDigits(s, "40f-21a-21d")
+	if len(v) != 3 {
+		return true
+	}
+	s = strings.TrimLeft(s[10:], " \t\n\f\r\vT")
+	switch {
+	case !p.parseHMS(s):
+	case s == "":
+		p.validHMS = false
+	default:
+		return true
+	}
+	p.validJD, p.validYMD = false, true
+	p.y, p.mo, p.d = v[0], v[1], v[2]
+	if neg {
+		p.y = -p.y
+	}
+	p.computeFloor()
+	if p.tz != 0 {
+		p.computeJD()
+	}
+	return false
+}
+
+// setNow sets the date and time to the time of the statement.
+func (p *dateTime) setNow(e *env) {
+	t := e.time()
+	p.jd = t.UnixMilli() + unixEpoch
+	p.validJD = true
+	p.isUTC, p.isLocal = true, false
+	p.clearYMDHMS()
+}
+
+// setRaw sets the date and time to the numeric value r, a julian day
+// number unless a modifier says otherwise.
+func (p *dateTime) setRaw(r float64) {
+	p.s, p.rawS = r, true
+	if r >= 0 && r < 5373484.5 {
+		p.jd = int64(r*msPerDay + 0.5)
+		p.validJD = true
+	}
+}
+
+// parse parses the time value s, and reports whether it is invalid.
+func (p *dateTime) parse(e *env, s string) bool {
+	if !p.parseYMD(s) || !p.parseHMS(s) {
+		return false
+	}
+	if strings.EqualFold(s, "now") {
+		p.setNow(e)
+		return false
+	}
+	if v, ok := parseNumeric(s); ok {
+		p.setRaw(toFloat(v))
+		return false
+	}
+	if strings.EqualFold(s, "subsec") || strings.EqualFold(s, "subsecond") {
+		p.subsec = true
+		p.setNow(e)
+		return false
+	}
+	return true
+}
+
+func validJD(jd int64) bool {
+	return jd >= 0 && jd <= maxJD
+}
+
+// computeYMD computes the year, month and day from the julian day
+// number.
+func (p *dateTime) computeYMD() {
+	switch {
+	case p.validYMD:
+		return
+	case !p.validJD:
+		p.y, p.mo, p.d = 2000, 1, 1
+	case !validJD(p.jd):
+		p.setError()
+		return
+	default:
+		z := int((p.jd + 43200000) / msPerDay)
+		alpha := int((float64(z)+32044.75)/36524.25) - 52
+		a := z + 1 + alpha - (alpha+100)/4 + 25
+		b := a + 1524
+		c := int((float64(b) - 122.1) / 365.25)
+		d := (36525 * (c & 32767)) / 100
+		e := int(float64(b-d) / 30.6001)
+		x1 := int(30.6001 * float64(e))
+		p.d = b - d - x1
+		if e < 14 {
+			p.mo = e - 1
+		} else {
+			p.mo = e - 13
+		}
+		if p.mo > 2 {
+			p.y = c - 4716
+		} else {
+			p.y = c - 4715
+		}
+	}
+	p.validYMD = true
+}
+
+// computeHMS computes the hour, minutes and seconds from the julian day
+// number.
+func (p *dateTime) computeHMS() {
+	if p.validHMS {
+		return
+	}
+	p.computeJD()
+	ms := int((p.jd + 43200000) % msPerDay)
+	p.s = float64(ms%60000) / 1000
+	min := ms / 60000
+	p.mi = min % 60
+	p.h = min / 60
+	p.rawS = false
+	p.validHMS = true
+}
+
+func (p *dateTime) computeYMDHMS() {
+	p.computeYMD()
+	p.computeHMS()
+}
+
+func (p *dateTime) clearYMDHMS() {
+	p.validYMD, p.validHMS = false, false
+	p.tz = 0
+}
+
+func (p *dateTime) setError() {
+	*p = dateTime{isError: true}
+}
+
+// toLocal converts the date and time, in UTC, to local time. Like SQLite,
+// dates outside of 1970-2037 are mapped to a year of that range with the
+// same calendar.
+func (p *dateTime) toLocal() {
+	p.computeJD()
+	var (
+		t        int64
+		yearDiff int
+	)
+	if p.jd < 2108667600*100000 || p.jd > 2130141456*100000 {
+		x := *p
+		x.computeYMDHMS()
+		yearDiff = (2000 + x.y%4) - x.y
+		x.y += yearDiff
+		x.validJD = false
+		x.computeJD()
+		t = x.jd/1000 - unixEpoch/1000
+	} else {
+		t = p.jd/1000 - unixEpoch/1000
+	}
+	local := time.Unix(t, 0).In(time.Local)
+	p.y = local.Year() - yearDiff
+	p.mo = int(local.Month())
+	p.d = local.Day()
+	p.h = local.Hour()
+	p.mi = local.Minute()
+	p.s = float64(local.Second()) + float64(p.jd%1000)*0.001
+	p.validYMD, p.validHMS = true, true
+	p.validJD, p.rawS = false, false
+	p.tz = 0
+	p.isError = false
+}
+
+// xforms are the units of the modifiers adding a number of units, with
+// the limit of the number and the length of the unit in seconds.
+var xforms = []struct {
+	name   string
+	limit  float64
+	length float64
+}{
+	{"second", float64(float32(4.6427e+14)), 1},
+	{"minute", float64(float32(7.7379e+12)), 60},
+	{"hour", float64(float32(1.2897e+11)), 3600},
+	{"day", 5373485, 86400},
+	{"month", 176546, 2592000},
+	{"year", 14713, 31536000},
+}
+
+// modify applies the modifier s, the i-th argument of the function, and
+// reports whether it is invalid.
+func (p *dateTime) modify(s string, i int) bool {
+	if s == "" {
+		return true
+	}
+	switch c := s[0]; {
+	case strings.EqualFold(s, "auto"):
+		if i > 1 {
+			return true
+		}
+		switch {
+		case !p.rawS || p.validJD:
+			p.rawS = false
+		case p.s >= -210866760000 && p.s <= 253402300799:
+			r := p.s*1000 + unixEpoch
+			p.clearYMDHMS()
+			p.jd = int64(r + 0.5)
+			p.validJD, p.rawS = true, false
+		default:
+			return true
+		}
+	case strings.EqualFold(s, "ceiling"):
+		p.computeJD()
+		p.clearYMDHMS()
+		p.nFloor = 0
+	case strings.EqualFold(s, "floor"):
+		p.computeJD()
+		p.jd -= int64(p.nFloor) * msPerDay
+		p.clearYMDHMS()
+	case strings.EqualFold(s, "julianday"):
+		if i > 1 || !p.validJD || !p.rawS {
+			return true
+		}
+		p.rawS = false
+	case strings.EqualFold(s, "localtime"):
+		if !p.isLocal {
+			p.toLocal()
+		}
+		p.isUTC, p.isLocal = false, true
+	case strings.EqualFold(s, "unixepoch"):
+		if i > 1 || !p.rawS {
+			return true
+		}
+		r := p.s*1000 + unixEpoch
+		if r < 0 || r >= maxJD+1 {
+			return true
+		}
+		p.clearYMDHMS()
+		p.jd = int64(r + 0.5)
+		p.validJD, p.rawS = true, false
+	case strings.EqualFold(s, "utc"):
+		if !p.isUTC {
+			p.toUTC()
+		}
+	case len(s) > 8 && strings.EqualFold(s[:8], "weekday "):
+		v, ok := parseNumeric(s[8:])
+		r := toFloat(v)
+		if !ok || r < 0 || r >= 7 || r != float64(int(r)) {
+			return true
+		}
+		n := int64(r)
+		p.computeYMDHMS()
+		p.tz = 0
+		p.validJD = false
+		p.computeJD()
+		z := ((p.jd + 129600000) / msPerDay) % 7
+		if z > n {
+			z -= 7
+		}
+		p.jd += (n - z) * msPerDay
+		p.clearYMDHMS()
+	case strings.EqualFold(s, "subsec") || strings.EqualFold(s, "subsecond"):
+		p.subsec = true
+	case len(s) > 9 && strings.EqualFold(s[:9], "start of "):
+		if !p.validJD && !p.validYMD && !p.validHMS {
+			return true
+		}
+		p.computeYMD()
+		p.validHMS = true
+		p.h, p.mi, p.s = 0, 0, 0
+		p.rawS = false
+		p.tz = 0
+		p.validJD = false
+		switch unit := s[9:]; {
+		case strings.EqualFold(unit, "month"):
+			p.d = 1
+		case strings.EqualFold(unit, "year"):
+			p.mo, p.d = 1, 1
+		case strings.EqualFold(unit, "day"):
+		default:
+			return true
+		}
+	case c == '+' || c == '-' || isDigit(c):
+		return p.add(s)
+	default:
+		return true
+	}
+	return false
+}
+
+// toUTC converts the date and time, in local time, to UTC.
+func (p *dateTime) toUTC() {
+	p.computeJD()
+	var (
+		orig  = p.jd
+		guess = orig
+		diff  int64
+	)
+	for n := 0; n < 4; n++ {
+		guess -= diff
+		x := dateTime{jd: guess, validJD: true}
+		x.toLocal()
+		x.computeJD()
+		if diff = x.jd - orig; diff == 0 {
+			break
+		}
+	}
+	*p = dateTime{jd: guess, validJD: true, isUTC: true, subsec: p.subsec}
+}
+
+// add applies the modifiers adding or subtracting a number of units, as
+// in "+3 days", a number of years, months and days, as in
+// "+0001-02-03", or a time, as in "-12:30".
+func (p *dateTime) add(s string) bool {
+	n := 1
+	for ; n < len(s); n++ {
+		c := s[n]
+		if c == ':' || isSpace(c) {
+			break
+		}
+		if c == '-' && (n == 5 && len(getDigits(s[1:], "40f")) == 1 || n == 6 && len(getDigits(s[1:], "50f")) == 1) {
+			break
+		}
+	}
+	v, ok := parseNumeric(s[:n])
+	if !ok {
+		return true
+	}
+	r := toFloat(v)
+	sign := s[0]
+
+	hms := s
+	if n < len(s) && s[n] == '-' {
+		// ±YYYY-MM-DD, with MM from 0 to 11 and DD from 0 to 30.
+		if sign != '+' && sign != '-' {
+			return true
+		}
+		format := "40f-20a-20d"
+		if n == 6 {
+			format = "50f-20a-20d"
+		}
+		v := getDigits(s[1:], format)
+		if len(v) != 3 || v[1] >= 12 || v[2] >= 31 {
+			return true
+		}
+		if n == 6 {
+			s = s[1:]
+		}
+		y, m, d := v[0], v[1], v[2]
+		p.computeYMDHMS()
+		p.validJD = false
+		if sign == '-' {
+			p.y -= y
+			p.mo -= m
+			d = -d
+		} else {
+			p.y += y
+			p.mo += m
+		}
+		p.normalizeMonth()
+		p.computeFloor()
+		p.computeJD()
+		p.validHMS, p.validYMD = false, false
+		p.jd += int64(d) * msPerDay
+		if len(s) == 11 {
+			return false
+		}
+		if !isSpace(s[11]) || len(getDigits(s[12:], "20c:20e")) != 2 {
+			return true
+		}
+		hms, n = s[12:], 2
+	}
+	if n < len(hms) && hms[n] == ':' {
+		// ±HH:MM[:SS[.SSS]]
+		if !isDigit(hms[0]) {
+			hms = hms[1:]
+		}
+		var t dateTime
+		if t.parseHMS(hms) {
+			return true
+		}
+		t.computeJD()
+		t.jd -= 43200000
+		t.jd -= t.jd / msPerDay * msPerDay
+		if sign == '-' {
+			t.jd = -t.jd
+		}
+		p.computeJD()
+		p.clearYMDHMS()
+		p.jd += t.jd
+		return false
+	}
+
+	// ±NNN units
+	unit := strings.TrimLeft(s[n:], " \t\n\f\r\v")
+	if len(unit) < 3 || len(unit) > 10 {
+		return true
+	}
+	if unit[len(unit)-1] == 's' || unit[len(unit)-1] == 'S' {
+		unit = unit[:len(unit)-1]
+	}
+	p.computeJD()
+	rounder := 0.5
+	if r < 0 {
+		rounder = -0.5
+	}
+	p.nFloor = 0
+	invalid := true
+	for i, x := range xforms {
+		if !strings.EqualFold(unit, x.name) || r <= -x.limit || r >= x.limit {
+			continue
+		}
+		switch i {
+		case 4: // months
+			p.computeYMDHMS()
+			p.mo += int(r)
+			p.normalizeMonth()
+			p.computeFloor()
+			p.validJD = false
+			r -= float64(int(r))
+		case 5: // years
+			p.computeYMDHMS()
+			p.y += int(r)
+			p.computeFloor()
+			p.validJD = false
+			r -= float64(int(r))
+		}
+		p.computeJD()
+		p.jd += int64(r*1000*x.length + rounder)
+		invalid = false
+		break
+	}
+	p.clearYMDHMS()
+	return invalid
+}
+
+// normalizeMonth brings the month between 1 and 12, changing the year.
+func (p *dateTime) normalizeMonth() {
+	var x int
+	if p.mo > 0 {
+		x = (p.mo - 1) / 12
+	} else {
+		x = (p.mo - 12) / 12
+	}
+	p.y += x
+	p.mo -= x * 12
+}
+
+// isDate computes the date and time of the arguments of a date and time
+// function: a time value and modifiers. It reports whether they are
+// invalid, and the function returns NULL.
+func (p *dateTime) isDate(e *env, args []interface{}) bool {
+	*p = dateTime{}
+	if len(args) == 0 {
+		p.setNow(e)
+		return false
+	}
+	switch v := args[0].(type) {
+	case nil:
+		return true
+	case int64, float64:
+		p.setRaw(toFloat(v))
+	default:
+		if p.parse(e, textValue(v)) {
+			return true
+		}
+	}
+	for i, v := range args[1:] {
+		if v == nil || p.modify(textValue(v), i+1) {
+			return true
+		}
+	}
+	p.computeJD()
+	if p.isError || !validJD(p.jd) {
+		return true
+	}
+	if len(args) == 1 && p.validYMD && p.d > 28 {
+		// a date like 2023-02-31 is normalized to 2023-03-03, like
+		// SQLite does since 3.46.
+		p.validYMD = false
+	}
+	return false
+}
+
+func juliandayFunc(c callCtx, args []interface{}) (interface{}, error) {
+	var p dateTime
+	if p.isDate(c.e, args) {
+		return nil, nil
+	}
+	return float64(p.jd) / msPerDay, nil
+}
+
+func unixepochFunc(c callCtx, args []interface{}) (interface{}, error) {
+	var p dateTime
+	if p.isDate(c.e, args) {
+		return nil, nil
+	}
+	if p.subsec {
+		return float64(p.jd-unixEpoch) / 1000, nil
+	}
+	return p.jd/1000 - unixEpoch/1000, nil
+}
+
+func dateFunc(c callCtx, args []interface{}) (interface{}, error) {
+	var p dateTime
+	if p.isDate(c.e, args) {
+		return nil, nil
+	}
+	p.computeYMD()
+	return p.formatDate(), nil
+}
+
+func timeFunc(c callCtx, args []interface{}) (interface{}, error) {
+	var p dateTime
+	if p.isDate(c.e, args) {
+		return nil, nil
+	}
+	p.computeHMS()
+	return p.formatTime(), nil
+}
+
+func datetimeFunc(c callCtx, args []interface{}) (interface{}, error) {
+	var p dateTime
+	if p.isDate(c.e, args) {
+		return nil, nil
+	}
+	p.computeYMDHMS()
+	return p.formatDate() + " " + p.formatTime(), nil
+}
+
+// formatDate formats the date as YYYY-MM-DD.
+func (p *dateTime) formatDate() string {
+	y, sign := p.y, ""
+	if y < 0 {
+		y, sign = -y, "-"
+	}
+	return fmt.Sprintf("%s%04d-%02d-%02d", sign, y%10000, p.mo, p.d)
+}
+
+// formatTime formats the time as HH:MM:SS, or HH:MM:SS.SSS with the
+// subsec modifier.
+func (p *dateTime) formatTime() string {
+	if p.subsec {
+		ms := int(1000*p.s + 0.5)
+		return fmt.Sprintf("%02d:%02d:%02d.%03d", p.h, p.mi, ms/1000, ms%1000)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", p.h, p.mi, int(p.s))
+}
+
+// daysAfterJan01 returns the number of days since the first of January
+// of the year of the date.
+func (p *dateTime) daysAfterJan01() int {
+	jan01 := *p
+	jan01.validJD = false
+	jan01.mo, jan01.d = 1, 1
+	jan01.computeJD()
+	return int((p.jd - jan01.jd + 43200000) / msPerDay)
+}
+
+func (p *dateTime) daysAfterMonday() int {
+	return int((p.jd+43200000)/msPerDay) % 7
+}
+
+func (p *dateTime) daysAfterSunday() int {
+	return int((p.jd+129600000)/msPerDay) % 7
+}
+
+// thursday returns the date of the Thursday of the ISO 8601 week of the
+// date.
+func (p *dateTime) thursday() dateTime {
+	y := *p
+	y.jd += int64(3-p.daysAfterMonday()) * msPerDay
+	y.validYMD = false
+	y.computeYMD()
+	return y
+}
+
+// strftimeFunc formats the date and time of its arguments following the
+// format given by its first argument, with the conversions of the C
+// strftime function supported by SQLite.
+func strftimeFunc(c callCtx, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	var p dateTime
+	if p.isDate(c.e, args[1:]) {
+		return nil, nil
+	}
+	p.computeJD()
+	p.computeYMDHMS()
+
+	var (
+		format = textValue(args[0])
+		b      strings.Builder
+	)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil, nil
+		}
+		switch f := format[i]; f {
+		case 'd':
+			fmt.Fprintf(&b, "%02d", p.d)
+		case 'e':
+			fmt.Fprintf(&b, "%2d", p.d)
+		case 'f':
+			s := p.s
+			if s > 59.999 {
+				s = 59.999
+			}
+			out, _ := sprintf("%06.3f", []interface{}{s})
+			b.WriteString(out.(string))
+		case 'F':
+			fmt.Fprintf(&b, "%04d-%02d-%02d", p.y, p.mo, p.d)
+		case 'G':
+			fmt.Fprintf(&b, "%04d", p.thursday().y)
+		case 'g':
+			fmt.Fprintf(&b, "%02d", p.thursday().y%100)
+		case 'H':
+			fmt.Fprintf(&b, "%02d", p.h)
+		case 'k':
+			fmt.Fprintf(&b, "%2d", p.h)
+		case 'I', 'l':
+			h := p.h
+			if h > 12 {
+				h -= 12
+			}
+			if h == 0 {
+				h = 12
+			}
+			if f == 'I' {
+				fmt.Fprintf(&b, "%02d", h)
+			} else {
+				fmt.Fprintf(&b, "%2d", h)
+			}
+		case 'j':
+			fmt.Fprintf(&b, "%03d", p.daysAfterJan01()+1)
+		case 'J':
+			out, _ := sprintf("%.16g", []interface{}{float64(p.jd) / msPerDay})
+			b.WriteString(out.(string))
+		case 'm':
+			fmt.Fprintf(&b, "%02d", p.mo)
+		case 'M':
+			fmt.Fprintf(&b, "%02d", p.mi)
+		case 'p', 'P':
+			s := "AM"
+			if p.h >= 12 {
+				s = "PM"
+			}
+			if f == 'P' {
+				s = strings.ToLower(s)
+			}
+			b.WriteString(s)
+		case 'R':
+			fmt.Fprintf(&b, "%02d:%02d", p.h, p.mi)
+		case 's':
+			if p.subsec {
+				out, _ := sprintf("%.3f", []interface{}{float64(p.jd-unixEpoch) / 1000})
+				b.WriteString(out.(string))
+			} else {
+				fmt.Fprintf(&b, "%d", p.jd/1000-unixEpoch/1000)
+			}
+		case 'S':
+			fmt.Fprintf(&b, "%02d", int(p.s))
+		case 'T':
+			fmt.Fprintf(&b, "%02d:%02d:%02d", p.h, p.mi, int(p.s))
+		case 'u':
+			d := p.daysAfterSunday()
+			if d == 0 {
+				d = 7
+			}
+			fmt.Fprintf(&b, "%d", d)
+		case 'w':
+			fmt.Fprintf(&b, "%d", p.daysAfterSunday())
+		case 'U':
+			fmt.Fprintf(&b, "%02d", (p.daysAfterJan01()-p.daysAfterSunday()+7)/7)
+		case 'V':
+			y := p.thursday()
+			fmt.Fprintf(&b, "%02d", y.daysAfterJan01()/7+1)
+		case 'W':
+			fmt.Fprintf(&b, "%02d", (p.daysAfterJan01()-p.daysAfterMonday()+7)/7)
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", p.y)
+		case '%':
+			b.WriteByte('%')
+		default:
+			return nil, nil
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,453 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ChangeKind describes how an object or a row changed between two
+// databases.
+type ChangeKind int
+
+const (
+	Inserted ChangeKind = iota + 1 // present only in the new database
+	Deleted                        // present only in the old database
+	Modified                       // present in both databases, with different content
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Inserted:
+		return "inserted"
+	case Deleted:
+		return "deleted"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// DbDiff describes the differences between two databases.
+type DbDiff struct {
+	Schema []SchemaChange // changes of the schema objects
+	Tables []TableDiff    // changes of the table rows, for tables with changes
+}
+
+// SchemaChange describes the change of a schema object.
+type SchemaChange struct {
+	Kind   ChangeKind
+	Type   string // "table", "index", "view" or "trigger"
+	Name   string
+	OldSQL string // SQL text of the object in the old database
+	NewSQL string // SQL text of the object in the new database
+}
+
+// TableDiff describes the changes of the rows of a table.
+type TableDiff struct {
+	Name    string
+	Key     []string // names of the key columns: "rowid" or the primary key of WITHOUT ROWID tables
+	Columns []string // names of the columns of the rows
+	Rows    []RowChange
+}
+
+// RowChange describes the change of a row.
+type RowChange struct {
+	Kind    ChangeKind
+	Key     []interface{} // value of the row key
+	Old     []interface{} // values of the row in the old database, nil for inserted rows
+	New     []interface{} // values of the row in the new database, nil for deleted rows
+	Changes []ColumnChange
+}
+
+// ColumnChange describes the change of the value of a column.
+type ColumnChange struct {
+	Column string
+	Old    interface{}
+	New    interface{}
+}
+
+// Diff compares the schema and the content of the tables of the old
+// database a and of the new database b.
+//
+// Tables are matched by name, and rows by rowid or, for WITHOUT ROWID
+// tables, by primary key, and their changes are reported in key order.
+// Columns are matched by name: only the columns present in both versions
+// of a table are compared.
+// Values are compared the way SQLite does, so that the integer 1 equals
+// the real 1.0.
+func Diff(a, b *DbFile) (*DbDiff, error) {
+	var diff DbDiff
+	diff.Schema = diffSchema(a.schema, b.schema)
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, db := range []*DbFile{a, b} {
+		for _, t := range db.tables {
+			key := strings.ToLower(t.name)
+			if !seen[key] {
+				seen[key] = true
+				names = append(names, t.name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		td, err := diffTable(a, b, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(td.Rows) > 0 {
+			diff.Tables = append(diff.Tables, td)
+		}
+	}
+	return &diff, nil
+}
+
+// diffSchema compares the schema objects created by SQL statements,
+// ignoring the internal ones.
+func diffSchema(a, b []SchemaObject) []SchemaChange {
+	type key struct{ typ, name string }
+	index := func(objs []SchemaObject) map[key]SchemaObject {
+		m := make(map[key]SchemaObject)
+		for _, obj := range objs {
+			if obj.SQL == "" || strings.HasPrefix(obj.Name, "sqlite_") {
+				continue
+			}
+			m[key{obj.Type, strings.ToLower(obj.Name)}] = obj
+		}
+		return m
+	}
+	olds := index(a)
+	news := index(b)
+
+	var changes []SchemaChange
+	for _, obj := range a {
+		old, ok := olds[key{obj.Type, strings.ToLower(obj.Name)}]
+		if !ok || old != obj {
+			continue
+		}
+		cur, ok := news[key{obj.Type, strings.ToLower(obj.Name)}]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: Deleted, Type: obj.Type, Name: obj.Name, OldSQL: obj.SQL})
+		case cur.SQL != obj.SQL:
+			changes = append(changes, SchemaChange{Kind: Modified, Type: obj.Type, Name: obj.Name, OldSQL: obj.SQL, NewSQL: cur.SQL})
+		}
+	}
+	for _, obj := range b {
+		cur, ok := news[key{obj.Type, strings.ToLower(obj.Name)}]
+		if !ok || cur != obj {
+			continue
+		}
+		if _, ok := olds[key{obj.Type, strings.ToLower(obj.Name)}]; !ok {
+			changes = append(changes, SchemaChange{Kind: Inserted, Type: obj.Type, Name: obj.Name, NewSQL: obj.SQL})
+		}
+	}
+	return changes
+}
+
+// diffRow is a row of a table, identified by its key.
+type diffRow struct {
+	key    []interface{}
+	values []interface{}
+}
+
+// visitRows calls f with each row of the table t, in b-tree order, with
+// values in column order, as a query returns them: integers are int64,
+// or float64 in REAL columns, and the rowid is substituted for the
+// INTEGER PRIMARY KEY column.
+func (t *Table) visitRows(f func(diffRow) error) error {
+	var pk []int
+	for _, col := range t.pk {
+		pk = append(pk, t.colIndex(col.name))
+	}
+	affs := make([]affinity, len(t.cols))
+	for i := range t.cols {
+		affs[i] = declAffinity(t.cols[i].decl)
+	}
+	return t.db.VisitTableRecords(t.name, func(rowid *int64, rec Record) error {
+		values := make([]interface{}, len(t.cols))
+		for i, v := range rec.Values {
+			if i < len(values) {
+				values[i] = columnValue(v, affs[i])
+			}
+		}
+		for i := len(rec.Values); i < len(t.cols); i++ {
+			values[i] = columnValue(t.cols[i].dflt, affs[i])
+		}
+		row := diffRow{values: values}
+		switch {
+		case t.withoutRowid:
+			for _, i := range pk {
+				if i >= 0 {
+					row.key = append(row.key, values[i])
+				}
+			}
+		default:
+			if rowid != nil {
+				row.key = []interface{}{*rowid}
+				if t.rowid >= 0 && values[t.rowid] == nil {
+					values[t.rowid] = *rowid
+				}
+			}
+		}
+		return f(row)
+	})
+}
+
+// rowStream reads the rows of a table from a goroutine, so that the rows
+// of two tables can be read in step.
+type rowStream struct {
+	rows chan diffRow
+	quit chan struct{}
+	err  error // only valid once rows is closed
+}
+
+// streamRows starts reading the rows of the table t, in b-tree order.
+func (t *Table) streamRows() *rowStream {
+	s := &rowStream{
+		rows: make(chan diffRow, 64),
+		quit: make(chan struct{}),
+	}
+	go func() {
+		s.err = t.visitRows(func(row diffRow) error {
+			select {
+			case s.rows <- row:
+				return nil
+			case <-s.quit:
+				return errScanStopped
+			}
+		})
+		close(s.rows)
+	}()
+	return s
+}
+
+// next returns the next row of the stream, if any. A nil stream has no
+// rows.
+func (s *rowStream) next() (diffRow, bool) {
+	if s == nil {
+		return diffRow{}, false
+	}
+	row, ok := <-s.rows
+	return row, ok
+}
+
+// stop stops the reading of the rows and returns its error, if any.
+func (s *rowStream) stop() error {
+	if s == nil {
+		return nil
+	}
+	close(s.quit)
+	for range s.rows {
+	}
+	if s.err == errScanStopped {
+		return nil
+	}
+	return s.err
+}
+
+// tableKey returns the names of the key columns of the table t.
+func (t *Table) tableKey() []string {
+	if !t.withoutRowid {
+		return []string{"rowid"}
+	}
+	var key []string
+	for _, col := range t.pk {
+		key = append(key, col.name)
+	}
+	return key
+}
+
+// sameKeyOrder reports whether the rows of the tables ta and tb are
+// identified and sorted by the same key: the rowid, or primary key
+// columns with the same names, collations and sort orders.
+func sameKeyOrder(ta, tb *Table) bool {
+	if ta.withoutRowid != tb.withoutRowid || !equalNames(ta.tableKey(), tb.tableKey()) {
+		return false
+	}
+	for i := range ta.pk {
+		ca, cb := ta.pk[i], tb.pk[i]
+		if ca.desc != cb.desc || !strings.EqualFold(ta.collationName(ca), tb.collationName(cb)) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffTable compares the rows of the table name of the databases a and
+// b. The rows of both tables are read in step, in key order, so that the
+// changes are reported in key order without holding the tables in
+// memory.
+func diffTable(a, b *DbFile, name string) (TableDiff, error) {
+	var (
+		td = TableDiff{Name: name}
+		ta = a.Table(name)
+		tb = b.Table(name)
+	)
+	switch {
+	case ta == nil:
+		td.Key = tb.tableKey()
+		td.Columns = columnNames(tb.cols)
+	case tb == nil:
+		td.Key = ta.tableKey()
+		td.Columns = columnNames(ta.cols)
+	default:
+		td.Key = tb.tableKey()
+		td.Columns = columnNames(tb.cols)
+		if !sameKeyOrder(ta, tb) {
+			// rows can not be matched: all of them changed.
+			err := ta.visitRows(func(row diffRow) error {
+				td.Rows = append(td.Rows, RowChange{Kind: Deleted, Key: row.key, Old: row.values})
+				return nil
+			})
+			if err != nil {
+				return td, err
+			}
+			ta = nil
+		}
+	}
+
+	// compare the keys in b-tree order.
+	kt := tb
+	if kt == nil {
+		kt = ta
+	}
+	compare := func(a, b []interface{}) int {
+		return compareInts(a[0].(int64), b[0].(int64))
+	}
+	if kt.withoutRowid {
+		keys, err := kt.keyColumns(kt.pk)
+		if err != nil {
+			return td, err
+		}
+		compare = func(a, b []interface{}) int {
+			return compareKey(keys, a, b)
+		}
+	}
+
+	// compare the columns present in both versions of the table.
+	type pair struct{ a, b int }
+	var common []pair
+	if ta != nil && tb != nil {
+		for i, col := range tb.cols {
+			if j := ta.colIndex(col.name); j >= 0 {
+				common = append(common, pair{j, i})
+			}
+		}
+	}
+
+	var olds, news *rowStream
+	if ta != nil {
+		olds = ta.streamRows()
+	}
+	if tb != nil {
+		news = tb.streamRows()
+	}
+	old, okOld := olds.next()
+	cur, okNew := news.next()
+	for okOld || okNew {
+		c := 0
+		switch {
+		case !okNew:
+			c = -1
+		case !okOld:
+			c = 1
+		default:
+			c = compare(old.key, cur.key)
+		}
+		switch {
+		case c < 0:
+			td.Rows = append(td.Rows, RowChange{Kind: Deleted, Key: old.key, Old: old.values})
+			old, okOld = olds.next()
+			continue
+		case c > 0:
+			td.Rows = append(td.Rows, RowChange{Kind: Inserted, Key: cur.key, New: cur.values})
+			cur, okNew = news.next()
+			continue
+		}
+
+		var changes []ColumnChange
+		for _, p := range common {
+			if !equalValues(old.values[p.a], cur.values[p.b]) {
+				changes = append(changes, ColumnChange{
+					Column: tb.cols[p.b].name,
+					Old:    old.values[p.a],
+					New:    cur.values[p.b],
+				})
+			}
+		}
+		if len(changes) > 0 {
+			td.Rows = append(td.Rows, RowChange{
+				Kind:    Modified,
+				Key:     cur.key,
+				Old:     old.values,
+				New:     cur.values,
+				Changes: changes,
+			})
+		}
+		old, okOld = olds.next()
+		cur, okNew = news.next()
+	}
+
+	errOld := olds.stop()
+	errNew := news.stop()
+	if errOld != nil {
+		return td, errOld
+	}
+	return td, errNew
+}
+
+func columnNames(cols []Column) []string {
+	names := make([]string, len(cols))
+	for i := range cols {
+		names[i] = cols[i].name
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalValues reports whether the normalized values a and b are equal.
+func equalValues(a, b interface{}) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a == b
+		case float64:
+			return compareIntFloat(a, b) == 0
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return compareIntFloat(b, a) == 0
+		case float64:
+			return a == b
+		}
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	}
+	return false
+}
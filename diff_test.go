@@ -0,0 +1,213 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := Open("testdata/diff-a.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Open("testdata/diff-b.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	d, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type schemaChange struct {
+		kind      ChangeKind
+		typ, name string
+	}
+	var schema []schemaChange
+	for _, c := range d.Schema {
+		schema = append(schema, schemaChange{c.Kind, c.Type, c.Name})
+	}
+	wantSchema := []schemaChange{
+		{Deleted, "index", "users_email"},
+		{Deleted, "table", "gone"},
+		{Modified, "view", "top"},
+		{Inserted, "table", "added"},
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("schema changes:\ngot = %v\nwant= %v", schema, wantSchema)
+	}
+
+	want := []TableDiff{
+		{
+			Name:    "users",
+			Key:     []string{"rowid"},
+			Columns: []string{"id", "name", "email", "score", "avatar"},
+			Rows: []RowChange{
+				{
+					Kind:    Modified,
+					Key:     []interface{}{int64(1)},
+					Old:     []interface{}{int64(1), "alice", "alice@example.com", 12.5, []byte{1, 2}},
+					New:     []interface{}{int64(1), "alice", "alice@example.com", 12.5, []byte{1, 3}},
+					Changes: []ColumnChange{{"avatar", []byte{1, 2}, []byte{1, 3}}},
+				},
+				{
+					Kind: Modified,
+					Key:  []interface{}{int64(2)},
+					Old:  []interface{}{int64(2), "bob", "bob@example.com", 3.0, nil},
+					New:  []interface{}{int64(2), "bob", "bob@example.org", 4.0, nil},
+					Changes: []ColumnChange{
+						{"email", "bob@example.com", "bob@example.org"},
+						{"score", 3.0, 4.0},
+					},
+				},
+				{
+					Kind: Deleted,
+					Key:  []interface{}{int64(4)},
+					Old:  []interface{}{int64(4), "dave", "dave@example.com", 1.0, nil},
+				},
+				{
+					Kind: Inserted,
+					Key:  []interface{}{int64(5)},
+					New:  []interface{}{int64(5), "eve", "eve@example.com", 7.0, nil},
+				},
+			},
+		},
+		{
+			Name:    "tags",
+			Key:     []string{"tag", "lang"},
+			Columns: []string{"tag", "lang", "label"},
+			Rows: []RowChange{
+				{
+					Kind: Deleted,
+					Key:  []interface{}{"blue", "en"},
+					Old:  []interface{}{"blue", "en", "Blue"},
+				},
+				{
+					Kind: Inserted,
+					Key:  []interface{}{"green", "en"},
+					New:  []interface{}{"green", "en", "Green"},
+				},
+				{
+					Kind:    Modified,
+					Key:     []interface{}{"red", "fr"},
+					Old:     []interface{}{"red", "fr", "Rouge"},
+					New:     []interface{}{"red", "fr", "Rouge!"},
+					Changes: []ColumnChange{{"label", "Rouge", "Rouge!"}},
+				},
+			},
+		},
+		{
+			Name:    "gone",
+			Key:     []string{"rowid"},
+			Columns: []string{"x"},
+			Rows: []RowChange{
+				{Kind: Deleted, Key: []interface{}{int64(1)}, Old: []interface{}{int64(1)}},
+				{Kind: Deleted, Key: []interface{}{int64(2)}, Old: []interface{}{int64(2)}},
+			},
+		},
+		{
+			Name:    "added",
+			Key:     []string{"rowid"},
+			Columns: []string{"y"},
+			Rows: []RowChange{
+				{Kind: Inserted, Key: []interface{}{int64(1)}, New: []interface{}{"new"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(d.Tables, want) {
+		t.Errorf("table changes:\ngot = %#v\nwant= %#v", d.Tables, want)
+	}
+
+	d, err = Diff(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Schema) != 0 || len(d.Tables) != 0 {
+		t.Errorf("database differs from itself: %#v", d)
+	}
+}
+
+func TestDiffKeyOrder(t *testing.T) {
+	a, err := Open("testdata/diff-keys-a.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Open("testdata/diff-keys-b.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	d, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		kind ChangeKind
+		key  []interface{}
+	}
+	got := make(map[string][]change)
+	for _, td := range d.Tables {
+		for _, r := range td.Rows {
+			got[td.Name] = append(got[td.Name], change{r.Kind, r.Key})
+		}
+	}
+	want := map[string][]change{
+		// keys compare with NOCASE, in descending order.
+		"words": {
+			{Inserted, []interface{}{"Elder"}},
+			{Deleted, []interface{}{"date"}},
+			{Modified, []interface{}{"cherry"}},
+			{Deleted, []interface{}{"Banana"}},
+			{Modified, []interface{}{"APPLE"}},
+		},
+		"big": {
+			{Modified, []interface{}{int64(3), "s003"}},
+			{Deleted, []interface{}{int64(7), "s007"}},
+			{Deleted, []interface{}{int64(57), "s057"}},
+			{Modified, []interface{}{int64(103), "s103"}},
+			{Deleted, []interface{}{int64(107), "s107"}},
+			{Deleted, []interface{}{int64(157), "s157"}},
+			{Modified, []interface{}{int64(203), "s203"}},
+			{Deleted, []interface{}{int64(207), "s207"}},
+			{Deleted, []interface{}{int64(257), "s257"}},
+			{Inserted, []interface{}{int64(1000), "new"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("row changes:\ngot = %v\nwant= %v", got, want)
+	}
+}
+
+func TestDiffEqualValues(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{int64(1), 1.0, true},
+		{int64(1), 1.5, false},
+		{int64(1<<53 + 1), float64(1 << 53), false},
+		{int64(-1 << 63), -9223372036854775808.0, true},
+		{int64(1<<63 - 1), 9223372036854775808.0, false},
+		{1e19, 2e19, false},
+		{math.Inf(1), int64(1<<63 - 1), false},
+		{math.NaN(), int64(0), false},
+	} {
+		if got := equalValues(tc.a, tc.b); got != tc.equal {
+			t.Errorf("equalValues(%v, %v)=%v, want %v", tc.a, tc.b, got, tc.equal)
+		}
+		if got := equalValues(tc.b, tc.a); got != tc.equal {
+			t.Errorf("equalValues(%v, %v)=%v, want %v", tc.b, tc.a, got, tc.equal)
+		}
+	}
+}
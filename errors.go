@@ -0,0 +1,51 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt is the error matched, with errors.Is, by all the errors
+// reporting a malformed database file.
+var ErrCorrupt = errors.New("sqlite3: database disk image is malformed")
+
+// CorruptError describes where and how a database file is malformed.
+type CorruptError struct {
+	Page   int    // page number, or 0 if unknown
+	Cell   int    // index of the cell within the page, or -1 if unknown
+	Offset int    // byte offset within the page, or -1 if unknown
+	Reason string // description of the problem
+}
+
+func corruptf(pgno, cell, offset int, format string, args ...interface{}) *CorruptError {
+	return &CorruptError{
+		Page:   pgno,
+		Cell:   cell,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *CorruptError) Error() string {
+	msg := "sqlite3: corrupt database"
+	if e.Page > 0 {
+		msg += fmt.Sprintf(" (page=%d", e.Page)
+		if e.Cell >= 0 {
+			msg += fmt.Sprintf(", cell=%d", e.Cell)
+		}
+		if e.Offset >= 0 {
+			msg += fmt.Sprintf(", offset=%d", e.Offset)
+		}
+		msg += ")"
+	}
+	return msg + ": " + e.Reason
+}
+
+// Is reports whether target is ErrCorrupt.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan is the plan of a query, as returned by Explain.
+type Plan struct {
+	Nodes []*PlanNode // steps of the query, in order
+}
+
+// PlanNode is a step of the plan of a query.
+type PlanNode struct {
+	Detail   string      // description of the step
+	Children []*PlanNode // steps run by this one, if any
+}
+
+// String returns the plan as printed by the sqlite3 shell for an EXPLAIN
+// QUERY PLAN statement.
+func (p *Plan) String() string {
+	var b strings.Builder
+	b.WriteString("QUERY PLAN\n")
+	writePlanNodes(&b, p.Nodes, "")
+	return b.String()
+}
+
+func writePlanNodes(b *strings.Builder, nodes []*PlanNode, indent string) {
+	for i, n := range nodes {
+		branch, next := "|--", "|  "
+		if i == len(nodes)-1 {
+			branch, next = "`--", "   "
+		}
+		fmt.Fprintf(b, "%s%s%s\n", indent, branch, n.Detail)
+		writePlanNodes(b, n.Children, indent+next)
+	}
+}
+
+// Explain compiles the SELECT statement query, without running it, and
+// returns its plan, described like the EXPLAIN QUERY PLAN statement of
+// SQLite does:
+//
+//	SCAN t                                      scan of the whole table t
+//	SEARCH t USING INTEGER PRIMARY KEY (rowid=?) lookup by rowid
+//	SEARCH t USING INDEX i (a=? AND b>?)        lookup with the index i
+//	SEARCH t USING PRIMARY KEY (a=?)            lookup of a WITHOUT ROWID table
+//	MATERIALIZE t                               rows of a view or common table expression
+//	USE TEMP B-TREE FOR ORDER BY                sort of the rows
+//
+// The tables of a SELECT statement are listed in join order, each one
+// being scanned or looked up for each row of the previous ones. Lookups
+// of the right table of a LEFT JOIN are followed by LEFT-JOIN.
+//
+// The counters of a query, collected while it runs, are returned by the
+// Stats method of its Rows.
+func (db *DbFile) Explain(query string) (*Plan, error) {
+	stmt, _, err := parseSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := db.compileQuery(stmt, nil)
+	if err != nil {
+		return nil, err
+	}
+	x := &explainer{seen: make(map[*derivedTable]bool)}
+	return &Plan{Nodes: x.plan(plan)}, nil
+}
+
+// explainer describes the steps of query plans.
+type explainer struct {
+	seen map[*derivedTable]bool // derived tables already described
+}
+
+func planNode(detail string, children ...*PlanNode) *PlanNode {
+	return &PlanNode{Detail: detail, Children: children}
+}
+
+// plan returns the steps of the query plan.
+func (x *explainer) plan(plan queryPlan) []*PlanNode {
+	switch plan := plan.(type) {
+	case *selectPlan:
+		return x.selectPlan(plan)
+	case *compoundPlan:
+		// a VALUES clause is a compound SELECT of a single term.
+		nodes := x.plan(plan.terms[0])
+		if len(plan.ops) > 0 {
+			n := planNode("COMPOUND QUERY", planNode("LEFT-MOST SUBQUERY", nodes...))
+			for i, op := range plan.ops {
+				if op != "UNION ALL" {
+					op += " USING TEMP B-TREE"
+				}
+				n.Children = append(n.Children, planNode(op, x.plan(plan.terms[i+1])...))
+			}
+			nodes = []*PlanNode{n}
+		}
+		if len(plan.order) > 0 {
+			nodes = append(nodes, planNode("USE TEMP B-TREE FOR ORDER BY"))
+		}
+		return nodes
+	case *recursivePlan:
+		var steps []*PlanNode
+		for _, step := range plan.steps {
+			steps = append(steps, x.plan(step)...)
+		}
+		return []*PlanNode{
+			planNode("SETUP", x.plan(plan.initial)...),
+			planNode("RECURSIVE STEP", steps...),
+		}
+	case *valuesPlan:
+		if len(plan.rows) == 1 {
+			return []*PlanNode{planNode("SCAN CONSTANT ROW")}
+		}
+		return []*PlanNode{planNode(fmt.Sprintf("SCAN %d-ROW VALUES CLAUSE", len(plan.rows)))}
+	}
+	panic(fmt.Sprintf("sqlite3: unknown query plan %T", plan))
+}
+
+// selectPlan returns the steps of the plan of a simple SELECT statement.
+func (x *explainer) selectPlan(plan *selectPlan) []*PlanNode {
+	var nodes []*PlanNode
+	if len(plan.sources) == 0 {
+		nodes = append(nodes, planNode("SCAN CONSTANT ROW"))
+	}
+	for _, src := range plan.sources {
+		if d := src.derived; d != nil && !src.recursive && !x.seen[d] {
+			x.seen[d] = true
+			nodes = append(nodes, planNode("MATERIALIZE "+d.name, x.plan(d.plan)...))
+		}
+		nodes = append(nodes, planNode(src.explain()))
+	}
+	if len(plan.groupBy) > 0 {
+		nodes = append(nodes, planNode("USE TEMP B-TREE FOR GROUP BY"))
+	}
+	if len(plan.wins) > 0 {
+		nodes = append(nodes, planNode("USE TEMP B-TREE FOR WINDOW"))
+	}
+	if plan.distinct {
+		nodes = append(nodes, planNode("USE TEMP B-TREE FOR DISTINCT"))
+	}
+	if len(plan.order) > 0 {
+		nodes = append(nodes, planNode("USE TEMP B-TREE FOR ORDER BY"))
+	}
+	return nodes
+}
+
+// explain describes the scan or the lookup of the source.
+func (src *source) explain() string {
+	var (
+		l      = src.lookup
+		detail string
+	)
+	switch {
+	case l == nil:
+		detail = "SCAN " + src.name
+	case l.index == nil:
+		detail = "SEARCH " + src.name + " USING INTEGER PRIMARY KEY (rowid=?)"
+	default:
+		using := "INDEX " + l.index.name
+		if l.index.pageid == src.table.pageid {
+			using = "PRIMARY KEY"
+		}
+		var (
+			names = l.index.Columns()
+			conds []string
+		)
+		for i := range l.eq {
+			conds = append(conds, names[i]+"=?")
+		}
+		if l.lo != nil {
+			conds = append(conds, names[len(l.eq)]+">?")
+		}
+		if l.hi != nil {
+			conds = append(conds, names[len(l.eq)]+"<?")
+		}
+		detail = fmt.Sprintf("SEARCH %s USING %s (%s)", src.name, using, strings.Join(conds, " AND "))
+	}
+	if src.left {
+		detail += " LEFT-JOIN"
+	}
+	return detail
+}
+
+// planSources calls f with each source of the query plan, and of the
+// plans of its derived tables.
+func planSources(plan queryPlan, f func(*source)) {
+	seen := make(map[*derivedTable]bool)
+	var walk func(plan queryPlan)
+	walk = func(plan queryPlan) {
+		switch plan := plan.(type) {
+		case *selectPlan:
+			for _, src := range plan.sources {
+				f(src)
+				if d := src.derived; d != nil && !src.recursive && !seen[d] {
+					seen[d] = true
+					walk(d.plan)
+				}
+			}
+		case *compoundPlan:
+			for _, term := range plan.terms {
+				walk(term)
+			}
+		case *recursivePlan:
+			walk(plan.initial)
+			for _, step := range plan.steps {
+				walk(step)
+			}
+		}
+	}
+	walk(plan)
+}
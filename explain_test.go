@@ -0,0 +1,164 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import "testing"
+
+func TestExplain(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected plans are those of SQLite, but for the join order, the
+	// covering indexes, and the materialization of views and common
+	// table expressions.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select * from urls`,
+			want: "QUERY PLAN\n" +
+				"`--SCAN urls\n",
+		},
+		{
+			query: `select * from urls where id = 3`,
+			want: "QUERY PLAN\n" +
+				"`--SEARCH urls USING INTEGER PRIMARY KEY (rowid=?)\n",
+		},
+		{
+			query: `select * from visits where url between 3 and 5`,
+			want: "QUERY PLAN\n" +
+				"`--SEARCH visits USING INDEX visits_url_index (url>? AND url<?)\n",
+		},
+		{
+			query: `select * from tags where url = 'x' and tag > 'a'`,
+			want: "QUERY PLAN\n" +
+				"`--SEARCH tags USING PRIMARY KEY (url=? AND tag>?)\n",
+		},
+		{
+			query: `select * from urls u left join visits v on v.url = u.id where u.id = 4`,
+			want: "QUERY PLAN\n" +
+				"|--SEARCH u USING INTEGER PRIMARY KEY (rowid=?)\n" +
+				"`--SEARCH v USING INDEX visits_url_index (url=?) LEFT-JOIN\n",
+		},
+		{
+			query: `select transition, count(*) from visits group by transition order by 2`,
+			want: "QUERY PLAN\n" +
+				"|--SCAN visits\n" +
+				"|--USE TEMP B-TREE FOR GROUP BY\n" +
+				"`--USE TEMP B-TREE FOR ORDER BY\n",
+		},
+		{
+			query: `select distinct transition from visits`,
+			want: "QUERY PLAN\n" +
+				"|--SCAN visits\n" +
+				"`--USE TEMP B-TREE FOR DISTINCT\n",
+		},
+		{
+			query: `with recursive c(x) as (select 1 union all select x+1 from c where x < 3) select * from c`,
+			want: "QUERY PLAN\n" +
+				"|--MATERIALIZE c\n" +
+				"|  |--SETUP\n" +
+				"|  |  `--SCAN CONSTANT ROW\n" +
+				"|  `--RECURSIVE STEP\n" +
+				"|     `--SCAN c\n" +
+				"`--SCAN c\n",
+		},
+		{
+			query: `with t as (select * from urls where id = 1) select * from t, t t2`,
+			want: "QUERY PLAN\n" +
+				"|--MATERIALIZE t\n" +
+				"|  `--SEARCH urls USING INTEGER PRIMARY KEY (rowid=?)\n" +
+				"|--SCAN t\n" +
+				"`--SCAN t2\n",
+		},
+		{
+			query: `select id from urls union select url from visits order by 1`,
+			want: "QUERY PLAN\n" +
+				"|--COMPOUND QUERY\n" +
+				"|  |--LEFT-MOST SUBQUERY\n" +
+				"|  |  `--SCAN urls\n" +
+				"|  `--UNION USING TEMP B-TREE\n" +
+				"|     `--SCAN visits\n" +
+				"`--USE TEMP B-TREE FOR ORDER BY\n",
+		},
+		{
+			query: `select * from top_popular`,
+			want: "QUERY PLAN\n" +
+				"|--MATERIALIZE top_popular\n" +
+				"|  |--MATERIALIZE popular\n" +
+				"|  |  |--SCAN urls\n" +
+				"|  |  |--SEARCH visits USING INDEX visits_url_index (url=?)\n" +
+				"|  |  `--USE TEMP B-TREE FOR GROUP BY\n" +
+				"|  |--SCAN popular\n" +
+				"|  `--USE TEMP B-TREE FOR ORDER BY\n" +
+				"`--SCAN top_popular\n",
+		},
+		{
+			query: `values (1), (2)`,
+			want: "QUERY PLAN\n" +
+				"`--SCAN 2-ROW VALUES CLAUSE\n",
+		},
+		{
+			query: `select 1`,
+			want: "QUERY PLAN\n" +
+				"`--SCAN CONSTANT ROW\n",
+		},
+	} {
+		plan, err := db.Explain(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if got := plan.String(); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+
+	if _, err := db.Explain(`select * from nosuchtable`); err == nil {
+		t.Errorf("no error for an unknown table")
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the urls table b-tree has 14 pages, of depth 2, for 200 rows.
+	for _, tc := range []struct {
+		query string
+		want  QueryStats
+	}{
+		{`select * from urls`, QueryStats{PagesRead: 14, RowsExamined: 200}},
+		{`select * from urls where visit_count > 1000`, QueryStats{PagesRead: 14, RowsExamined: 200}},
+		{`select * from urls where id = 3`, QueryStats{PagesRead: 2, RowsExamined: 1}},
+		{`select * from urls where id = 1000`, QueryStats{PagesRead: 2, RowsExamined: 0}},
+		{`select * from urls u, urls v where v.id = u.id`, QueryStats{PagesRead: 14 + 2*200, RowsExamined: 400}},
+		{`with recursive c(x) as (select 1 union all select x+1 from c where x < 3) select * from c`, QueryStats{RowsExamined: 6}},
+		{`select 1`, QueryStats{}},
+	} {
+		rows, err := db.Query(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if got := rows.Stats(); got != (QueryStats{}) {
+			t.Errorf("%s: got %+v before the end of the query", tc.query, got)
+		}
+		for rows.Next() {
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if got := rows.Stats(); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// env is the environment in which expressions are evaluated.
+type env struct {
+	row  []interface{} // values of the current row, by slot
+	aggs []interface{} // values of the aggregate functions of the current group
+	wins []interface{} // values of the window functions of the current row
+	now  time.Time     // time of the statement, set when first used
+}
+
+// time returns the current time of the statement, the same for all the
+// calls of the date and time functions.
+func (e *env) time() time.Time {
+	if e.now.IsZero() {
+		e.now = time.Now()
+	}
+	return e.now
+}
+
+// expr is a node of a SQL expression.
+type expr interface {
+	// eval returns the value of the expression, one of nil, int64,
+	// float64, string or []byte.
+	eval(e *env) (interface{}, error)
+}
+
+// literal is a constant value.
+type literal struct {
+	v interface{}
+}
+
+func (x *literal) eval(*env) (interface{}, error) {
+	return x.v, nil
+}
+
+// param is a parameter of a statement, bound to the value of an
+// argument of the query.
+type param struct {
+	name  string // name of the parameter, as written: "?", "?2", ":x", ...
+	index int    // index of the parameter, starting at 1
+	v     interface{}
+}
+
+func (x *param) eval(*env) (interface{}, error) {
+	return x.v, nil
+}
+
+// colRef is a reference to a column of a table of the FROM clause.
+type colRef struct {
+	table  string // name or alias of the table, if qualified
+	name   string
+	quoted bool // whether the name is a quoted identifier
+
+	slot  int      // slot holding the value of the column in the rows, or -1
+	aff   affinity // affinity of the column
+	coll  string   // name of the collation of the column
+	alias expr     // result column the name is the alias of, if any
+}
+
+func (x *colRef) eval(e *env) (interface{}, error) {
+	if x.alias != nil {
+		return x.alias.eval(e)
+	}
+	if x.slot < 0 {
+		// like SQLite, a quoted identifier that is not the name of
+		// a column is a string literal.
+		return x.name, nil
+	}
+	return e.row[x.slot], nil
+}
+
+// unaryExpr is a prefix operator applied to an expression: -, +, ~ or
+// NOT, or a postfix ISNULL or NOTNULL operator.
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+func (x *unaryExpr) eval(e *env) (interface{}, error) {
+	v, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "ISNULL":
+		return boolValue(v == nil), nil
+	case "NOTNULL":
+		return boolValue(v != nil), nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch x.op {
+	case "-":
+		return negate(v), nil
+	case "+":
+		return v, nil
+	case "~":
+		return ^toInteger(v), nil
+	case "NOT":
+		ok, _ := truth(v)
+		return boolValue(!ok), nil
+	}
+	return nil, fmt.Errorf("sqlite3: invalid unary operator %q", x.op)
+}
+
+// binaryExpr is a binary operator applied to two expressions.
+type binaryExpr struct {
+	op   string // operator, with == normalized to = and <> to !=
+	x, y expr
+	coll collation // collation of comparisons
+}
+
+func (x *binaryExpr) eval(e *env) (interface{}, error) {
+	switch x.op {
+	case "AND", "OR":
+		return x.logical(e)
+	}
+
+	a, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	b, err := x.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "+", "-", "*", "/", "%":
+		return arith(x.op, a, b), nil
+	case "&", "|", "<<", ">>":
+		return bitwise(x.op, a, b), nil
+	case "||":
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		return textValue(a) + textValue(b), nil
+	case "IS", "IS NOT":
+		eq := false
+		switch {
+		case a == nil || b == nil:
+			eq = a == nil && b == nil
+		default:
+			eq = compare(x.x, x.y, a, b, x.coll) == 0
+		}
+		return boolValue(eq == (x.op == "IS")), nil
+	}
+
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	c := compare(x.x, x.y, a, b, x.coll)
+	switch x.op {
+	case "=":
+		return boolValue(c == 0), nil
+	case "!=":
+		return boolValue(c != 0), nil
+	case "<":
+		return boolValue(c < 0), nil
+	case "<=":
+		return boolValue(c <= 0), nil
+	case ">":
+		return boolValue(c > 0), nil
+	case ">=":
+		return boolValue(c >= 0), nil
+	}
+	return nil, fmt.Errorf("sqlite3: invalid binary operator %q", x.op)
+}
+
+// logical evaluates the AND and OR operators, with the three-valued
+// logic of SQL.
+func (x *binaryExpr) logical(e *env) (interface{}, error) {
+	a, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	ta, na := truth(a)
+	if !na && ta == (x.op == "OR") {
+		// the result does not depend on the right operand.
+		return boolValue(ta), nil
+	}
+	b, err := x.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	tb, nb := truth(b)
+	switch {
+	case !nb && tb == (x.op == "OR"):
+		return boolValue(tb), nil
+	case na || nb:
+		return nil, nil
+	}
+	return boolValue(tb), nil
+}
+
+// exprAffinity returns the affinity of the expression x.
+func exprAffinity(x expr) affinity {
+	switch x := x.(type) {
+	case *colRef:
+		return x.aff
+	case *castExpr:
+		return x.aff
+	case *collateExpr:
+		return exprAffinity(x.x)
+	}
+	return affNone
+}
+
+// compare compares the values a and b of the expressions x and y, after
+// applying the affinity of the comparison, with the collation coll.
+func compare(x, y expr, a, b interface{}, coll collation) int {
+	aff := compareAffinity(exprAffinity(x), exprAffinity(y))
+	return compareCollated(applyAffinity(a, aff), applyAffinity(b, aff), coll)
+}
+
+// likeExpr is a LIKE or GLOB operator.
+type likeExpr struct {
+	op      string // "LIKE" or "GLOB"
+	not     bool
+	x       expr
+	pattern expr
+	escape  expr // escape character of LIKE patterns, if any
+}
+
+func (x *likeExpr) eval(e *env) (interface{}, error) {
+	s, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := x.pattern.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	var esc rune
+	if x.escape != nil {
+		v, err := x.escape.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		if esc, err = escapeChar(v); err != nil {
+			return nil, err
+		}
+	}
+	if s == nil || pat == nil {
+		return nil, nil
+	}
+	var ok bool
+	switch x.op {
+	case "GLOB":
+		ok = globMatch(textValue(pat), textValue(s))
+	default:
+		ok = likeMatch(textValue(pat), textValue(s), esc)
+	}
+	return boolValue(ok != x.not), nil
+}
+
+// escapeChar returns the escape character of LIKE patterns given by the
+// non-NULL value v.
+func escapeChar(v interface{}) (rune, error) {
+	s := textValue(v)
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("sqlite3: ESCAPE expression must be a single character")
+	}
+	c, _ := utf8.DecodeRuneInString(s)
+	return c, nil
+}
+
+// betweenExpr is a BETWEEN operator.
+type betweenExpr struct {
+	not       bool
+	x, lo, hi expr
+	colls     [2]collation // collations of the comparisons with lo and hi
+}
+
+func (x *betweenExpr) eval(e *env) (interface{}, error) {
+	v, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	lo, err := x.lo.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := x.hi.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	// v >= lo AND v <= hi
+	var (
+		geLo, nullLo = false, lo == nil
+		leHi, nullHi = false, hi == nil
+	)
+	if !nullLo {
+		geLo = compare(x.x, x.lo, v, lo, x.colls[0]) >= 0
+	}
+	if !nullHi {
+		leHi = compare(x.x, x.hi, v, hi, x.colls[1]) <= 0
+	}
+	switch {
+	case (!nullLo && !geLo) || (!nullHi && !leHi):
+		return boolValue(x.not), nil
+	case nullLo || nullHi:
+		return nil, nil
+	}
+	return boolValue(!x.not), nil
+}
+
+// inExpr is an IN operator with a list of values.
+type inExpr struct {
+	not  bool
+	x    expr
+	list []expr
+	coll collation // collation of the comparisons
+}
+
+func (x *inExpr) eval(e *env) (interface{}, error) {
+	v, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(x.list) == 0 {
+		return boolValue(x.not), nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	null := false
+	for _, item := range x.list {
+		w, err := item.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			null = true
+			continue
+		}
+		if compare(x.x, item, v, w, x.coll) == 0 {
+			return boolValue(!x.not), nil
+		}
+	}
+	if null {
+		return nil, nil
+	}
+	return boolValue(x.not), nil
+}
+
+// caseExpr is a CASE expression.
+type caseExpr struct {
+	x     expr // base expression compared with the WHEN ones, if any
+	whens []whenClause
+	els   expr // ELSE expression, if any
+}
+
+type whenClause struct {
+	cond expr
+	then expr
+	coll collation // collation of the comparison with the base expression
+}
+
+func (x *caseExpr) eval(e *env) (interface{}, error) {
+	var base interface{}
+	if x.x != nil {
+		v, err := x.x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		base = v
+	}
+	for _, w := range x.whens {
+		c, err := w.cond.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		switch {
+		case x.x == nil:
+			ok, _ = truth(c)
+		default:
+			ok = base != nil && c != nil && compare(x.x, w.cond, base, c, w.coll) == 0
+		}
+		if ok {
+			return w.then.eval(e)
+		}
+	}
+	if x.els == nil {
+		return nil, nil
+	}
+	return x.els.eval(e)
+}
+
+// castExpr is a CAST expression.
+type castExpr struct {
+	x   expr
+	typ string   // name of the type
+	aff affinity // affinity of the type
+}
+
+func (x *castExpr) eval(e *env) (interface{}, error) {
+	v, err := x.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return castValue(v, x.aff), nil
+}
+
+// collateExpr is a COLLATE operator, selecting the collation used to
+// compare the value of an expression.
+type collateExpr struct {
+	x    expr
+	name string
+}
+
+func (x *collateExpr) eval(e *env) (interface{}, error) {
+	return x.x.eval(e)
+}
+
+// funcExpr is a call of a function.
+type funcExpr struct {
+	name     string
+	args     []expr
+	star     bool // whether the arguments are written *, as in count(*)
+	distinct bool
+
+	over *windowDef // OVER clause of a call of a window function, if any
+
+	fn     *scalarFunc // scalar function called, if any
+	agg    *aggFunc    // aggregate function called, if any
+	win    *winFunc    // window function called, if any
+	window *window     // window of the window function
+	slot   int         // index of the value of the aggregate or window function in the groups or rows
+	coll   collation   // collation of the arguments
+}
+
+func (x *funcExpr) eval(e *env) (interface{}, error) {
+	if x.win != nil {
+		return e.wins[x.slot], nil
+	}
+	if x.agg != nil {
+		return e.aggs[x.slot], nil
+	}
+	if x.fn == nil {
+		return nil, fmt.Errorf("sqlite3: no such function: %s", x.name)
+	}
+	args := make([]interface{}, len(x.args))
+	for i, arg := range x.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if x.fn.lazy && v != nil {
+			return v, nil
+		}
+		args[i] = v
+	}
+	if x.fn.lazy {
+		return nil, nil
+	}
+	return x.fn.call(callCtx{e: e, coll: x.coll}, args)
+}
+
+// walkExpr calls f for x and, unless f returns false, for each of the
+// sub-expressions of x, depth first.
+func walkExpr(x expr, f func(expr) bool) {
+	if x == nil || !f(x) {
+		return
+	}
+	switch x := x.(type) {
+	case *unaryExpr:
+		walkExpr(x.x, f)
+	case *binaryExpr:
+		walkExpr(x.x, f)
+		walkExpr(x.y, f)
+	case *likeExpr:
+		walkExpr(x.x, f)
+		walkExpr(x.pattern, f)
+		walkExpr(x.escape, f)
+	case *betweenExpr:
+		walkExpr(x.x, f)
+		walkExpr(x.lo, f)
+		walkExpr(x.hi, f)
+	case *inExpr:
+		walkExpr(x.x, f)
+		for _, item := range x.list {
+			walkExpr(item, f)
+		}
+	case *caseExpr:
+		walkExpr(x.x, f)
+		for _, w := range x.whens {
+			walkExpr(w.cond, f)
+			walkExpr(w.then, f)
+		}
+		walkExpr(x.els, f)
+	case *castExpr:
+		walkExpr(x.x, f)
+	case *collateExpr:
+		walkExpr(x.x, f)
+	case *funcExpr:
+		for _, arg := range x.args {
+			walkExpr(arg, f)
+		}
+	}
+}
@@ -5,11 +5,13 @@
 package sqlite3
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/gonuts/binary"
 )
@@ -20,15 +22,26 @@ const (
 	printfDebug = false
 )
 
-var (
-	tblconstraints = []string{"CHECK", "FOREIGN KEY", "UNIQUE", "PRIMARY KEY"}
-)
-
+// DbFile is a SQLite database file.
+// DbFile is safe for concurrent use by multiple goroutines.
 type DbFile struct {
-	pager  pager
+	pager  *pager
 	header dbHeader
 	tables []Table
 	close  func() error
+
+	schema   []SchemaObject
+	internal []Table // internal tables, such as sqlite_sequence
+	indexes  []Index
+	views    []View
+	stats    map[string]*TableStats // statistics of the analyzed tables, by lower-case name
+
+	zeroCopy bool // whether decoded values may point into the pages
+
+	attachMu sync.RWMutex
+	attached []attachment // attached databases, in order
+
+	rawHeader Header // header as read from the file
 }
 
 type dbHeader struct {
@@ -65,51 +78,104 @@ type dbHeader struct {
 	SqliteVersion int32    // SQLITE_VERSION_NUMBER
 }
 
-func OpenFrom(f io.ReadSeeker) (*DbFile, error) {
+// OpenFrom opens the SQLite database read from f.
+//
+// If f also implements io.ReaderAt, pages are read with ReadAt and the
+// returned DbFile may be used concurrently from multiple goroutines.
+func OpenFrom(f io.ReadSeeker, opts ...Option) (*DbFile, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		r = &seekReaderAt{f: f}
+	}
+	return OpenReaderAt(r, size, opts...)
+}
+
+// OpenReaderAt opens the SQLite database of the given size, in bytes,
+// read from r.
+//
+// The returned DbFile is safe for concurrent use by multiple goroutines.
+func OpenReaderAt(r io.ReaderAt, size int64, opts ...Option) (*DbFile, error) {
+	return open(r, size, nil, newOptions(opts))
+}
+
+// open opens the SQLite database of the given size read from r.
+// If data is not nil, it holds the whole content of the database file
+// and pages are directly retrieved from it.
+func open(r io.ReaderAt, size int64, data []byte, o options) (*DbFile, error) {
 	var db DbFile
 
-	dec := binary.NewDecoder(f)
+	var hdr io.Reader = io.NewSectionReader(r, 0, size)
+	if o.codec != nil {
+		// the file header may be encoded as well.
+		page, err := decodeFirstPage(r, o.codec)
+		if err != nil {
+			return nil, err
+		}
+		hdr = bytes.NewReader(page)
+	}
+
+	dec := binary.NewDecoder(hdr)
 	dec.Order = binary.BigEndian
 	err := dec.Decode(&db.header)
 	if err != nil {
 		return nil, err
 	}
 
-	if db.header.DbSize == 0 {
+	if string(db.header.Magic[:]) != sqlite3Magic {
+		return nil, fmt.Errorf(
+			"sqlite: invalid file header.\ngot:  %q\nwant: %q\n",
+			string(db.header.Magic[:]),
+			sqlite3Magic,
+		)
+	}
+
+	if err := db.header.validate(); err != nil {
+		return nil, err
+	}
+	db.rawHeader = db.header.export()
+
+	pagesz := db.PageSize()
+	if !db.header.dbSizeValid() {
 		// determine it based on the size of the database file.
 		// if the size of the database file is not an integer multiple of
 		// the page-size, round down to the nearest page.
 		// except, any file larger than 0-bytes in size, is considered to
 		// contain at least one page.
-		size, err := f.Seek(0, io.SeekEnd)
-		if err != nil {
-			return nil, err
-		}
-		if _, err := f.Seek(0, io.SeekStart); err != nil {
-			return nil, err
-		}
-		pagesz := int64(db.header.PageSize)
+		pagesz := int64(pagesz)
 		npages := (size + pagesz - 1) / pagesz
 		db.header.DbSize = int32(npages)
 	}
 
+	if npages := (size + int64(pagesz) - 1) / int64(pagesz); int64(db.header.DbSize) > npages {
+		// pages past the end of the file can not be read.
+		db.header.DbSize = int32(npages)
+	}
+
 	if printfDebug {
 		fmt.Printf("db: %#v\n", db.header)
 	}
-	_, err = f.Seek(0, 0)
-	if err != nil {
-		return nil, err
-	}
 
-	if string(db.header.Magic[:]) != sqlite3Magic {
-		return nil, fmt.Errorf(
-			"sqlite: invalid file header.\ngot:  %q\nwant: %q\n",
-			string(db.header.Magic[:]),
-			sqlite3Magic,
-		)
+	db.pager = newPager(r, db.PageSize(), db.NumPage())
+	if data != nil {
+		db.pager.data = data
+		db.zeroCopy = true
 	}
-
-	db.pager = newPager(f, db.PageSize(), db.NumPage())
+	if o.checksums {
+		err = db.hasChecksums()
+		if err != nil {
+			return nil, err
+		}
+		db.pager.checksums = true
+	}
+	db.pager.codec = o.codec
 
 	err = db.init()
 	if err != nil {
@@ -119,18 +185,78 @@ func OpenFrom(f io.ReadSeeker) (*DbFile, error) {
 	return &db, err
 }
 
-func Open(fname string) (*DbFile, error) {
+// decodeFirstPage reads and decodes the first page of the database read
+// from r.
+func decodeFirstPage(r io.ReaderAt, codec PageCodec) ([]byte, error) {
+	var pagesz int
+	switch c := codec.(type) {
+	case codecPageSizer:
+		pagesz = c.pageSize()
+	default:
+		var buf [2]byte
+		_, err := r.ReadAt(buf[:], 16)
+		if err != nil {
+			return nil, err
+		}
+		pagesz = int(buf[0])<<8 | int(buf[1])
+		if pagesz == 1 {
+			pagesz = 65536
+		}
+	}
+	if pagesz < 512 || pagesz > 65536 {
+		return nil, fmt.Errorf("sqlite3: invalid page size (%d)", pagesz)
+	}
+
+	raw := make([]byte, pagesz)
+	_, err := r.ReadAt(raw, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return codec.Decode(1, raw)
+}
+
+// Open opens the named SQLite database file.
+func Open(fname string, opts ...Option) (*DbFile, error) {
+	o := newOptions(opts)
+
 	f, err := os.Open(fname)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := OpenFrom(f)
+	fi, err := f.Stat()
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
+
+	var data []byte
+	if o.mmap && fi.Size() > 0 {
+		data, err = mmap(f, fi.Size())
+		if err != nil {
+			// fall back to regular reads.
+			data = nil
+		}
+	}
+
+	db, err := open(f, fi.Size(), data, o)
+	if err != nil {
+		if data != nil {
+			munmap(data)
+		}
+		f.Close()
+		return nil, err
+	}
 	db.close = f.Close
+	if data != nil {
+		db.close = func() error {
+			err := munmap(data)
+			if e := f.Close(); e != nil && err == nil {
+				err = e
+			}
+			return err
+		}
+	}
 	return db, nil
 }
 
@@ -144,7 +270,7 @@ func (db *DbFile) Close() error {
 
 // PageSize returns the database page size in bytes
 func (db *DbFile) PageSize() int {
-	return int(db.header.PageSize)
+	return db.header.pageSize()
 }
 
 // NumPage returns the number of pages for this database
@@ -175,7 +301,7 @@ func (db *DbFile) init() error {
 	}
 
 	if page.Kind() != BTreeLeafTableKind && page.Kind() != BTreeInteriorTableKind {
-		return fmt.Errorf("sqlite3: invalid page kind (%v)", page.Kind())
+		return corruptf(1, -1, 100, "invalid page kind (%v)", page.Kind())
 	}
 
 	btree, err := newBtreeTable(page, db)
@@ -188,129 +314,182 @@ func (db *DbFile) init() error {
 		fmt.Printf(">>> init... (ncells=%d)\n", btree.NumCell())
 	}
 
-	return btree.visitRecordsInorder(func(_ *int64, rec Record) error {
+	if btree.NumCell() > 0 && (db.header.SchemaFormat == 0 || db.header.DbEncoding == 0) {
+		// only empty databases may have no schema format or encoding.
+		return corruptf(1, -1, 44, "invalid schema format (%d) or text encoding (%d) for a non-empty schema",
+			db.header.SchemaFormat, db.header.DbEncoding)
+	}
+
+	err = btree.visitRecordsInorder(func(_ *int64, rec Record) error {
 		// {"table", "tbl1", "tbl1", 2, "CREATE TABLE tbl1(one varchar(10), two smallint)"} (body=62)
 		// {"table", "tbl2", "tbl2", 3, "CREATE TABLE tbl2(\n f1 varchar(30) primary key,\n f2 text,\n f3 real\n)"}
 		if len(rec.Values) != 5 {
-			return fmt.Errorf("sqlite3: invalid table format")
+			return corruptf(0, -1, -1, "invalid table format")
 		}
 
-		rectype := rec.Values[0].(string)
-		if rectype != "table" {
-			return nil
+		var obj SchemaObject
+		for i, v := range []*string{&obj.Type, &obj.Name, &obj.TableName} {
+			s, ok := rec.Values[i].(string)
+			if !ok {
+				return corruptf(0, -1, -1, "invalid sqlite_master record (%T)", rec.Values[i])
+			}
+			*v = s
 		}
-
-		pageid := reflect.ValueOf(rec.Values[3])
-		table := Table{
-			name:   rec.Values[1].(string),
-			pageid: int(pageid.Int()),
+		if rec.Values[3] != nil {
+			pageid, ok := toInt64(rec.Values[3])
+			if !ok || pageid < 0 || pageid > math.MaxInt32 {
+				return corruptf(0, -1, -1, "invalid root page for %s %q (%v)", obj.Type, obj.Name, rec.Values[3])
+			}
+			obj.RootPage = int(pageid)
 		}
-
-		// skip internal tables, aka don't expose them
-		if strings.HasPrefix(table.name, "sqlite_") {
-			return nil
+		if rec.Values[4] != nil {
+			sql, ok := rec.Values[4].(string)
+			if !ok {
+				return corruptf(0, -1, -1, "invalid definition for %s %q (%T)", obj.Type, obj.Name, rec.Values[4])
+			}
+			obj.SQL = sql
 		}
+		db.schema = append(db.schema, obj)
 
-		def := rec.Values[4].(string)
-		def = strings.Replace(def, "CREATE TABLE "+table.name, "", 1)
-		def = strings.Replace(def, "\n", "", -1)
-		def = strings.TrimSpace(def)
-		if def[0] == '(' {
-			def = def[1:]
+		if obj.Type != "table" || obj.RootPage == 0 {
+			// virtual tables have no b-tree.
+			return nil
 		}
-		if def[len(def)-1] == ')' {
-			def = def[:len(def)-1]
+		if obj.SQL == "" {
+			return corruptf(0, -1, -1, "empty definition for table %q", obj.Name)
 		}
-		def = strings.TrimSpace(def)
 
-		parts := strings.Split(def, ",")
-		// strip away statements like 'UNIQUE ...' or 'PRIMARY KEY ...' from a table definition
-		for i := range parts {
-			if i >= len(parts) {
-				break // we removed at least one elem, so avoid out of bounds read
-			}
-
-			parts[i] = strings.TrimSpace(parts[i])
-			for j := range tblconstraints {
-				if strings.HasPrefix(parts[i], tblconstraints[j]) {
-					// drop all other elements
-					parts = parts[:i]
-					break
-				}
-			}
+		table, err := parseCreateTable(obj.SQL)
+		if err != nil {
+			return fmt.Errorf("sqlite3: invalid definition for table %q: %w", obj.Name, err)
 		}
+		table.db = db
+		table.name = obj.Name
+		table.pageid = obj.RootPage
+		table.sql = obj.SQL
 
-		table.cols = make([]Column, len(parts))
-		for i := range parts {
-			parts[i] = strings.TrimSpace(parts[i])
-			idx := strings.Index(parts[i], " ") // find where col name ends and type starts
-			if idx > 0 {
-				table.cols[i].name = parts[i][:idx]
-			} else {
-				table.cols[i].name = parts[i]
-			}
+		if printfDebug {
+			fmt.Printf(">>> def: %q => ncols=%d\n", obj.SQL, len(table.cols))
 		}
 
-		if printfDebug {
-			fmt.Printf(">>> def: %q => ncols=%d\n", def, len(table.cols))
+		// don't expose internal tables
+		if strings.HasPrefix(table.name, "sqlite_") {
+			db.internal = append(db.internal, table)
+			return nil
 		}
 
 		db.tables = append(db.tables, table)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if err := db.initIndexes(); err != nil {
+		return err
+	}
+	if err := db.initStats(); err != nil {
+		return err
+	}
+	return db.initViews()
 }
 
+// Table returns the table with the given name, including internal tables
+// such as sqlite_sequence, or nil if there is no such table.
+func (db *DbFile) Table(name string) *Table {
+	for _, tables := range [][]Table{db.tables, db.internal} {
+		for i := range tables {
+			if strings.EqualFold(tables[i].name, name) {
+				return &tables[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Dumpdb prints the kind of each page of the database, and the cells of
+// b-tree pages, on stdout.
 func (db *DbFile) Dumpdb() error {
-	var err error
-	for i := 1; i < db.NumPage(); i++ {
-		page, err := db.pager.Page(i)
+	roles := db.pageRoles()
+	for i := 1; i <= db.NumPage(); i++ {
+		info, err := db.inspectPage(i, roles)
 		if err != nil {
 			fmt.Printf("error: sqlite3: error retrieving page-%d: %v\n", i, err)
 			continue
 		}
-		fmt.Printf("page-%d: %v\n", i, page.Kind())
-		btree, err := newBtreeTable(page, db)
-		if err != nil {
-			fmt.Printf("** error: %v\n", err)
-			continue
+		fmt.Printf("page-%d: %v\n", i, info.Kind)
+		if info.Err != nil {
+			fmt.Printf("** error: %v\n", info.Err)
 		}
-		for i := 0; i < btree.NumCell(); i++ {
-			cell, err := btree.loadCell(i)
-			if err != nil {
-				fmt.Printf("** error: %v\n", err)
+		for j, cell := range info.Cells {
+			if cell.Err != nil {
+				fmt.Printf("** error: %v\n", cell.Err)
 				continue
 			}
+			var rowid int64
+			if cell.RowID != nil {
+				rowid = *cell.RowID
+			}
 			fmt.Printf("--- cell[%03d/%03d]= leftchildpage=%d row=%d payload=%d overflow=%d\n",
-				i+1, btree.NumCell(),
-				cell.LeftChildPage,
-				cell.RowID,
-				len(cell.Payload),
+				j+1, len(info.Cells),
+				cell.LeftChild,
+				rowid,
+				cell.PayloadSize,
 				cell.OverflowPage,
 			)
 		}
 	}
-
-	return err
+	return nil
 }
 
 // VisitTableRecords performs an inorder traversal of all cells in the
 // btree for the table with the given name, passing the (optional,
 // hence nullable) RowID, and record-decoded payload of each cell to
 // the visitor function `f`.
-func (db *DbFile) VisitTableRecords(tableName string, f func(*int64, Record) error) error {
-	for _, table := range db.tables {
-		if table.name != tableName {
-			continue
+//
+// Rows of WITHOUT ROWID tables have no RowID and are visited in primary
+// key order. Their record values and types are reordered to follow the
+// order of the table columns.
+//
+// The Columns option restricts the decoded values.
+//
+// If there is no table with the given name but a view, the rows of the
+// view are computed and passed to f, with a nil RowID, in records whose
+// values are those of Query, without header nor body.
+func (db *DbFile) VisitTableRecords(tableName string, f func(*int64, Record) error, opts ...ScanOption) error {
+	table := db.Table(tableName)
+	if table == nil {
+		if v := db.View(tableName); v != nil {
+			return v.visitRecords(f, opts)
 		}
-		page, err := db.pager.Page(table.pageid)
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+	o := newScanOptions(opts)
+	want, err := table.projection(o)
+	if err != nil {
+		return err
+	}
+	page, err := db.pager.Page(table.pageid)
+	if err != nil {
+		return err
+	}
+	if table.withoutRowid {
+		btree, err := newBtree(page, db)
 		if err != nil {
 			return err
 		}
-		btree, err := newBtreeTable(page, db)
-		if err != nil {
-			return err
+		if btree.isTable() {
+			return corruptf(btree.ID(), -1, btree.hdrAddr(), "invalid WITHOUT ROWID b-tree page kind (%v)", btree.Kind())
 		}
-		return btree.visitRecordsInorder(f)
+		btree.pages = o.pages
+		order := table.recordColumns()
+		return btree.visitRecords(want, func(_ *int64, rec Record) error {
+			return f(nil, table.tableRecord(order, rec))
+		})
+	}
+	btree, err := newBtreeTable(page, db)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown table %q", tableName)
+	btree.pages = o.pages
+	return btree.visitRecords(want, f)
 }
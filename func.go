@@ -0,0 +1,423 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// scalarFunc is a scalar function.
+type scalarFunc struct {
+	minArgs, maxArgs int // maxArgs is -1 for any number of arguments
+
+	// lazy is set for the functions returning their first non-NULL
+	// argument, whose other arguments are not evaluated.
+	lazy bool
+
+	// call returns the value of the function for the arguments args.
+	call func(c callCtx, args []interface{}) (interface{}, error)
+}
+
+// callCtx is the context of a call of a scalar function.
+type callCtx struct {
+	e    *env
+	coll collation // collation of the arguments
+}
+
+// scalarFuncs holds the built-in scalar functions, by lower case name.
+var scalarFuncs = map[string]*scalarFunc{
+	"abs":      {minArgs: 1, maxArgs: 1, call: absFunc},
+	"coalesce": {minArgs: 2, maxArgs: -1, lazy: true},
+	"glob":     {minArgs: 2, maxArgs: 2, call: globFunc},
+	"hex":      {minArgs: 1, maxArgs: 1, call: hexFunc},
+	"ifnull":   {minArgs: 2, maxArgs: 2, lazy: true},
+	"instr":    {minArgs: 2, maxArgs: 2, call: instrFunc},
+	"length":   {minArgs: 1, maxArgs: 1, call: lengthFunc},
+	"like":     {minArgs: 2, maxArgs: 3, call: likeFunc},
+	"lower":    {minArgs: 1, maxArgs: 1, call: caseFunc('A', 'Z', 'a'-'A')},
+	"ltrim":    {minArgs: 1, maxArgs: 2, call: trimFunc(strings.TrimLeft)},
+	"max":      {minArgs: 2, maxArgs: -1, call: minMaxFunc(1)},
+	"min":      {minArgs: 2, maxArgs: -1, call: minMaxFunc(-1)},
+	"nullif":   {minArgs: 2, maxArgs: 2, call: nullifFunc},
+	"printf":   {minArgs: 1, maxArgs: -1, call: printfFunc},
+	"replace":  {minArgs: 3, maxArgs: 3, call: replaceFunc},
+	"round":    {minArgs: 1, maxArgs: 2, call: roundFunc},
+	"rtrim":    {minArgs: 1, maxArgs: 2, call: trimFunc(strings.TrimRight)},
+	"substr":   {minArgs: 2, maxArgs: 3, call: substrFunc},
+	"trim":     {minArgs: 1, maxArgs: 2, call: trimFunc(strings.Trim)},
+	"typeof":   {minArgs: 1, maxArgs: 1, call: typeofFunc},
+	"upper":    {minArgs: 1, maxArgs: 1, call: caseFunc('a', 'z', 'A'-'a')},
+
+	"date":      {maxArgs: -1, call: dateFunc},
+	"datetime":  {maxArgs: -1, call: datetimeFunc},
+	"julianday": {maxArgs: -1, call: juliandayFunc},
+	"strftime":  {minArgs: 1, maxArgs: -1, call: strftimeFunc},
+	"time":      {maxArgs: -1, call: timeFunc},
+	"unixepoch": {maxArgs: -1, call: unixepochFunc},
+}
+
+func init() {
+	scalarFuncs["format"] = scalarFuncs["printf"]
+	scalarFuncs["substring"] = scalarFuncs["substr"]
+}
+
+var funcs = struct {
+	sync.RWMutex
+	m map[string]*scalarFunc
+}{
+	m: make(map[string]*scalarFunc),
+}
+
+// RegisterFunc makes the Go function fn available to the expressions of
+// queries as the SQL function name, called with nargs arguments, or
+// with any number of arguments if nargs is -1. Names are case
+// insensitive.
+//
+// The arguments of fn are nil, int64, float64, string or []byte values.
+// Its result may be any of the values accepted as arguments of queries.
+// fn may be called concurrently from multiple goroutines.
+//
+// RegisterFunc replaces any function previously registered with the
+// same name. It panics if fn is nil, if nargs is less than -1, or if
+// name is the name of a built-in function.
+func RegisterFunc(name string, nargs int, fn func(args []interface{}) (interface{}, error)) {
+	if fn == nil {
+		panic("sqlite3: RegisterFunc function is nil")
+	}
+	if nargs < -1 {
+		panic(fmt.Sprintf("sqlite3: RegisterFunc called with %d arguments", nargs))
+	}
+	key := strings.ToLower(name)
+	if scalarFuncs[key] != nil || aggFuncs[key] != nil || winFuncs[key] != nil {
+		panic("sqlite3: RegisterFunc called for built-in function " + key)
+	}
+	f := &scalarFunc{
+		minArgs: nargs,
+		maxArgs: nargs,
+		call: func(_ callCtx, args []interface{}) (interface{}, error) {
+			v, err := fn(args)
+			if err != nil {
+				return nil, fmt.Errorf("sqlite3: %s(): %w", name, err)
+			}
+			return queryValue(v)
+		},
+	}
+	if nargs < 0 {
+		f.minArgs = 0
+	}
+	funcs.Lock()
+	defer funcs.Unlock()
+	funcs.m[key] = f
+}
+
+// lookupFunc returns the scalar function with the given name, or nil.
+func lookupFunc(name string) *scalarFunc {
+	key := strings.ToLower(name)
+	if fn := scalarFuncs[key]; fn != nil {
+		return fn
+	}
+	funcs.RLock()
+	defer funcs.RUnlock()
+	return funcs.m[key]
+}
+
+// check checks the number of arguments of the call x of the function.
+func (fn *scalarFunc) check(x *funcExpr) error {
+	n := len(x.args)
+	if x.star || n < fn.minArgs || fn.maxArgs >= 0 && n > fn.maxArgs {
+		return fmt.Errorf("sqlite3: wrong number of arguments to function %s()", x.name)
+	}
+	return nil
+}
+
+// hasNull reports whether one of the values vals is NULL.
+func hasNull(vals []interface{}) bool {
+	for _, v := range vals {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cText returns the TEXT value of v up to its first NUL character, as
+// seen by the functions of SQLite.
+func cText(v interface{}) string {
+	s := textValue(v)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func absFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if v == math.MinInt64 {
+			return nil, errors.New("sqlite3: integer overflow")
+		}
+		if v < 0 {
+			v = -v
+		}
+		return v, nil
+	}
+	return math.Abs(toFloat(args[0])), nil
+}
+
+func typeofFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	return typeName(args[0]), nil
+}
+
+func lengthFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return int64(len(v)), nil
+	}
+	return int64(utf8.RuneCountInString(cText(args[0]))), nil
+}
+
+func hexFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return "", nil
+	}
+	return strings.ToUpper(hex.EncodeToString([]byte(textValue(args[0])))), nil
+}
+
+// caseFunc returns the lower or upper function, adding delta to the
+// bytes of its argument between lo and hi: only ASCII letters change
+// case.
+func caseFunc(lo, hi byte, delta int) func(callCtx, []interface{}) (interface{}, error) {
+	return func(_ callCtx, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		b := []byte(textValue(args[0]))
+		for i, c := range b {
+			if lo <= c && c <= hi {
+				b[i] = byte(int(c) + delta)
+			}
+		}
+		return string(b), nil
+	}
+}
+
+// trimFunc returns the trim, ltrim or rtrim function, removing the
+// spaces, or the characters of its second argument, with trim.
+func trimFunc(trim func(s, cutset string) string) func(callCtx, []interface{}) (interface{}, error) {
+	return func(_ callCtx, args []interface{}) (interface{}, error) {
+		if hasNull(args) {
+			return nil, nil
+		}
+		cutset := " "
+		if len(args) > 1 {
+			cutset = textValue(args[1])
+		}
+		return trim(textValue(args[0]), cutset), nil
+	}
+}
+
+func replaceFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+	old := textValue(args[1])
+	if old == "" {
+		return textValue(args[0]), nil
+	}
+	return strings.ReplaceAll(textValue(args[0]), old, textValue(args[2])), nil
+}
+
+func instrFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+	b1, ok1 := args[0].([]byte)
+	b2, ok2 := args[1].([]byte)
+	if ok1 && ok2 {
+		return int64(bytes.Index(b1, b2) + 1), nil
+	}
+	s := textValue(args[0])
+	i := strings.Index(s, textValue(args[1]))
+	if i < 0 {
+		return int64(0), nil
+	}
+	return int64(utf8.RuneCountInString(s[:i]) + 1), nil
+}
+
+// substrFunc returns the substring of characters, or of bytes for a
+// BLOB, starting at the position given by the second argument, counted
+// from the end if it is negative, and of the length given by the third
+// one, preceding the position if it is negative.
+func substrFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+	var (
+		b, isBlob = args[0].([]byte)
+		s         string
+		n         int64 // length of the value
+	)
+	if isBlob {
+		n = int64(len(b))
+	} else {
+		s = cText(args[0])
+		n = int64(utf8.RuneCountInString(s))
+	}
+
+	p1, p2 := toInteger(args[1]), int64(maxLength)
+	neg := false
+	if len(args) > 2 {
+		p2 = toInteger(args[2])
+		if p2 < 0 {
+			p2, neg = -p2, true
+		}
+		if p2 < 0 {
+			p2 = math.MaxInt64
+		}
+	}
+	switch {
+	case p1 < 0:
+		p1 += n
+		if p1 < 0 {
+			p2 += p1
+			if p2 < 0 {
+				p2 = 0
+			}
+			p1 = 0
+		}
+	case p1 > 0:
+		p1--
+	case p2 > 0:
+		p2--
+	}
+	if neg {
+		p1 -= p2
+		if p1 < 0 {
+			p2 += p1
+			p1 = 0
+		}
+	}
+
+	if p1 > n {
+		p1 = n
+	}
+	if p2 > n-p1 {
+		p2 = n - p1
+	}
+	if isBlob {
+		return b[p1 : p1+p2], nil
+	}
+	i := 0
+	for ; p1 > 0; p1-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	j := i
+	for ; p2 > 0; p2-- {
+		_, size := utf8.DecodeRuneInString(s[j:])
+		j += size
+	}
+	return s[i:j], nil
+}
+
+// minMaxFunc returns the scalar max function if sign is 1, or the min
+// one if it is -1: the largest, or smallest, of the values of its
+// arguments, or NULL if one of them is NULL.
+func minMaxFunc(sign int) func(callCtx, []interface{}) (interface{}, error) {
+	return func(c callCtx, args []interface{}) (interface{}, error) {
+		if hasNull(args) {
+			return nil, nil
+		}
+		v := args[0]
+		for _, w := range args[1:] {
+			if compareCollated(w, v, c.coll)*sign > 0 {
+				v = w
+			}
+		}
+		return v, nil
+	}
+}
+
+func nullifFunc(c callCtx, args []interface{}) (interface{}, error) {
+	if args[0] != nil && args[1] != nil && compareCollated(args[0], args[1], c.coll) == 0 {
+		return nil, nil
+	}
+	return args[0], nil
+}
+
+// likeFunc is the like(pattern, s[, escape]) function, equivalent to
+// the s LIKE pattern [ESCAPE escape] operator.
+func likeFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	var esc rune
+	if len(args) > 2 {
+		if args[2] == nil {
+			return nil, nil
+		}
+		var err error
+		if esc, err = escapeChar(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	return boolValue(likeMatch(textValue(args[0]), textValue(args[1]), esc)), nil
+}
+
+// globFunc is the glob(pattern, s) function, equivalent to the s GLOB
+// pattern operator.
+func globFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+	return boolValue(globMatch(textValue(args[0]), textValue(args[1]))), nil
+}
+
+// roundFunc rounds its first argument to the number of decimal digits
+// given by the second one, between 0 and 30, half away from zero.
+func roundFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+	var n int64
+	if len(args) > 1 {
+		n = toInteger(args[1])
+		switch {
+		case n < 0:
+			n = 0
+		case n > 30:
+			n = 30
+		}
+	}
+	r := toFloat(args[0])
+	switch {
+	case r < -4503599627370496 || r > 4503599627370496:
+		// r has no fractional part.
+	case n == 0:
+		if r < 0 {
+			r = float64(int64(r - 0.5))
+		} else {
+			r = float64(int64(r + 0.5))
+		}
+	default:
+		s, err := sprintf("%!.*f", []interface{}{n, r})
+		if err != nil {
+			return nil, err
+		}
+		r = toFloat(s)
+	}
+	return r, nil
+}
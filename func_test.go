@@ -0,0 +1,205 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQueryFunc(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select substr('hello', 2), substr('hello', -3, 2), substr('hello', 0, 2), substr('héllo', 2, 2), hex(substr(x'010203', 2, 1)), substring('abc', 2)`,
+			want:  "ello|ll|h|él|02|bc\n",
+		},
+		{
+			query: `select length('héllo'), length(x'0102'), upper('àbc'), lower('ABC'), trim('  a  '), ltrim('xxa', 'x'), rtrim('axx', 'x'), instr('héllo', 'l'), replace('hello', 'l', 'L')`,
+			want:  "5|2|àBC|abc|a|a|a|3|heLLo\n",
+		},
+		{
+			query: `select coalesce(NULL, 2, 3), ifnull(NULL, 'x'), nullif(1, 1), abs(-5), abs(-5.5), hex('abc'), typeof(1.0), typeof(x'00'), max(1, 2, 3), min(1, NULL, 3), max('a', 'B' collate nocase)`,
+			want:  "2|x|NULL|5|5.5|616263|real|blob|3|NULL|B\n",
+		},
+		{
+			query: `select like('a%', 'ABC'), glob('A*', 'abc'), like('a\%', 'a%', '\'), round(0.125, 2), round(1.005, 2), round(-2.5), round(123.456, -1)`,
+			want:  "1|0|1|0.13|1.0|-3.0|123.0\n",
+		},
+		{
+			query: `select printf('%5.2f|%-5d|%05d|%x|%c|%q|%Q|%w|%%', 3.14159, 42, 42, 255, 'hello', 'it''s', NULL, 'a"b'), printf('%,d %.3s %10s| %+d %#o %r', 1234567, 'abcdef', 'hi', 5, 8, 2), format('%e %g %.2f', 1234.5, 0.0001, 1.005)`,
+			want:  " 3.14|42   |00042|ff|h|it''s|NULL|a\"\"b|%|1,234,567 abc         hi| +5 010 2nd|1.234500e+03 0.0001 1.00\n",
+		},
+		{
+			query: `select typeof(printf('')), printf('%s'), printf('a%yb'), printf('%!.3f %.15g', 1.5, 0.1)`,
+			want:  "null||a|1.5 0.1\n",
+		},
+		{
+			query: `select printf('%p|%#p|%10p|%-5p|%p|%#x', 255, 255, 255, 255, -1, 255)`,
+			want:  "FF|0xFF|        FF|FF   |FFFFFFFFFFFFFFFF|0xff\n",
+		},
+		{
+			query: `select id, upper(name), substr(name, 1, 3), coalesce(city, 'none') from people where id < 5`,
+			want:  "1|BOB0|Bob|Lyon\n2|CAROL0|car|Berlin\n3|DAVE0|Dav|paris\n4|EVE0|eve|Tokyo\n",
+		},
+		{
+			query: `select lower(city), count(*) from people group by 1 order by 1`,
+			want:  "NULL|50\nberlin|50\nlyon|50\nparis|100\ntokyo|50\n",
+		},
+		{
+			// coalesce does not evaluate the arguments after the first non-NULL one.
+			query: `select coalesce(1, abs(-9223372036854775808))`,
+			want:  "1\n",
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`select length(1, 2)`, "wrong number of arguments to function length()"},
+		{`select length(*)`, "wrong number of arguments to function length()"},
+		{`select coalesce(1)`, "wrong number of arguments to function coalesce()"},
+		{`select abs(-9223372036854775808)`, "integer overflow"},
+		{`select like('a', 'a', 'ab')`, "ESCAPE expression must be a single character"},
+		{`select printf('%*d', 2000000000, 1)`, "string or blob too big"},
+	} {
+		rows, err := db.Query(tc.query)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
+
+func TestQueryDate(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select date('2024-02-29'), date('2023-02-31'), datetime('2023-02-31 10:00'), time('2024-01-15 12:34:56.789'), datetime('2024-01-15T12:34'), datetime('2024-01-15 12:34:56+02:00')`,
+			want:  "2024-02-29|2023-03-03|2023-03-03 10:00:00|12:34:56|2024-01-15 12:34:00|2024-01-15 10:34:56\n",
+		},
+		{
+			query: `select julianday('2000-01-01 12:00'), datetime(2451545.5), unixepoch('2024-01-15 12:34:56'), unixepoch('2024-01-15 12:34:56.789', 'subsec'), datetime(1700000000, 'unixepoch'), datetime(1700000000, 'auto')`,
+			want:  "2451545.0|2000-01-02 00:00:00|1705322096|1705322096.789|2023-11-14 22:13:20|2023-11-14 22:13:20\n",
+		},
+		{
+			query: `select datetime('2024-02-30'), date('2023-02-29'), date('2023-02-29', '+0 days'), strftime('%Y-%m-%d %j', '2023-02-30'), julianday('2023-02-30'), date('2023-02-31', 'start of month')`,
+			want:  "2024-03-01 00:00:00|2023-03-01|2023-03-01|2023-03-02 061|2460005.5|2023-02-01\n",
+		},
+		{
+			query: `select date('2024-01-31', '+1 month'), date('2024-01-31', '+1 month', 'floor'), date('2024-03-31', '-1 month'), datetime('2024-01-15', '+36 hours'), datetime('2024-01-15', '-3601 seconds')`,
+			want:  "2024-03-02|2024-02-29|2024-03-02|2024-01-16 12:00:00|2024-01-14 22:59:59\n",
+		},
+		{
+			query: `select date('2024-01-15', 'start of month'), date('2024-05-15', 'start of year'), date('2024-01-15', 'weekday 0'), date('2024-01-14', 'weekday 0'), datetime('2024-01-15 10:00', '+01:30'), datetime('2024-01-15 10:00', '-0001-02-03')`,
+			want:  "2024-01-01|2024-01-01|2024-01-21|2024-01-14|2024-01-15 11:30:00|2022-11-12 10:00:00\n",
+		},
+		{
+			query: `select strftime('%d %e %f %H %I %j %k %l %m %M %p %s %S %u %w %Y %%', '2024-01-15 13:04:05.678'), strftime('%G-W%V-%u %U %W', '2021-01-03'), strftime('%F %T %R %J', '2024-12-30')`,
+			want:  "15 15 05.678 13 01 015 13  1 01 04 PM 1705323845 05 1 1 2024 %|2020-W53-7 01 00|2024-12-30 00:00:00 00:00 2460674.5\n",
+		},
+		{
+			query: `select date(NULL), date('garbage'), date('2024-13-01'), date('2024-01-15', 'bogus'), strftime('%', '2020-01-01'), date('-0044-03-15'), date('9999-12-31', '+1 day')`,
+			want:  "NULL|NULL|NULL|NULL|NULL|-0044-03-15|NULL\n",
+		},
+		{
+			// 'now' is the same time for the whole statement.
+			query: `select datetime('now') = datetime(), time() = time('now', '+0 seconds'), typeof(julianday('now'))`,
+			want:  "1|1|real\n",
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	RegisterFunc("Reverse", 1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		r := []rune(s)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+	RegisterFunc("nargs", -1, func(args []interface{}) (interface{}, error) {
+		return len(args), nil
+	})
+	RegisterFunc("fail", 0, func(args []interface{}) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+
+	const query = `select reverse(name), nargs(), nargs(id, city, 3) from people where id < 3`
+	if got, want := queryString(t, db, query), "0boB|0|3\n0lorac|0|3\n"; got != want {
+		t.Errorf("%s:\ngot:\n%s\nwant:\n%s", query, got, want)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`select reverse()`, "wrong number of arguments to function reverse()"},
+		{`select fail()`, "sqlite3: fail(): boom"},
+	} {
+		rows, err := db.Query(tc.query)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+
+	for _, name := range []string{"count", "SUBSTR", "max"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterFunc(%q) did not panic", name)
+				}
+			}()
+			RegisterFunc(name, 1, func(args []interface{}) (interface{}, error) { return nil, nil })
+		}()
+	}
+}
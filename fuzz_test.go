@@ -0,0 +1,368 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// seedFiles returns the content of all the testdata databases, to seed
+// the corpus of the fuzz targets.
+func seedFiles(f *testing.F) [][]byte {
+	fnames, err := filepath.Glob("testdata/*.sqlite")
+	if err != nil {
+		f.Fatal(err)
+	}
+	var raws [][]byte
+	for _, fname := range fnames {
+		raw, err := ioutil.ReadFile(fname)
+		if err != nil {
+			f.Fatal(err)
+		}
+		raws = append(raws, raw)
+	}
+	return raws
+}
+
+// maxSeedSize is the maximum size of the databases returned by
+// smallSeedFiles.
+const maxSeedSize = 8 << 10
+
+// smallSeedFiles returns the contents of the testdata databases truncated
+// to their first pages, and also cut in the middle of their last page:
+// the fuzzer runs and mutates small inputs much faster than whole files.
+func smallSeedFiles(f *testing.F) [][]byte {
+	var seeds [][]byte
+	for _, raw := range seedFiles(f) {
+		if len(raw) < 100 {
+			continue
+		}
+		pagesz := int(binary.BigEndian.Uint16(raw[16:18]))
+		if pagesz == 1 {
+			pagesz = 65536
+		}
+		n := maxSeedSize / pagesz * pagesz
+		if n == 0 {
+			continue
+		}
+		if n > len(raw) {
+			n = len(raw)
+		}
+		seeds = append(seeds, raw[:n])
+		if n > pagesz {
+			seeds = append(seeds, raw[:n-pagesz/2])
+		}
+	}
+	return seeds
+}
+
+// FuzzOpenFrom checks the opening of databases and the reading of their
+// tables and indexes.
+//
+// The minimization of new inputs takes time quadratic in their size, and
+// stops fuzzing meanwhile: run the database targets with a bounded
+// -fuzzminimizetime, such as 1000x.
+func FuzzOpenFrom(f *testing.F) {
+	for _, raw := range smallSeedFiles(f) {
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			return
+		}
+		defer db.Close()
+
+		for _, table := range db.Tables() {
+			for _, col := range table.Columns() {
+				b, err := table.OpenBlob(1, col.Name())
+				if err == nil {
+					_, _ = io.Copy(ioutil.Discard, b)
+				}
+			}
+		}
+		for _, table := range db.Tables() {
+			_ = db.VisitTableRecords(table.Name(), func(*int64, Record) error {
+				return nil
+			})
+			cols := table.Columns()
+			if len(cols) > 0 {
+				_ = db.VisitTableRecords(table.Name(), func(*int64, Record) error {
+					return nil
+				}, Columns(cols[len(cols)-1].Name()))
+			}
+		}
+		for _, idx := range db.Indexes() {
+			n := 0
+			_ = idx.Range(nil, nil, func(*int64, Record) error {
+				n++
+				if n == 100 {
+					return io.EOF
+				}
+				return nil
+			})
+		}
+	})
+}
+
+// FuzzAnalyze checks the space analysis of databases and their
+// comparison with themselves, which read all their pages and rows.
+func FuzzAnalyze(f *testing.F) {
+	for _, raw := range smallSeedFiles(f) {
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			return
+		}
+		defer db.Close()
+
+		_, _ = db.Analyze()
+		_, _ = Diff(db, db)
+	})
+}
+
+// FuzzInspectPage checks the decoding and the dump of all the pages of
+// databases.
+func FuzzInspectPage(f *testing.F) {
+	for _, raw := range smallSeedFiles(f) {
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			return
+		}
+		defer db.Close()
+
+		roles := db.pageRoles()
+		for i := 1; i <= db.NumPage(); i++ {
+			info, err := db.inspectPage(i, roles)
+			if err == nil {
+				_ = info.Dump(ioutil.Discard)
+			}
+		}
+	})
+}
+
+// FuzzQueryTables checks queries over all the rows of the tables of
+// databases.
+func FuzzQueryTables(f *testing.F) {
+	for _, raw := range smallSeedFiles(f) {
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			return
+		}
+		defer db.Close()
+
+		for _, table := range db.Tables() {
+			rows, err := db.Query(`select * from "` + strings.ReplaceAll(table.Name(), `"`, `""`) + `" order by 1`)
+			if err == nil {
+				for rows.Next() {
+				}
+				rows.Close()
+			}
+		}
+	})
+}
+
+func FuzzQuery(f *testing.F) {
+	for _, query := range []string{
+		`select * from people where id < 10 order by name desc limit 3`,
+		`select id, age + 1, height * 2, -note, ~age, name || city from people`,
+		`select a, b, c, d, e from mixed where a = '1' or c > 1 and e is not null`,
+		`select case when a < 2 then 'x' when b in (1, 2.0, '3') then 'y' end from mixed`,
+		`select cast(a as integer), cast(b as real), c like 'a%', d glob '[0-9]*' from mixed`,
+		`select * from cities where population between ? and :hi order by 3 desc nulls last`,
+		`select 9223372036854775807 + 1, 1 << 63 >> 64, 5 % 0, x'00' || 'a'`,
+		`select city c, count(*), sum(age), avg(note), min(name) from people group by c having count(*) > 1 order by 2`,
+		`select distinct active, group_concat(distinct city), total(height), max(id) from people group by 1`,
+		`select p.name, c.country from people p left join cities c on c.name = p.city where p.id < 20`,
+		`select * from people natural join cities, mixed m join people q using (id) where q.age > m.c`,
+		`select substr(name, 2, age % 5), printf('%-*.*f|%q', id, note, height, city), coalesce(null, round(note, 2)) from people`,
+		`select date(age * 1e7, 'unixepoch', '+1 month', 'weekday 3'), strftime('%G %V %j %J', '2024-02-29', name) from people`,
+	} {
+		f.Add(query)
+	}
+
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer db.Close()
+
+	f.Fuzz(func(t *testing.T, query string) {
+		rows, err := db.Query(query, 1, 2, 3)
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+		for i := 0; i < 100 && rows.Next(); i++ {
+			if got, want := len(rows.Values()), len(rows.Columns()); got != want {
+				t.Fatalf("got %d values for %d columns", got, want)
+			}
+		}
+	})
+}
+
+func FuzzDecodeRecord(f *testing.F) {
+	for _, raw := range seedFiles(f) {
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, table := range db.Tables() {
+			page, err := db.pager.Page(table.pageid)
+			if err != nil {
+				f.Fatal(err)
+			}
+			bt, err := newBtree(page, db)
+			if err != nil {
+				f.Fatal(err)
+			}
+			err = bt.visitRawInorder(func(cell cellInfo) error {
+				f.Add(cell.Payload)
+				return nil
+			})
+			if err != nil {
+				f.Fatal(err)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		rec, err := DecodeRecord(payload)
+		if err != nil {
+			return
+		}
+		if len(rec.Values) != len(rec.Header.Types) {
+			t.Fatalf("got %d values for %d types", len(rec.Values), len(rec.Header.Types))
+		}
+		buf, err := AppendRecord(nil, rec.Values...)
+		if err != nil {
+			t.Fatalf("could not encode decoded record: %v", err)
+		}
+		got, err := DecodeRecord(buf)
+		if err != nil {
+			t.Fatalf("could not decode encoded record: %v", err)
+		}
+		if len(got.Values) != len(rec.Values) {
+			t.Fatalf("got %d values, want %d", len(got.Values), len(rec.Values))
+		}
+		for i, want := range rec.Values {
+			if f, ok := want.(float64); ok && math.IsNaN(f) {
+				// NaN floats are stored as NULL.
+				want = nil
+			}
+			if !reflect.DeepEqual(got.Values[i], want) {
+				t.Fatalf("value %d: got %#v, want %#v", i, got.Values[i], want)
+			}
+		}
+	})
+}
+
+func FuzzVarint(f *testing.F) {
+	f.Add([]byte{0x7f})
+	f.Add([]byte{0x81, 0x01})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, n := varint(data)
+		if n < 0 || n > 9 || n > len(data) {
+			t.Fatalf("invalid varint length %d for %d bytes", n, len(data))
+		}
+	})
+}
+
+// fuzzDB returns an in-memory database built from test-2.sqlite where
+// all the pages but the first one are replaced with the content of raw.
+func fuzzDB(t testing.TB, raw []byte) *DbFile {
+	db0, err := ioutil.ReadFile("testdata/test-2.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pagesz = 1024
+	buf := make([]byte, 4*pagesz)
+	copy(buf, db0[:pagesz])
+	copy(buf[pagesz:], raw)
+
+	db, err := OpenFrom(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func FuzzParseCell(f *testing.F) {
+	for _, raw := range seedFiles(f) {
+		if len(raw) < 4*1024 {
+			continue
+		}
+		f.Add(raw[1024 : 2*1024])
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		db := fuzzDB(t, raw)
+		defer db.Close()
+
+		page, err := db.pager.Page(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bt, err := newBtreeTable(page, db)
+		if err != nil {
+			return
+		}
+		for i := 0; i < bt.NumCell(); i++ {
+			_, _ = bt.loadCell(i)
+		}
+		_ = bt.visitRecordsInorder(func(*int64, Record) error { return nil })
+	})
+}
+
+func FuzzReadOverflow(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 3, 'a', 'b', 'c'}, int32(2), 16)
+	f.Add([]byte{0, 0, 0, 2, 'a', 'b', 'c'}, int32(2), 4096)
+
+	f.Fuzz(func(t *testing.T, raw []byte, pgno int32, size int) {
+		if size < 0 || size > 1<<20 {
+			return
+		}
+		db := fuzzDB(t, raw)
+		defer db.Close()
+
+		page, err := db.pager.Page(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bt, err := newBtreeTable(page, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := bt.readOverflow(pgno, size)
+		if err != nil {
+			return
+		}
+		if len(buf) != size {
+			t.Fatalf("read %d bytes instead of %d", len(buf), size)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/binary"
+)
+
+// Header describes the database header, stored in the first 100 bytes of
+// the database file.
+type Header struct {
+	PageSize      int // page size in bytes
+	WriteVersion  int // file format write version: 1 for legacy, 2 for WAL
+	ReadVersion   int // file format read version: 1 for legacy, 2 for WAL
+	ReservedBytes int // bytes of unused reserved space at the end of each page
+
+	MaxPayloadFraction  int // maximum embedded payload fraction, always 64
+	MinPayloadFraction  int // minimum embedded payload fraction, always 32
+	LeafPayloadFraction int // leaf payload fraction, always 32
+
+	ChangeCounter uint32 // file change counter
+	DbSize        int    // in-header database size in pages, as stored in the file
+	FreelistTrunk int    // page number of the first freelist trunk page
+	FreelistPages int    // total number of freelist pages
+	SchemaCookie  uint32 // schema cookie
+	SchemaFormat  int    // schema format number: 1 to 4, or 0 for an empty database
+	CacheSize     int    // suggested page cache size
+	LargestRoot   int    // largest root b-tree page in auto-vacuum mode, zero otherwise
+	TextEncoding  int    // 1 for UTF-8, 2 for UTF-16le, 3 for UTF-16be
+	UserVersion   int32  // user version, as set by the user_version PRAGMA
+	Incremental   bool   // whether the database is in incremental-vacuum mode
+	ApplicationID int32  // application ID, as set by the application_id PRAGMA
+
+	VersionValidFor uint32 // value of the change counter when SQLiteVersion was stored
+	SQLiteVersion   int    // SQLITE_VERSION_NUMBER of the library that last wrote the file
+}
+
+// AutoVacuum reports whether the database is in auto-vacuum or
+// incremental-vacuum mode.
+func (h *Header) AutoVacuum() bool {
+	return h.LargestRoot != 0
+}
+
+// Header returns the header of the database, as read from the file.
+func (db *DbFile) Header() Header {
+	return db.rawHeader
+}
+
+// export returns the exported form of the header.
+func (h *dbHeader) export() Header {
+	return Header{
+		PageSize:            h.pageSize(),
+		WriteVersion:        int(h.WVersion),
+		ReadVersion:         int(h.RVersion),
+		ReservedBytes:       int(h.NReserved),
+		MaxPayloadFraction:  int(h.MaxFraction),
+		MinPayloadFraction:  int(h.MinFraction),
+		LeafPayloadFraction: int(h.LeafFraction),
+		ChangeCounter:       uint32(h.NFileChanges),
+		DbSize:              int(h.DbSize),
+		FreelistTrunk:       int(h.FreePage),
+		FreelistPages:       int(h.NFreePages),
+		SchemaCookie:        binary.BigEndian.Uint32(h.SchemaCookie[:]),
+		SchemaFormat:        int(h.SchemaFormat),
+		CacheSize:           int(h.PageCacheSize),
+		LargestRoot:         int(h.AutoVacuum),
+		TextEncoding:        int(h.DbEncoding),
+		UserVersion:         h.UserVersion,
+		Incremental:         h.IncrVacuum != 0,
+		ApplicationID:       h.ApplicationID,
+		VersionValidFor:     uint32(h.VersionValid),
+		SQLiteVersion:       int(h.SqliteVersion),
+	}
+}
+
+// pageSize returns the page size in bytes: the value 1 stands for 65536.
+func (h *dbHeader) pageSize() int {
+	if h.PageSize == 1 {
+		return 65536
+	}
+	return int(h.PageSize)
+}
+
+// validate checks the header fields against the rules of the file
+// format.
+func (h *dbHeader) validate() error {
+	pagesz := h.pageSize()
+	if pagesz < 512 || pagesz&(pagesz-1) != 0 {
+		return corruptf(1, -1, 16, "invalid page size (%d)", pagesz)
+	}
+	if h.WVersion < 1 || h.WVersion > 2 {
+		return corruptf(1, -1, 18, "unsupported file format write version (%d)", h.WVersion)
+	}
+	if h.RVersion < 1 || h.RVersion > 2 {
+		return corruptf(1, -1, 19, "unsupported file format read version (%d)", h.RVersion)
+	}
+	if usable := pagesz - int(h.NReserved); usable < 480 {
+		return corruptf(1, -1, 20, "invalid usable page size (%d)", usable)
+	}
+	if h.MaxFraction != 64 || h.MinFraction != 32 || h.LeafFraction != 32 {
+		return corruptf(1, -1, 21, "invalid payload fractions (%d/%d/%d)", h.MaxFraction, h.MinFraction, h.LeafFraction)
+	}
+	if h.SchemaFormat < 0 || h.SchemaFormat > 4 {
+		// 0 is only valid for empty databases: see DbFile.init.
+		return corruptf(1, -1, 44, "unsupported schema format (%d)", h.SchemaFormat)
+	}
+	if h.DbEncoding < 0 || h.DbEncoding > 3 {
+		return corruptf(1, -1, 56, "invalid text encoding (%d)", h.DbEncoding)
+	}
+	return nil
+}
+
+// dbSizeValid reports whether the in-header database size may be
+// trusted: it is only maintained by SQLite 3.7.0 and later, which also
+// store the change counter in the version-valid-for number.
+func (h *dbHeader) dbSizeValid() bool {
+	return h.DbSize > 0 && h.NFileChanges == h.VersionValid
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	for _, tc := range []struct {
+		fname string
+		want  Header
+	}{
+		{
+			fname: "testdata/fragmented.sqlite",
+			want: Header{
+				PageSize:            1024,
+				WriteVersion:        1,
+				ReadVersion:         1,
+				MaxPayloadFraction:  64,
+				MinPayloadFraction:  32,
+				LeafPayloadFraction: 32,
+				ChangeCounter:       7,
+				DbSize:              84,
+				FreelistTrunk:       21,
+				FreelistPages:       31,
+				SchemaCookie:        3,
+				SchemaFormat:        4,
+				LargestRoot:         5,
+				TextEncoding:        1,
+				Incremental:         true,
+				VersionValidFor:     7,
+				SQLiteVersion:       3050002,
+			},
+		},
+		{
+			fname: "testdata/page-65536.sqlite",
+			want: Header{
+				PageSize:            65536,
+				WriteVersion:        1,
+				ReadVersion:         1,
+				MaxPayloadFraction:  64,
+				MinPayloadFraction:  32,
+				LeafPayloadFraction: 32,
+				ChangeCounter:       4,
+				DbSize:              2,
+				SchemaCookie:        1,
+				SchemaFormat:        4,
+				TextEncoding:        1,
+				UserVersion:         -7,
+				ApplicationID:       1234,
+				VersionValidFor:     4,
+				SQLiteVersion:       3050002,
+			},
+		},
+	} {
+		t.Run(tc.fname, func(t *testing.T) {
+			db, err := Open(tc.fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if got := db.Header(); got != tc.want {
+				t.Errorf("invalid header:\ngot = %+v\nwant= %+v", got, tc.want)
+			}
+			if got, want := db.PageSize(), tc.want.PageSize; got != want {
+				t.Errorf("page size = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHeaderPageSize65536(t *testing.T) {
+	db, err := Open("testdata/page-65536.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got []interface{}
+	err = db.VisitTableRecords("t", func(_ *int64, rec Record) error {
+		got = append(got, rec.Values...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "big pages" {
+		t.Errorf("invalid content: %v", got)
+	}
+}
+
+func TestHeaderDbSize(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/test-2.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		valid  bool
+		npages int
+	}{
+		{"valid", true, 2},
+		{"stale", false, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := append([]byte(nil), raw...)
+			binary.BigEndian.PutUint32(buf[28:], 2) // in-header database size
+			counter := binary.BigEndian.Uint32(buf[24:])
+			if !tc.valid {
+				// written by a version of SQLite not maintaining the
+				// database size.
+				counter++
+			}
+			binary.BigEndian.PutUint32(buf[92:], counter)
+
+			db, err := OpenFrom(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if got := db.NumPage(); got != tc.npages {
+				t.Errorf("num pages = %d, want %d", got, tc.npages)
+			}
+			if got := db.Header().DbSize; got != 2 {
+				t.Errorf("header database size = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestHeaderCorrupt(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/test-1.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		patch func(buf []byte)
+	}{
+		{"page size", func(buf []byte) { binary.BigEndian.PutUint16(buf[16:], 1000) }},
+		{"write version", func(buf []byte) { buf[18] = 3 }},
+		{"read version", func(buf []byte) { buf[19] = 0 }},
+		{"reserved bytes", func(buf []byte) { binary.BigEndian.PutUint16(buf[16:], 512); buf[20] = 33 }},
+		{"max fraction", func(buf []byte) { buf[21] = 63 }},
+		{"min fraction", func(buf []byte) { buf[22] = 31 }},
+		{"leaf fraction", func(buf []byte) { buf[23] = 33 }},
+		{"schema format", func(buf []byte) { binary.BigEndian.PutUint32(buf[44:], 5) }},
+		{"empty schema format", func(buf []byte) { binary.BigEndian.PutUint32(buf[44:], 0) }},
+		{"text encoding", func(buf []byte) { binary.BigEndian.PutUint32(buf[56:], 4) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := append([]byte(nil), raw...)
+			tc.patch(buf)
+			_, err := OpenFrom(bytes.NewReader(buf))
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("invalid error: %v", err)
+			}
+		})
+	}
+}
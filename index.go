@@ -0,0 +1,604 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index is an index of a SQLite table.
+type Index struct {
+	db      *DbFile
+	name    string
+	table   string
+	pageid  int
+	sql     string
+	unique  bool
+	partial bool // whether the index has a WHERE clause
+	cols    []indexedColumn
+	stats   *IndexStats // statistics of the index, if analyzed
+}
+
+// Name returns the name of the index
+func (idx *Index) Name() string {
+	return idx.name
+}
+
+// TableName returns the name of the indexed table
+func (idx *Index) TableName() string {
+	return idx.table
+}
+
+// SQL returns the CREATE INDEX statement of the index, or the empty
+// string for the indexes created for PRIMARY KEY and UNIQUE constraints
+func (idx *Index) SQL() string {
+	return idx.sql
+}
+
+// Unique reports whether the index is a UNIQUE index
+func (idx *Index) Unique() bool {
+	return idx.unique
+}
+
+// Partial reports whether the index is a partial index, only holding the
+// rows matching its WHERE clause
+func (idx *Index) Partial() bool {
+	return idx.partial
+}
+
+// Columns returns the names of the indexed columns, or the SQL text of
+// the indexed expressions
+func (idx *Index) Columns() []string {
+	names := make([]string, len(idx.cols))
+	for i, col := range idx.cols {
+		names[i] = col.name
+		if col.expr != "" {
+			names[i] = col.expr
+		}
+	}
+	return names
+}
+
+// Indexes returns the indexes of the database, in schema order.
+func (db *DbFile) Indexes() []Index {
+	return db.indexes
+}
+
+// Index returns the index with the given name, or nil if there is no such
+// index.
+func (db *DbFile) Index(name string) *Index {
+	for i := range db.indexes {
+		if strings.EqualFold(db.indexes[i].name, name) {
+			return &db.indexes[i]
+		}
+	}
+	return nil
+}
+
+// Indexes returns the indexes of the table.
+func (t *Table) Indexes() []*Index {
+	var idxs []*Index
+	for i := range t.db.indexes {
+		if strings.EqualFold(t.db.indexes[i].table, t.name) {
+			idxs = append(idxs, &t.db.indexes[i])
+		}
+	}
+	return idxs
+}
+
+// initIndexes loads the indexes of the schema.
+func (db *DbFile) initIndexes() error {
+	for _, obj := range db.schema {
+		if obj.Type != "index" || obj.RootPage == 0 {
+			continue
+		}
+		t := db.Table(obj.TableName)
+		if t == nil {
+			return corruptf(0, -1, -1, "no table %q for index %q", obj.TableName, obj.Name)
+		}
+
+		var idx Index
+		switch obj.SQL {
+		case "":
+			cols, ok := t.autoIndex(obj.Name)
+			if !ok {
+				// not an index we know how to compare keys of.
+				continue
+			}
+			idx.cols = cols
+			idx.unique = true
+		default:
+			var err error
+			idx, err = parseCreateIndex(obj.SQL)
+			if err != nil {
+				return fmt.Errorf("sqlite3: invalid definition for index %q: %w", obj.Name, err)
+			}
+		}
+		idx.db = db
+		idx.name = obj.Name
+		idx.table = t.name
+		idx.pageid = obj.RootPage
+		idx.sql = obj.SQL
+		db.indexes = append(db.indexes, idx)
+	}
+	return nil
+}
+
+// autoIndex returns the columns of the automatic index with the given
+// name, created for a PRIMARY KEY or UNIQUE constraint of the table.
+//
+// Automatic indexes are numbered from 1 in the order of the constraints,
+// skipping the INTEGER PRIMARY KEY and the constraints indexing the same
+// columns as a previous one.
+func (t *Table) autoIndex(name string) ([]indexedColumn, bool) {
+	prefix := "sqlite_autoindex_" + t.name + "_"
+	if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+		return nil, false
+	}
+	n, err := strconv.Atoi(name[len(prefix):])
+	if err != nil {
+		return nil, false
+	}
+
+	var keys [][]indexedColumn
+loop:
+	for _, key := range t.keys {
+		if key.pk && t.rowid >= 0 {
+			continue
+		}
+		for _, prev := range keys {
+			if t.sameColumns(prev, key.cols) {
+				continue loop
+			}
+		}
+		keys = append(keys, key.cols)
+	}
+	if n < 1 || n > len(keys) {
+		return nil, false
+	}
+	return keys[n-1], true
+}
+
+// sameColumns reports whether a and b index the same columns of the
+// table, with the same collations.
+func (t *Table) sameColumns(a, b []indexedColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !t.sameColumn(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameColumn reports whether a and b index the same column of the table,
+// with the same collation.
+func (t *Table) sameColumn(a, b indexedColumn) bool {
+	return a.expr == "" && b.expr == "" &&
+		strings.EqualFold(a.name, b.name) &&
+		strings.EqualFold(t.collationName(a), t.collationName(b))
+}
+
+// collationName returns the name of the collation of the indexed column
+// col: its own, the one of the table column, or BINARY.
+func (t *Table) collationName(col indexedColumn) string {
+	if col.collate != "" {
+		return col.collate
+	}
+	if col.expr == "" {
+		if i := t.colIndex(col.name); i >= 0 && t.cols[i].collate != "" {
+			return t.cols[i].collate
+		}
+	}
+	return "BINARY"
+}
+
+// keyColumn describes how the values of a column of b-tree keys compare.
+type keyColumn struct {
+	aff  affinity // affinity of the column
+	desc bool
+	coll collation
+}
+
+// keyColumns returns how the values of the indexed columns cols of the
+// table compare.
+func (t *Table) keyColumns(cols []indexedColumn) ([]keyColumn, error) {
+	keys := make([]keyColumn, len(cols))
+	for i, col := range cols {
+		if col.expr == "" {
+			if j := t.colIndex(col.name); j >= 0 {
+				keys[i].aff = declAffinity(t.cols[j].decl)
+			}
+		}
+		coll, err := lookupCollation(t.collationName(col))
+		if err != nil {
+			return nil, err
+		}
+		keys[i].desc = col.desc
+		keys[i].coll = coll
+	}
+	return keys, nil
+}
+
+// compareKey compares the first values of the b-tree key vals with the
+// key prefix key.
+func compareKey(keys []keyColumn, vals, key []interface{}) int {
+	for i, k := range key {
+		var v interface{}
+		if i < len(vals) {
+			v = columnValue(vals[i], affNone)
+		}
+		c := compareCollated(v, k, keys[i].coll)
+		if keys[i].desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// keyBound is a bound of a range of b-tree keys.
+type keyBound struct {
+	key  []interface{} // prefix of the keys
+	open bool          // whether the keys starting with key are excluded
+}
+
+// keyRange is a range of b-tree keys, in b-tree order.
+type keyRange struct {
+	keys   []keyColumn
+	lo, hi *keyBound // bounds of the range, or nil if unbounded
+	pages  *int64    // counter of the pages read, if any
+}
+
+// before reports whether the key vals sorts before the range.
+func (r *keyRange) before(vals []interface{}) bool {
+	if r.lo == nil {
+		return false
+	}
+	c := compareKey(r.keys, vals, r.lo.key)
+	return c < 0 || c == 0 && r.lo.open
+}
+
+// after reports whether the key vals sorts after the range.
+func (r *keyRange) after(vals []interface{}) bool {
+	if r.hi == nil {
+		return false
+	}
+	c := compareKey(r.keys, vals, r.hi.key)
+	return c > 0 || c == 0 && r.hi.open
+}
+
+// visitKeyRange calls f with the record of each key of the index b-tree
+// rooted at page root that is within the range r, in b-tree order.
+func (db *DbFile) visitKeyRange(root int, r *keyRange, f func(Record) error) error {
+	page, err := db.pager.Page(root)
+	if err != nil {
+		return err
+	}
+	btree, err := newBtree(page, db)
+	if err != nil {
+		return err
+	}
+	if btree.isTable() {
+		return corruptf(btree.ID(), -1, btree.hdrAddr(), "invalid index b-tree page kind (%v)", btree.Kind())
+	}
+	btree.pages = r.pages
+	btree.count()
+	_, err = btree.visitKeys(newBtreeWalk(root), 0, r, f)
+	return err
+}
+
+// visitKeys calls f with the record of each key of the index b-tree that
+// is within the range r, skipping the sub-trees before it. It reports
+// whether a key after the range was found.
+func (btree *btreeTable) visitKeys(w *btreeWalk, depth int, r *keyRange, f func(Record) error) (bool, error) {
+	// the keys of the left child of a cell sort before the key of the
+	// cell: find the first cell whose key is not before the range.
+	var (
+		n     = btree.NumCell()
+		start = 0
+		err   error
+	)
+	if r.lo != nil {
+		start = sort.Search(n, func(i int) bool {
+			if err != nil {
+				return true
+			}
+			var rec Record
+			rec, err = btree.keyRecord(i)
+			return !r.before(rec.Values)
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for i := start; i < n; i++ {
+		cell, err := btree.loadCell(i)
+		if err != nil {
+			return false, err
+		}
+		if cell.LeftChildPage != 0 {
+			child, err := btree.child(w, depth+1, i, cell.LeftChildPage)
+			if err != nil {
+				return false, err
+			}
+			done, err := child.visitKeys(w, depth+1, r, f)
+			if done || err != nil {
+				return done, err
+			}
+		}
+		rec, err := btree.decodeRecord(cell.Payload)
+		if err != nil {
+			return false, cell.locate(err)
+		}
+		if r.after(rec.Values) {
+			return true, nil
+		}
+		if err := f(rec); err != nil {
+			return false, err
+		}
+	}
+
+	if btree.pointer != 0 {
+		child, err := btree.child(w, depth+1, -1, btree.pointer)
+		if err != nil {
+			return false, err
+		}
+		return child.visitKeys(w, depth+1, r, f)
+	}
+	return false, nil
+}
+
+// keyRecord returns the record-decoded key of the cell icell of an index
+// b-tree page.
+func (btree *btreeTable) keyRecord(icell int) (Record, error) {
+	cell, err := btree.loadCell(icell)
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := btree.decodeRecord(cell.Payload)
+	if err != nil {
+		return Record{}, cell.locate(err)
+	}
+	return rec, nil
+}
+
+// rowRecord returns the record-decoded payload of the row of the table
+// b-tree with the given rowid, and whether there is such a row.
+func (btree *btreeTable) rowRecord(rowid int64) (Record, bool, error) {
+	leaf, icell, err := btree.findRowid(rowid)
+	if err != nil || icell < 0 {
+		return Record{}, false, err
+	}
+	cell, err := leaf.loadCell(icell)
+	if err != nil {
+		return Record{}, false, err
+	}
+	rec, err := leaf.decodeRecord(cell.Payload)
+	if err != nil {
+		return Record{}, false, cell.locate(err)
+	}
+	return rec, true, nil
+}
+
+// errKeyFound stops the search of a key in a WITHOUT ROWID table.
+var errKeyFound = errors.New("sqlite3: key found")
+
+// keyRecord returns the record of the row of the WITHOUT ROWID table with
+// the given primary key, whose values compare as keys, and whether there
+// is such a row. The pages read are added to pages, if not nil.
+func (t *Table) keyRecord(keys []keyColumn, key []interface{}, pages *int64) (Record, bool, error) {
+	var (
+		rec   Record
+		found bool
+		bound = &keyBound{key: key}
+	)
+	err := t.db.visitKeyRange(t.pageid, &keyRange{keys: keys, lo: bound, hi: bound, pages: pages}, func(r Record) error {
+		rec, found = r, true
+		return errKeyFound
+	})
+	if err != nil && err != errKeyFound {
+		return Record{}, false, err
+	}
+	return rec, found, nil
+}
+
+// tableRecord reorders the values and types of the record rec of a
+// WITHOUT ROWID table to follow the order of the table columns.
+func (t *Table) tableRecord(order []int, rec Record) Record {
+	values := make([]interface{}, len(t.cols))
+	types := make([]SerialType, len(t.cols))
+	for i, v := range rec.Values {
+		if i < len(order) {
+			values[order[i]] = v
+			types[order[i]] = rec.Header.Types[i]
+		}
+	}
+	rec.Values = values
+	rec.Header.Types = types
+	return rec
+}
+
+// pkIndex returns the primary key of the WITHOUT ROWID table t, as an
+// index whose records are the rows of the table.
+func (t *Table) pkIndex() *Index {
+	var stats *IndexStats
+	if ts := t.Stats(); ts != nil {
+		stats = ts.PrimaryKey
+	}
+	return &Index{
+		db:     t.db,
+		table:  t.name,
+		pageid: t.pageid,
+		unique: true,
+		cols:   t.pk,
+		stats:  stats,
+	}
+}
+
+// Seek calls f for each row of the indexed table whose first indexed
+// columns are equal to values, in index order, passing its rowid (nil
+// for WITHOUT ROWID tables) and its record-decoded payload like
+// VisitTableRecords.
+//
+// Values are converted with the affinity of their column, and compared
+// with the type ordering of SQLite and the collation and sort order of
+// their column. Partial indexes only hold the rows matching their WHERE
+// clause.
+func (idx *Index) Seek(values []interface{}, f func(*int64, Record) error) error {
+	key, err := idx.key(values)
+	if err != nil {
+		return err
+	}
+	bound := &keyBound{key: key}
+	return idx.visit(bound, bound, nil, f)
+}
+
+// Range calls f for each row of the indexed table whose indexed columns
+// are between the keys lo and hi, included, like Seek. Keys may hold
+// the values of the first indexed columns only, and are unbounded when
+// empty.
+//
+// Keys are compared in index order: lo is the larger of the two bounds
+// of a range of values of a DESC column.
+func (idx *Index) Range(lo, hi []interface{}, f func(*int64, Record) error) error {
+	var r [2]*keyBound
+	for i, values := range [][]interface{}{lo, hi} {
+		if len(values) == 0 {
+			continue
+		}
+		key, err := idx.key(values)
+		if err != nil {
+			return err
+		}
+		r[i] = &keyBound{key: key}
+	}
+	return idx.visit(r[0], r[1], nil, f)
+}
+
+// key converts values into a prefix of the keys of the index.
+func (idx *Index) key(values []interface{}) ([]interface{}, error) {
+	if len(values) > len(idx.cols) {
+		return nil, fmt.Errorf("sqlite3: %d values for the %d columns of index %q", len(values), len(idx.cols), idx.name)
+	}
+	t := idx.db.Table(idx.table)
+	keys, err := t.keyColumns(idx.cols[:len(values)])
+	if err != nil {
+		return nil, err
+	}
+	key := make([]interface{}, len(values))
+	for i, v := range values {
+		v, err := queryValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: invalid value for column %d of index %q: %w", i, idx.name, err)
+		}
+		key[i] = applyAffinity(v, keys[i].aff)
+	}
+	return key, nil
+}
+
+// visit calls f for each row of the indexed table whose key is between
+// lo and hi, in index order. The pages read are added to pages, if not
+// nil.
+func (idx *Index) visit(lo, hi *keyBound, pages *int64, f func(*int64, Record) error) error {
+	t := idx.db.Table(idx.table)
+	keys, err := t.keyColumns(idx.cols)
+	if err != nil {
+		return err
+	}
+	r := &keyRange{keys: keys, lo: lo, hi: hi, pages: pages}
+
+	if t.withoutRowid {
+		order := t.recordColumns()
+		if idx.pageid == t.pageid {
+			return idx.db.visitKeyRange(idx.pageid, r, func(rec Record) error {
+				return f(nil, t.tableRecord(order, rec))
+			})
+		}
+
+		pkKeys, err := t.keyColumns(t.pk)
+		if err != nil {
+			return err
+		}
+		pos := idx.pkPositions(t)
+		return idx.db.visitKeyRange(idx.pageid, r, func(rec Record) error {
+			key := make([]interface{}, len(pos))
+			for i, j := range pos {
+				if j < len(rec.Values) {
+					key[i] = columnValue(rec.Values[j], affNone)
+				}
+			}
+			row, ok, err := t.keyRecord(pkKeys, key, pages)
+			switch {
+			case err != nil:
+				return err
+			case !ok:
+				return corruptf(idx.pageid, -1, -1, "no row for the key %v of index %q", key, idx.name)
+			}
+			return f(nil, t.tableRecord(order, row))
+		})
+	}
+
+	page, err := idx.db.pager.Page(t.pageid)
+	if err != nil {
+		return err
+	}
+	table, err := newBtreeTable(page, idx.db)
+	if err != nil {
+		return err
+	}
+	table.pages = pages
+	return idx.db.visitKeyRange(idx.pageid, r, func(rec Record) error {
+		var (
+			rowid int64
+			ok    bool
+		)
+		if n := len(rec.Values); n > len(idx.cols) {
+			rowid, ok = toInt64(rec.Values[n-1])
+		}
+		if !ok {
+			return corruptf(idx.pageid, -1, -1, "invalid rowid in a key of index %q", idx.name)
+		}
+		row, ok, err := table.rowRecord(rowid)
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			return corruptf(idx.pageid, -1, -1, "no row %d for a key of index %q", rowid, idx.name)
+		}
+		return f(&rowid, row)
+	})
+}
+
+// pkPositions returns the position, within the keys of the index of the
+// WITHOUT ROWID table t, of each of the primary key columns of t: they
+// follow the indexed columns, unless they are indexed columns already.
+func (idx *Index) pkPositions(t *Table) []int {
+	pos := make([]int, len(t.pk))
+	n := len(idx.cols)
+	for i, pk := range t.pk {
+		pos[i] = -1
+		for j, col := range idx.cols {
+			if t.sameColumn(col, pk) {
+				pos[i] = j
+				break
+			}
+		}
+		if pos[i] < 0 {
+			pos[i] = n
+			n++
+		}
+	}
+	return pos
+}
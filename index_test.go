@@ -0,0 +1,277 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIndexes(t *testing.T) {
+	db, err := Open("testdata/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type index struct {
+		name    string
+		table   string
+		cols    string
+		unique  bool
+		partial bool
+	}
+	var got []index
+	for _, idx := range db.Indexes() {
+		got = append(got, index{
+			idx.Name(), idx.TableName(), strings.Join(idx.Columns(), ","),
+			idx.Unique(), idx.Partial(),
+		})
+	}
+	want := []index{
+		{"sqlite_autoindex_items_1", "items", "sku", true, false},
+		{"items_cat_price", "items", "cat,price", false, false},
+		{"items_qty", "items", "qty", false, false},
+		{"items_tag", "items", "tag", false, false},
+		{"items_lower", "items", "lower(sku)", false, false},
+		{"items_big", "items", "qty", false, true},
+		{"items_name", "items", "name", false, false},
+		{"codes_label", "codes", "label", false, false},
+		{"words_n", "words", "n", false, false},
+		{"words_r", "words", "r", false, false},
+		{"words_w", "words", "w", false, false},
+		{"words_rev", "words", "w", false, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid indexes:\ngot:  %v\nwant: %v", got, want)
+	}
+
+	if n := len(db.Table("items").Indexes()); n != 7 {
+		t.Errorf("items: got %d indexes, want 7", n)
+	}
+	if db.Index("sqlite_autoindex_items_1").SQL() != "" {
+		t.Errorf("automatic index with a SQL definition")
+	}
+	if db.Index("nosuchindex") != nil {
+		t.Errorf("unknown index found")
+	}
+}
+
+func TestParseCreateIndex(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		want []indexedColumn
+		err  bool
+	}{
+		{
+			sql:  "CREATE UNIQUE INDEX IF NOT EXISTS main.i ON t(a, b DESC)",
+			want: []indexedColumn{{name: "a"}, {name: "b", desc: true}},
+		},
+		{
+			sql:  `CREATE INDEX "i" ON "t" (lower(a) COLLATE NOCASE, "b c" ASC) WHERE a > 0`,
+			want: []indexedColumn{{expr: "lower(a)", collate: "NOCASE"}, {name: "b c"}},
+		},
+		{sql: "CREATE INDEX i ON t", err: true},
+		{sql: "CREATE INDEX i ON t(a) garbage", err: true},
+	} {
+		idx, err := parseCreateIndex(tc.sql)
+		switch {
+		case tc.err && err == nil:
+			t.Errorf("%s: expected an error", tc.sql)
+		case !tc.err && err != nil:
+			t.Errorf("%s: %v", tc.sql, err)
+		case !tc.err && !reflect.DeepEqual(idx.cols, tc.want):
+			t.Errorf("%s: got %+v, want %+v", tc.sql, idx.cols, tc.want)
+		}
+	}
+}
+
+func TestIndexSeek(t *testing.T) {
+	db, err := Open("testdata/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		index  string
+		lo, hi []interface{} // seek lo if hi is nil
+		n      int
+		want   []int64 // first rowids
+	}{
+		{index: "items_qty", lo: []interface{}{42}, n: 19, want: []int64{34, 134, 234, 334, 534}},
+		{index: "items_qty", lo: []interface{}{"42"}, n: 19, want: []int64{34, 134, 234, 334, 534}},
+		{index: "items_qty", lo: []interface{}{42.5}, n: 0},
+		{index: "items_cat_price", lo: []interface{}{"music"}, n: 400, want: []int64{27, 527, 1027, 1527, 162}},
+		{index: "items_cat_price", lo: []interface{}{"books", 30.25}, n: 0},
+		{index: "items_tag", lo: []interface{}{"7"}, n: 222, want: []int64{8, 17, 26, 35, 44}},
+		{index: "items_tag", lo: []interface{}{[]byte{7}}, n: 222, want: []int64{4, 13, 22, 31, 40}},
+		{index: "items_tag", lo: []interface{}{nil}, n: 222, want: []int64{9, 18, 27, 36, 45}},
+		{index: "items_lower", lo: []interface{}{"sku-07919"}, n: 1, want: []int64{1}},
+		{index: "sqlite_autoindex_items_1", lo: []interface{}{"SKU-07919"}, n: 1, want: []int64{1}},
+		{index: "items_qty", lo: []interface{}{10}, hi: []interface{}{12}, n: 58, want: []int64{70, 170, 270, 370, 470}},
+		{
+			// price is sorted in descending order.
+			index: "items_cat_price",
+			lo:    []interface{}{"games", 100}, hi: []interface{}{"games", 99},
+			n: 4, want: []int64{281, 781, 1281, 1781},
+		},
+		{index: "items_big", lo: []interface{}{98}, hi: []interface{}{}, n: 39, want: []int64{46, 146, 246, 346, 446}},
+		{index: "items_name", lo: []interface{}{"BOLT"}, n: 400, want: []int64{1, 6, 11, 16, 21}},
+		{index: "words_n", lo: []interface{}{"apple"}, n: 15, want: []int64{1, 2, 3, 15, 16}},
+		{index: "words_r", lo: []interface{}{"banana"}, n: 5, want: []int64{5, 19, 33, 47, 61}},
+		{index: "words_w", lo: []interface{}{"APPLE"}, n: 15, want: []int64{1, 2, 3, 15, 16}},
+	} {
+		var got []int64
+		f := func(rowid *int64, rec Record) error {
+			got = append(got, *rowid)
+			return nil
+		}
+		idx := db.Index(tc.index)
+		switch tc.hi {
+		case nil:
+			err = idx.Seek(tc.lo, f)
+		default:
+			err = idx.Range(tc.lo, tc.hi, f)
+		}
+		if err != nil {
+			t.Errorf("%s %v %v: %v", tc.index, tc.lo, tc.hi, err)
+			continue
+		}
+		if len(got) != tc.n {
+			t.Errorf("%s %v %v: got %d rows, want %d", tc.index, tc.lo, tc.hi, len(got), tc.n)
+			continue
+		}
+		if len(got) > len(tc.want) {
+			got = got[:len(tc.want)]
+		}
+		if len(got) > 0 && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %v %v: got rows %v, want %v", tc.index, tc.lo, tc.hi, got, tc.want)
+		}
+	}
+
+	// the rows of WITHOUT ROWID tables are found by primary key.
+	var got []interface{}
+	err = db.Index("codes_label").Seek([]interface{}{"label 017"}, func(rowid *int64, rec Record) error {
+		if rowid != nil {
+			t.Errorf("unexpected rowid %d", *rowid)
+		}
+		for _, v := range rec.Values {
+			got = append(got, columnValue(v, affNone))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"DE", int64(75), "label 017", "DE", int64(0), "label 017"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("codes_label: got %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		index  string
+		values []interface{}
+		err    string
+	}{
+		{"items_qty", []interface{}{1, 2}, "2 values for the 1 columns"},
+		{"items_qty", []interface{}{struct{}{}}, "invalid value"},
+	} {
+		err := db.Index(tc.index).Seek(tc.values, func(*int64, Record) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s %v: got error %v, want %q", tc.index, tc.values, err, tc.err)
+		}
+	}
+}
+
+func TestQueryIndex(t *testing.T) {
+	db, err := Open("testdata/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		where string
+		index string // index of the lookup, "rowid" or "" for a scan
+	}{
+		{"qty = 42", "items_qty"},
+		{"42 = qty", "items_qty"},
+		{"qty = '42'", "items_qty"},
+		{"qty = 42.0", "items_qty"},
+		{"qty = NULL", "items_qty"},
+		{"qty < 3", "items_qty"},
+		{"qty >= 98", "items_qty"},
+		{"qty between 10 and 12", "items_qty"},
+		{"qty > 'abc'", "items_qty"},
+		{"qty + 0 = 42", ""},
+		{"cat = 'books' and price > 30", "items_cat_price"},
+		{"price <= 30 and cat = 'tools' and qty > 50", "items_cat_price"},
+		{"cat = 'games' and price between 99 and 100", "items_cat_price"},
+		{"cat > 'music'", "items_cat_price"},
+		{"cat = 5", "items_cat_price"},
+		{"cat = cast(5 as integer)", ""},
+		{"tag = 7", "items_tag"},
+		{"tag = '7'", "items_tag"},
+		{"tag >= 'seven'", "items_tag"},
+		{"sku = 'SKU-07919' or qty = 1", ""},
+		{"id = 17", "rowid"},
+		{"id = '17'", "rowid"},
+		{"rowid = 17.5", "rowid"},
+		{"name = 'bolt'", "items_name"},
+		{"name = 'bolt' collate binary", ""},
+		{"name collate nocase > 'cog'", "items_name"},
+		{"sku = 'sku-07919' collate nocase", ""},
+	} {
+		query := "select * from items where " + tc.where
+		stmt, _, err := parseSelect(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := db.compileSelect(stmt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index string
+		switch l := plan.sources[0].lookup; {
+		case l == nil:
+		case l.index == nil:
+			index = "rowid"
+		default:
+			index = l.index.name
+		}
+		if index != tc.index {
+			t.Errorf("%s: got lookup %q, want %q", tc.where, index, tc.index)
+		}
+
+		// the lookup must find the rows found by a scan.
+		got := planRows(t, plan)
+		plan.sources[0].lookup = nil
+		want := planRows(t, plan)
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d rows, want %d", tc.where, len(got), len(want))
+		}
+	}
+}
+
+// planRows runs the query plan and returns its rows, formatted with
+// fmt.Sprint.
+func planRows(t *testing.T, plan *selectPlan) []string {
+	t.Helper()
+	var rows []string
+	err := plan.run(func(vals []interface{}) error {
+		rows = append(rows, fmt.Sprint(vals...))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
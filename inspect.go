@@ -0,0 +1,572 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PageInfo describes the content of a page of the database file.
+type PageInfo struct {
+	Number int      // page number
+	Kind   PageKind // kind of the page
+	Owner  string   // name of the table or index the page belongs to, if any
+	Parent int      // page referring to this page, or 0
+	Data   []byte   // content of the page
+
+	Header       *BtreePageHeader // header of b-tree pages
+	CellPointers []int            // cell pointer array of b-tree pages
+	Cells        []PageCell       // cells of b-tree pages
+	FreeBlocks   []FreeBlock      // free blocks of b-tree pages
+
+	Next       int               // next page of overflow and freelist trunk pages
+	Leaves     []int             // leaf pages listed by a freelist trunk page
+	PointerMap []PointerMapEntry // entries of pointer map pages
+
+	// Err is the error encountered while decoding the page content, if any.
+	Err error
+
+	reserved int // number of reserved bytes at the end of the page
+}
+
+// BtreePageHeader is the header of a b-tree page.
+type BtreePageHeader struct {
+	Kind            PageKind
+	FreeBlock       int // offset of the first free block, or 0
+	NumCells        int // number of cells
+	CellContent     int // offset of the cell content area
+	FragmentedBytes int // number of fragmented free bytes
+	RightChild      int // right-most child page of interior pages
+}
+
+// PageCell describes a cell of a b-tree page.
+type PageCell struct {
+	Offset       int           // offset of the cell within the page
+	Size         int           // size of the cell within the page
+	LeftChild    int           // left child page of interior cells
+	RowID        *int64        // rowid of table cells
+	PayloadSize  int           // total payload size
+	LocalSize    int           // payload bytes stored within the page
+	OverflowPage int           // first overflow page, or 0
+	Types        []SerialType  // serial types of the record
+	Values       []interface{} // values of the record
+	Err          error         // error decoding the cell, if any
+}
+
+// FreeBlock is a free block of a b-tree page.
+type FreeBlock struct {
+	Offset int
+	Size   int
+}
+
+// PointerMapEntry is an entry of a pointer map page.
+type PointerMapEntry struct {
+	Page   int // page described by the entry
+	Type   int // 1: root page, 2: free page, 3: first overflow page, 4: next overflow page, 5: b-tree page
+	Parent int // parent page, or 0
+}
+
+// InspectPage identifies and decodes the page number n.
+//
+// The kind and owner of the page are found by walking all the b-trees
+// and the freelist of the database. Pages of corrupted files are decoded
+// as far as possible, with the first error reported in PageInfo.Err.
+func (db *DbFile) InspectPage(n int) (*PageInfo, error) {
+	return db.inspectPage(n, db.pageRoles())
+}
+
+// inspectPage decodes the page number n, given the roles of the pages.
+func (db *DbFile) inspectPage(n int, roles map[int]pageRole) (*PageInfo, error) {
+	if n < 1 || n > db.NumPage() {
+		return nil, fmt.Errorf("sqlite3: page number out of range (%d)", n)
+	}
+	page, err := db.pager.Page(n)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PageInfo{
+		Number:   n,
+		Data:     page.buf,
+		reserved: int(db.header.NReserved),
+	}
+
+	role, ok := roles[n]
+	switch {
+	case ok:
+		info.Kind = role.kind
+		info.Owner = role.owner
+		info.Parent = role.parent
+	default:
+		// orphaned b-tree pages are still identified.
+		switch kind := page.Kind(); kind {
+		case BTreeInteriorIndexKind, BTreeInteriorTableKind,
+			BTreeLeafIndexKind, BTreeLeafTableKind:
+			info.Kind = kind
+		}
+	}
+
+	buf := page.buf
+	switch info.Kind {
+	case BTreeInteriorIndexKind, BTreeInteriorTableKind,
+		BTreeLeafIndexKind, BTreeLeafTableKind:
+		info.inspectBtree(db, page)
+
+	case OverflowKind:
+		info.Next = int(binary.BigEndian.Uint32(buf))
+
+	case FreelistTrunkKind:
+		info.Next = int(binary.BigEndian.Uint32(buf))
+		nleaves := int(binary.BigEndian.Uint32(buf[4:]))
+		if max := db.maxFreelistLeaves(); nleaves > max {
+			info.Err = corruptf(n, -1, 4, "invalid number of freelist leaf pages (%d)", nleaves)
+			nleaves = max
+		}
+		for i := 0; i < nleaves; i++ {
+			info.Leaves = append(info.Leaves, int(binary.BigEndian.Uint32(buf[8+4*i:])))
+		}
+
+	case PointerMapKind:
+		usable := len(buf) - info.reserved
+		for i := 0; 5*i+5 <= usable && n+1+i <= db.NumPage(); i++ {
+			info.PointerMap = append(info.PointerMap, PointerMapEntry{
+				Page:   n + 1 + i,
+				Type:   int(buf[5*i]),
+				Parent: int(binary.BigEndian.Uint32(buf[5*i+1:])),
+			})
+		}
+	}
+	return info, nil
+}
+
+func (info *PageInfo) inspectBtree(db *DbFile, page page) {
+	bt, err := newBtree(page, db)
+	if err != nil {
+		info.Err = err
+		return
+	}
+	info.Header = &BtreePageHeader{
+		Kind:            bt.Kind(),
+		FreeBlock:       bt.FreeBlockAddr(),
+		NumCells:        bt.NumCell(),
+		CellContent:     bt.CellsAddr(),
+		FragmentedBytes: int(bt.raw.NFreeBytes),
+		RightChild:      int(bt.pointer),
+	}
+	for _, addr := range bt.addrs {
+		info.CellPointers = append(info.CellPointers, int(addr))
+	}
+
+	for i := range bt.addrs {
+		cell := PageCell{Offset: int(bt.addrs[i])}
+		ext, err := bt.cellExtent(i)
+		if err != nil {
+			cell.Err = err
+			info.Cells = append(info.Cells, cell)
+			continue
+		}
+		cell.Size = ext.size
+		cell.PayloadSize = ext.payload
+		cell.LocalSize = ext.local
+		cell.OverflowPage = int(ext.overflow)
+		if !bt.isLeaf() {
+			cell.LeftChild = int(binary.BigEndian.Uint32(page.buf[cell.Offset:]))
+		}
+
+		ci, err := bt.loadCell(i)
+		cell.RowID = ci.RowID
+		switch {
+		case err != nil:
+			cell.Err = err
+		case len(ci.Payload) > 0:
+			rec, err := bt.decodeRecord(ci.Payload)
+			cell.Types = rec.Header.Types
+			cell.Values = rec.Values
+			if err != nil {
+				cell.Err = ci.locate(err)
+			}
+		}
+		info.Cells = append(info.Cells, cell)
+	}
+
+	// walk the free blocks, as long as they are valid.
+	usable := len(page.buf) - info.reserved
+	for addr := bt.FreeBlockAddr(); addr != 0; {
+		if addr+4 > usable {
+			info.Err = corruptf(info.Number, -1, addr, "free block out of page")
+			break
+		}
+		next := int(binary.BigEndian.Uint16(page.buf[addr:]))
+		size := int(binary.BigEndian.Uint16(page.buf[addr+2:]))
+		info.FreeBlocks = append(info.FreeBlocks, FreeBlock{Offset: addr, Size: size})
+		if next != 0 && next <= addr+size {
+			info.Err = corruptf(info.Number, -1, addr, "invalid next free block (%d)", next)
+			break
+		}
+		addr = next
+	}
+}
+
+// pageRole describes the role of a page within the database.
+type pageRole struct {
+	kind   PageKind
+	owner  string // name of the table or index owning the page
+	parent int    // page referring to the page
+}
+
+// pageRoles walks the b-trees, the overflow chains and the freelist of
+// the database and returns the role of each page found.
+// The walk skips the parts of a corrupted file that can not be decoded.
+func (db *DbFile) pageRoles() map[int]pageRole {
+	roles := make(map[int]pageRole)
+	set := func(pgno int, role pageRole) bool {
+		if pgno < 1 || pgno > db.NumPage() {
+			return false
+		}
+		if _, dup := roles[pgno]; dup {
+			return false
+		}
+		roles[pgno] = role
+		return true
+	}
+
+	objs := []SchemaObject{{Name: "sqlite_master", RootPage: 1}}
+	for _, obj := range db.schema {
+		if obj.RootPage > 0 {
+			objs = append(objs, obj)
+		}
+	}
+	for _, obj := range objs {
+		page, err := db.pager.Page(obj.RootPage)
+		if err != nil {
+			continue
+		}
+		bt, err := newBtree(page, db)
+		if err != nil {
+			continue
+		}
+		if !set(bt.ID(), pageRole{kind: bt.Kind(), owner: obj.Name}) {
+			continue
+		}
+		bt.roles(newBtreeWalk(bt.ID()), 0, obj.Name, set)
+	}
+
+	parent := 0
+	seen := make(map[int]bool)
+	for pgno := int(db.header.FreePage); pgno != 0 && !seen[pgno]; {
+		seen[pgno] = true
+		if !set(pgno, pageRole{kind: FreelistTrunkKind, parent: parent}) {
+			break
+		}
+		page, err := db.pager.Page(pgno)
+		if err != nil {
+			break
+		}
+		buf := page.buf
+		n := min(int(binary.BigEndian.Uint32(buf[4:])), db.maxFreelistLeaves())
+		for i := 0; i < n; i++ {
+			set(int(binary.BigEndian.Uint32(buf[8+4*i:])), pageRole{kind: FreelistLeafKind, parent: pgno})
+		}
+		parent = pgno
+		pgno = int(binary.BigEndian.Uint32(buf))
+	}
+
+	if db.header.AutoVacuum != 0 {
+		for pgno := 2; pgno <= db.NumPage(); pgno++ {
+			if db.pointerMapPage(pgno) == pgno {
+				set(pgno, pageRole{kind: PointerMapKind})
+			}
+		}
+	}
+	set(db.lockBytePage(), pageRole{kind: LockByteKind})
+
+	return roles
+}
+
+// roles records the role of the overflow pages and children of the
+// b-tree page btree, at the given depth, and of their descendants.
+func (btree *btreeTable) roles(w *btreeWalk, depth int, owner string, set func(int, pageRole) bool) {
+	usable := btree.usable()
+	for i := range btree.addrs {
+		ext, err := btree.cellExtent(i)
+		if err != nil || ext.overflow == 0 {
+			continue
+		}
+		n := (ext.payload - ext.local + usable - 5) / (usable - 4)
+		prev := btree.ID()
+		pgno := int(ext.overflow)
+		for k := 0; k < n && pgno != 0; k++ {
+			if !set(pgno, pageRole{kind: OverflowKind, owner: owner, parent: prev}) {
+				break
+			}
+			page, err := btree.db.pager.Page(pgno)
+			if err != nil {
+				break
+			}
+			prev, pgno = pgno, int(binary.BigEndian.Uint32(page.buf))
+		}
+	}
+
+	if btree.isLeaf() {
+		return
+	}
+	for i := -1; i < len(btree.addrs); i++ {
+		var pgno int32
+		switch {
+		case i < 0:
+			pgno = btree.pointer
+		case int(btree.addrs[i])+4 <= len(btree.page.buf):
+			pgno = int32(binary.BigEndian.Uint32(btree.page.buf[btree.addrs[i]:]))
+		default:
+			continue
+		}
+		child, err := btree.child(w, depth+1, i, pgno)
+		if err != nil {
+			continue
+		}
+		if set(child.ID(), pageRole{kind: child.Kind(), owner: owner, parent: btree.ID()}) {
+			child.roles(w, depth+1, owner, set)
+		}
+	}
+}
+
+// Dump writes a description of the page followed by an annotated
+// hexdump of its content.
+func (info *PageInfo) Dump(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("page %d: %v", info.Number, info.Kind)
+	if info.Owner != "" {
+		ew.printf(" of %q", info.Owner)
+	}
+	if info.Parent != 0 {
+		ew.printf(", parent page %d", info.Parent)
+	}
+	ew.printf("\n")
+
+	if hdr := info.Header; hdr != nil {
+		ew.printf("header: kind=0x%02x first-freeblock=%d cells=%d cell-content=%d fragmented=%d",
+			byte(hdr.Kind), hdr.FreeBlock, hdr.NumCells, hdr.CellContent, hdr.FragmentedBytes,
+		)
+		if hdr.RightChild != 0 {
+			ew.printf(" right-child=%d", hdr.RightChild)
+		}
+		ew.printf("\n")
+	}
+	for i, cell := range info.Cells {
+		ew.printf("cell[%d]: offset=%d size=%d", i, cell.Offset, cell.Size)
+		if cell.LeftChild != 0 {
+			ew.printf(" left-child=%d", cell.LeftChild)
+		}
+		if cell.RowID != nil {
+			ew.printf(" rowid=%d", *cell.RowID)
+		}
+		if cell.PayloadSize > 0 {
+			ew.printf(" payload=%d local=%d", cell.PayloadSize, cell.LocalSize)
+		}
+		if cell.OverflowPage != 0 {
+			ew.printf(" overflow=%d", cell.OverflowPage)
+		}
+		if len(cell.Types) > 0 {
+			ew.printf("\n  types=%v\n  values=%s", cell.Types, formatValues(cell.Values))
+		}
+		if cell.Err != nil {
+			ew.printf("\n  error: %v", cell.Err)
+		}
+		ew.printf("\n")
+	}
+	for _, fb := range info.FreeBlocks {
+		ew.printf("free block: offset=%d size=%d\n", fb.Offset, fb.Size)
+	}
+	if info.Next != 0 {
+		ew.printf("next page: %d\n", info.Next)
+	}
+	if len(info.Leaves) > 0 {
+		ew.printf("leaf pages: %v\n", info.Leaves)
+	}
+	for _, e := range info.PointerMap {
+		if e.Type != 0 {
+			ew.printf("pointer map: page=%d type=%d parent=%d\n", e.Page, e.Type, e.Parent)
+		}
+	}
+	if info.Err != nil {
+		ew.printf("error: %v\n", info.Err)
+	}
+
+	ew.printf("\n")
+	for _, r := range info.regions() {
+		ew.printf("-- [%#04x, %#04x) %s\n", r.beg, r.end, r.label)
+		hexdump(ew, info.Data[r.beg:r.end], r.beg)
+	}
+	return ew.err
+}
+
+// region is an annotated range of bytes of a page.
+type region struct {
+	beg, end int
+	label    string
+}
+
+// regions splits the page content into annotated regions.
+func (info *PageInfo) regions() []region {
+	var (
+		size   = len(info.Data)
+		usable = size - info.reserved
+		rs     []region
+	)
+	add := func(beg, end int, format string, args ...interface{}) {
+		beg = max(0, min(beg, size))
+		end = max(beg, min(end, size))
+		if beg < end {
+			rs = append(rs, region{beg, end, fmt.Sprintf(format, args...)})
+		}
+	}
+
+	switch info.Kind {
+	case BTreeInteriorIndexKind, BTreeInteriorTableKind,
+		BTreeLeafIndexKind, BTreeLeafTableKind:
+		hdr := 0
+		if info.Number == 1 {
+			add(0, 100, "database header")
+			hdr = 100
+		}
+		if info.Header == nil {
+			break
+		}
+		hdrsz := 12
+		if info.Header.Kind&leafKind != 0 {
+			hdrsz = 8
+		}
+		add(hdr, hdr+hdrsz, "b-tree page header")
+		add(hdr+hdrsz, hdr+hdrsz+2*len(info.CellPointers), "cell pointer array")
+		for i, cell := range info.Cells {
+			add(cell.Offset, cell.Offset+max(cell.Size, 1), "cell[%d]", i)
+		}
+		for _, fb := range info.FreeBlocks {
+			add(fb.Offset, fb.Offset+fb.Size, "free block")
+		}
+	case OverflowKind:
+		add(0, 4, "next overflow page")
+		add(4, usable, "overflow content")
+	case FreelistTrunkKind:
+		add(0, 4, "next trunk page")
+		add(4, 8, "number of leaf pages")
+		add(8, 8+4*len(info.Leaves), "leaf pages")
+	case PointerMapKind:
+		add(0, 5*len(info.PointerMap), "pointer map entries")
+	case FreelistLeafKind:
+		add(0, usable, "free page")
+	case LockByteKind:
+		add(0, usable, "lock-byte page")
+	}
+	add(usable, size, "reserved")
+
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].beg < rs[j].beg })
+
+	// fill the gaps between regions.
+	var (
+		out []region
+		pos = 0
+	)
+	for _, r := range rs {
+		if r.beg > pos {
+			out = append(out, region{pos, r.beg, "unused"})
+		}
+		out = append(out, r)
+		pos = max(pos, r.end)
+	}
+	if pos < size {
+		out = append(out, region{pos, size, "unused"})
+	}
+	return out
+}
+
+// hexdump writes buf in the canonical hex+ASCII format, with offsets
+// starting at off. Repeated lines are collapsed into a single '*' line,
+// the last line being always written.
+func hexdump(ew *errWriter, buf []byte, off int) {
+	var prev []byte
+	star := false
+	for i := 0; i < len(buf); i += 16 {
+		line := buf[i:min(i+16, len(buf))]
+		last := i+16 >= len(buf)
+		if prev != nil && !last && string(line) == string(prev) {
+			if !star {
+				ew.printf("*\n")
+				star = true
+			}
+			continue
+		}
+		prev, star = line, false
+
+		var hex, ascii strings.Builder
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				hex.WriteByte(' ')
+			}
+			if j >= len(line) {
+				hex.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(&hex, " %02x", line[j])
+			c := line[j]
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			ascii.WriteByte(c)
+		}
+		ew.printf("%04x %s  |%s|\n", off+i, hex.String(), ascii.String())
+	}
+}
+
+// maxDumpValue is the number of bytes of strings and blobs displayed by
+// PageInfo.Dump.
+const maxDumpValue = 32
+
+func formatValues(vs []interface{}) string {
+	var o strings.Builder
+	o.WriteString("[")
+	for i, v := range vs {
+		if i > 0 {
+			o.WriteString(" ")
+		}
+		switch v := v.(type) {
+		case nil:
+			o.WriteString("NULL")
+		case string:
+			if len(v) > maxDumpValue {
+				fmt.Fprintf(&o, "%q...(%d bytes)", v[:maxDumpValue], len(v))
+				break
+			}
+			fmt.Fprintf(&o, "%q", v)
+		case []byte:
+			if len(v) > maxDumpValue {
+				fmt.Fprintf(&o, "x'%x'...(%d bytes)", v[:maxDumpValue], len(v))
+				break
+			}
+			fmt.Fprintf(&o, "x'%x'", v)
+		default:
+			fmt.Fprintf(&o, "%v", v)
+		}
+	}
+	o.WriteString("]")
+	return o.String()
+}
+
+// errWriter is a writer that records the first write error.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInspectPage(t *testing.T) {
+	db, err := Open("testdata/fragmented.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// pages of the b-trees, as reported by the dbstat virtual table.
+	const dbstat = `1|sqlite_master|leaf 3|t|internal 4|t_name|internal 5|kv|internal
+		6|t|leaf 7|t|leaf 8|t|overflow 9|t|overflow 10|t|leaf 11|t|leaf
+		12|t_name|leaf 13|t_name|leaf 14|t|leaf 15|t|overflow 16|t|overflow
+		17|t|leaf 18|t|leaf 19|t_name|leaf 20|t|leaf 23|t|leaf 26|t|leaf
+		27|t|leaf 32|t_name|leaf 39|t_name|leaf 45|t_name|leaf 50|t|leaf
+		51|t|leaf 52|t|overflow 53|t|overflow 55|t|leaf 56|t_name|leaf
+		57|t_name|leaf 58|t|leaf 61|t|leaf 63|t|leaf 64|t_name|leaf
+		65|t|overflow 66|t|overflow 67|t|leaf 68|t|leaf 69|t|leaf
+		70|t|overflow 71|t|overflow 72|t|leaf 73|t|leaf 74|t_name|leaf
+		75|t|leaf 76|t_name|leaf 81|kv|leaf 82|kv|leaf 83|kv|leaf 84|kv|leaf`
+
+	type want struct {
+		owner string
+		kind  string
+	}
+	wants := make(map[int]want)
+	for _, line := range strings.Fields(dbstat) {
+		var (
+			pgno int
+			w    want
+		)
+		_, err := fmt.Sscanf(strings.Replace(line, "|", " ", -1), "%d %s %s", &pgno, &w.owner, &w.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wants[pgno] = w
+	}
+
+	nleaves := 0
+	for i := 1; i <= db.NumPage(); i++ {
+		info, err := db.InspectPage(i)
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if info.Err != nil {
+			t.Fatalf("page %d: %v", i, info.Err)
+		}
+
+		var kind string
+		switch info.Kind {
+		case BTreeInteriorIndexKind, BTreeInteriorTableKind:
+			kind = "internal"
+		case BTreeLeafIndexKind, BTreeLeafTableKind:
+			kind = "leaf"
+		case OverflowKind:
+			kind = "overflow"
+		}
+
+		want, ok := wants[i]
+		switch {
+		case ok:
+			if kind != want.kind || info.Owner != want.owner {
+				t.Fatalf("page %d: got=(%v, %q), want=(%s, %q)", i, info.Kind, info.Owner, want.kind, want.owner)
+			}
+		case i == 2:
+			if info.Kind != PointerMapKind {
+				t.Fatalf("page %d: got=%v, want=%v", i, info.Kind, PointerMapKind)
+			}
+			if e := info.PointerMap[0]; e != (PointerMapEntry{Page: 3, Type: 1}) {
+				t.Fatalf("invalid pointer map entry: %+v", e)
+			}
+			if e := info.PointerMap[5]; e != (PointerMapEntry{Page: 8, Type: 3, Parent: 10}) {
+				t.Fatalf("invalid pointer map entry: %+v", e)
+			}
+		case i == 21:
+			if info.Kind != FreelistTrunkKind || len(info.Leaves) != 30 {
+				t.Fatalf("page %d: got=(%v, leaves=%d), want=(%v, leaves=30)", i, info.Kind, len(info.Leaves), FreelistTrunkKind)
+			}
+		default:
+			if info.Kind != FreelistLeafKind || info.Parent != 21 {
+				t.Fatalf("page %d: got=(%v, parent=%d), want=(%v, parent=21)", i, info.Kind, info.Parent, FreelistLeafKind)
+			}
+			nleaves++
+		}
+
+		if info.Kind == OverflowKind && info.Parent == 0 {
+			t.Fatalf("page %d: overflow page without parent", i)
+		}
+	}
+	if nleaves != 30 {
+		t.Fatalf("invalid number of freelist leaf pages: got=%d, want=30", nleaves)
+	}
+}
+
+func TestPageInfoDump(t *testing.T) {
+	db, err := Open("testdata/test-2.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	info, err := db.InspectPage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Kind != BTreeLeafTableKind || info.Owner != "tbl1" {
+		t.Fatalf("invalid page: %v %q", info.Kind, info.Owner)
+	}
+	if len(info.Cells) != 2 {
+		t.Fatalf("invalid number of cells: %d", len(info.Cells))
+	}
+	if cell := info.Cells[1]; *cell.RowID != 2 || cell.Values[0] != "goodbye" || cell.Types[0] != SerialType(27) {
+		t.Fatalf("invalid cell: %+v", cell)
+	}
+
+	var buf bytes.Buffer
+	err = info.Dump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"page 2: BTreeLeafTable of \"tbl1\"\n",
+		"cell[1]: offset=999 size=13 rowid=2 payload=11 local=11\n",
+		"  values=[\"goodbye\" 20]\n",
+		"-- [0x0000, 0x0008) b-tree page header\n0000  0d 00 00 00 02 03 e7 00",
+		"-- [0x03e7, 0x03f4) cell[1]\n03e7  0b 02 03 1b 01 67 6f 6f  64 62 79 65 14           |.....goodbye.|\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("missing %q in dump:\n%s", want, buf.String())
+		}
+	}
+
+	_, err = db.InspectPage(5)
+	if err == nil {
+		t.Fatalf("expected an error for an out of range page")
+	}
+}
+
+func TestInspectFreelistReserved(t *testing.T) {
+	const max = 1016/4 - 2 // page numbers in the usable space of a trunk page
+	db := freelistDB(t, max+1)
+	defer db.Close()
+
+	info, err := db.InspectPage(db.NumPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Kind != FreelistTrunkKind {
+		t.Fatalf("got kind=%v, want %v", info.Kind, FreelistTrunkKind)
+	}
+	if _, ok := info.Err.(*CorruptError); !ok {
+		t.Fatalf("got err=%v, want an invalid number of freelist leaf pages", info.Err)
+	}
+	if len(info.Leaves) != max {
+		t.Fatalf("got %d leaf pages, want %d", len(info.Leaves), max)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryJoin(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select urls.id, visits.id, visit_time from urls join visits on visits.url = urls.id where urls.id < 3 order by visit_time`,
+			want: `1|95|13300004294842925
+2|131|13300005924937638
+1|304|13300013616860912
+2|332|13300014816330270
+2|382|13300017075467994
+1|455|13300020383158555
+1|529|13300024072902973
+2|533|13300024379826128
+1|709|13300032446243261
+1|800|13300036326591735
+1|1167|13300052279202979
+2|1170|13300052492116459
+2|1201|13300053713197259
+1|1260|13300056220767370
+2|1421|13300064207501676
+1|1473|13300066842963916
+`,
+		},
+		{
+			query: `select u.id, v.id from urls u, visits v where v.url = u.id and u.id = 7 order by 2`,
+			want: `7|171
+7|601
+7|639
+7|640
+7|958
+7|1036
+7|1078
+7|1264
+7|1412
+7|1416
+7|1434
+`,
+		},
+		{
+			query: `select u.id, count(v.id) from urls u left join visits v on v.url = u.id group by u.id having count(v.id) = 0 limit 3`,
+			want: `181|0
+182|0
+183|0
+`,
+		},
+		{
+			query: `select u.id, v.id from urls u left join visits v on v.url = u.id and v.transition = 1 where u.id between 10 and 12 order by 1, 2`,
+			want: `10|281
+10|1352
+10|1495
+11|75
+11|176
+11|1417
+12|189
+12|525
+12|690
+`,
+		},
+		{
+			query: `select u.id, v.id from urls u left join visits v on v.url = u.id where v.id is null order by 1 limit 4`,
+			want: `181|NULL
+182|NULL
+183|NULL
+184|NULL
+`,
+		},
+		{
+			query: `select count(*), count(distinct u.id) from visits v join urls u on u.id = v.url`,
+			want: `1500|180
+`,
+		},
+		{
+			query: `select count(*) from visits v, urls u`,
+			want: `300000
+`,
+		},
+		{
+			query: `select count(*) from visits v cross join urls u where u.id = v.url and u.hidden`,
+			want: `56
+`,
+		},
+		{
+			query: `select v.id, u.title, p.visit_time - v.visit_time from visits v join visits p on p.id = v.from_visit join urls u on u.id = v.url where v.id < 25 order by v.id`,
+			want: `6|Page 40 on example.com|-29321322
+14|Page 64 on go.dev|-68104660
+16|Page 98 on news.ycombinator.com|-20251302
+17|Page 142 on sqlite.org|-12592664
+18|Page 16 on golang.org|-70143575
+19|Page 160 on example.com|-73916656
+`,
+		},
+		{
+			query: `select k.term, u.id from keyword_search_terms k left join urls u on u.id = k.url_id where k.keyword_id = 2 order by k.rowid`,
+			want: `Go|6
+index|168
+go|163
+index|NULL
+join|2
+Go|23
+Go|92
+sqlite|11
+sqlite|182
+sqlite|188
+go|190
+Go|189
+Go|85
+go|171
+join|105
+Go|132
+Go|200
+Go|NULL
+join|NULL
+Go|55
+`,
+		},
+		{
+			query: `select * from tags natural join urls order by id limit 3`,
+			want: `https://golang.org/page1|read|1|Page 1 on golang.org|9|0
+https://go.dev/page4|read|4|Page 4 on go.dev|9|0
+https://sqlite.org/page7|work|7|Page 7 on sqlite.org|11|0
+`,
+		},
+		{
+			query: `select url, tag, id from tags join urls using (url) where tag = 'work' order by id limit 4`,
+			want: `https://sqlite.org/page7|work|7
+https://example.com/page25|work|25
+https://news.ycombinator.com/page28|work|28
+https://go.dev/page34|work|34
+`,
+		},
+		{
+			query: `select t.*, u.id from tags t join urls u on u.url = t.url order by u.id limit 3`,
+			want: `https://golang.org/page1|read|1
+https://go.dev/page4|read|4
+https://sqlite.org/page7|work|7
+`,
+		},
+		{
+			query: `select u.id, t.tag from urls u left join tags t using (url) where u.id < 8 order by u.id`,
+			want: `1|read
+2|NULL
+3|NULL
+4|read
+5|NULL
+6|NULL
+7|work
+`,
+		},
+		{
+			query: `select a.id, b.id, c.id from urls a left join urls b on b.id = a.id * 100 left join urls c on c.id = b.id + 1 where a.id < 4`,
+			want: `1|100|101
+2|200|NULL
+3|NULL|NULL
+`,
+		},
+		{
+			query: `select a.id, b.id, c.id from urls a left join urls b on b.id = a.id * 100 join urls c on c.id = b.id + 1 where a.id < 4`,
+			want: `1|100|101
+`,
+		},
+		{
+			query: `select a.id, b.id from urls a left join urls b on a.id < 3 and b.id = 1 order by a.id limit 4`,
+			want: `1|1
+2|1
+3|NULL
+4|NULL
+`,
+		},
+		{
+			query: `select max(v.visit_time), u.url from visits v join urls u on u.id = v.url group by u.url order by 1 desc limit 3`,
+			want: `13300067780668544|https://sqlite.org/page142
+13300067708325989|https://news.ycombinator.com/page113
+13300067700318380|https://sqlite.org/page57
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestJoinLookups(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		from string
+		want []string // lookup of each table: an index, "primary key", "rowid" or "" for a scan
+	}{
+		{"visits v join urls u on u.id = v.url where v.id < 100", []string{"", "rowid"}},
+		{"urls u join visits v on v.url = u.id where u.id < 20", []string{"", "visits_url_index"}},
+		{"urls u, visits v where v.url = u.id and u.id = 7", []string{"rowid", "visits_url_index"}},
+		{"urls u left join visits v on v.url = u.id where u.id > 170", []string{"", "visits_url_index"}},
+		{"urls u left join visits v on u.id < 3 where v.url = u.id and u.id < 5", []string{"", ""}},
+		{"visits v join visits p on p.id = v.from_visit join urls u on u.id = p.url where v.id < 50", []string{"", "rowid", "rowid"}},
+		{"visits v join urls u on u.url = 'https://' || v.url where v.id < 100", []string{"", "urls_url_index"}},
+		{"tags t join urls u on u.url = t.url", []string{"", "urls_url_index"}},
+		{"urls u join tags t on t.url = u.url", []string{"", "primary key"}},
+		{"urls u join visits v on v.url + 0 = u.id where u.id < 3", []string{"", ""}},
+	} {
+		query := "select * from " + tc.from
+		stmt, _, err := parseSelect(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := db.compileSelect(stmt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, src := range plan.sources {
+			switch l := src.lookup; {
+			case l == nil:
+				got = append(got, "")
+			case l.index == nil:
+				got = append(got, "rowid")
+			case l.index.name == "":
+				got = append(got, "primary key")
+			default:
+				got = append(got, l.index.name)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got lookups %q, want %q", tc.from, got, tc.want)
+		}
+
+		// the lookups must find the rows found by scans.
+		rows := planRows(t, plan)
+		for _, src := range plan.sources {
+			src.lookup = nil
+		}
+		if want := planRows(t, plan); len(rows) != len(want) {
+			t.Errorf("%s: got %d rows, want %d", tc.from, len(rows), len(want))
+		}
+	}
+}
+
+func TestQueryJoinErrors(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`select url from urls join visits on visits.url = urls.id`, "ambiguous column name: url"},
+		{`select * from urls join visits using (visit_time)`, "cannot join using column visit_time - column not present in both tables"},
+		{`select * from urls right join visits on 1`, "RIGHT and FULL OUTER JOINs are not supported"},
+		{`select * from urls natural join visits using (id)`, "a NATURAL join may not have an ON or USING clause"},
+		{`select * from urls natural, visits`, "expected JOIN"},
+		{`select * from urls u join visits v on w.id = 1 join visits w`, "no such column: w.id"},
+		{`select * from urls u join visits v on count(*) > 1`, "misuse of aggregate: count()"},
+	} {
+		_, err := db.Query(tc.query)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind describes the kind of a SQL token.
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIdent            // identifier or keyword
+	tokQIdent           // quoted identifier: "x", [x] or `x`
+	tokString           // string literal: 'x'
+	tokBlob             // blob literal: x'00ff'
+	tokInt              // integer literal
+	tokFloat            // floating point literal
+	tokParam            // parameter: ?, ?NNN, :name, @name or $name
+	tokOp               // operator or punctuation
+)
+
+// token is a SQL token.
+type token struct {
+	kind tokenKind
+	text string // unquoted text of the token
+	pos  int    // byte offset of the token in the input
+}
+
+// is reports whether the token is the (case-insensitive) keyword kw.
+func (tok token) is(kw string) bool {
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, kw)
+}
+
+// isOp reports whether the token is the operator op.
+func (tok token) isOp(op string) bool {
+	return tok.kind == tokOp && tok.text == op
+}
+
+// isName reports whether the token may be used as a name.
+func (tok token) isName() bool {
+	return tok.kind == tokIdent || tok.kind == tokQIdent || tok.kind == tokString
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return "'" + tok.text + "'"
+	case tokQIdent:
+		return `"` + tok.text + `"`
+	}
+	return tok.text
+}
+
+// lex splits the SQL text src into tokens.
+// The returned slice always ends with a tokEOF token.
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				i = len(src)
+				break
+			}
+			i += end + 1
+
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+				break
+			}
+			i += end + 4
+
+		case c == '\'':
+			s, n, err := lexQuoted(src[i:], '\'')
+			if err != nil {
+				return nil, fmt.Errorf("sqlite3: unterminated string at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i += n
+
+		case c == '"' || c == '`':
+			s, n, err := lexQuoted(src[i:], c)
+			if err != nil {
+				return nil, fmt.Errorf("sqlite3: unterminated identifier at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokQIdent, text: s, pos: i})
+			i += n
+
+		case c == '[':
+			end := strings.IndexByte(src[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("sqlite3: unterminated identifier at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokQIdent, text: src[i+1 : i+end], pos: i})
+			i += end + 1
+
+		case (c == 'x' || c == 'X') && i+1 < len(src) && src[i+1] == '\'':
+			s, n, err := lexQuoted(src[i+1:], '\'')
+			if err != nil {
+				return nil, fmt.Errorf("sqlite3: unterminated blob literal at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokBlob, text: s, pos: i})
+			i += n + 1
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			tok, n := lexNumber(src[i:])
+			tok.pos = i
+			toks = append(toks, tok)
+			i += n
+
+		case c == '?':
+			j := i + 1
+			for j < len(src) && isDigit(src[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokParam, text: src[i:j], pos: i})
+			i = j
+
+		case c == ':' || c == '@' || c == '$':
+			j := i + 1
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("sqlite3: invalid parameter name at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokParam, text: src[i:j], pos: i})
+			i = j
+
+		default:
+			op := lexOp(src[i:])
+			if op == "" {
+				return nil, fmt.Errorf("sqlite3: unrecognized token %q at offset %d", src[i:i+1], i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	toks = append(toks, token{kind: tokEOF, pos: len(src)})
+	return toks, nil
+}
+
+// lexQuoted lexes a string quoted with q, where a doubled quote stands
+// for a single quote character.
+// lexQuoted returns the unquoted string and the number of bytes consumed.
+func lexQuoted(src string, q byte) (string, int, error) {
+	var s strings.Builder
+	for i := 1; i < len(src); i++ {
+		if src[i] != q {
+			s.WriteByte(src[i])
+			continue
+		}
+		if i+1 < len(src) && src[i+1] == q {
+			s.WriteByte(q)
+			i++
+			continue
+		}
+		return s.String(), i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated")
+}
+
+func lexNumber(src string) (token, int) {
+	if len(src) > 2 && src[0] == '0' && (src[1] == 'x' || src[1] == 'X') && isHexDigit(src[2]) {
+		i := 2
+		for i < len(src) && isHexDigit(src[i]) {
+			i++
+		}
+		return token{kind: tokInt, text: src[:i]}, i
+	}
+
+	kind := tokInt
+	i := 0
+	for i < len(src) && isDigit(src[i]) {
+		i++
+	}
+	if i < len(src) && src[i] == '.' {
+		kind = tokFloat
+		i++
+		for i < len(src) && isDigit(src[i]) {
+			i++
+		}
+	}
+	if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+		j := i + 1
+		if j < len(src) && (src[j] == '+' || src[j] == '-') {
+			j++
+		}
+		if j < len(src) && isDigit(src[j]) {
+			kind = tokFloat
+			i = j
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+		}
+	}
+	return token{kind: kind, text: src[:i]}, i
+}
+
+var sqlOps = []string{
+	"->>", "||", "<=", ">=", "==", "!=", "<>", "<<", ">>", "->",
+	"(", ")", ",", ";", ".", "+", "-", "*", "/", "%", "<", ">", "=",
+	"&", "|", "~",
+}
+
+func lexOp(src string) string {
+	for _, op := range sqlOps {
+		if strings.HasPrefix(src, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
@@ -0,0 +1,22 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package sqlite3
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoMmap = errors.New("sqlite3: mmap not supported")
+
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+func munmap(data []byte) error {
+	return errNoMmap
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+// Option configures how a database file is opened.
+type Option func(*options)
+
+type options struct {
+	mmap      bool      // whether to memory-map the database file
+	checksums bool      // whether to verify cksumvfs checksums
+	codec     PageCodec // codec decoding pages, if any
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMmap requests the database file to be memory-mapped, when
+// supported by the platform.
+// Pages are then read directly from the mapping, without any copy.
+//
+// When the file is memory-mapped, Record.Body and the []byte values
+// of decoded records point into the read-only mapping: they must not
+// be modified and are only valid until the DbFile is closed.
+//
+// WithMmap is only honored by Open: on platforms without mmap support,
+// the file is read as usual.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithChecksums requests the checksum of every page loaded from the
+// database file to be verified.
+// The database must have been written with the SQLite checksum VFS
+// (cksumvfs), which stores an 8-byte checksum in the reserved space at
+// the end of each page.
+//
+// Pages whose checksum does not match their content are reported as a
+// *CorruptError.
+func WithChecksums() Option {
+	return func(o *options) {
+		o.checksums = true
+	}
+}
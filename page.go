@@ -31,11 +31,14 @@ const (
 	BTreeLeafIndexKind     = zeroDataKind | leafKind
 	BTreeLeafTableKind     = leafDataKind | intKeyKind | leafKind
 
-	pkLockByte
-	pkFreelistTrunk
-	pkFreelistLeaf
-	pkPayloadOverflow
-	pkPointerMap
+	// kinds of the pages that are not b-tree pages, as identified by
+	// DbFile.InspectPage. They are not stored in the pages.
+	UnknownKind       PageKind = 0x00 // page not referenced by the database
+	OverflowKind      PageKind = 0x10 // payload overflow page
+	FreelistTrunkKind PageKind = 0x11 // freelist trunk page
+	FreelistLeafKind  PageKind = 0x12 // freelist leaf page
+	PointerMapKind    PageKind = 0x13 // pointer map page
+	LockByteKind      PageKind = 0x14 // lock-byte page
 )
 
 func (pk PageKind) String() string {
@@ -48,9 +51,21 @@ func (pk PageKind) String() string {
 		return "BTreeLeafIndex"
 	case BTreeLeafTableKind:
 		return "BTreeLeafTable"
+	case UnknownKind:
+		return "Unknown"
+	case OverflowKind:
+		return "Overflow"
+	case FreelistTrunkKind:
+		return "FreelistTrunk"
+	case FreelistLeafKind:
+		return "FreelistLeaf"
+	case PointerMapKind:
+		return "PointerMap"
+	case LockByteKind:
+		return "LockByte"
 	}
 
-	panic(fmt.Sprintf("sqlite3: invalid PageKind value (0x%02x)", byte(pk)))
+	return fmt.Sprintf("PageKind(0x%02x)", byte(pk))
 }
 
 /*
@@ -73,9 +88,10 @@ func newPage(i int, pb pageBuffer) (Page, error) {
 */
 
 // page is a page loaded from disk.
+// The content of a page is immutable once loaded: pages are shared
+// between all the users of a DbFile.
 type page struct {
 	id  int
-	pos int
 	buf []byte
 }
 
@@ -95,63 +111,77 @@ func (p *page) PageSize() int {
 	return len(p.buf)
 }
 
-func (p *page) Seek(offset int64, whence int) (ret int64, err error) {
+// reader returns a new cursor over the page content, positioned at
+// offset pos.
+func (p *page) reader(pos int) *pageReader {
+	return &pageReader{page: *p, pos: pos}
+}
+
+// pageReader is a cursor reading data from a page.
+// Each goroutine should use its own pageReader.
+type pageReader struct {
+	page page
+	pos  int
+}
+
+func (r *pageReader) Seek(offset int64, whence int) (ret int64, err error) {
+	buf := r.page.buf
 	switch whence {
 	case 0:
 		offset := int(offset)
-		if offset > len(p.buf) {
+		if offset > len(buf) {
 			return 0, fmt.Errorf("sqlite: offset too big (%d)", offset)
 		}
-		p.pos = offset
+		r.pos = offset
 	case 1:
 		offset := int(offset)
-		pos := p.pos + offset
-		if pos > len(p.buf) {
+		pos := r.pos + offset
+		if pos > len(buf) {
 			return 0, fmt.Errorf("sqlite: offset too big (%d)", offset)
 		}
-		p.pos = pos
+		r.pos = pos
 	case 2:
 		offset := int(offset)
-		pos := len(p.buf) - offset
+		pos := len(buf) - offset
 		if pos < 0 {
 			return 0, fmt.Errorf("sqlite: offset too big (%d)", offset)
 		}
-		p.pos = pos
+		r.pos = pos
 	}
-	return int64(p.pos), nil
+	return int64(r.pos), nil
 }
 
-func (p *page) Pos() int {
-	return p.pos
+func (r *pageReader) Pos() int {
+	return r.pos
 }
 
-func (p *page) Bytes() []byte {
-	return p.buf[p.pos:]
+func (r *pageReader) Bytes() []byte {
+	return r.page.buf[r.pos:]
 }
 
-func (p *page) Decode(ptr interface{}) error {
-	n, err := unmarshal(p.buf[p.pos:], ptr)
+func (r *pageReader) Decode(ptr interface{}) error {
+	n, err := unmarshal(r.page.buf[r.pos:], ptr)
 	if err != nil {
 		return err
 	}
-	p.pos += int(n)
+	r.pos += int(n)
 	return err
 }
 
-func (p *page) Read(data []byte) (int, error) {
-	n := copy(data, p.buf[p.pos:p.pos+len(data)])
+func (r *pageReader) Read(data []byte) (int, error) {
+	n := copy(data, r.page.buf[r.pos:])
 	if n != len(data) {
 		return n, fmt.Errorf("error. read too few bytes: %d. want %d", n, len(data))
 	}
-	p.pos += n
+	r.pos += n
 	return n, nil
 }
 
-func (p *page) Varint() (int64, int) {
-	v, n := varint(p.Bytes())
+func (r *pageReader) Varint() (int64, int) {
+	v, n := varint(r.Bytes())
 	if n <= 0 {
 		return v, n
 	}
-	p.pos += int(n)
+	r.pos += int(n)
 	return v, n
 }
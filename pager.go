@@ -7,66 +7,153 @@ package sqlite3
 import (
 	"fmt"
 	"io"
+	"sync"
 )
 
+// pager loads pages from the underlying database file.
+// pager is safe for concurrent use by multiple goroutines.
 type pager struct {
-	f      io.ReadSeeker
-	size   int          // page size in bytes
-	npages int          // total number of pages in db
-	pages  map[int]page // cache of pages
-	lru    []int        // list of last used pages
+	r      io.ReaderAt
+	data   []byte // memory-mapped content of the file, if any
+	size   int    // page size in bytes
+	npages int    // total number of pages in db
+
+	checksums bool      // whether to verify cksumvfs checksums of pages
+	codec     PageCodec // codec decoding the pages, if any
+
+	mu    sync.RWMutex
+	pages map[int]page // cache of pages
 }
 
-func newPager(f io.ReadSeeker, size, npages int) pager {
-	pager := pager{
-		f:      f,
+func newPager(r io.ReaderAt, size, npages int) *pager {
+	return &pager{
+		r:      r,
 		size:   size,
 		npages: npages,
-		pages:  make(map[int]page, npages),
-		lru:    make([]int, 0, 2),
+		pages:  make(map[int]page, min(npages, 1024)),
 	}
-
-	return pager
 }
 
 func (p *pager) Page(i int) (page, error) {
-	var err error
+	if p.data != nil && !p.checksums && p.codec == nil {
+		return p.mapped(i)
+	}
+
+	p.mu.RLock()
 	page, ok := p.pages[i]
+	p.mu.RUnlock()
 	if ok {
-		return page, err
+		return page, nil
 	}
 
-	if i > p.npages {
-		return page, fmt.Errorf("sqlite3: out of range (%d > %d)", i, p.npages)
+	page, err := p.read(i)
+	if err != nil {
+		return page, err
 	}
 
-	pos, _ := p.f.Seek(0, io.SeekCurrent)
-	defer p.f.Seek(pos, io.SeekStart)
+	p.mu.Lock()
+	if cached, ok := p.pages[i]; ok {
+		// another goroutine loaded this page concurrently.
+		page = cached
+	} else if p.pages != nil {
+		p.pages[i] = page
+	}
+	p.mu.Unlock()
+	return page, nil
+}
 
-	buf := make([]byte, p.size)
-	if _, err := p.f.Seek(int64((i-1)*p.size), io.SeekStart); err != nil {
-		return page, err
+// read reads and decodes the i-th page, bypassing the cache.
+// read is used for pages which are only read once, such as the overflow
+// pages of large values.
+func (p *pager) read(i int) (page, error) {
+	if p.data != nil && !p.checksums && p.codec == nil {
+		return p.mapped(i)
 	}
-	n, err := p.f.Read(buf)
+
+	var page page
+	buf, err := p.load(i)
 	if err != nil {
 		return page, err
 	}
 
-	if n != len(buf) {
-		return page, fmt.Errorf("sqlite3: read too few bytes")
+	if p.checksums {
+		err = verifyChecksum(i, buf)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	if p.codec != nil {
+		buf, err = p.codec.Decode(i, buf)
+		if err != nil {
+			return page, err
+		}
+		if len(buf) != p.size {
+			return page, fmt.Errorf("sqlite3: codec decoded %d bytes instead of %d", len(buf), p.size)
+		}
 	}
 
 	page.id = i
 	page.buf = buf
+	return page, nil
+}
 
-	p.pages[i] = page
-	p.lru = append(p.lru, i)
-	return page, err
+// load loads the content of the i-th page, bypassing the cache.
+func (p *pager) load(i int) ([]byte, error) {
+	if p.data != nil {
+		page, err := p.mapped(i)
+		return page.buf, err
+	}
+
+	if i < 1 || i > p.npages {
+		return nil, fmt.Errorf("sqlite3: out of range (%d > %d)", i, p.npages)
+	}
+
+	buf := make([]byte, p.size)
+	n, err := p.r.ReadAt(buf, int64(i-1)*int64(p.size))
+	if n != len(buf) {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("sqlite3: read too few bytes")
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// mapped returns the i-th page, directly from the memory-mapped file.
+func (p *pager) mapped(i int) (page, error) {
+	if i < 1 || i > p.npages {
+		return page{}, fmt.Errorf("sqlite3: out of range (%d > %d)", i, p.npages)
+	}
+
+	beg := (i - 1) * p.size
+	end := beg + p.size
+	if end > len(p.data) {
+		return page{}, fmt.Errorf("sqlite3: read too few bytes")
+	}
+	return page{id: i, buf: p.data[beg:end:end]}, nil
 }
 
 func (p *pager) Delete() error {
-	var err error
+	p.mu.Lock()
 	p.pages = nil
-	p.lru = nil
-	return err
+	p.mu.Unlock()
+	return nil
+}
+
+// seekReaderAt adapts an io.ReadSeeker to the io.ReaderAt interface.
+// Accesses to the underlying io.ReadSeeker are serialized.
+type seekReaderAt struct {
+	mu sync.Mutex
+	f  io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.f, p)
 }
@@ -0,0 +1,144 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parser is a recursive-descent parser of SQL statements.
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+
+	params []*param // parameters of the statement, in order of appearance
+}
+
+func newParser(src string) (*parser, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{src: src, toks: toks}, nil
+}
+
+// peek returns the current token.
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+// peekN returns the token n positions after the current one.
+func (p *parser) peekN(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+// next consumes and returns the current token.
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the current token if it is one of the keywords kws,
+// in sequence.
+func (p *parser) accept(kws ...string) bool {
+	for i, kw := range kws {
+		if !p.peekN(i).is(kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+// acceptOp consumes the current token if it is the operator op.
+func (p *parser) acceptOp(op string) bool {
+	if p.peek().isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the keywords kws, in sequence, or fails.
+func (p *parser) expect(kws ...string) error {
+	for _, kw := range kws {
+		if !p.accept(kw) {
+			return p.errorf("expected %s", strings.ToUpper(kw))
+		}
+	}
+	return nil
+}
+
+// expectOp consumes the operator op or fails.
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf("expected %q", op)
+	}
+	return nil
+}
+
+// name consumes and returns a name (identifier, quoted identifier or
+// string used as a name).
+func (p *parser) name() (string, error) {
+	tok := p.peek()
+	if !tok.isName() {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+// qualifiedName consumes a name, optionally prefixed by a schema name,
+// and returns the schema and object names.
+func (p *parser) qualifiedName() (schema, name string, err error) {
+	name, err = p.name()
+	if err != nil {
+		return "", "", err
+	}
+	if p.peek().isOp(".") && p.peekN(1).isName() {
+		p.pos++
+		schema = name
+		name, err = p.name()
+	}
+	return schema, name, err
+}
+
+// skipParens consumes a parenthesized group of tokens, including any
+// nested group, and returns the source text within the parentheses.
+func (p *parser) skipParens() (string, error) {
+	open := p.peek()
+	if err := p.expectOp("("); err != nil {
+		return "", err
+	}
+	depth := 1
+	for depth > 0 {
+		tok := p.next()
+		switch {
+		case tok.kind == tokEOF:
+			return "", p.errorf("unbalanced parentheses")
+		case tok.isOp("("):
+			depth++
+		case tok.isOp(")"):
+			depth--
+			if depth == 0 {
+				return strings.TrimSpace(p.src[open.pos+1 : tok.pos]), nil
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	tok := p.peek()
+	return fmt.Errorf("sqlite3: %s near %s (offset %d)", fmt.Sprintf(format, args...), tok, tok.pos)
+}
@@ -0,0 +1,448 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"math"
+	"strings"
+)
+
+// lookup is an index or rowid lookup, replacing the scan of the whole
+// table of a source.
+type lookup struct {
+	index *Index     // index, or nil for a lookup by rowid
+	desc  bool       // whether the column of the range is sorted in descending order
+	eq    []expr     // values of the first indexed columns, or of the rowid
+	affs  []affinity // affinity of the comparisons with eq
+	lo    *rangeEnd  // lower bound of the values of the next indexed column
+	hi    *rangeEnd  // upper bound of the values of the next indexed column
+}
+
+// rangeEnd is a bound of a range of values.
+type rangeEnd struct {
+	x    expr
+	open bool     // whether the bound is excluded
+	aff  affinity // affinity of the comparison
+}
+
+// term is a comparison of a WHERE clause between a column of a source
+// and an expression not depending on the row.
+type term struct {
+	col  int    // index of the column, or -1 for the rowid
+	op   string // =, <, <=, > or >=
+	x    expr
+	aff  affinity // affinity of the comparison
+	coll string   // name of the collation of the comparison
+}
+
+// flipped maps comparison operators to the ones giving the same result
+// when their operands are swapped.
+var flipped = map[string]string{
+	"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// conjuncts returns the operands of the AND operators of x.
+func conjuncts(x expr) []expr {
+	if b, ok := x.(*binaryExpr); ok && b.op == "AND" {
+		return append(conjuncts(b.x), conjuncts(b.y)...)
+	}
+	return []expr{x}
+}
+
+// terms returns the comparisons of the conditions conds that could be
+// answered with a lookup of the source.
+func (src *source) terms(conds []expr) []term {
+	var terms []term
+	for _, x := range conds {
+		switch x := x.(type) {
+		case *binaryExpr:
+			switch x.op {
+			case "=", "<", "<=", ">", ">=":
+				if t, ok := src.term(x.x, x.op, x.y); ok {
+					terms = append(terms, t)
+				} else if t, ok := src.term(x.y, flipped[x.op], x.x); ok {
+					terms = append(terms, t)
+				}
+			}
+		case *betweenExpr:
+			if x.not {
+				break
+			}
+			lo, ok1 := src.term(x.x, ">=", x.lo)
+			hi, ok2 := src.term(x.x, "<=", x.hi)
+			if ok1 && ok2 {
+				terms = append(terms, lo, hi)
+			}
+		}
+	}
+	return terms
+}
+
+// term returns the comparison of the column c of the source with x,
+// which may only use the values of the previous sources.
+func (src *source) term(c expr, op string, x expr) (term, bool) {
+	ref, ok := c.(*colRef)
+	if coll, isColl := c.(*collateExpr); isColl {
+		ref, ok = coll.x.(*colRef)
+	}
+	if !ok || ref.slot < src.off || ref.slot >= src.off+src.nslots() || maxSlot(x) >= src.off {
+		return term{}, false
+	}
+	t := term{col: ref.slot - src.off, op: op, x: x}
+	if t.col == len(src.table.cols) || t.col == src.table.rowid {
+		t.col = -1
+	}
+	t.aff = compareAffinity(ref.aff, exprAffinity(x))
+	if !indexable(ref.aff, t.aff) {
+		return term{}, false
+	}
+	t.coll = compareCollation(c, x)
+	if t.coll == "" {
+		t.coll = "BINARY"
+	}
+	return t, true
+}
+
+// maxSlot returns the largest slot of the row used by x, or -1.
+func maxSlot(x expr) int {
+	max := -1
+	walkExpr(x, func(x expr) bool {
+		ref, ok := x.(*colRef)
+		switch {
+		case !ok:
+		case ref.alias != nil:
+			if n := maxSlot(ref.alias); n > max {
+				max = n
+			}
+		case ref.slot > max:
+			max = ref.slot
+		}
+		return true
+	})
+	return max
+}
+
+// indexable reports whether the values of a column of affinity col are
+// left unchanged when compared with the affinity cmp: the keys of an
+// index are the values of the column, as stored.
+func indexable(col, cmp affinity) bool {
+	switch {
+	case cmp.isNumeric():
+		return col.isNumeric()
+	case cmp == affText:
+		return col == affText
+	}
+	return true
+}
+
+// place attaches the conditions conds of a query to the first source
+// after which they may be checked, and selects the lookups of the
+// sources. The rows of the right table of a LEFT JOIN are only looked up
+// with its ON condition.
+func (plan *selectPlan) place(conds []expr) {
+	for _, x := range conds {
+		var (
+			slot = maxSlot(x)
+			src  = plan.sources[0]
+		)
+		for _, s := range plan.sources {
+			if slot >= s.off {
+				src = s
+			}
+		}
+		src.filters = append(src.filters, x)
+	}
+	for _, src := range plan.sources {
+		switch {
+		case !src.left:
+			src.plan(conds)
+		case src.on != nil:
+			src.plan(conjuncts(src.on))
+		}
+	}
+}
+
+// plan selects the lookup used to scan the source for the rows matching
+// the conditions conds, if any: a lookup by rowid, or an index lookup.
+//
+// The index lookup of an analyzed table is chosen from the statistics of
+// its indexes, when cheaper than the scan of the whole table; otherwise
+// it is the one using the most comparisons.
+func (src *source) plan(conds []expr) {
+	all := src.terms(conds)
+	if len(all) == 0 || src.derived != nil {
+		return
+	}
+	for _, tm := range all {
+		if tm.col < 0 && tm.op == "=" {
+			src.lookup = &lookup{eq: []expr{tm.x}, affs: []affinity{tm.aff}}
+			return
+		}
+	}
+
+	var (
+		t     = src.table
+		idxs  = t.Indexes()
+		stats = t.Stats()
+		best  *lookup
+		score = 0
+		cost  float64 // cost of the best lookup, or of the scan of the table
+	)
+	if stats != nil {
+		cost = float64(stats.Rows)
+	}
+	if t.withoutRowid {
+		idxs = append(idxs, t.pkIndex())
+	}
+	for _, idx := range idxs {
+		if idx.partial {
+			continue
+		}
+		keys, err := t.keyColumns(idx.cols)
+		if err != nil {
+			continue
+		}
+		l := &lookup{index: idx}
+	cols:
+		for _, col := range idx.cols {
+			icol := t.colIndex(col.name)
+			if col.expr != "" || icol < 0 {
+				break
+			}
+			if icol == t.rowid {
+				icol = -1
+			}
+			// the comparisons must use the collation of the index.
+			coll := t.collationName(col)
+			var terms []term
+			for _, tm := range all {
+				if tm.col == icol && (tm.col < 0 || strings.EqualFold(tm.coll, coll)) {
+					terms = append(terms, tm)
+				}
+			}
+			for _, tm := range terms {
+				if tm.op == "=" {
+					l.eq = append(l.eq, tm.x)
+					l.affs = append(l.affs, tm.aff)
+					continue cols
+				}
+			}
+			if idx.stats != nil && idx.stats.Unordered {
+				break
+			}
+			for _, tm := range terms {
+				end := &rangeEnd{x: tm.x, open: tm.op == "<" || tm.op == ">", aff: tm.aff}
+				switch {
+				case (tm.op == ">" || tm.op == ">=") && l.lo == nil:
+					l.lo = end
+				case (tm.op == "<" || tm.op == "<=") && l.hi == nil:
+					l.hi = end
+				}
+			}
+			l.desc = col.desc
+			break
+		}
+
+		n := 2 * len(l.eq)
+		if l.lo != nil || l.hi != nil {
+			n++
+		}
+		switch {
+		case n == 0:
+		case stats != nil:
+			if c := l.cost(t, keys); c < cost {
+				best, cost = l, c
+			}
+		case n > score:
+			best, score = l, n
+		}
+	}
+	src.lookup = best
+}
+
+// defaultAvgEq is the number of entries of an index assumed to have the
+// same values of its first columns, when the index was not analyzed.
+const defaultAvgEq = 10
+
+// cost returns the estimated cost of the index lookup in the analyzed
+// table t, whose keys compare like keys, in rows read: the index b-tree
+// is searched, then each row found is looked up in the table b-tree,
+// unless the index is the primary key of a WITHOUT ROWID table. The scan
+// of the whole table costs its number of rows.
+func (l *lookup) cost(t *Table, keys []keyColumn) float64 {
+	var (
+		rows  = float64(t.Stats().Rows)
+		n     = l.estimate(keys, rows)
+		depth = math.Log2(rows + 1)
+	)
+	if l.index.pageid == t.pageid {
+		return depth + n
+	}
+	return depth + n*(1+depth)
+}
+
+// estimate returns the estimated number of rows found by the index
+// lookup, in a table of the given number of rows. When the values of the
+// lookup are constant, they are compared with the samples of the index.
+func (l *lookup) estimate(keys []keyColumn, rows float64) float64 {
+	var (
+		idx = l.index
+		st  = idx.stats
+		m   = len(l.eq)
+		n   = rows
+	)
+	lo, hi, known := l.constBounds()
+	if known && (st == nil || len(st.Samples) == 0) {
+		known = false
+	}
+	switch {
+	case m == 0:
+	case idx.unique && m == len(idx.cols):
+		n = 1
+	case st == nil:
+		n = math.Min(defaultAvgEq, rows)
+	case known:
+		n = st.eqEntries(keys, lo.key[:m])
+	default:
+		n = st.avgEq(m)
+	}
+	switch {
+	case l.lo == nil && l.hi == nil:
+	case known:
+		n = st.rank(keys, hi.key, !hi.open) - st.rank(keys, lo.key, lo.open)
+	default:
+		// like SQLite, assume each bound of the range keeps a fourth of
+		// the rows.
+		for _, end := range []*rangeEnd{l.lo, l.hi} {
+			if end != nil {
+				n /= 4
+			}
+		}
+	}
+	return math.Max(n, 1)
+}
+
+// constBounds returns the range of the keys found by the lookup, when
+// its values are constant and not NULL.
+func (l *lookup) constBounds() (lo, hi *keyBound, ok bool) {
+	xs := append([]expr(nil), l.eq...)
+	for _, end := range []*rangeEnd{l.lo, l.hi} {
+		if end != nil {
+			xs = append(xs, end.x)
+		}
+	}
+	for _, x := range xs {
+		if !constant(x) {
+			return nil, nil, false
+		}
+	}
+	lo, hi, ok, err := l.bounds(&env{})
+	if err != nil {
+		return nil, nil, false
+	}
+	return lo, hi, ok
+}
+
+// constant reports whether the value of x is known when the query is
+// planned: x is a literal or a parameter, possibly negated, cast or
+// given a collation.
+func constant(x expr) bool {
+	switch x := x.(type) {
+	case *literal, *param:
+		return true
+	case *unaryExpr:
+		return (x.op == "-" || x.op == "+") && constant(x.x)
+	case *castExpr:
+		return constant(x.x)
+	case *collateExpr:
+		return constant(x.x)
+	}
+	return false
+}
+
+// bounds returns the range of the keys of the index, or the rowid, found
+// by the lookup with the values of its expressions in e. It returns false
+// when no key may be found, as comparisons with NULL are never true.
+func (l *lookup) bounds(e *env) (lo, hi *keyBound, ok bool, err error) {
+	key := make([]interface{}, 0, len(l.eq)+1)
+	for i, x := range l.eq {
+		v, err := x.eval(e)
+		if err != nil || v == nil {
+			return nil, nil, false, err
+		}
+		key = append(key, applyAffinity(v, l.affs[i]))
+	}
+	if l.lo == nil && l.hi == nil {
+		lo = &keyBound{key: key}
+		return lo, lo, true, nil
+	}
+
+	for _, end := range []struct {
+		r *rangeEnd
+		b **keyBound
+	}{{l.lo, &lo}, {l.hi, &hi}} {
+		if end.r == nil {
+			continue
+		}
+		v, err := end.r.x.eval(e)
+		if err != nil || v == nil {
+			return nil, nil, false, err
+		}
+		v = applyAffinity(v, end.r.aff)
+		*end.b = &keyBound{key: append(key[:len(key):len(key)], v), open: end.r.open}
+	}
+	if lo == nil {
+		// NULL values sort before all the others.
+		lo = &keyBound{key: append(key[:len(key):len(key)], nil), open: true}
+	}
+	if hi == nil {
+		hi = &keyBound{key: key}
+	}
+	if l.desc {
+		lo, hi = hi, lo
+	}
+	return lo, hi, true, nil
+}
+
+// seek calls f for each row of the source found by its lookup, after
+// storing its values in the row of e.
+func (src *source) seek(e *env, f func() error) error {
+	l := src.lookup
+	lo, hi, ok, err := l.bounds(e)
+	if err != nil || !ok {
+		return err
+	}
+
+	t := src.table
+	if l.index == nil {
+		rowid, ok := lo.key[0].(int64)
+		if x, isFloat := lo.key[0].(float64); isFloat {
+			rowid = floatToInt(x)
+			ok = float64(rowid) == x
+		}
+		if !ok {
+			return nil
+		}
+		page, err := t.db.pager.Page(t.pageid)
+		if err != nil {
+			return err
+		}
+		btree, err := newBtreeTable(page, t.db)
+		if err != nil {
+			return err
+		}
+		btree.pages = &src.stats.PagesRead
+		rec, found, err := btree.rowRecord(rowid)
+		if err != nil || !found {
+			return err
+		}
+		src.load(e, &rowid, rec)
+		return f()
+	}
+	return l.index.visit(lo, hi, &src.stats.PagesRead, func(rowid *int64, rec Record) error {
+		src.load(e, rowid, rec)
+		return f()
+	})
+}
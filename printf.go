@@ -0,0 +1,521 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLength is the maximum length of the TEXT and BLOB values built by
+// functions, the default limit of SQLite.
+const maxLength = 1000000000
+
+var errTooBig = errors.New("sqlite3: string or blob too big")
+
+// printfFunc is the printf, or format, function.
+func printfFunc(_ callCtx, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return sprintf(textValue(args[0]), args[1:])
+}
+
+// sprintf formats the values args according to format, like the printf
+// function of SQLite: format holds % conversions with the flags, width
+// and precision of C, and the SQL conversions %q, %Q and %w. Missing
+// arguments are NULL. sprintf stops at the first invalid conversion, and
+// returns NULL if it produced nothing.
+func sprintf(format string, args []interface{}) (interface{}, error) {
+	var (
+		b    strings.Builder
+		used bool // whether something was produced, even empty
+	)
+	next := func() interface{} {
+		if len(args) == 0 {
+			return nil
+		}
+		v := args[0]
+		args = args[1:]
+		return v
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			j := strings.IndexByte(format[i:], '%')
+			if j < 0 {
+				j = len(format) - i
+			}
+			b.WriteString(format[i : i+j])
+			used = true
+			i += j - 1
+			continue
+		}
+		i++
+		if i == len(format) {
+			b.WriteByte('%')
+			used = true
+			break
+		}
+
+		var (
+			leftJustify, alternate, altForm2, zeroPad bool
+			prefix, thousands                         byte
+			width, precision                          = 0, -1
+		)
+	flags:
+		for ; i < len(format); i++ {
+			switch c := format[i]; {
+			case c == '-':
+				leftJustify = true
+			case c == '+' || c == ' ':
+				prefix = c
+			case c == '#':
+				alternate = true
+			case c == '!':
+				altForm2 = true
+			case c == '0':
+				zeroPad = true
+			case c == ',':
+				thousands = ','
+			default:
+				break flags
+			}
+		}
+		switch {
+		case i < len(format) && format[i] == '*':
+			w := toInteger(next())
+			if w < 0 {
+				leftJustify = true
+				w = -w
+			}
+			width = int(min64(w, maxLength+1))
+			i++
+		default:
+			for ; i < len(format) && isDigit(format[i]); i++ {
+				width = min(width*10+int(format[i]-'0'), maxLength+1)
+			}
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			precision = 0
+			switch {
+			case i < len(format) && format[i] == '*':
+				p := toInteger(next())
+				if p < 0 {
+					p = -p
+				}
+				precision = int(min64(p, maxLength+1))
+				i++
+			default:
+				for ; i < len(format) && isDigit(format[i]); i++ {
+					precision = min(precision*10+int(format[i]-'0'), maxLength+1)
+				}
+			}
+		}
+		if width > maxLength || precision > maxLength {
+			return nil, errTooBig
+		}
+		// length modifiers are ignored.
+		for n := 0; n < 2 && i < len(format) && format[i] == 'l'; n++ {
+			i++
+		}
+		if i == len(format) {
+			break
+		}
+
+		var (
+			out   string
+			chars bool // whether the width counts characters rather than bytes
+		)
+		switch c := format[i]; c {
+		case 'd', 'i', 'u', 'x', 'X', 'o', 'p', 'r':
+			if c != 'd' && c != 'i' && c != 'u' {
+				thousands = 0
+			}
+			v := toInteger(next())
+			out = formatInt(c, v, prefix, thousands, alternate, zeroPad, width, precision)
+		case 'f', 'e', 'E', 'g', 'G':
+			if precision < 0 {
+				precision = 6
+			}
+			out = formatReal(c, toFloat(next()), prefix, thousands, alternate, altForm2, zeroPad, leftJustify, width, precision)
+		case 's', 'z':
+			v := next()
+			if v != nil {
+				out = cText(v)
+			}
+			out, chars = truncate(out, precision, altForm2), altForm2
+		case 'c':
+			var s string
+			if v := next(); v != nil {
+				s = cText(v)
+			}
+			if s != "" {
+				_, n := utf8.DecodeRuneInString(s)
+				s = s[:n]
+			}
+			if precision > 1 {
+				s = strings.Repeat(s, precision)
+			}
+			out, chars = s, true
+		case 'q', 'Q', 'w':
+			quote := "'"
+			if c == 'w' {
+				quote = `"`
+			}
+			v := next()
+			s := "(NULL)"
+			switch {
+			case v != nil:
+				s = cText(v)
+			case c == 'Q':
+				s = "NULL"
+			}
+			s = strings.ReplaceAll(truncate(s, precision, altForm2), quote, quote+quote)
+			if c == 'Q' && v != nil {
+				s = "'" + s + "'"
+			}
+			out, chars = s, altForm2
+		case '%':
+			out = "%"
+		case 'n':
+			width = 0
+		default:
+			// like SQLite, stop at an invalid conversion.
+			return result(b.String(), used)
+		}
+
+		used = true
+		n := len(out)
+		if chars {
+			n = utf8.RuneCountInString(out)
+		}
+		if pad := width - n; pad > 0 {
+			if leftJustify {
+				out += strings.Repeat(" ", pad)
+			} else {
+				out = strings.Repeat(" ", pad) + out
+			}
+		}
+		if b.Len()+len(out) > maxLength {
+			return nil, errTooBig
+		}
+		b.WriteString(out)
+	}
+	return result(b.String(), used)
+}
+
+// truncate returns the first precision bytes of s, or characters if
+// chars is set, or s if precision is negative.
+func truncate(s string, precision int, chars bool) string {
+	if precision < 0 || precision >= len(s) {
+		return s
+	}
+	if !chars {
+		return s[:precision]
+	}
+	for i := range s {
+		if precision == 0 {
+			return s[:i]
+		}
+		precision--
+	}
+	return s
+}
+
+// result returns s, or NULL if used is false.
+func result(s string, used bool) (interface{}, error) {
+	if !used {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// formatInt formats v for the integer conversion c of sprintf.
+func formatInt(c byte, v int64, prefix, thousands byte, alternate, zeroPad bool, width, precision int) string {
+	var (
+		u    = uint64(v)
+		base = uint64(10)
+	)
+	switch c {
+	case 'd', 'i', 'r':
+		if v < 0 {
+			u = -u
+			prefix = '-'
+		}
+	default:
+		prefix = 0
+	}
+	if u == 0 {
+		alternate = false
+	}
+	sign := 0
+	if prefix != 0 {
+		sign = 1
+	}
+	if zeroPad && precision < width-sign {
+		precision = width - sign
+	}
+
+	var (
+		digits = "0123456789abcdef"
+		pre    string // prefix of the alternate form
+	)
+	switch c {
+	case 'x':
+		base, pre = 16, "0x"
+	case 'p':
+		base, pre, digits = 16, "0x", "0123456789ABCDEF"
+	case 'X':
+		base, pre, digits = 16, "0X", "0123456789ABCDEF"
+	case 'o':
+		base, pre = 8, "0"
+	}
+
+	var buf []byte
+	if c == 'r' {
+		suffix := "th"
+		if n := u % 10; n < 4 && (u/10)%10 != 1 {
+			suffix = []string{"th", "st", "nd", "rd"}[n]
+		}
+		buf = append(buf, suffix[1], suffix[0])
+	}
+	for {
+		buf = append(buf, digits[u%base])
+		u /= base
+		if u == 0 {
+			break
+		}
+	}
+	for len(buf) < precision {
+		buf = append(buf, '0')
+	}
+	if thousands != 0 {
+		var grouped []byte
+		for i, d := range buf {
+			if i > 0 && i%3 == 0 {
+				grouped = append(grouped, thousands)
+			}
+			grouped = append(grouped, d)
+		}
+		buf = grouped
+	}
+	if prefix != 0 {
+		buf = append(buf, prefix)
+	}
+	if alternate && pre != "" {
+		for i := len(pre) - 1; i >= 0; i-- {
+			buf = append(buf, pre[i])
+		}
+	}
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return string(buf)
+}
+
+// formatReal formats r for the floating point conversion c of sprintf.
+func formatReal(c byte, r float64, prefix, thousands byte, alternate, altForm2, zeroPad, leftJustify bool, width, precision int) string {
+	switch {
+	case math.IsNaN(r):
+		if zeroPad {
+			return "null"
+		}
+		return "NaN"
+	case math.IsInf(r, 0) && !zeroPad:
+		s := "Inf"
+		switch {
+		case r < 0:
+			s = "-Inf"
+		case prefix != 0:
+			s = string(prefix) + s
+		}
+		return s
+	}
+
+	var iRound int
+	switch c {
+	case 'f':
+		iRound = -precision
+	case 'g', 'G':
+		if precision == 0 {
+			precision = 1
+		}
+		iRound = precision
+	default:
+		iRound = precision + 1
+	}
+	mxRound := 16
+	if altForm2 {
+		mxRound = 26
+	}
+	neg, z, iDP := fpDecode(r, iRound, mxRound)
+	if math.IsInf(r, 0) {
+		// with the 0 flag, infinities are formatted as 9e999.
+		z, iDP = []byte("9"), 1000
+	}
+	if neg {
+		prefix = '-'
+	}
+
+	exp := iDP - 1
+	rtz := altForm2 // whether to remove the trailing zeros
+	isExp := c == 'e' || c == 'E'
+	if c == 'g' || c == 'G' {
+		precision--
+		rtz = !alternate
+		if exp < -4 || exp > precision {
+			isExp = true
+		} else {
+			precision -= exp
+		}
+	}
+	e2 := iDP - 1
+	if isExp {
+		e2 = 0
+	}
+
+	var (
+		buf []byte
+		j   = 0 // next digit of z
+	)
+	digit := func() byte {
+		if j < len(z) {
+			j++
+			return z[j-1]
+		}
+		return '0'
+	}
+	if prefix != 0 {
+		buf = append(buf, prefix)
+	}
+	if e2 < 0 {
+		buf = append(buf, '0')
+	} else {
+		for ; e2 >= 0; e2-- {
+			buf = append(buf, digit())
+			if thousands != 0 && e2%3 == 0 && e2 > 1 {
+				buf = append(buf, thousands)
+			}
+		}
+	}
+	dp := precision > 0 || alternate || altForm2
+	if dp {
+		buf = append(buf, '.')
+	}
+	for e2++; e2 < 0 && precision > 0; precision, e2 = precision-1, e2+1 {
+		buf = append(buf, '0')
+	}
+	for ; precision > 0; precision-- {
+		buf = append(buf, digit())
+	}
+	if rtz && dp {
+		for buf[len(buf)-1] == '0' {
+			buf = buf[:len(buf)-1]
+		}
+		if buf[len(buf)-1] == '.' {
+			if altForm2 {
+				buf = append(buf, '0')
+			} else {
+				buf = buf[:len(buf)-1]
+			}
+		}
+	}
+	if isExp {
+		exp = iDP - 1
+		e := byte('e')
+		if c == 'E' || c == 'G' {
+			e = 'E'
+		}
+		buf = append(buf, e)
+		if exp < 0 {
+			buf = append(buf, '-')
+			exp = -exp
+		} else {
+			buf = append(buf, '+')
+		}
+		if exp >= 100 {
+			buf = append(buf, byte(exp/100)+'0')
+			exp %= 100
+		}
+		buf = append(buf, byte(exp/10)+'0', byte(exp%10)+'0')
+	}
+
+	if zeroPad && !leftJustify && len(buf) < width {
+		sign := 0
+		if prefix != 0 {
+			sign = 1
+		}
+		pad := strings.Repeat("0", width-len(buf))
+		buf = append(buf[:sign], append([]byte(pad), buf[sign:]...)...)
+	}
+	return string(buf)
+}
+
+// fpDecode returns the decimal digits of the finite float r, without
+// trailing zeros, and the position of the decimal point relative to the
+// first digit, like the sqlite3FpDecode function of SQLite. The digits
+// are rounded half away from zero to iRound significant digits, or, if
+// iRound is negative or zero, to -iRound digits after the decimal point;
+// but to no more than mxRound digits.
+func fpDecode(r float64, iRound, mxRound int) (neg bool, z []byte, iDP int) {
+	switch {
+	case r == 0:
+		return false, []byte("0"), 1
+	case math.IsInf(r, 0):
+		return r < 0, nil, 0
+	case r < 0:
+		neg, r = true, -r
+	}
+	// SQLite truncates the value to 19 significant digits.
+	s := strconv.FormatFloat(r, 'e', 30, 64)
+	e := strings.IndexByte(s, 'e')
+	exp, _ := strconv.Atoi(s[e+1:])
+	z = []byte(s[:1] + s[2:20])
+	iDP = exp + 1
+
+	if iRound <= 0 {
+		iRound = iDP - iRound
+		if iRound == 0 && z[0] >= '5' {
+			iRound = 1
+			z = append([]byte{'0'}, z...)
+			iDP++
+		}
+	}
+	if iRound > 0 && (iRound < len(z) || len(z) > mxRound) {
+		if iRound > mxRound {
+			iRound = mxRound
+		}
+		up := z[iRound] >= '5'
+		z = z[:iRound]
+		for j := iRound - 1; up; j-- {
+			z[j]++
+			if z[j] <= '9' {
+				break
+			}
+			z[j] = '0'
+			if j == 0 {
+				z = append([]byte{'1'}, z...)
+				iDP++
+				break
+			}
+		}
+	}
+	for len(z) > 1 && z[len(z)-1] == '0' {
+		z = z[:len(z)-1]
+	}
+	return neg, z, iDP
+}
@@ -0,0 +1,1056 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Query runs the SELECT statement query and returns its result rows.
+//
+// The statement may use the expressions, operators and comparison rules
+// of SQLite, on the values of the tables and views of db and of its
+// attached databases. Values are compared with the type ordering and
+// affinity rules of SQLite.
+//
+// Tables are joined with nested loops, in the order of the FROM clause:
+// inner joins (comma, JOIN, INNER JOIN, CROSS JOIN) and LEFT JOIN, with
+// ON, USING or NATURAL join constraints.
+//
+// The parameters of the statement (?, ?NNN, :name, @name or $name) are
+// bound to args in order, or by name with sql.Named. Arguments may be
+// nil, integers, floats, booleans, strings, byte slices or values
+// implementing driver.Valuer.
+//
+// The aggregate functions count, sum, total, avg, min, max and
+// group_concat compute their values over the groups of the GROUP BY
+// clause, or over all the rows, while the table is scanned. The scalar
+// functions of SQLite, including printf and the date and time functions,
+// are available, as well as those added with RegisterFunc.
+//
+// Window functions (row_number, rank, dense_rank, percent_rank,
+// cume_dist, ntile, lag, lead, first_value, last_value, nth_value and the
+// aggregate functions) are computed with an OVER clause or a named
+// window of the WINDOW clause, with PARTITION BY, ORDER BY and ROWS,
+// RANGE or GROUPS frames, once all the rows of the query are known.
+//
+// A query may be a compound SELECT (UNION, UNION ALL, INTERSECT, EXCEPT)
+// or a VALUES clause, and be preceded by a WITH clause defining common
+// table expressions. Like in SQLite, a common table expression whose
+// compound SELECT references itself is recursive: its rows are computed
+// from a queue, one row at a time.
+//
+// Equality and range comparisons of columns with constant values, or
+// with the columns of the tables joined before theirs, are answered with
+// a lookup by rowid or with an index of the table, when possible,
+// instead of a scan of the whole table. In databases analyzed by SQLite,
+// the statistics of the sqlite_stat1 and sqlite_stat4 tables are used to
+// estimate the rows found by each index, and to prefer the scan of the
+// table when it is cheaper. Explain describes these choices.
+//
+// Rows are produced while the tables are scanned: the returned Rows must
+// be closed unless they were all read.
+func (db *DbFile) Query(query string, args ...interface{}) (*Rows, error) {
+	stmt, params, err := parseSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := bindParams(params, args); err != nil {
+		return nil, err
+	}
+	plan, err := db.compileQuery(stmt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(plan), nil
+}
+
+// bindParams binds the parameters params to the arguments args.
+func bindParams(params []*param, args []interface{}) error {
+	var (
+		byIndex = make(map[int]interface{})
+		byName  = make(map[string]interface{})
+		n       = 0
+	)
+	for _, arg := range args {
+		if arg, ok := arg.(sql.NamedArg); ok {
+			byName[arg.Name] = arg.Value
+			continue
+		}
+		n++
+		byIndex[n] = arg
+	}
+
+	max := 0
+	for _, prm := range params {
+		if prm.index > max {
+			max = prm.index
+		}
+	}
+	if n > max {
+		return fmt.Errorf("sqlite3: %d arguments for %d parameters", n, max)
+	}
+
+	for _, prm := range params {
+		v, ok := byIndex[prm.index]
+		if prm.name[0] != '?' {
+			if w, found := byName[prm.name[1:]]; found {
+				v, ok = w, true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("sqlite3: missing argument for parameter %s", prm.name)
+		}
+		v, err := queryValue(v)
+		if err != nil {
+			return fmt.Errorf("sqlite3: invalid argument for parameter %s: %w", prm.name, err)
+		}
+		prm.v = v
+	}
+	return nil
+}
+
+// source is a table of the FROM clause of a query.
+type source struct {
+	name      string // name of the table, or its alias
+	table     *Table
+	derived   *derivedTable // table computed by a query, if any
+	recursive bool          // whether the source is the current row of the recursive table derived
+	off       int           // slot of the first column; the rowid follows the last one
+	affs      []affinity    // affinity of each column
+	used      []bool        // whether each column is used by the query
+	hidden    []bool        // whether each column is hidden from unqualified references, as the columns of USING clauses
+
+	left    bool    // whether the source is the right table of a LEFT JOIN
+	on      expr    // ON condition of the LEFT JOIN
+	filters []expr  // conditions checked once the row of the source is known
+	lookup  *lookup // lookup finding the rows of the query, if any
+
+	stats *QueryStats // counters of the query
+}
+
+func newSource(t *Table, name string, off int) *source {
+	src := &source{
+		name:   name,
+		table:  t,
+		off:    off,
+		affs:   make([]affinity, len(t.cols)),
+		used:   make([]bool, len(t.cols)),
+		hidden: make([]bool, len(t.cols)),
+		stats:  &QueryStats{},
+	}
+	for i := range t.cols {
+		src.affs[i] = declAffinity(t.cols[i].decl)
+	}
+	return src
+}
+
+// nslots returns the number of slots holding the values of the source.
+func (src *source) nslots() int {
+	return len(src.table.cols) + 1
+}
+
+// scan calls f for each row of the source, after storing its values in
+// the row of e.
+func (src *source) scan(e *env, f func() error) error {
+	if src.derived != nil {
+		return src.derived.scan(src.recursive, func(vals []interface{}) error {
+			src.stats.RowsExamined++
+			copy(e.row[src.off:], vals)
+			return f()
+		})
+	}
+	if src.lookup != nil {
+		return src.seek(e, f)
+	}
+	var (
+		t     = src.table
+		names []string
+	)
+	for i, used := range src.used {
+		if used {
+			names = append(names, t.cols[i].name)
+		}
+	}
+	return t.db.VisitTableRecords(t.name, func(rowid *int64, rec Record) error {
+		src.load(e, rowid, rec)
+		return f()
+	}, Columns(names...), countPages(&src.stats.PagesRead))
+}
+
+// load stores the values of the row of the source with the given rowid
+// and record in the row of e.
+func (src *source) load(e *env, rowid *int64, rec Record) {
+	src.stats.RowsExamined++
+	var (
+		t     = src.table
+		ncols = len(t.cols)
+		row   = e.row[src.off : src.off+ncols+1]
+	)
+	for i := range t.cols {
+		if !src.used[i] {
+			continue
+		}
+		switch {
+		case i == t.rowid && rowid != nil:
+			row[i] = *rowid
+		case i < len(rec.Values):
+			row[i] = columnValue(rec.Values[i], src.affs[i])
+		default:
+			// the row was written before the column was added.
+			row[i] = columnValue(t.cols[i].dflt, src.affs[i])
+		}
+	}
+	if rowid != nil {
+		row[ncols] = *rowid
+	}
+}
+
+// clear stores NULL values for the row of the source in the row of e,
+// for the LEFT JOIN rows without a matching row of the source.
+func (src *source) clear(e *env) {
+	row := e.row[src.off : src.off+src.nslots()]
+	for i := range row {
+		row[i] = nil
+	}
+}
+
+// isRowidName reports whether name is one of the names of the rowid.
+func isRowidName(name string) bool {
+	for _, s := range []string{"rowid", "oid", "_rowid_"} {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// scope resolves the names used by the expressions of a query.
+type scope struct {
+	sources []*source
+	aggs    []*funcExpr // calls of aggregate functions
+	wins    []*funcExpr // calls of window functions
+	results []expr      // expressions of the result columns
+	aliases []string    // aliases of the result columns, or ""
+
+	// aggErr returns the error for a call of the aggregate function
+	// name, if aggregate functions may not be used by the expressions
+	// being bound.
+	aggErr func(name string) error
+
+	// windows reports whether window functions may be used by the
+	// expressions being bound.
+	windows bool
+
+	windowDefs   map[string]*windowDef // windows of the WINDOW clause, by lower case name
+	namedWindows map[string]*window    // compiled windows of the WINDOW clause
+}
+
+func aggMisuse(name string) error {
+	return fmt.Errorf("sqlite3: misuse of aggregate: %s()", name)
+}
+
+func aggNested(name string) error {
+	return fmt.Errorf("sqlite3: misuse of aggregate function %s()", name)
+}
+
+func aggGroupBy(string) error {
+	return errors.New("sqlite3: aggregate functions are not allowed in the GROUP BY clause")
+}
+
+// bind resolves the column references of the expression x, checks the
+// functions it uses and sets the collations of its comparisons.
+func (s *scope) bind(x expr) error {
+	var err error
+	walkExpr(x, func(x expr) bool {
+		switch x := x.(type) {
+		case *colRef:
+			err = s.resolve(x)
+		case *funcExpr:
+			err = s.bindFunc(x)
+			return false
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bindCollations(x)
+}
+
+// bindFunc checks the function call x, and binds its arguments. Calls
+// of aggregate functions are added to the ones of the scope. Like in
+// SQLite, min and max are aggregate functions with a single argument,
+// and scalar ones with more.
+func (s *scope) bindFunc(x *funcExpr) error {
+	if x.over != nil {
+		return s.bindWindow(x)
+	}
+	fn := aggFuncs[strings.ToLower(x.name)]
+	if scalar := lookupFunc(x.name); scalar != nil && (fn == nil || !x.star && len(x.args) > fn.maxArgs) {
+		if err := scalar.check(x); err != nil {
+			return err
+		}
+		for _, arg := range x.args {
+			if err := s.bind(arg); err != nil {
+				return err
+			}
+		}
+		x.fn = scalar
+		return nil
+	}
+	switch {
+	case fn == nil && winFuncs[strings.ToLower(x.name)] != nil:
+		return fmt.Errorf("sqlite3: misuse of window function %s()", x.name)
+	case fn == nil:
+		return fmt.Errorf("sqlite3: no such function: %s", x.name)
+	case s.aggErr != nil:
+		return s.aggErr(x.name)
+	}
+	if err := fn.check(x); err != nil {
+		return err
+	}
+	s.aggErr = aggNested
+	defer func() { s.aggErr = nil }()
+	for _, arg := range x.args {
+		if err := s.bind(arg); err != nil {
+			return err
+		}
+	}
+	x.agg, x.slot = fn, len(s.aggs)
+	s.aggs = append(s.aggs, x)
+	return nil
+}
+
+// resolve resolves the column reference ref, whose name is set to the
+// declared name of the column.
+func (s *scope) resolve(ref *colRef) error {
+	var (
+		found *source
+		icol  int
+	)
+	for _, src := range s.sources {
+		if ref.table != "" && !strings.EqualFold(ref.table, src.name) {
+			continue
+		}
+		i := src.table.colIndex(ref.name)
+		if i >= 0 && ref.table == "" && src.hidden[i] {
+			continue
+		}
+		if i < 0 && isRowidName(ref.name) && !src.table.withoutRowid {
+			i = len(src.table.cols)
+			if src.table.rowid >= 0 {
+				i = src.table.rowid
+			}
+		}
+		if i < 0 {
+			continue
+		}
+		if found != nil {
+			return fmt.Errorf("sqlite3: ambiguous column name: %s", ref.name)
+		}
+		found, icol = src, i
+	}
+
+	if found == nil && ref.table == "" {
+		// like SQLite, the aliases of the result columns may be used
+		// where no column has their name.
+		for i, alias := range s.aliases {
+			if alias == "" || !strings.EqualFold(alias, ref.name) {
+				continue
+			}
+			x := s.results[i]
+			if fn := firstAggregate(x); fn != nil && s.aggErr != nil {
+				return s.aggErr(fn.name)
+			}
+			if fn := firstWindow(x); fn != nil && !s.windows {
+				return fmt.Errorf("sqlite3: misuse of window function %s()", fn.name)
+			}
+			ref.slot, ref.alias, ref.aff = -1, x, exprAffinity(x)
+			ref.coll, _ = exprCollation(x)
+			return nil
+		}
+	}
+
+	switch {
+	case found == nil && ref.table == "" && ref.quoted:
+		ref.slot = -1
+		return nil
+	case found == nil && ref.table != "":
+		return fmt.Errorf("sqlite3: no such column: %s.%s", ref.table, ref.name)
+	case found == nil:
+		return fmt.Errorf("sqlite3: no such column: %s", ref.name)
+	}
+
+	ref.slot = found.off + icol
+	if icol == len(found.table.cols) {
+		ref.aff = affInteger
+		return nil
+	}
+	found.used[icol] = true
+	ref.aff = found.affs[icol]
+	ref.name = found.table.cols[icol].name
+	ref.coll = found.table.cols[icol].collate
+	return nil
+}
+
+// join binds the join constraint of the table ref, the last source src
+// of the scope, and returns its condition, if any: the ON condition, or
+// the equality of the columns of the USING clause, or of the columns of
+// the same name for a NATURAL JOIN.
+func (s *scope) join(ref *tableRef, src *source) (expr, error) {
+	var (
+		prev  = s.sources[:len(s.sources)-1]
+		using = ref.using
+	)
+	if ref.natural {
+		for _, c := range src.table.cols {
+			for _, p := range prev {
+				if i := p.table.colIndex(c.name); i >= 0 && !p.hidden[i] {
+					using = append(using, c.name)
+					break
+				}
+			}
+		}
+	}
+
+	on := ref.on
+	for _, name := range using {
+		var left *source
+		for _, p := range prev {
+			if i := p.table.colIndex(name); i >= 0 && !p.hidden[i] {
+				left = p
+				break
+			}
+		}
+		i := src.table.colIndex(name)
+		if left == nil || i < 0 {
+			return nil, fmt.Errorf("sqlite3: cannot join using column %s - column not present in both tables", name)
+		}
+		src.hidden[i] = true
+		var eq expr = &binaryExpr{
+			op: "=",
+			x:  &colRef{table: left.name, name: name},
+			y:  &colRef{table: src.name, name: name},
+		}
+		if on != nil {
+			eq = &binaryExpr{op: "AND", x: on, y: eq}
+		}
+		on = eq
+	}
+	if on == nil {
+		return nil, nil
+	}
+
+	// the condition may only use the tables joined so far, and no
+	// aggregate function.
+	sc := scope{sources: s.sources, aggErr: aggMisuse}
+	if err := sc.bind(on); err != nil {
+		return nil, err
+	}
+	return on, nil
+}
+
+// selectPlan is a compiled SELECT statement.
+type selectPlan struct {
+	names    []string    // names of the result columns
+	exprs    []expr      // expressions of the result columns
+	wins     []*funcExpr // calls of window functions
+	distinct bool
+	colls    []collation // collations of the result columns, for DISTINCT
+	sources  []*source   // tables of the FROM clause, in join order
+	nslots   int         // number of slots of the rows
+
+	where      expr        // WHERE clause of a query without FROM clause
+	aggregate  bool        // whether the rows are grouped
+	groupBy    []expr      // terms of the GROUP BY clause
+	groupColls []collation // collations of the GROUP BY terms
+	aggs       []*funcExpr // calls of aggregate functions
+	having     expr
+
+	order  []sortKey
+	limit  int64 // maximum number of rows, or -1
+	offset int64 // number of rows skipped
+}
+
+// sortKey is a key of the ORDER BY clause.
+type sortKey struct {
+	x          expr
+	desc       bool
+	nullsFirst bool
+	coll       collation
+}
+
+// compileSelect compiles the simple SELECT statement stmt, where the
+// common table expressions of with are visible.
+func (db *DbFile) compileSelect(stmt *selectStmt, with *withScope) (*selectPlan, error) {
+	plan := &selectPlan{limit: -1}
+	var (
+		s     = scope{windowDefs: stmt.windows}
+		conds []expr // conditions of the WHERE clause and of the inner joins
+	)
+	for _, ref := range stmt.from {
+		name := ref.alias
+		if name == "" {
+			name = ref.name
+		}
+		var (
+			src *source
+			err error
+		)
+		if d := with.lookup(ref.name); d != nil && ref.schema == "" {
+			src, err = db.derivedSource(d, name, plan.nslots)
+		} else if v := db.lookupView(ref.schema, ref.name); v != nil {
+			if ref.alias == "" {
+				name = v.name
+			}
+			var d *derivedTable
+			if d, err = v.compile(with); err == nil {
+				src, err = db.derivedSource(d, name, plan.nslots)
+			}
+		} else {
+			var t *Table
+			t, err = db.lookupTable(ref.schema, ref.name)
+			if err == nil {
+				if ref.alias == "" {
+					name = t.name
+				}
+				src = newSource(t, name, plan.nslots)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		src.left = ref.left
+		plan.sources = append(plan.sources, src)
+		plan.nslots += src.nslots()
+		s.sources = append(s.sources, src)
+
+		on, err := s.join(ref, src)
+		switch {
+		case err != nil:
+			return nil, err
+		case src.left:
+			src.on = on
+		case on != nil:
+			conds = append(conds, conjuncts(on)...)
+		}
+	}
+
+	var aliases []string
+	s.windows = true
+	for _, col := range stmt.cols {
+		if !col.star {
+			if err := s.bind(col.expr); err != nil {
+				return nil, err
+			}
+			name := col.alias
+			if ref, ok := col.expr.(*colRef); ok && name == "" && ref.slot >= 0 {
+				name = ref.name
+			}
+			if name == "" {
+				name = col.text
+			}
+			plan.names = append(plan.names, name)
+			plan.exprs = append(plan.exprs, col.expr)
+			aliases = append(aliases, col.alias)
+			continue
+		}
+
+		n := 0
+		for _, src := range s.sources {
+			if col.table != "" && !strings.EqualFold(col.table, src.name) {
+				continue
+			}
+			for i, c := range src.table.cols {
+				if col.table == "" && src.hidden[i] {
+					continue
+				}
+				src.used[i] = true
+				plan.names = append(plan.names, c.name)
+				plan.exprs = append(plan.exprs, &colRef{name: c.name, slot: src.off + i, aff: src.affs[i], coll: c.collate})
+				aliases = append(aliases, "")
+			}
+			n++
+		}
+		switch {
+		case n == 0 && col.table != "":
+			return nil, fmt.Errorf("sqlite3: no such table: %s", col.table)
+		case n == 0:
+			return nil, fmt.Errorf("sqlite3: no tables specified")
+		}
+	}
+
+	s.windows = false
+	s.results, s.aliases = plan.exprs, aliases
+
+	var err error
+	if stmt.distinct {
+		plan.distinct = true
+		plan.colls, err = exprCollations(plan.exprs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stmt.where != nil {
+		s.aggErr = aggMisuse
+		if err := s.bind(stmt.where); err != nil {
+			return nil, err
+		}
+		s.aggErr = nil
+		conds = append(conds, conjuncts(stmt.where)...)
+	}
+	if len(plan.sources) == 0 {
+		plan.where = stmt.where
+	} else {
+		plan.place(conds)
+	}
+
+	for i, term := range stmt.groupBy {
+		s.aggErr = aggGroupBy
+		x, err := s.resultTerm("GROUP BY", term, i, plan.exprs, aliases)
+		if err != nil {
+			return nil, err
+		}
+		s.aggErr = nil
+		if firstAggregate(x) != nil {
+			return nil, aggGroupBy("")
+		}
+		coll, err := termCollation(term, x)
+		if err != nil {
+			return nil, err
+		}
+		plan.groupBy = append(plan.groupBy, x)
+		plan.groupColls = append(plan.groupColls, coll)
+	}
+	plan.aggregate = len(s.aggs) > 0 || len(plan.groupBy) > 0
+	if stmt.having != nil {
+		if !plan.aggregate {
+			return nil, errors.New("sqlite3: HAVING clause on a non-aggregate query")
+		}
+		if err := s.bind(stmt.having); err != nil {
+			return nil, err
+		}
+		plan.having = stmt.having
+	}
+
+	s.windows = true
+	for i, term := range stmt.orderBy {
+		if !plan.aggregate {
+			s.aggErr = aggMisuse
+		}
+		x, err := s.resultTerm("ORDER BY", term.expr, i, plan.exprs, aliases)
+		if err != nil {
+			return nil, err
+		}
+		s.aggErr = nil
+		key, err := newSortKey(term, x)
+		if err != nil {
+			return nil, err
+		}
+		plan.order = append(plan.order, key)
+	}
+	s.windows = false
+	plan.aggs, plan.wins = s.aggs, s.wins
+
+	plan.limit, plan.offset, err = limitOffset(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (plan *selectPlan) columns() []string {
+	return plan.names
+}
+
+func (plan *selectPlan) results() []expr {
+	return plan.exprs
+}
+
+// newSortKey returns the key of the ORDER BY term, resolved to the
+// expression x.
+func newSortKey(term orderingTerm, x expr) (sortKey, error) {
+	key := sortKey{x: x, desc: term.desc, nullsFirst: !term.desc}
+	coll, err := termCollation(term.expr, x)
+	if err != nil {
+		return key, err
+	}
+	key.coll = coll
+	switch term.nulls {
+	case "FIRST":
+		key.nullsFirst = true
+	case "LAST":
+		key.nullsFirst = false
+	}
+	return key, nil
+}
+
+// limitOffset returns the values of the LIMIT and OFFSET clauses of
+// stmt: -1 and 0 if they are missing or negative.
+func limitOffset(stmt *selectStmt) (limit, offset int64, err error) {
+	limit = -1
+	if stmt.limit != nil {
+		limit, err = limitValue(stmt.limit)
+		if err != nil {
+			return 0, 0, err
+		}
+		if limit < 0 {
+			limit = -1
+		}
+	}
+	if stmt.offset != nil {
+		offset, err = limitValue(stmt.offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	return limit, offset, nil
+}
+
+// resultTerm resolves the i-th term x of an ORDER BY or GROUP BY
+// clause, which may be the number or the alias of a result column. The
+// aliases of GROUP BY terms are only used if no column has their name.
+func (s *scope) resultTerm(clause string, x expr, i int, exprs []expr, aliases []string) (expr, error) {
+	switch x := x.(type) {
+	case *literal:
+		k, ok := x.v.(int64)
+		if !ok {
+			break
+		}
+		if k < 1 || k > int64(len(exprs)) {
+			return nil, fmt.Errorf("sqlite3: %s %s term out of range - should be between 1 and %d", ordinal(i+1), clause, len(exprs))
+		}
+		return exprs[k-1], nil
+	case *colRef:
+		if x.table != "" || clause == "GROUP BY" && s.hasColumn(x.name) {
+			break
+		}
+		for j, alias := range aliases {
+			if alias != "" && strings.EqualFold(alias, x.name) {
+				return exprs[j], nil
+			}
+		}
+	}
+	if err := s.bind(x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// hasColumn reports whether one of the sources has a column with the
+// given name.
+func (s *scope) hasColumn(name string) bool {
+	for _, src := range s.sources {
+		if src.table.colIndex(name) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// termCollation returns the collation of the ORDER BY or GROUP BY term
+// x, resolved to the expression y: the one of x, or else the one of y.
+func termCollation(x, y expr) (collation, error) {
+	name, _ := exprCollation(x)
+	if name == "" {
+		name, _ = exprCollation(y)
+	}
+	return lookupCollation(name)
+}
+
+// exprCollations returns the collations of the expressions xs.
+func exprCollations(xs []expr) ([]collation, error) {
+	colls := make([]collation, len(xs))
+	for i, x := range xs {
+		name, _ := exprCollation(x)
+		coll, err := lookupCollation(name)
+		if err != nil {
+			return nil, err
+		}
+		colls[i] = coll
+	}
+	return colls, nil
+}
+
+// ordinal returns the English ordinal of n: 1st, 2nd, 3rd, 4th...
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// limitValue returns the value of the LIMIT or OFFSET expression x,
+// which must be an integer.
+func limitValue(x expr) (int64, error) {
+	s := scope{aggErr: aggNested}
+	if err := s.bind(x); err != nil {
+		return 0, err
+	}
+	v, err := x.eval(&env{})
+	if err != nil {
+		return 0, err
+	}
+	switch v := applyAffinity(v, affInteger).(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if i := floatToInt(v); float64(i) == v {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("sqlite3: datatype mismatch")
+}
+
+var (
+	// errLimitReached stops the scan of a query when its LIMIT is
+	// reached.
+	errLimitReached = errors.New("sqlite3: limit reached")
+
+	// errRowsClosed stops the scan of a query when its Rows are closed.
+	errRowsClosed = errors.New("sqlite3: rows closed")
+)
+
+// run runs the query, calling emit with the values of each result row.
+func (plan *selectPlan) run(emit func([]interface{}) error) error {
+	if plan.limit == 0 {
+		return nil
+	}
+
+	var (
+		e      = &env{row: make([]interface{}, plan.nslots)}
+		out    = &output{exprs: plan.exprs, order: plan.order, limit: plan.limit, emit: emit, skip: plan.offset}
+		groups = &grouping{plan: plan}
+		wins   = &windowing{plan: plan}
+		add    = out.add
+	)
+	if plan.distinct {
+		out.seen = &rowSet{colls: plan.colls}
+	}
+	if len(plan.wins) > 0 {
+		add = wins.add
+	}
+	err := plan.scan(e, func() error {
+		if plan.aggregate {
+			return groups.add(e)
+		}
+		return add(e)
+	})
+	if err == nil && plan.aggregate {
+		err = groups.each(e, func() error {
+			if ok, err := holds(e, plan.having); err != nil || !ok {
+				return err
+			}
+			return add(e)
+		})
+	}
+	if err == nil && len(plan.wins) > 0 {
+		err = wins.each(e, func() error {
+			return out.add(e)
+		})
+	}
+	switch {
+	case err == errLimitReached:
+		return nil
+	case err != nil:
+		return err
+	}
+	return out.flush()
+}
+
+// output produces the result rows of a query, applying its DISTINCT,
+// ORDER BY, LIMIT and OFFSET clauses.
+type output struct {
+	exprs []expr // expressions of the result columns
+	order []sortKey
+	limit int64 // maximum number of rows, or -1
+	emit  func([]interface{}) error
+	seen  *rowSet   // rows already produced, for DISTINCT
+	rows  []sortRow // rows to sort, for ORDER BY
+	skip  int64     // number of rows still to skip
+	n     int64     // number of rows produced
+}
+
+type sortRow struct {
+	keys []interface{}
+	vals []interface{}
+}
+
+// add adds the result row of the query for the row of e.
+func (o *output) add(e *env) error {
+	vals, err := evalAll(e, o.exprs)
+	if err != nil {
+		return err
+	}
+	if o.seen != nil && !o.seen.add(vals) {
+		return nil
+	}
+	if len(o.order) > 0 {
+		keys, err := evalKeys(e, o.order)
+		if err != nil {
+			return err
+		}
+		o.rows = append(o.rows, sortRow{keys: keys, vals: vals})
+		return nil
+	}
+	return o.produce(vals)
+}
+
+// produce emits the result row vals, unless it is skipped. It returns
+// errLimitReached after the last row.
+func (o *output) produce(vals []interface{}) error {
+	if o.skip > 0 {
+		o.skip--
+		return nil
+	}
+	if err := o.emit(vals); err != nil {
+		return err
+	}
+	o.n++
+	if o.n == o.limit {
+		return errLimitReached
+	}
+	return nil
+}
+
+// flush emits the rows to sort, in order.
+func (o *output) flush() error {
+	sort.SliceStable(o.rows, func(i, j int) bool {
+		return sortLess(o.order, o.rows[i].keys, o.rows[j].keys)
+	})
+	for _, row := range o.rows {
+		switch err := o.produce(row.vals); err {
+		case nil:
+		case errLimitReached:
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls f for each row of the join of the tables of the FROM
+// clause matching the WHERE clause, after storing its values in the row
+// of e.
+func (plan *selectPlan) scan(e *env, f func() error) error {
+	if len(plan.sources) == 0 {
+		if ok, err := holds(e, plan.where); err != nil || !ok {
+			return err
+		}
+		return f()
+	}
+	return plan.join(e, 0, f)
+}
+
+// join calls f for each row of the join of the sources, from the i-th
+// one, with the rows of the previous sources stored in e.
+func (plan *selectPlan) join(e *env, i int, f func() error) error {
+	src := plan.sources[i]
+	next := f
+	if i+1 < len(plan.sources) {
+		next = func() error {
+			return plan.join(e, i+1, f)
+		}
+	}
+	matched := false
+	err := src.scan(e, func() error {
+		if ok, err := holds(e, src.on); err != nil || !ok {
+			return err
+		}
+		matched = true
+		if ok, err := holds(e, src.filters...); err != nil || !ok {
+			return err
+		}
+		return next()
+	})
+	if err != nil || matched || !src.left {
+		return err
+	}
+	src.clear(e)
+	if ok, err := holds(e, src.filters...); err != nil || !ok {
+		return err
+	}
+	return next()
+}
+
+// holds reports whether the conditions conds are all true for the row of
+// e. Nil conditions are ignored.
+func holds(e *env, conds ...expr) (bool, error) {
+	for _, x := range conds {
+		if x == nil {
+			continue
+		}
+		v, err := x.eval(e)
+		if err != nil {
+			return false, err
+		}
+		if ok, _ := truth(v); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sortLess reports whether the row with the values a of the sort keys
+// order sorts before the one with the values b.
+func sortLess(order []sortKey, a, b []interface{}) bool {
+	for i, key := range order {
+		x, y := a[i], b[i]
+		if (x == nil) != (y == nil) {
+			return (x == nil) == key.nullsFirst
+		}
+		c := compareCollated(x, y, key.coll)
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// evalAll evaluates the expressions xs.
+func evalAll(e *env, xs []expr) ([]interface{}, error) {
+	vals := make([]interface{}, len(xs))
+	for i, x := range xs {
+		v, err := x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// evalKeys evaluates the expressions of the sort keys order.
+func evalKeys(e *env, order []sortKey) ([]interface{}, error) {
+	keys := make([]interface{}, len(order))
+	for i, key := range order {
+		v, err := key.x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = v
+	}
+	return keys, nil
+}
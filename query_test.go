@@ -0,0 +1,606 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queryString runs query on db and returns its rows, one per line, with
+// values separated by |, NULL values written as NULL and BLOB values as
+// x'' literals.
+func queryString(t *testing.T, db *DbFile, query string, args ...interface{}) string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+
+	var o strings.Builder
+	for rows.Next() {
+		for i, v := range rows.Values() {
+			if i > 0 {
+				o.WriteString("|")
+			}
+			switch v := v.(type) {
+			case nil:
+				o.WriteString("NULL")
+			case []byte:
+				fmt.Fprintf(&o, "x'%x'", v)
+			default:
+				o.WriteString(textValue(v))
+			}
+		}
+		o.WriteString("\n")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return o.String()
+}
+
+func TestQuery(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select * from people where id < 4`,
+			want: `1|Bob0|7|161.5|Lyon|42|1
+2|carol0|14|172.0|Berlin|4.5|1
+3|Dave0|21|183.5|paris|text|1
+`,
+		},
+		{
+			query: `select id, name, age + 1, height * 2 as h2 from people where age > 80 order by age desc, id limit 5`,
+			want: `77|Heidi7|90|395.0
+167|Heidi16|90|375.0
+257|Heidi25|90|355.0
+64|eve6|89|308.0
+154|eve15|89|388.0
+`,
+		},
+		{
+			query: `select name from people where city = 'paris' order by name limit 3 offset 2`,
+			want: `Bob17
+Bob2
+Bob20
+`,
+		},
+		{
+			query: `select a, b, c, d from mixed where a = '1'`,
+			want: `1|1|1|1.0
+`,
+		},
+		{
+			query: `select a, c from mixed where c = '1'`,
+			want: `1|1
+1|1
+`,
+		},
+		{
+			query: `select a, d from mixed where d > '1'`,
+			want: `1.5|1.5
+x'31'|x'31'
+abc|abc
+9223372036854775807|9.22337203685478e+18
+0.0|300.0
+`,
+		},
+		{
+			query: `select a from mixed where a > 'a'`,
+			want: `x'31'
+abc
+`,
+		},
+		{
+			query: `select a from mixed order by a`,
+			want: `NULL
+0.0
+1
+1.5
+9223372036854775807
+1
+abc
+x'31'
+`,
+		},
+		{
+			query: `select c, d from mixed order by c nulls last, d`,
+			want: `1|1.0
+1|1.0
+1.5|1.5
+12|300.0
+9.22337203685478e+18|9.22337203685478e+18
+abc|abc
+x'31'|x'31'
+NULL|NULL
+`,
+		},
+		{
+			query: `select id, age from people where age between 10 and 12 order by 2, 1`,
+			want: `40|10
+130|10
+220|10
+53|11
+143|11
+233|11
+66|12
+156|12
+246|12
+`,
+		},
+		{
+			query: `select id, city from people where city not in ('Lyon', 'Tokyo', null) and id < 15`,
+			want: ``,
+		},
+		{
+			query: `select id, note from people where note like 'ABC%'`,
+			want: `7|abc%
+17|abc%
+27|abc%
+37|abc%
+47|abc%
+57|abc%
+67|abc%
+77|abc%
+87|abc%
+97|abc%
+107|abc%
+117|abc%
+127|abc%
+137|abc%
+147|abc%
+157|abc%
+167|abc%
+177|abc%
+187|abc%
+197|abc%
+207|abc%
+217|abc%
+227|abc%
+237|abc%
+247|abc%
+257|abc%
+267|abc%
+277|abc%
+287|abc%
+297|abc%
+`,
+		},
+		{
+			query: `select id, note from people where note like 'a\_c' escape '\'`,
+			want: `8|a_c
+18|a_c
+28|a_c
+38|a_c
+48|a_c
+58|a_c
+68|a_c
+78|a_c
+88|a_c
+98|a_c
+108|a_c
+118|a_c
+128|a_c
+138|a_c
+148|a_c
+158|a_c
+168|a_c
+178|a_c
+188|a_c
+198|a_c
+208|a_c
+218|a_c
+228|a_c
+238|a_c
+248|a_c
+258|a_c
+268|a_c
+278|a_c
+288|a_c
+298|a_c
+`,
+		},
+		{
+			query: `select id, name from people where name glob '*[0-9]9' order by id desc limit 3`,
+			want: `299|Judy29
+298|ivan29
+297|Heidi29
+`,
+		},
+		{
+			query: `select id, case when age < 30 then 'young' when age < 60 then 'mid' else 'old' end as grp from people where id < 6`,
+			want: `1|young
+2|young
+3|young
+4|young
+5|mid
+`,
+		},
+		{
+			query: `select cast(height as integer), cast(id as text) || 'x', cast('12abc' as integer), cast(3 as real) from people where id = 3`,
+			want: `183|3x|12|3.0
+`,
+		},
+		{
+			query: `select cast('1e3' as integer), cast('12.9e1' as integer), cast(' 42abc' as integer), cast('99999999999999999999' as integer), cast('-99999999999999999999' as integer), cast(12.9e1 as integer) from people where id = 3`,
+			want: `1|12|42|9223372036854775807|-9223372036854775808|129
+`,
+		},
+		{
+			query: `select 1 + 2 * 3, 7 / 2, 7 % 3, 7.0 / 2, -7 / 2, 1 << 3, 5 & 3, 5 | 3, ~5, 'a' || 'b' || 1`,
+			want: `7|3|1|3.5|-3|8|1|7|-6|ab1
+`,
+		},
+		{
+			query: `select 1 = 1.0, 'a' < 'b', x'01' > 'a', null = null, null is null, 1 is not 2, not 0, not null`,
+			want: `1|1|1|NULL|1|1|1|NULL
+`,
+		},
+		{
+			query: `select rowid, oid, id from people where rowid = 7`,
+			want: `7|7|7
+`,
+		},
+		{
+			query: `select * from cities order by population desc`,
+			want: `Tokyo|JP|13960000
+Berlin|DE|3645000
+Paris|FR|2161000
+Lyon|FR|513275
+Osaka|JP|NULL
+`,
+		},
+		{
+			query: `select id, active from people where id between 1 and 6`,
+			want: `1|1
+2|1
+3|1
+4|1
+5|0
+6|1
+`,
+		},
+		{
+			query: `select "name", "nope" from people where id = 1`,
+			want: `Bob0|nope
+`,
+		},
+		{
+			query: `select p.name, p.age from people p where p.id = 2`,
+			want: `carol0|14
+`,
+		},
+		{
+			query: `select id as i from people where id < 5 order by i desc`,
+			want: `4
+3
+2
+1
+`,
+		},
+		{
+			query: `select id, age from people where age > 85 order by age desc nulls first, id limit 4`,
+			want: `77|89
+167|89
+257|89
+64|88
+`,
+		},
+		{
+			query: `select id from people limit 298, 5`,
+			want: `299
+300
+`,
+		},
+		{
+			query: `select id, age from people where age = ' 21 '`,
+			want: `3|21
+93|21
+183|21
+273|21
+`,
+		},
+		{
+			query: `select 9223372036854775807 + 1, -9223372036854775808, -(-9223372036854775808), 9223372036854775807 * 2`,
+			want: `9.22337203685478e+18|-9223372036854775808|9.22337203685478e+18|1.84467440737096e+19
+`,
+		},
+		{
+			query: `select 5 / 0, 5 % 0, 5.0 / 0, 5.5 % 2`,
+			want: `NULL|NULL|NULL|1.0
+`,
+		},
+		{
+			query: `select 'x' + 1, '3.5' * 2, x'3132' + 1`,
+			want: `1|7.0|13
+`,
+		},
+		{
+			query: `select id from people where id in (1, 2.0, '3', x'34')`,
+			want: `1
+2
+3
+`,
+		},
+		{
+			query: `select 1 in (), null in (), null in (1), 2 between 1 and null, 0 between 1 and null`,
+			want: `0|0|NULL|NULL|0
+`,
+		},
+		{
+			query: `select (1 or null), (0 or null), (1 and null), (0 and null)`,
+			want: `1|NULL|NULL|0
+`,
+		},
+		{
+			query: `select 1 where 0`,
+			want:  ``,
+		},
+		{
+			query: `select 1 where 1`,
+			want: `1
+`,
+		},
+		{
+			query: `with t(x) as (select 1 where 0) select count(*) from t`,
+			want: `0
+`,
+		},
+	} {
+		got := queryString(t, db, tc.query)
+		if got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{
+			query: `select id, name from people where id = ?`,
+			args:  []interface{}{3},
+			want:  "3|Dave0\n",
+		},
+		{
+			query: `select ?, ?, ?, ?, ?, ?, ?`,
+			args:  []interface{}{nil, int8(-1), uint32(7), 1.5, float32(0.5), true, []byte("ab")},
+			want:  "NULL|-1|7|1.5|0.5|1|x'6162'\n",
+		},
+		{
+			query: `select ?2, ?1, ?`,
+			args:  []interface{}{"a", "b", "c"},
+			want:  "b|a|c\n",
+		},
+		{
+			query: `select id from people where age = :age and city = @city or id = :age`,
+			args:  []interface{}{sql.Named("age", 21), sql.Named("city", "paris")},
+			want:  "3\n21\n93\n183\n273\n",
+		},
+		{
+			query: `select :a, $b, ?`,
+			args:  []interface{}{1, 2, 3},
+			want:  "1|2|3\n",
+		},
+		{
+			query: `select id from people where age > ? order by id limit ? offset ?`,
+			args:  []interface{}{"85", 2, 1},
+			want:  "64\n77\n",
+		},
+		{
+			query: `select ? = 12, ? || 'x'`,
+			args:  []interface{}{sql.NullInt64{Int64: 12, Valid: true}, sql.NullString{}},
+			want:  "1|NULL\n",
+		},
+	} {
+		got := queryString(t, db, tc.query, tc.args...)
+		if got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{query: `select`, want: "syntax error"},
+		{query: `delete from people`, want: "expected SELECT"},
+		{query: `select 1; select 2`, want: "unexpected token"},
+		{query: `select * from nope`, want: "no such table: nope"},
+		{query: `select nope from people`, want: "no such column: nope"},
+		{query: `select x.id from people`, want: "no such column: x.id"},
+		{query: `select rowid from cities`, want: "no such column: rowid"},
+		{query: `select *`, want: "no tables specified"},
+		{query: `select nope(1)`, want: "no such function: nope"},
+		{query: `select 'a' collate nope`, want: "no such collation sequence: nope"},
+		{query: `select id from people order by 2`, want: "1st ORDER BY term out of range - should be between 1 and 1"},
+		{query: `select 1 limit 'a'`, want: "datatype mismatch"},
+		{query: `select 1 limit 1.5`, want: "datatype mismatch"},
+		{query: `select ?`, want: "missing argument for parameter ?"},
+		{query: `select ?`, args: []interface{}{1, 2}, want: "2 arguments for 1 parameters"},
+		{query: `select ?`, args: []interface{}{struct{}{}}, want: "invalid argument for parameter ?"},
+		{query: `select ?0`, want: "variable number must be between ?1 and ?32766"},
+		{query: `select 'a' like 'a' escape 'ab'`, want: "ESCAPE expression must be a single character"},
+		{query: `select count(*) from people where count(*) > 1`, want: "misuse of aggregate: count()"},
+		{query: `select count(count(*)) from people`, want: "misuse of aggregate function count()"},
+		{query: `select city, count(*) from people group by 2`, want: "aggregate functions are not allowed in the GROUP BY clause"},
+		{query: `select city from people group by 2`, want: "1st GROUP BY term out of range - should be between 1 and 1"},
+		{query: `select id from people order by count(*)`, want: "misuse of aggregate: count()"},
+		{query: `select name from people having count(*) > 1`, want: "HAVING clause on a non-aggregate query"},
+		{query: `select count(1, 2) from people`, want: "wrong number of arguments to function count()"},
+		{query: `select max(*) from people`, want: "wrong number of arguments to function max()"},
+		{query: `select group_concat(distinct city, '-') from people`, want: "DISTINCT aggregates must have exactly one argument"},
+		{query: `select sum(9223372036854775807) from people`, want: "integer overflow"},
+	} {
+		rows, err := db.Query(tc.query, tc.args...)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
+
+type scanned struct {
+	v interface{}
+}
+
+func (s *scanned) Scan(v interface{}) error {
+	s.v = v
+	return nil
+}
+
+func TestRowsScan(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`select id, name, height, note, active, note from people where id in (2, 4) order by id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if got, want := rows.Columns(), []string{"id", "name", "height", "note", "active", "note"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid columns: got=%q, want=%q", got, want)
+	}
+	if err := rows.Scan(new(interface{})); err == nil {
+		t.Fatalf("expected an error scanning before Next")
+	}
+
+	var (
+		id     int
+		name   string
+		height float64
+		note   interface{}
+		active bool
+		raw    scanned
+	)
+	for _, want := range []struct {
+		id     int
+		name   string
+		height float64
+		note   interface{}
+	}{
+		{2, "carol0", 172, 4.5},
+		{4, "eve0", 194, []byte{0, 1}},
+	} {
+		if !rows.Next() {
+			t.Fatalf("missing row %d: %v", want.id, rows.Err())
+		}
+		if err := rows.Scan(&id, &name, &height, &note, &active, &raw); err != nil {
+			t.Fatal(err)
+		}
+		if id != want.id || name != want.name || height != want.height ||
+			!reflect.DeepEqual(note, want.note) || !active || !reflect.DeepEqual(raw.v, want.note) {
+			t.Fatalf("invalid row: %v %q %v %v %v %v", id, name, height, note, active, raw.v)
+		}
+		if err := rows.Scan(&id); err == nil {
+			t.Fatalf("expected an error scanning too few values")
+		}
+	}
+	if rows.Next() {
+		t.Fatalf("unexpected row")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	for _, tc := range []struct {
+		query string
+		dest  interface{}
+		want  string
+	}{
+		{`select null`, &s, "converting NULL to *string is unsupported"},
+		{`select 'x'`, &id, "converting \"x\" to int"},
+		{`select 1.5`, &id, "not an integer"},
+		{`select 3000000000`, new(int32), "value out of range"},
+		{`select 1`, new(uint8), "unsupported Scan destination *uint8"},
+	} {
+		rows, err := db.Query(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Next()
+		err = rows.Scan(tc.dest)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+		rows.Close()
+	}
+}
+
+func TestRowsClose(t *testing.T) {
+	db, err := Open("testdata/large.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`select id, name from items`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !rows.Next() {
+			t.Fatalf("missing row %d", i)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatalf("unexpected row after Close")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := queryString(t, db, `select id from items where id % 1000 = 0 limit 2`)
+	if want := "1000\n2000\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
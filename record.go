@@ -4,6 +4,13 @@
 
 package sqlite3
 
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
 type RecordHeader struct {
 	Len   int
 	Types []SerialType
@@ -14,3 +21,353 @@ type Record struct {
 	Body   []byte
 	Values []interface{}
 }
+
+// DecodeRecord decodes a record in the SQLite record format, with text
+// encoded as UTF-8.
+//
+// Integer values are decoded as int64, so that the values of the record
+// can be encoded back with AppendRecord; BLOB values are copied.
+func DecodeRecord(payload []byte) (Record, error) {
+	rec, err := decodeRecord(payload, 1, false, nil)
+	if err != nil {
+		return rec, err
+	}
+	for i, v := range rec.Values {
+		if iv, ok := toInt64(v); ok {
+			rec.Values[i] = iv
+		}
+	}
+	return rec, nil
+}
+
+// decodeRecord decodes the record held by payload.
+// Text values are decoded with the database encoding enc. With zeroCopy,
+// BLOB values point into payload.
+// If want is not nil, only the values whose index is set in want are
+// decoded, the others being nil: payload may then end after the last of
+// them.
+// Offsets of corruption errors are relative to the start of the payload.
+func decodeRecord(payload []byte, enc int32, zeroCopy bool, want []bool) (Record, error) {
+	var rec Record
+
+	// decode record
+	recbuf := payload[:]
+	rhdrsz, n := varint(recbuf)
+	if n <= 0 {
+		return rec, corruptf(0, -1, 0, "error decoding record header (n=%d)", n)
+	}
+	if rhdrsz < int64(n) || rhdrsz > int64(len(payload)) {
+		return rec, corruptf(0, -1, 0, "invalid record header size (%d)", rhdrsz)
+	}
+	recbuf = recbuf[n:]
+
+	rec.Header.Len = int(rhdrsz) - n
+	for ii := 0; ii < rec.Header.Len; {
+		v, n := varint(recbuf)
+		// fmt.Printf("ii=%d nn=%d len=%d\n", ii, n, rec.Header.Len)
+		if n <= 0 {
+			return rec, corruptf(0, -1, len(payload)-len(recbuf), "error decoding record header type (n=%d)", n)
+		}
+		recbuf = recbuf[n:]
+		ii += int(n)
+		rec.Header.Types = append(rec.Header.Types, SerialType(v))
+	}
+	rec.Body = recbuf[:]
+	//copy(rec.Body, recbuf)
+
+	// fmt.Printf(">>> record: %#v (body=%d)\n", rec.Header, len(rec.Body))
+	for i, st := range rec.Header.Types {
+		var (
+			v   interface{}
+			err error
+			off = len(payload) - len(recbuf)
+		)
+		if st.NBytes() < 0 {
+			return rec, corruptf(0, -1, off, "invalid serial type (%d)", int64(st))
+		}
+		if want != nil && (i >= len(want) || !want[i]) {
+			recbuf = recbuf[min(st.NBytes(), len(recbuf)):]
+			rec.Values = append(rec.Values, nil)
+			continue
+		}
+		if st.NBytes() > len(recbuf) {
+			return rec, corruptf(0, -1, off, "truncated %v value", st)
+		}
+
+		switch st {
+		case StInt8:
+			recbuf, v, err = readStInt8(recbuf)
+
+		case StInt16:
+			recbuf, v, err = readStInt16(recbuf)
+
+		case StInt24:
+			recbuf, v, err = readStInt24(recbuf)
+
+		case StInt32:
+			recbuf, v, err = readStInt32(recbuf)
+
+		case StInt48:
+			recbuf, v, err = readStInt48(recbuf)
+
+		case StInt64:
+			recbuf, v, err = readStInt64(recbuf)
+
+		case StFloat:
+			var vv float64
+			var n int64
+			n, err = unmarshal(recbuf, &vv)
+			if err == nil {
+				recbuf = recbuf[int(n):]
+				v = vv
+			}
+
+		case StC0:
+			v = 0
+
+		case StC1:
+			v = 1
+
+		default:
+			if st.IsBlob() {
+				var vv []byte
+				n := st.NBytes()
+				switch {
+				case zeroCopy:
+					vv = recbuf[:n:n]
+				default:
+					vv = make([]byte, n)
+					copy(vv, recbuf)
+				}
+				recbuf = recbuf[n:]
+				v = vv
+			}
+			if st.IsText() {
+				vv := make([]byte, st.NBytes())
+				n := copy(vv, recbuf)
+				recbuf = recbuf[int(n):]
+				// FIXME(sbinet)
+				// handle db string encoding
+				switch enc {
+				case 1:
+					s := string(vv)
+					idx := strings.Index(s, "\x00")
+					if idx >= 0 {
+						s = s[:idx]
+					}
+					v = s
+				default:
+					return rec, fmt.Errorf("sqlite3: UTF-16 text encoding not supported")
+				}
+			}
+		}
+		if err != nil {
+			return rec, corruptf(0, -1, off, "error decoding %v value: %v", st, err)
+		}
+
+		rec.Values = append(rec.Values, v)
+	}
+	// fmt.Printf(">>> record: %#v (body=%d)\n", rec.Values, len(rec.Body))
+
+	return rec, nil
+}
+
+// EncodeVarint returns the SQLite variable-length encoding of v, which
+// takes 1 to 9 bytes.
+func EncodeVarint(v int64) []byte {
+	return appendVarint(nil, v)
+}
+
+// appendVarint appends the variable-length encoding of v to dst.
+func appendVarint(dst []byte, v int64) []byte {
+	u := uint64(v)
+	if u > 0x00ffffffffffffff {
+		// 8 bytes of 7 bits, then a last byte of 8 bits.
+		var buf [9]byte
+		buf[8] = byte(u)
+		u >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(u&0x7f) | 0x80
+			u >>= 7
+		}
+		return append(dst, buf[:]...)
+	}
+	var (
+		buf [8]byte
+		i   = len(buf) - 1
+	)
+	buf[i] = byte(u & 0x7f)
+	for u >>= 7; u != 0; u >>= 7 {
+		i--
+		buf[i] = byte(u&0x7f) | 0x80
+	}
+	return append(dst, buf[i:]...)
+}
+
+// varintLen returns the number of bytes of the variable-length encoding
+// of v.
+func varintLen(v int64) int {
+	u := uint64(v)
+	if u > 0x00ffffffffffffff {
+		return 9
+	}
+	n := 1
+	for u >>= 7; u != 0; u >>= 7 {
+		n++
+	}
+	return n
+}
+
+// AppendRecord appends to dst the record holding values, and returns the
+// extended buffer.
+//
+// Values may be nil, integers, floats, booleans, strings or byte slices.
+// Each value is stored with the smallest serial type holding it, with the
+// StC0 and StC1 types for the integers 0 and 1: records are thus those of
+// the schema format 4, the default since SQLite 3.7.10.
+// Strings are stored as UTF-8 text and NaN floats as NULL, like SQLite
+// does.
+func AppendRecord(dst []byte, values ...interface{}) ([]byte, error) {
+	return AppendRecordFormat(dst, 4, values...)
+}
+
+// AppendRecordFormat is like AppendRecord, for the records of a database
+// of the given schema format, from 1 to 4. The StC0 and StC1 serial types
+// are only used by the schema format 4: older formats store the integers
+// 0 and 1 in one byte.
+func AppendRecordFormat(dst []byte, format int, values ...interface{}) ([]byte, error) {
+	if format < 1 || format > 4 {
+		return dst, fmt.Errorf("sqlite3: unsupported schema format (%d)", format)
+	}
+	types := make([]SerialType, len(values))
+	ints := make([]int64, len(values))
+	hdrsz := 0
+	for i, v := range values {
+		st, iv, err := serialType(v)
+		if err != nil {
+			return dst, fmt.Errorf("sqlite3: value %d: %w", i, err)
+		}
+		if format < 4 && (st == StC0 || st == StC1) {
+			st = StInt8
+		}
+		types[i] = st
+		ints[i] = iv
+		hdrsz += varintLen(int64(st))
+	}
+
+	// the header size includes its own varint.
+	n := varintLen(int64(hdrsz + 1))
+	if varintLen(int64(hdrsz+n)) > n {
+		n++
+	}
+	dst = appendVarint(dst, int64(hdrsz+n))
+	for _, st := range types {
+		dst = appendVarint(dst, int64(st))
+	}
+
+	for i, st := range types {
+		switch st {
+		case StInt8, StInt16, StInt24, StInt32, StInt48, StInt64:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], uint64(ints[i]))
+			dst = append(dst, buf[8-st.NBytes():]...)
+		case StFloat:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(toFloat64(values[i])))
+			dst = append(dst, buf[:]...)
+		default:
+			switch v := values[i].(type) {
+			case string:
+				dst = append(dst, v...)
+			case []byte:
+				dst = append(dst, v...)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// serialType returns the serial type storing the value v and, for
+// integers, its int64 value.
+func serialType(v interface{}) (SerialType, int64, error) {
+	var iv int64
+	switch v := v.(type) {
+	case nil:
+		return StNull, 0, nil
+	case int:
+		iv = int64(v)
+	case int8:
+		iv = int64(v)
+	case int16:
+		iv = int64(v)
+	case int32:
+		iv = int64(v)
+	case int64:
+		iv = v
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, 0, fmt.Errorf("integer %d overflows int64", v)
+		}
+		iv = int64(v)
+	case uint8:
+		iv = int64(v)
+	case uint16:
+		iv = int64(v)
+	case uint32:
+		iv = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, 0, fmt.Errorf("integer %d overflows int64", v)
+		}
+		iv = int64(v)
+	case bool:
+		if v {
+			iv = 1
+		}
+	case float32:
+		if v != v {
+			return StNull, 0, nil
+		}
+		return StFloat, 0, nil
+	case float64:
+		if math.IsNaN(v) {
+			return StNull, 0, nil
+		}
+		return StFloat, 0, nil
+	case string:
+		return SerialType(2*len(v) + 13), 0, nil
+	case []byte:
+		return SerialType(2*len(v) + 12), 0, nil
+	default:
+		return 0, 0, fmt.Errorf("unsupported value type %T", v)
+	}
+
+	u := uint64(iv)
+	if iv < 0 {
+		u = ^u
+	}
+	switch {
+	case iv == 0:
+		return StC0, iv, nil
+	case iv == 1:
+		return StC1, iv, nil
+	case u <= 0x7f:
+		return StInt8, iv, nil
+	case u <= 0x7fff:
+		return StInt16, iv, nil
+	case u <= 0x7fffff:
+		return StInt24, iv, nil
+	case u <= 0x7fffffff:
+		return StInt32, iv, nil
+	case u <= 0x7fffffffffff:
+		return StInt48, iv, nil
+	}
+	return StInt64, iv, nil
+}
+
+func toFloat64(v interface{}) float64 {
+	if v, ok := v.(float32); ok {
+		return float64(v)
+	}
+	return v.(float64)
+}
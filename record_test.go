@@ -0,0 +1,280 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestEncodeVarint(t *testing.T) {
+	for _, tc := range []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7f, []byte{0x7f}},
+		{0x81, []byte{0x81, 0x01}},
+		{0x1e0, []byte{0x83, 0x60}},
+		{13088612140104066, []byte{0x97, 0xa0, 0x80, 0xdf, 0xe9, 0xda, 0xf3, 0x02}},
+		{-1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	} {
+		got := EncodeVarint(tc.v)
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("EncodeVarint(%d) = %x, want %x", tc.v, got, tc.want)
+		}
+	}
+
+	roundtrip := func(v int64, shift uint8) bool {
+		v >>= shift % 64 // exercise all encoding lengths
+		buf := EncodeVarint(v)
+		got, n := varint(append(buf, 0x42))
+		return got == v && n == len(buf) && n == varintLen(v)
+	}
+	if err := quick.Check(roundtrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppendRecord(t *testing.T) {
+	for _, tc := range []struct {
+		values []interface{}
+		want   []byte
+	}{
+		{nil, []byte{0x01}},
+		{[]interface{}{"hello!", 10}, []byte{0x03, 0x19, 0x01, 'h', 'e', 'l', 'l', 'o', '!', 0x0a}},
+		{[]interface{}{nil, 0, 1, true, -1}, []byte{0x06, 0x00, 0x08, 0x09, 0x09, 0x01, 0xff}},
+		{[]interface{}{-129, int64(1) << 40}, []byte{0x03, 0x02, 0x05, 0xff, 0x7f, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{[]interface{}{1.5, math.NaN(), []byte{0xca, 0xfe}}, []byte{0x04, 0x07, 0x00, 0x10, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xca, 0xfe}},
+	} {
+		got, err := AppendRecord([]byte{0x42}, tc.values...)
+		if err != nil {
+			t.Errorf("AppendRecord(%v): %v", tc.values, err)
+			continue
+		}
+		if !bytes.Equal(got, append([]byte{0x42}, tc.want...)) {
+			t.Errorf("AppendRecord(%v) = %x, want 42%x", tc.values, got, tc.want)
+		}
+	}
+
+	for _, v := range []interface{}{uint64(math.MaxUint64), struct{}{}} {
+		if _, err := AppendRecord(nil, v); err == nil {
+			t.Errorf("AppendRecord(%v): expected an error", v)
+		}
+	}
+}
+
+func TestAppendRecordFormat(t *testing.T) {
+	values := []interface{}{nil, 0, 1, true, false, 2, "a"}
+	for _, tc := range []struct {
+		format int
+		want   []byte
+	}{
+		{4, []byte{0x08, 0x00, 0x08, 0x09, 0x09, 0x08, 0x01, 0x0f, 0x02, 'a'}},
+		{3, []byte{0x08, 0x00, 0x01, 0x01, 0x01, 0x01, 0x01, 0x0f, 0x00, 0x01, 0x01, 0x00, 0x02, 'a'}},
+		{1, []byte{0x08, 0x00, 0x01, 0x01, 0x01, 0x01, 0x01, 0x0f, 0x00, 0x01, 0x01, 0x00, 0x02, 'a'}},
+	} {
+		got, err := AppendRecordFormat(nil, tc.format, values...)
+		if err != nil {
+			t.Errorf("format %d: %v", tc.format, err)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("format %d: got %x, want %x", tc.format, got, tc.want)
+		}
+	}
+
+	for _, format := range []int{0, 5} {
+		if _, err := AppendRecordFormat(nil, format, 1); err == nil {
+			t.Errorf("format %d: expected an error", format)
+		}
+	}
+}
+
+// TestDecodeRecordRoundtrip checks that the values of decoded records are
+// encoded back into the same records, including the negative integers of
+// the serial types without Go counterpart.
+func TestDecodeRecordRoundtrip(t *testing.T) {
+	for _, tc := range []struct {
+		v  int64
+		st SerialType
+	}{
+		{-40000, StInt24},
+		{-1 << 23, StInt24},
+		{1<<23 - 1, StInt24},
+		{-3000000000, StInt48},
+		{-1 << 47, StInt48},
+		{1<<47 - 1, StInt48},
+		{-1, StInt8},
+		{-1 << 63, StInt64},
+	} {
+		buf, err := AppendRecord(nil, tc.v)
+		if err != nil {
+			t.Errorf("AppendRecord(%d): %v", tc.v, err)
+			continue
+		}
+		rec, err := DecodeRecord(buf)
+		if err != nil {
+			t.Errorf("DecodeRecord(%x): %v", buf, err)
+			continue
+		}
+		if st := rec.Header.Types[0]; st != tc.st {
+			t.Errorf("%d: serial type %v, want %v", tc.v, st, tc.st)
+		}
+		if got := rec.Values[0]; got != interface{}(tc.v) {
+			t.Errorf("%d: decoded %#v", tc.v, got)
+		}
+		got, err := AppendRecord(nil, rec.Values...)
+		if err != nil {
+			t.Errorf("AppendRecord(%d): %v", tc.v, err)
+			continue
+		}
+		if !bytes.Equal(got, buf) {
+			t.Errorf("%d: got %x, want %x", tc.v, got, buf)
+		}
+	}
+}
+
+// TestAppendRecordFiles checks that re-encoding the records of the test
+// files gives back the records written by SQLite.
+func TestAppendRecordFiles(t *testing.T) {
+	fnames, err := filepath.Glob("testdata/*.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range fnames {
+		db, err := Open(fname)
+		if err != nil {
+			continue
+		}
+		n := 0
+		for _, obj := range append(db.Schema(), SchemaObject{Name: "sqlite_master", RootPage: 1}) {
+			if obj.RootPage == 0 {
+				continue
+			}
+			page, err := db.pager.Page(obj.RootPage)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			bt, err := newBtree(page, db)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			err = bt.visitRawInorder(func(cell cellInfo) error {
+				if len(cell.Payload) == 0 {
+					return nil // interior table cell
+				}
+				rec, err := DecodeRecord(cell.Payload)
+				if err != nil {
+					return err
+				}
+				got, err := AppendRecordFormat(nil, db.Header().SchemaFormat, rec.Values...)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(got, cell.Payload) {
+					t.Errorf("%s: %s: cell %d:\ngot = %x\nwant= %x", fname, obj.Name, cell.Cell, got, cell.Payload)
+				}
+				n++
+				return nil
+			})
+			if err != nil {
+				t.Errorf("%s: %s: %v", fname, obj.Name, err)
+			}
+		}
+		db.Close()
+		if n == 0 {
+			t.Errorf("%s: no record", fname)
+		}
+	}
+}
+
+// recordValue is a random value of a record.
+type recordValue struct {
+	v interface{}
+}
+
+func (recordValue) Generate(r *rand.Rand, size int) reflect.Value {
+	var v interface{}
+	switch r.Intn(5) {
+	case 0:
+		v = nil
+	case 1:
+		v = r.Int63() >> uint(r.Intn(64))
+		if r.Intn(2) == 0 {
+			v = -v.(int64)
+		}
+	case 2:
+		v = r.NormFloat64() * math.Pow(10, float64(r.Intn(40)-20))
+	case 3:
+		buf := make([]byte, r.Intn(size+1))
+		for i := range buf {
+			buf[i] = byte('a' + r.Intn(26))
+		}
+		v = string(buf)
+	case 4:
+		buf := make([]byte, r.Intn(size+1))
+		r.Read(buf)
+		v = buf
+	}
+	return reflect.ValueOf(recordValue{v})
+}
+
+func TestRecordRoundtrip(t *testing.T) {
+	for _, format := range []int{1, 4} {
+		t.Run(fmt.Sprintf("format=%d", format), func(t *testing.T) {
+			testRecordRoundtrip(t, format)
+		})
+	}
+}
+
+func testRecordRoundtrip(t *testing.T, format int) {
+	roundtrip := func(rvs []recordValue) bool {
+		values := make([]interface{}, len(rvs))
+		for i, rv := range rvs {
+			values[i] = rv.v
+		}
+		buf, err := AppendRecordFormat(nil, format, values...)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		rec, err := DecodeRecord(buf)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		for i, st := range rec.Header.Types {
+			if format < 4 && (st == StC0 || st == StC1) {
+				t.Logf("value %d: serial type %v in schema format %d", i, st, format)
+				return false
+			}
+		}
+		got := rec.Values
+		if len(got) != len(values) {
+			return false
+		}
+		for i := range got {
+			if b, ok := values[i].([]byte); ok && len(b) == 0 {
+				// empty BLOBs are decoded as empty, non-nil, slices.
+				values[i] = []byte{}
+			}
+			if !reflect.DeepEqual(got[i], values[i]) {
+				t.Logf("value %d: got %#v, want %#v", i, got[i], values[i])
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(roundtrip, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
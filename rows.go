@@ -0,0 +1,246 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Rows is the result of a query. Its cursor starts before the first row:
+// use Next to advance from row to row.
+//
+// Rows are produced by a goroutine running the query: Rows must be
+// closed, unless Next returned false.
+type Rows struct {
+	cols  []string
+	items chan []interface{}
+	quit  chan struct{}
+	once  sync.Once
+
+	err   error      // error of the query, valid once items is closed
+	stats QueryStats // counters of the query, valid once items is closed
+	done  bool       // whether items is closed
+	cur   []interface{}
+}
+
+// QueryStats holds the counters of a query, collected while it runs.
+//
+// Pages are counted each time they are read, by the scans and lookups of
+// the tables and indexes: a lookup by rowid reads the pages from the
+// root of the table b-tree to the leaf holding the row.
+type QueryStats struct {
+	PagesRead    int64 // b-tree and overflow pages read
+	RowsExamined int64 // rows of the FROM clauses read, before the conditions of the query are checked
+}
+
+// newRows starts running the query plan.
+func newRows(plan queryPlan) *Rows {
+	rows := &Rows{
+		cols:  plan.columns(),
+		items: make(chan []interface{}, 64),
+		quit:  make(chan struct{}),
+	}
+	planSources(plan, func(src *source) {
+		src.stats = &rows.stats
+	})
+	go func() {
+		err := plan.run(func(vals []interface{}) error {
+			select {
+			case rows.items <- vals:
+				return nil
+			case <-rows.quit:
+				return errRowsClosed
+			}
+		})
+		if err != errRowsClosed {
+			rows.err = err
+		}
+		close(rows.items)
+	}()
+	return rows
+}
+
+// Columns returns the names of the columns of the rows.
+func (rows *Rows) Columns() []string {
+	return rows.cols
+}
+
+// Next prepares the next row for reading with Values or Scan. Next
+// returns false when there is no next row, or when an error occurred:
+// Err should then be checked.
+func (rows *Rows) Next() bool {
+	if rows.done {
+		return false
+	}
+	vals, ok := <-rows.items
+	if !ok {
+		rows.done = true
+		rows.cur = nil
+		return false
+	}
+	rows.cur = vals
+	return true
+}
+
+// Values returns the values of the current row: nil, int64, float64,
+// string or []byte values.
+func (rows *Rows) Values() []interface{} {
+	return rows.cur
+}
+
+// Scan copies the values of the current row into the values pointed at
+// by dest, one per column.
+//
+// Destinations may be pointers to interface{}, int64, int, int32,
+// float64, bool, string or []byte, or implement sql.Scanner. NULL values
+// may only be stored in interface{} and []byte destinations, or given
+// to a sql.Scanner.
+func (rows *Rows) Scan(dest ...interface{}) error {
+	if rows.cur == nil {
+		return fmt.Errorf("sqlite3: Scan called without calling Next")
+	}
+	if len(dest) != len(rows.cur) {
+		return fmt.Errorf("sqlite3: expected %d destination arguments in Scan, not %d", len(rows.cur), len(dest))
+	}
+	for i, v := range rows.cur {
+		if err := scanValue(dest[i], v); err != nil {
+			return fmt.Errorf("sqlite3: Scan error on column %d (%q): %w", i, rows.cols[i], err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error that stopped the query, if any.
+func (rows *Rows) Err() error {
+	if !rows.done {
+		return nil
+	}
+	return rows.err
+}
+
+// Stats returns the counters of the query, once Next returned false or
+// the rows are closed, and zero counters before.
+func (rows *Rows) Stats() QueryStats {
+	if !rows.done {
+		return QueryStats{}
+	}
+	return rows.stats
+}
+
+// Close stops the query and releases the rows.
+func (rows *Rows) Close() error {
+	rows.once.Do(func() { close(rows.quit) })
+	for range rows.items {
+		// wait for the query to stop.
+	}
+	rows.done = true
+	rows.cur = nil
+	return nil
+}
+
+// scanValue stores the query value v in dest.
+func scanValue(dest, v interface{}) error {
+	switch d := dest.(type) {
+	case sql.Scanner:
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		return d.Scan(v)
+	case *interface{}:
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		*d = v
+		return nil
+	case *[]byte:
+		switch v := v.(type) {
+		case nil:
+			*d = nil
+		case []byte:
+			*d = append([]byte(nil), v...)
+		default:
+			*d = []byte(textValue(v))
+		}
+		return nil
+	}
+
+	if v == nil {
+		return fmt.Errorf("converting NULL to %T is unsupported", dest)
+	}
+	switch d := dest.(type) {
+	case *string:
+		*d = textValue(v)
+	case *int64:
+		i, err := scanInt(v, 64)
+		*d = i
+		return err
+	case *int:
+		i, err := scanInt(v, strconv.IntSize)
+		*d = int(i)
+		return err
+	case *int32:
+		i, err := scanInt(v, 32)
+		*d = int32(i)
+		return err
+	case *float64:
+		switch v := v.(type) {
+		case int64:
+			*d = float64(v)
+		case float64:
+			*d = v
+		default:
+			f, err := strconv.ParseFloat(textValue(v), 64)
+			if err != nil {
+				return fmt.Errorf("converting %q to float64: %w", textValue(v), err)
+			}
+			*d = f
+		}
+	case *bool:
+		switch v := v.(type) {
+		case int64, float64:
+			ok, _ := truth(v)
+			*d = ok
+		default:
+			b, err := strconv.ParseBool(textValue(v))
+			if err != nil {
+				return fmt.Errorf("converting %q to bool: %w", textValue(v), err)
+			}
+			*d = b
+		}
+	default:
+		return fmt.Errorf("unsupported Scan destination %T", dest)
+	}
+	return nil
+}
+
+// scanInt converts the non-NULL value v to an integer of the given bit
+// size.
+func scanInt(v interface{}, bits int) (int64, error) {
+	var (
+		i   int64
+		err error
+	)
+	switch v := v.(type) {
+	case int64:
+		i = v
+	case float64:
+		i = floatToInt(v)
+		if float64(i) != v {
+			return 0, fmt.Errorf("converting %v to int%d: not an integer", v, bits)
+		}
+	default:
+		i, err = strconv.ParseInt(textValue(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("converting %q to int%d: %w", textValue(v), bits, err)
+		}
+	}
+	if bits < 64 && (i < -1<<(bits-1) || i > 1<<(bits-1)-1) {
+		return 0, fmt.Errorf("converting %d to int%d: value out of range", i, bits)
+	}
+	return i, nil
+}
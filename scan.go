@@ -0,0 +1,353 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ScanOption configures a table scan.
+type ScanOption func(*scanOptions)
+
+type scanOptions struct {
+	ordered bool     // whether records are delivered in rowid order
+	project bool     // whether only some columns are decoded
+	columns []string // names of the decoded columns
+	pages   *int64   // counter of the pages read, if any
+}
+
+func newScanOptions(opts []ScanOption) scanOptions {
+	var o scanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// InRowidOrder requests a parallel scan to deliver records in
+// increasing rowid order, or primary key order for WITHOUT ROWID tables,
+// from a single goroutine.
+func InRowidOrder() ScanOption {
+	return func(o *scanOptions) {
+		o.ordered = true
+	}
+}
+
+// Columns requests a scan to only decode the values of the named
+// columns. The values of the other columns are nil, and the record Body
+// may only hold the bytes up to the last decoded value: overflow pages
+// are not read unless they hold some of the decoded values.
+func Columns(names ...string) ScanOption {
+	return func(o *scanOptions) {
+		o.project = true
+		o.columns = append(o.columns[:0:0], names...)
+	}
+}
+
+// countPages requests a scan to add the number of pages it reads to n.
+func countPages(n *int64) ScanOption {
+	return func(o *scanOptions) {
+		o.pages = n
+	}
+}
+
+// projection returns whether each value of the records of the table is
+// decoded by a scan with the options o, or nil if all of them are.
+func (t *Table) projection(o scanOptions) ([]bool, error) {
+	if !o.project {
+		return nil, nil
+	}
+	cols := make([]bool, len(t.cols))
+	for _, name := range o.columns {
+		i := t.colIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("sqlite3: no such column %q in table %q", name, t.name)
+		}
+		cols[i] = true
+	}
+	if !t.withoutRowid {
+		return cols, nil
+	}
+	want := make([]bool, len(t.cols))
+	for i, icol := range t.recordColumns() {
+		want[i] = cols[icol]
+	}
+	return want, nil
+}
+
+// ParallelScan visits all the records of the table, passing the rowid
+// (nil for WITHOUT ROWID tables) and the record-decoded payload of each
+// cell to fn.
+//
+// The table b-tree is split at its interior pages into independent
+// sub-trees, covering disjoint rowid or primary key ranges, which are
+// scanned by n goroutines.
+// Unless the InRowidOrder option is given, fn is called concurrently
+// from multiple goroutines, in no particular order.
+// The Columns option restricts the decoded values.
+//
+// ParallelScan stops at the first error and returns it.
+func (t *Table) ParallelScan(n int, fn func(*int64, Record) error, opts ...ScanOption) error {
+	if n < 1 {
+		return fmt.Errorf("sqlite3: invalid number of goroutines (%d)", n)
+	}
+	o := newScanOptions(opts)
+	want, err := t.projection(o)
+	if err != nil {
+		return err
+	}
+
+	parts, err := t.db.splitBtree(t.pageid, !t.withoutRowid, 4*n)
+	if err != nil {
+		return err
+	}
+	if t.withoutRowid {
+		order := t.recordColumns()
+		visit := fn
+		fn = func(_ *int64, rec Record) error {
+			return visit(nil, t.tableRecord(order, rec))
+		}
+	}
+
+	if o.ordered {
+		return parallelScanOrdered(parts, n, want, fn)
+	}
+	return parallelScan(parts, n, want, fn)
+}
+
+// scanPart is a part of a b-tree scanned by ParallelScan: a sub-tree, or
+// a cell of an interior index b-tree page, whose key sorts between the
+// sub-trees of its left child and of the next cell.
+type scanPart struct {
+	bt   *btreeTable
+	cell int // index of the cell of bt, or -1 for the whole sub-tree
+}
+
+// visitRecords passes the record-decoded payload of each cell of the
+// part to f, in order.
+func (p scanPart) visitRecords(want []bool, f func(*int64, Record) error) error {
+	if p.cell < 0 {
+		return p.bt.visitRecords(want, f)
+	}
+	ci, err := p.bt.loadCellPayload(p.cell, want == nil)
+	if err != nil {
+		return err
+	}
+	return p.bt.visitCell(ci, want, f)
+}
+
+// splitBtree splits the table b-tree, or the index b-tree of a WITHOUT
+// ROWID table, rooted at page root into, at most, about max parts,
+// returned in key order.
+func (db *DbFile) splitBtree(root int, table bool, max int) ([]scanPart, error) {
+	page, err := db.pager.Page(root)
+	if err != nil {
+		return nil, err
+	}
+	var bt *btreeTable
+	switch {
+	case table:
+		bt, err = newBtreeTable(page, db)
+	default:
+		bt, err = newBtree(page, db)
+		if err == nil && bt.isTable() {
+			err = corruptf(bt.ID(), -1, bt.hdrAddr(), "invalid WITHOUT ROWID b-tree page kind (%v)", bt.Kind())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		w     = newBtreeWalk(root)
+		parts = []scanPart{{bt: bt, cell: -1}}
+		depth = 0
+	)
+	for len(parts) < max {
+		var (
+			next  = make([]scanPart, 0, len(parts))
+			split = false
+		)
+		for _, p := range parts {
+			if p.cell >= 0 || p.bt.isLeaf() {
+				next = append(next, p)
+				continue
+			}
+			split = true
+			children, err := p.bt.children(w, depth)
+			if err != nil {
+				return nil, err
+			}
+			for i, child := range children {
+				next = append(next, scanPart{bt: child, cell: -1})
+				if !p.bt.isTable() && i < p.bt.NumCell() {
+					// interior index pages hold keys as well.
+					next = append(next, scanPart{bt: p.bt, cell: i})
+				}
+			}
+		}
+		if !split {
+			break
+		}
+		parts = next
+		depth++
+	}
+
+	return parts, nil
+}
+
+// children returns the child b-trees of an interior b-tree, in order.
+func (btree *btreeTable) children(w *btreeWalk, depth int) ([]*btreeTable, error) {
+	children := make([]*btreeTable, 0, btree.NumCell()+1)
+	for i := 0; i < btree.NumCell(); i++ {
+		cell, err := btree.loadCell(i)
+		if err != nil {
+			return nil, err
+		}
+		child, err := btree.child(w, depth+1, i, cell.LeftChildPage)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if btree.pointer != 0 {
+		child, err := btree.child(w, depth+1, -1, btree.pointer)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// errScanStopped is used to stop the scan of a sub-tree when the scan
+// of another sub-tree failed.
+var errScanStopped = fmt.Errorf("sqlite3: scan stopped")
+
+func parallelScan(parts []scanPart, n int, want []bool, fn func(*int64, Record) error) error {
+	var (
+		wg    sync.WaitGroup
+		work  = make(chan scanPart)
+		quit  = make(chan struct{})
+		once  sync.Once
+		first error
+	)
+
+	stop := func(err error) {
+		once.Do(func() {
+			first = err
+			close(quit)
+		})
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				err := p.visitRecords(want, func(rowid *int64, rec Record) error {
+					select {
+					case <-quit:
+						return errScanStopped
+					default:
+					}
+					return fn(rowid, rec)
+				})
+				if err != nil && err != errScanStopped {
+					stop(err)
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, p := range parts {
+		select {
+		case work <- p:
+		case <-quit:
+			break loop
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return first
+}
+
+// scanItem is a record produced by a sub-tree scan.
+type scanItem struct {
+	rowid *int64
+	rec   Record
+}
+
+func parallelScanOrdered(parts []scanPart, n int, want []bool, fn func(*int64, Record) error) error {
+	type result struct {
+		items chan scanItem
+		err   error // only valid once items is closed
+	}
+
+	var (
+		wg      sync.WaitGroup
+		work    = make(chan int)
+		quit    = make(chan struct{})
+		results = make([]result, len(parts))
+	)
+
+	for i := range results {
+		results[i].items = make(chan scanItem, 64)
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				res := &results[i]
+				err := parts[i].visitRecords(want, func(rowid *int64, rec Record) error {
+					select {
+					case res.items <- scanItem{rowid, rec}:
+						return nil
+					case <-quit:
+						return errScanStopped
+					}
+				})
+				res.err = err
+				close(res.items)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(work)
+		for i := range parts {
+			select {
+			case work <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	var err error
+loop:
+	for i := range results {
+		res := &results[i]
+		for item := range res.items {
+			err = fn(item.rowid, item.rec)
+			if err != nil {
+				break loop
+			}
+		}
+		if res.err != nil {
+			err = res.err
+			break loop
+		}
+	}
+	close(quit)
+	wg.Wait()
+
+	return err
+}
@@ -0,0 +1,483 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// SchemaObject describes an object of the database schema, as recorded
+// in the sqlite_master table.
+type SchemaObject struct {
+	Type      string // "table", "index", "view" or "trigger"
+	Name      string // name of the object
+	TableName string // name of the table the object is associated with
+	RootPage  int    // root b-tree page of tables and indexes, 0 otherwise
+	SQL       string // SQL text creating the object, empty for automatic indexes
+}
+
+// Schema returns all the objects of the database schema, including the
+// internal ones, in the order of the sqlite_master table.
+func (db *DbFile) Schema() []SchemaObject {
+	return db.schema
+}
+
+// indexedColumn describes a column of an index or of a table constraint.
+type indexedColumn struct {
+	name    string // column name, empty for an expression
+	expr    string // SQL text of the indexed expression, if any
+	desc    bool   // whether the column is sorted in descending order
+	collate string // name of the collation, if any
+}
+
+// indexedColumns parses a parenthesized list of indexed columns.
+func (p *parser) indexedColumns() ([]indexedColumn, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var cols []indexedColumn
+	for {
+		var col indexedColumn
+		tok := p.peek()
+		next := p.peekN(1)
+		switch {
+		case tok.isName() && (next.isOp(",") || next.isOp(")") || next.is("COLLATE") || next.is("ASC") || next.is("DESC")):
+			col.name = tok.text
+			p.next()
+		default:
+			// an expression: consume it up to the next top-level
+			// comma, closing parenthesis or modifier.
+			beg := tok.pos
+			depth := 0
+		loop:
+			for {
+				tok := p.peek()
+				switch {
+				case tok.kind == tokEOF:
+					return nil, p.errorf("unexpected end of input")
+				case depth == 0 && (tok.isOp(",") || tok.isOp(")") || tok.is("COLLATE") || tok.is("ASC") || tok.is("DESC")):
+					break loop
+				case tok.isOp("("):
+					depth++
+				case tok.isOp(")"):
+					depth--
+				}
+				p.next()
+			}
+			col.expr = strings.TrimSpace(p.src[beg:p.peek().pos])
+		}
+
+		if p.accept("COLLATE") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			col.collate = name
+		}
+		switch {
+		case p.accept("ASC"):
+		case p.accept("DESC"):
+			col.desc = true
+		}
+		cols = append(cols, col)
+
+		if p.acceptOp(")") {
+			return cols, nil
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseCreateTable parses a CREATE TABLE statement, as stored in the
+// sqlite_master table.
+func parseCreateTable(sql string) (Table, error) {
+	var t Table
+	t.rowid = -1
+
+	p, err := newParser(sql)
+	if err != nil {
+		return t, err
+	}
+
+	if err := p.expect("CREATE"); err != nil {
+		return t, err
+	}
+	_ = p.accept("TEMP") || p.accept("TEMPORARY")
+	if err := p.expect("TABLE"); err != nil {
+		return t, err
+	}
+	_ = p.accept("IF", "NOT", "EXISTS")
+	_, t.name, err = p.qualifiedName()
+	if err != nil {
+		return t, err
+	}
+
+	if err := p.expectOp("("); err != nil {
+		return t, err
+	}
+
+	for {
+		if isTableConstraint(p.peek()) {
+			break
+		}
+		col, err := p.columnDef(&t)
+		if err != nil {
+			return t, err
+		}
+		t.cols = append(t.cols, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	for !p.peek().isOp(")") {
+		err := p.tableConstraint(&t)
+		if err != nil {
+			return t, err
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return t, err
+	}
+
+	for {
+		switch {
+		case p.accept("WITHOUT", "ROWID"):
+			t.withoutRowid = true
+		case p.accept("STRICT"):
+		default:
+			if p.peek().kind != tokEOF && !p.peek().isOp(";") {
+				return t, p.errorf("unexpected table option")
+			}
+			if t.withoutRowid {
+				t.rowid = -1
+			}
+			return t, nil
+		}
+		p.acceptOp(",")
+	}
+}
+
+// parseCreateIndex parses a CREATE INDEX statement, as stored in the
+// sqlite_master table.
+func parseCreateIndex(sql string) (Index, error) {
+	var idx Index
+
+	p, err := newParser(sql)
+	if err != nil {
+		return idx, err
+	}
+
+	if err := p.expect("CREATE"); err != nil {
+		return idx, err
+	}
+	idx.unique = p.accept("UNIQUE")
+	if err := p.expect("INDEX"); err != nil {
+		return idx, err
+	}
+	_ = p.accept("IF", "NOT", "EXISTS")
+	_, idx.name, err = p.qualifiedName()
+	if err != nil {
+		return idx, err
+	}
+	if err := p.expect("ON"); err != nil {
+		return idx, err
+	}
+	idx.table, err = p.name()
+	if err != nil {
+		return idx, err
+	}
+	idx.cols, err = p.indexedColumns()
+	if err != nil {
+		return idx, err
+	}
+	if p.accept("WHERE") {
+		idx.partial = true
+		return idx, nil
+	}
+	if p.peek().kind != tokEOF && !p.peek().isOp(";") {
+		return idx, p.errorf("unexpected index option")
+	}
+	return idx, nil
+}
+
+func isTableConstraint(tok token) bool {
+	for _, kw := range []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"} {
+		if tok.is(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnDef parses the definition of a column of table t.
+func (p *parser) columnDef(t *Table) (Column, error) {
+	var col Column
+	name, err := p.name()
+	if err != nil {
+		return col, err
+	}
+	col.name = name
+
+	// type name: a sequence of names, optionally followed by one or
+	// two numbers in parentheses.
+	var typ []string
+	for p.peek().isName() && !isColumnConstraint(p.peek()) {
+		typ = append(typ, p.next().text)
+	}
+	if len(typ) > 0 && p.peek().isOp("(") {
+		args, err := p.skipParens()
+		if err != nil {
+			return col, err
+		}
+		typ[len(typ)-1] += "(" + args + ")"
+	}
+	col.decl = strings.Join(typ, " ")
+
+	for {
+		tok := p.peek()
+		switch {
+		case p.accept("CONSTRAINT"):
+			if _, err := p.name(); err != nil {
+				return col, err
+			}
+		case p.accept("PRIMARY", "KEY"):
+			col.pk = true
+			desc := false
+			switch {
+			case p.accept("ASC"):
+			case p.accept("DESC"):
+				desc = true
+			}
+			if err := p.conflictClause(); err != nil {
+				return col, err
+			}
+			_ = p.accept("AUTOINCREMENT")
+			t.pk = []indexedColumn{{name: col.name, desc: desc}}
+			t.keys = append(t.keys, tableKey{cols: t.pk, pk: true})
+			if strings.EqualFold(col.decl, "INTEGER") && !desc {
+				t.rowid = len(t.cols)
+			}
+		case p.accept("NOT", "NULL"), p.accept("NULL"):
+			col.notNull = col.notNull || tok.is("NOT")
+			if err := p.conflictClause(); err != nil {
+				return col, err
+			}
+		case p.accept("UNIQUE"):
+			if err := p.conflictClause(); err != nil {
+				return col, err
+			}
+			t.keys = append(t.keys, tableKey{cols: []indexedColumn{{name: col.name}}})
+		case p.accept("CHECK"):
+			if _, err := p.skipParens(); err != nil {
+				return col, err
+			}
+		case p.accept("DEFAULT"):
+			v, err := p.defaultValue()
+			if err != nil {
+				return col, err
+			}
+			col.dflt = v
+		case p.accept("COLLATE"):
+			name, err := p.name()
+			if err != nil {
+				return col, err
+			}
+			col.collate = name
+		case p.accept("REFERENCES"):
+			if err := p.foreignKeyClause(); err != nil {
+				return col, err
+			}
+		case p.accept("GENERATED", "ALWAYS", "AS"), p.accept("AS"):
+			if _, err := p.skipParens(); err != nil {
+				return col, err
+			}
+			col.generated = true
+			_ = p.accept("STORED") || p.accept("VIRTUAL")
+		default:
+			return col, nil
+		}
+	}
+}
+
+func isColumnConstraint(tok token) bool {
+	for _, kw := range []string{
+		"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK",
+		"DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS",
+	} {
+		if tok.is(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictClause parses an optional ON CONFLICT clause.
+func (p *parser) conflictClause() error {
+	if !p.accept("ON", "CONFLICT") {
+		return nil
+	}
+	_, err := p.name()
+	return err
+}
+
+// defaultValue parses the value of a DEFAULT column constraint.
+// defaultValue returns the value of literal defaults, and nil otherwise.
+func (p *parser) defaultValue() (interface{}, error) {
+	if p.peek().isOp("(") {
+		_, err := p.skipParens()
+		return nil, err
+	}
+	neg := false
+	switch {
+	case p.acceptOp("-"):
+		neg = true
+	case p.acceptOp("+"):
+	}
+	tok := p.next()
+	switch tok.kind {
+	case tokEOF:
+		return nil, p.errorf("expected a default value")
+	case tokString:
+		return tok.text, nil
+	case tokBlob:
+		v, err := hex.DecodeString(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid blob literal")
+		}
+		return v, nil
+	case tokInt, tokFloat:
+		v, err := parseNumber(tok.text, neg)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return v, nil
+	}
+	return nil, nil
+}
+
+// parseNumber parses the text of a numeric literal, as an int64 or, if it
+// does not fit, as a float64.
+func parseNumber(text string, neg bool) (interface{}, error) {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		v, err := strconv.ParseUint(text[2:], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if neg {
+			return -int64(v), nil
+		}
+		return int64(v), nil
+	}
+	if neg {
+		text = "-" + text
+	}
+	if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		// out of range literals are infinities, or zero.
+		return v, nil
+	}
+	return v, err
+}
+
+// foreignKeyClause parses the clause following the REFERENCES keyword.
+func (p *parser) foreignKeyClause() error {
+	if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.peek().isOp("(") {
+		if _, err := p.skipParens(); err != nil {
+			return err
+		}
+	}
+	for {
+		switch {
+		case p.accept("ON"):
+			if _, err := p.name(); err != nil { // DELETE or UPDATE
+				return err
+			}
+			switch {
+			case p.accept("SET", "NULL"), p.accept("SET", "DEFAULT"),
+				p.accept("CASCADE"), p.accept("RESTRICT"), p.accept("NO", "ACTION"):
+			default:
+				return p.errorf("invalid foreign key action")
+			}
+		case p.accept("MATCH"):
+			if _, err := p.name(); err != nil {
+				return err
+			}
+		case p.accept("NOT", "DEFERRABLE"), p.accept("DEFERRABLE"):
+			_ = p.accept("INITIALLY", "DEFERRED") || p.accept("INITIALLY", "IMMEDIATE")
+		default:
+			return nil
+		}
+	}
+}
+
+// tableConstraint parses a table constraint of table t.
+func (p *parser) tableConstraint(t *Table) error {
+	if p.accept("CONSTRAINT") {
+		if _, err := p.name(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case p.accept("PRIMARY", "KEY"):
+		cols, err := p.indexedColumns()
+		if err != nil {
+			return err
+		}
+		t.pk = cols
+		t.keys = append(t.keys, tableKey{cols: cols, pk: true})
+		if len(cols) == 1 && !cols[0].desc {
+			for i := range t.cols {
+				if strings.EqualFold(t.cols[i].name, cols[0].name) {
+					t.cols[i].pk = true
+					if strings.EqualFold(t.cols[i].decl, "INTEGER") {
+						t.rowid = i
+					}
+				}
+			}
+		}
+		for _, col := range cols {
+			for i := range t.cols {
+				if strings.EqualFold(t.cols[i].name, col.name) {
+					t.cols[i].pk = true
+				}
+			}
+		}
+		_ = p.accept("AUTOINCREMENT")
+		return p.conflictClause()
+	case p.accept("UNIQUE"):
+		cols, err := p.indexedColumns()
+		if err != nil {
+			return err
+		}
+		t.keys = append(t.keys, tableKey{cols: cols})
+		return p.conflictClause()
+	case p.accept("CHECK"):
+		_, err := p.skipParens()
+		return err
+	case p.accept("FOREIGN", "KEY"):
+		if _, err := p.skipParens(); err != nil {
+			return err
+		}
+		if err := p.expect("REFERENCES"); err != nil {
+			return err
+		}
+		return p.foreignKeyClause()
+	}
+	return p.errorf("invalid table constraint")
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCreateTable(t *testing.T) {
+	for _, tc := range []struct {
+		sql     string
+		name    string
+		cols    []string
+		decls   []string
+		rowid   int
+		noRowid bool
+		pk      []string
+	}{
+		{
+			sql:   "CREATE TABLE tbl1(one varchar(10), two smallint)",
+			name:  "tbl1",
+			cols:  []string{"one", "two"},
+			decls: []string{"varchar(10)", "smallint"},
+			rowid: -1,
+		},
+		{
+			sql:   "CREATE TABLE tbl2(\n f1 varchar(30) primary key,\n f2 text,\n f3 real\n)",
+			name:  "tbl2",
+			cols:  []string{"f1", "f2", "f3"},
+			decls: []string{"varchar(30)", "text", "real"},
+			rowid: -1,
+			pk:    []string{"f1"},
+		},
+		{
+			sql:   `CREATE TABLE IF NOT EXISTS main."a table" (id INTEGER PRIMARY KEY AUTOINCREMENT, "x, y" DECIMAL(10, 2) NOT NULL DEFAULT -1.5, [z] CHECK (z > 0 AND (z < 10)), w TEXT COLLATE NOCASE REFERENCES other(id) ON DELETE CASCADE)`,
+			name:  "a table",
+			cols:  []string{"id", "x, y", "z", "w"},
+			decls: []string{"INTEGER", "DECIMAL(10, 2)", "", "TEXT"},
+			rowid: 0,
+			pk:    []string{"id"},
+		},
+		{
+			sql:   "CREATE TABLE t(a, b integer, c, PRIMARY KEY (b), UNIQUE (a, c), FOREIGN KEY (c) REFERENCES u(x), CHECK (a != b))",
+			name:  "t",
+			cols:  []string{"a", "b", "c"},
+			decls: []string{"", "integer", ""},
+			rowid: 1,
+			pk:    []string{"b"},
+		},
+		{
+			sql:   "CREATE TABLE t(a INTEGER PRIMARY KEY DESC, b)",
+			name:  "t",
+			cols:  []string{"a", "b"},
+			decls: []string{"INTEGER", ""},
+			rowid: -1,
+			pk:    []string{"a"},
+		},
+		{
+			sql:     "CREATE TABLE t(a INTEGER DEFAULT 0x10, b TEXT DEFAULT 'x', c AS (a+1) STORED, CONSTRAINT pk PRIMARY KEY (a, b)) WITHOUT ROWID, STRICT",
+			name:    "t",
+			cols:    []string{"a", "b", "c"},
+			decls:   []string{"INTEGER", "TEXT", ""},
+			rowid:   -1,
+			noRowid: true,
+			pk:      []string{"a", "b"},
+		},
+		{
+			sql:     "CREATE TABLE t(a INTEGER PRIMARY KEY) WITHOUT ROWID",
+			name:    "t",
+			cols:    []string{"a"},
+			decls:   []string{"INTEGER"},
+			rowid:   -1,
+			noRowid: true,
+			pk:      []string{"a"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tbl, err := parseCreateTable(tc.sql)
+			if err != nil {
+				t.Fatalf("could not parse %q: %v", tc.sql, err)
+			}
+			if tbl.name != tc.name {
+				t.Fatalf("invalid name: got=%q, want=%q", tbl.name, tc.name)
+			}
+			var cols, decls, pk []string
+			for _, col := range tbl.cols {
+				cols = append(cols, col.Name())
+				decls = append(decls, col.DeclType())
+			}
+			for _, col := range tbl.pk {
+				pk = append(pk, col.name)
+			}
+			if !reflect.DeepEqual(cols, tc.cols) {
+				t.Fatalf("invalid columns:\ngot= %q\nwant=%q", cols, tc.cols)
+			}
+			if !reflect.DeepEqual(decls, tc.decls) {
+				t.Fatalf("invalid types:\ngot= %q\nwant=%q", decls, tc.decls)
+			}
+			if !reflect.DeepEqual(pk, tc.pk) {
+				t.Fatalf("invalid primary key:\ngot= %q\nwant=%q", pk, tc.pk)
+			}
+			if tbl.rowid != tc.rowid {
+				t.Fatalf("invalid rowid alias: got=%d, want=%d", tbl.rowid, tc.rowid)
+			}
+			if tbl.withoutRowid != tc.noRowid {
+				t.Fatalf("invalid WITHOUT ROWID: got=%v, want=%v", tbl.withoutRowid, tc.noRowid)
+			}
+		})
+	}
+}
+
+func TestColumnAffinity(t *testing.T) {
+	tbl, err := parseCreateTable("CREATE TABLE t(a INT, b VARCHAR(10), c, d DOUBLE PRECISION, e DECIMAL(10,5), f FLOATING POINT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var affs []string
+	for _, col := range tbl.cols {
+		affs = append(affs, col.Affinity())
+	}
+	// FLOATING POINT holds "INT": its affinity is INTEGER, like in SQLite.
+	want := []string{"INTEGER", "TEXT", "BLOB", "REAL", "NUMERIC", "INTEGER"}
+	if !reflect.DeepEqual(affs, want) {
+		t.Fatalf("invalid affinities:\ngot= %q\nwant=%q", affs, want)
+	}
+}
+
+func TestParseCreateTableErrors(t *testing.T) {
+	for _, sql := range []string{
+		"",
+		"CREATE INDEX i ON t(a)",
+		"CREATE TABLE t",
+		"CREATE TABLE t(a",
+		"CREATE TABLE t(a CHECK (a > 0)",
+		"CREATE TABLE t(a) WITHOUT",
+		"CREATE TABLE t(a, 'unterminated)",
+	} {
+		_, err := parseCreateTable(sql)
+		if err == nil {
+			t.Errorf("expected an error parsing %q", sql)
+		}
+	}
+}
@@ -0,0 +1,1093 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// selectStmt is a parsed SELECT statement. The statement is a compound
+// SELECT if it is followed by other SELECT statements combined with it:
+// its ORDER BY and LIMIT clauses then apply to the whole compound SELECT.
+type selectStmt struct {
+	with     []*cteDef // common table expressions of the WITH clause
+	distinct bool
+	cols     []resultColumn
+	values   [][]expr    // rows of a VALUES clause, used instead of the columns
+	from     []*tableRef // tables of the FROM clause, in join order
+	where    expr
+	groupBy  []expr
+	having   expr
+	windows  map[string]*windowDef // windows of the WINDOW clause, by lower case name
+	compound []compoundTerm        // SELECT statements combined with this one
+	orderBy  []orderingTerm
+	limit    expr
+	offset   expr
+}
+
+// compoundTerm is a SELECT statement combined with the previous ones by
+// a compound operator.
+type compoundTerm struct {
+	op   string // "UNION", "UNION ALL", "INTERSECT" or "EXCEPT"
+	stmt *selectStmt
+}
+
+// cteDef is a common table expression of a WITH clause.
+type cteDef struct {
+	name string
+	cols []string // names of the columns, if given
+	stmt *selectStmt
+}
+
+// resultColumn is a column of the result of a SELECT statement.
+type resultColumn struct {
+	star  bool   // whether the column is * or table.*
+	table string // table qualifying *, if any
+	expr  expr
+	alias string
+	text  string // SQL text of the expression
+}
+
+// tableRef is a table of a FROM clause.
+type tableRef struct {
+	schema string
+	name   string
+	alias  string
+
+	// join of the table with the previous ones, if any.
+	left    bool     // whether the join is a LEFT JOIN
+	natural bool     // whether the join is a NATURAL JOIN
+	on      expr     // ON condition, if any
+	using   []string // columns of the USING clause, if any
+}
+
+// orderingTerm is a term of an ORDER BY clause.
+type orderingTerm struct {
+	expr  expr
+	desc  bool
+	nulls string // "FIRST", "LAST", or empty for the default order
+}
+
+// reserved holds the keywords that may not be used as unquoted names in
+// queries, nor as implicit aliases.
+var reserved = make(map[string]bool)
+
+func init() {
+	for _, kw := range strings.Fields(`
+		ALL AND AS ASC BETWEEN BY CASE CAST COLLATE CROSS DESC DISTINCT
+		ELSE END ESCAPE EXCEPT EXISTS FROM FULL GLOB GROUP HAVING IN INNER
+		INTERSECT IS ISNULL JOIN LEFT LIKE LIMIT NATURAL NOT NOTNULL NULL
+		OFFSET ON OR ORDER OUTER RIGHT SELECT THEN UNION USING WHEN WHERE
+		WINDOW WITH`) {
+		reserved[kw] = true
+	}
+}
+
+func isReserved(tok token) bool {
+	return tok.kind == tokIdent && reserved[strings.ToUpper(tok.text)]
+}
+
+// parseSelect parses a SELECT statement, optionally followed by a
+// semicolon.
+func parseSelect(sql string) (*selectStmt, []*param, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt, err := p.selectStmt()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.acceptOp(";")
+	if p.peek().kind != tokEOF {
+		return nil, nil, p.errorf("unexpected token")
+	}
+	return stmt, p.params, nil
+}
+
+// selectStmt parses a SELECT statement, with its optional WITH clause,
+// and the SELECT statements combined with it.
+func (p *parser) selectStmt() (*selectStmt, error) {
+	var with []*cteDef
+	if p.accept("WITH") {
+		p.accept("RECURSIVE")
+		var err error
+		if with, err = p.withClause(); err != nil {
+			return nil, err
+		}
+	}
+
+	stmt, err := p.selectCore()
+	if err != nil {
+		return nil, err
+	}
+	stmt.with = with
+	for {
+		op := p.compoundOp()
+		if op == "" {
+			break
+		}
+		core, err := p.selectCore()
+		if err != nil {
+			return nil, err
+		}
+		stmt.compound = append(stmt.compound, compoundTerm{op: op, stmt: core})
+	}
+
+	if p.accept("ORDER", "BY") {
+		for {
+			term, err := p.orderingTerm()
+			if err != nil {
+				return nil, err
+			}
+			stmt.orderBy = append(stmt.orderBy, term)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("LIMIT") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.limit = x
+		switch {
+		case p.accept("OFFSET"):
+			stmt.offset, err = p.expr()
+		case p.acceptOp(","):
+			// LIMIT offset, limit
+			stmt.offset = stmt.limit
+			stmt.limit, err = p.expr()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if op := p.peek(); op.is("UNION") || op.is("INTERSECT") || op.is("EXCEPT") {
+		clause := "ORDER BY"
+		if stmt.limit != nil {
+			clause = "LIMIT"
+		}
+		return nil, p.errorf("%s clause should come after %s not before", clause, strings.ToUpper(op.text))
+	}
+	return stmt, nil
+}
+
+// compoundOp consumes a compound operator, if any, and returns it.
+func (p *parser) compoundOp() string {
+	switch {
+	case p.accept("UNION", "ALL"):
+		return "UNION ALL"
+	case p.accept("UNION"):
+		return "UNION"
+	case p.accept("INTERSECT"):
+		return "INTERSECT"
+	case p.accept("EXCEPT"):
+		return "EXCEPT"
+	}
+	return ""
+}
+
+// withClause parses the common table expressions of a WITH clause.
+func (p *parser) withClause() ([]*cteDef, error) {
+	var ctes []*cteDef
+	for {
+		var (
+			def cteDef
+			err error
+		)
+		if def.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if p.peek().isOp("(") {
+			if def.cols, err = p.columnNames(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		// materialization hints do not change the result.
+		if !p.accept("MATERIALIZED") {
+			p.accept("NOT", "MATERIALIZED")
+		}
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		if def.stmt, err = p.selectStmt(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		ctes = append(ctes, &def)
+		if !p.acceptOp(",") {
+			return ctes, nil
+		}
+	}
+}
+
+// columnNames parses a parenthesized list of column names.
+func (p *parser) columnNames() ([]string, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return names, p.expectOp(")")
+}
+
+// selectCore parses a SELECT statement without its ORDER BY and LIMIT
+// clauses, or a VALUES clause.
+func (p *parser) selectCore() (*selectStmt, error) {
+	var stmt selectStmt
+	if p.accept("VALUES") {
+		return &stmt, p.valuesClause(&stmt)
+	}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept("DISTINCT") {
+		stmt.distinct = true
+	} else {
+		p.accept("ALL")
+	}
+
+	for {
+		col, err := p.resultColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.cols = append(stmt.cols, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if p.accept("FROM") {
+		refs, err := p.fromClause()
+		if err != nil {
+			return nil, err
+		}
+		stmt.from = refs
+	}
+
+	if p.accept("WHERE") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.where = x
+	}
+
+	if p.accept("GROUP", "BY") {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.groupBy = append(stmt.groupBy, x)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("HAVING") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.having = x
+	}
+
+	if p.accept("WINDOW") {
+		stmt.windows = make(map[string]*windowDef)
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("AS"); err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			def, err := p.windowDef()
+			if err != nil {
+				return nil, err
+			}
+			stmt.windows[strings.ToLower(name)] = def
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	return &stmt, nil
+}
+
+// valuesClause parses the rows of a VALUES clause, after the VALUES
+// keyword.
+func (p *parser) valuesClause(stmt *selectStmt) error {
+	for {
+		if err := p.expectOp("("); err != nil {
+			return err
+		}
+		var row []expr
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return err
+			}
+			row = append(row, x)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return err
+		}
+		if len(stmt.values) > 0 && len(row) != len(stmt.values[0]) {
+			return p.errorf("all VALUES must have the same number of terms")
+		}
+		stmt.values = append(stmt.values, row)
+		if !p.acceptOp(",") {
+			return nil
+		}
+	}
+}
+
+// resultColumn parses a result column of a SELECT statement.
+func (p *parser) resultColumn() (resultColumn, error) {
+	var col resultColumn
+	switch {
+	case p.acceptOp("*"):
+		col.star = true
+		return col, nil
+	case p.peek().isName() && p.peekN(1).isOp(".") && p.peekN(2).isOp("*"):
+		col.star = true
+		col.table = p.next().text
+		p.pos += 2
+		return col, nil
+	}
+
+	beg := p.peek().pos
+	x, err := p.expr()
+	if err != nil {
+		return col, err
+	}
+	col.expr = x
+	col.text = strings.TrimSpace(p.src[beg:p.peek().pos])
+
+	if alias, ok, err := p.alias(); err != nil || ok {
+		col.alias = alias
+		return col, err
+	}
+	return col, nil
+}
+
+// alias parses an optional alias: a name, optionally preceded by AS.
+func (p *parser) alias() (string, bool, error) {
+	if p.accept("AS") {
+		name, err := p.name()
+		return name, true, err
+	}
+	tok := p.peek()
+	if tok.isName() && !isReserved(tok) {
+		p.next()
+		return tok.text, true, nil
+	}
+	return "", false, nil
+}
+
+// fromClause parses the tables of a FROM clause, and their joins.
+func (p *parser) fromClause() ([]*tableRef, error) {
+	var (
+		refs    []*tableRef
+		left    bool
+		natural bool
+	)
+	for {
+		ref, err := p.tableRef()
+		if err != nil {
+			return nil, err
+		}
+		ref.left, ref.natural = left, natural
+		if len(refs) > 0 {
+			if err := p.joinConstraint(ref); err != nil {
+				return nil, err
+			}
+		}
+		refs = append(refs, ref)
+
+		left, natural = false, p.accept("NATURAL")
+		switch {
+		case !natural && p.acceptOp(","):
+		case p.accept("JOIN"), p.accept("INNER", "JOIN"), p.accept("CROSS", "JOIN"):
+		case p.accept("LEFT", "JOIN"), p.accept("LEFT", "OUTER", "JOIN"):
+			left = true
+		case p.peek().is("RIGHT") || p.peek().is("FULL"):
+			return nil, p.errorf("RIGHT and FULL OUTER JOINs are not supported")
+		case natural:
+			return nil, p.errorf("expected JOIN")
+		default:
+			return refs, nil
+		}
+	}
+}
+
+// joinConstraint parses the optional ON or USING clause of the join of
+// the table ref.
+func (p *parser) joinConstraint(ref *tableRef) error {
+	switch {
+	case p.accept("ON"):
+		x, err := p.expr()
+		if err != nil {
+			return err
+		}
+		ref.on = x
+	case p.accept("USING"):
+		if err := p.expectOp("("); err != nil {
+			return err
+		}
+		for {
+			name, err := p.name()
+			if err != nil {
+				return err
+			}
+			ref.using = append(ref.using, name)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	if ref.natural {
+		return p.errorf("a NATURAL join may not have an ON or USING clause")
+	}
+	return nil
+}
+
+// tableRef parses a table of a FROM clause.
+func (p *parser) tableRef() (*tableRef, error) {
+	var (
+		ref tableRef
+		err error
+	)
+	ref.schema, ref.name, err = p.qualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	alias, _, err := p.alias()
+	if err != nil {
+		return nil, err
+	}
+	ref.alias = alias
+	return &ref, nil
+}
+
+// orderingTerm parses a term of an ORDER BY clause.
+func (p *parser) orderingTerm() (orderingTerm, error) {
+	var term orderingTerm
+	x, err := p.expr()
+	if err != nil {
+		return term, err
+	}
+	term.expr = x
+	switch {
+	case p.accept("ASC"):
+	case p.accept("DESC"):
+		term.desc = true
+	}
+	switch {
+	case p.accept("NULLS", "FIRST"):
+		term.nulls = "FIRST"
+	case p.accept("NULLS", "LAST"):
+		term.nulls = "LAST"
+	}
+	return term, nil
+}
+
+// expr parses an expression.
+func (p *parser) expr() (expr, error) {
+	x, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		y, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "OR", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) andExpr() (expr, error) {
+	x, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		y, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "AND", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) notExpr() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.equality()
+}
+
+// equality parses the operators with the precedence of =: IS, IN, LIKE,
+// GLOB, BETWEEN, ISNULL and NOTNULL.
+func (p *parser) equality() (expr, error) {
+	x, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptOp("="), p.acceptOp("=="):
+			op = "="
+		case p.acceptOp("!="), p.acceptOp("<>"):
+			op = "!="
+		case p.accept("IS"):
+			op = "IS"
+			if p.accept("NOT") {
+				op = "IS NOT"
+			}
+		case p.accept("ISNULL"):
+			x = &unaryExpr{op: "ISNULL", x: x}
+			continue
+		case p.accept("NOTNULL"), p.accept("NOT", "NULL"):
+			x = &unaryExpr{op: "NOTNULL", x: x}
+			continue
+		}
+		if op != "" {
+			y, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			x = &binaryExpr{op: op, x: x, y: y}
+			continue
+		}
+
+		not := false
+		if next := p.peekN(1); p.peek().is("NOT") &&
+			(next.is("IN") || next.is("LIKE") || next.is("GLOB") || next.is("BETWEEN")) {
+			p.next()
+			not = true
+		}
+		switch {
+		case p.accept("IN"):
+			x, err = p.inExpr(x, not)
+		case p.peek().is("LIKE") || p.peek().is("GLOB"):
+			x, err = p.likeExpr(x, not)
+		case p.accept("BETWEEN"):
+			x, err = p.betweenExpr(x, not)
+		default:
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) inExpr(x expr, not bool) (expr, error) {
+	in := &inExpr{x: x, not: not}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if p.acceptOp(")") {
+		return in, nil
+	}
+	for {
+		item, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		in.list = append(in.list, item)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (p *parser) likeExpr(x expr, not bool) (expr, error) {
+	like := &likeExpr{op: strings.ToUpper(p.next().text), x: x, not: not}
+	pat, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	like.pattern = pat
+	if like.op == "LIKE" && p.accept("ESCAPE") {
+		esc, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		like.escape = esc
+	}
+	return like, nil
+}
+
+func (p *parser) betweenExpr(x expr, not bool) (expr, error) {
+	lo, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AND"); err != nil {
+		return nil, err
+	}
+	hi, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	return &betweenExpr{not: not, x: x, lo: lo, hi: hi}, nil
+}
+
+// binaryLevels lists the binary operators with a precedence higher than
+// the comparison ones, from the lowest to the highest precedence.
+var binaryLevels = [][]string{
+	{"<", "<=", ">", ">="},
+	{"&", "|", "<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"||"},
+}
+
+func (p *parser) comparison() (expr, error) {
+	return p.binary(0)
+}
+
+// binary parses the binary operators of the precedence level, and
+// higher.
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range binaryLevels[level] {
+			if p.acceptOp(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	for _, op := range []string{"-", "+", "~"} {
+		if !p.acceptOp(op) {
+			continue
+		}
+		if op == "-" && p.peek().kind == tokInt && p.peek().text == "9223372036854775808" {
+			// the smallest integer, whose absolute value overflows.
+			p.next()
+			return &literal{v: int64(-1 << 63)}, nil
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, x: x}, nil
+	}
+
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("COLLATE") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		x = &collateExpr{x: x, name: name}
+	}
+	return x, nil
+}
+
+// primary parses a literal, a parameter, a column reference, a function
+// call, a parenthesized expression, or a CAST or CASE expression.
+func (p *parser) primary() (expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokInt, tokFloat:
+		p.next()
+		v, err := parseNumber(tok.text, false)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		if tok.kind == tokFloat {
+			if i, ok := v.(int64); ok {
+				v = float64(i)
+			}
+		}
+		return &literal{v: v}, nil
+	case tokString:
+		p.next()
+		return &literal{v: tok.text}, nil
+	case tokBlob:
+		p.next()
+		v, err := hex.DecodeString(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid blob literal")
+		}
+		return &literal{v: v}, nil
+	case tokParam:
+		p.next()
+		return p.param(tok)
+	}
+
+	switch {
+	case p.acceptOp("("):
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case p.accept("NULL"):
+		return &literal{}, nil
+	case p.accept("TRUE"):
+		return &literal{v: int64(1)}, nil
+	case p.accept("FALSE"):
+		return &literal{v: int64(0)}, nil
+	case p.accept("CAST"):
+		return p.castExpr()
+	case p.accept("CASE"):
+		return p.caseExpr()
+	}
+
+	// like and glob are keywords, but also the names of functions.
+	fn := (tok.is("LIKE") || tok.is("GLOB")) && p.peekN(1).isOp("(")
+	if !fn && (tok.kind != tokIdent && tok.kind != tokQIdent || isReserved(tok)) {
+		return nil, p.errorf("syntax error")
+	}
+	p.next()
+	if p.acceptOp("(") {
+		return p.funcCall(tok.text)
+	}
+	ref := &colRef{name: tok.text, quoted: tok.kind == tokQIdent}
+	if p.peek().isOp(".") && p.peekN(1).isName() {
+		p.next()
+		ref.table = ref.name
+		ref.name = p.next().text
+		ref.quoted = false
+	}
+	return ref, nil
+}
+
+// param returns the parameter of the token tok. Anonymous parameters are
+// numbered after the largest index used so far, and named parameters
+// share the index of their first occurrence.
+func (p *parser) param(tok token) (expr, error) {
+	max := 0
+	for _, prm := range p.params {
+		if prm.index > max {
+			max = prm.index
+		}
+	}
+	x := &param{name: tok.text, index: max + 1}
+	switch {
+	case tok.text == "?":
+	case tok.text[0] == '?':
+		n, err := strconv.Atoi(tok.text[1:])
+		if err != nil || n < 1 || n > 32766 {
+			return nil, p.errorf("variable number must be between ?1 and ?32766")
+		}
+		x.index = n
+	default:
+		for _, prm := range p.params {
+			if prm.name == tok.text {
+				x.index = prm.index
+				break
+			}
+		}
+	}
+	p.params = append(p.params, x)
+	return x, nil
+}
+
+// funcCall parses the arguments of a call of the function name, after
+// the opening parenthesis.
+func (p *parser) funcCall(name string) (expr, error) {
+	fn := &funcExpr{name: name}
+	switch {
+	case p.acceptOp("*"):
+		fn.star = true
+	case p.peek().isOp(")"):
+	default:
+		fn.distinct = p.accept("DISTINCT")
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			fn.args = append(fn.args, arg)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if p.accept("OVER") {
+		if !p.acceptOp("(") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn.over = &windowDef{base: name}
+			return fn, nil
+		}
+		def, err := p.windowDef()
+		if err != nil {
+			return nil, err
+		}
+		fn.over = def
+	}
+	return fn, nil
+}
+
+// windowDef parses a window definition, after its opening parenthesis.
+func (p *parser) windowDef() (*windowDef, error) {
+	var def windowDef
+	if tok := p.peek(); tok.isName() && !tok.is("PARTITION") && !tok.is("ORDER") && frameUnit(tok) == "" {
+		def.base = p.next().text
+	}
+	if p.accept("PARTITION", "BY") {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			def.partition = append(def.partition, x)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("ORDER", "BY") {
+		for {
+			term, err := p.orderingTerm()
+			if err != nil {
+				return nil, err
+			}
+			def.orderBy = append(def.orderBy, term)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if unit := frameUnit(p.peek()); unit != "" {
+		p.next()
+		frame, err := p.frameSpec(unit)
+		if err != nil {
+			return nil, err
+		}
+		def.frame = frame
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// frameUnit returns the frame unit of the token tok, ROWS, RANGE or
+// GROUPS, or "".
+func frameUnit(tok token) string {
+	for _, unit := range []string{"ROWS", "RANGE", "GROUPS"} {
+		if tok.is(unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// frameSpec parses the frame specification of a window, after its unit.
+func (p *parser) frameSpec(unit string) (*frameSpec, error) {
+	var (
+		frame = &frameSpec{unit: unit, end: frameBound{kind: frameCurrent}, exclude: "NO OTHERS"}
+		err   error
+	)
+	between := p.accept("BETWEEN")
+	if frame.start, err = p.frameBound(); err != nil {
+		return nil, err
+	}
+	if between {
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		if frame.end, err = p.frameBound(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case frame.start.kind == frameUnboundedFollowing, frame.end.kind == frameUnboundedPreceding:
+		return nil, p.errorf("syntax error")
+	case frame.start.kind > frame.end.kind:
+		return nil, p.errorf("unsupported frame specification")
+	}
+
+	if p.accept("EXCLUDE") {
+		switch {
+		case p.accept("NO", "OTHERS"):
+		case p.accept("CURRENT", "ROW"):
+			frame.exclude = "CURRENT ROW"
+		case p.accept("GROUP"):
+			frame.exclude = "GROUP"
+		case p.accept("TIES"):
+			frame.exclude = "TIES"
+		default:
+			return nil, p.errorf("syntax error")
+		}
+	}
+	return frame, nil
+}
+
+// frameBound parses a bound of a window frame.
+func (p *parser) frameBound() (frameBound, error) {
+	switch {
+	case p.accept("UNBOUNDED", "PRECEDING"):
+		return frameBound{kind: frameUnboundedPreceding}, nil
+	case p.accept("UNBOUNDED", "FOLLOWING"):
+		return frameBound{kind: frameUnboundedFollowing}, nil
+	case p.accept("CURRENT", "ROW"):
+		return frameBound{kind: frameCurrent}, nil
+	}
+	x, err := p.expr()
+	if err != nil {
+		return frameBound{}, err
+	}
+	switch {
+	case p.accept("PRECEDING"):
+		return frameBound{kind: framePreceding, x: x}, nil
+	case p.accept("FOLLOWING"):
+		return frameBound{kind: frameFollowing, x: x}, nil
+	}
+	return frameBound{}, p.errorf("expected PRECEDING or FOLLOWING")
+}
+
+// castExpr parses a CAST expression, after the CAST keyword.
+func (p *parser) castExpr() (expr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AS"); err != nil {
+		return nil, err
+	}
+	var typ []string
+	for p.peek().isName() {
+		typ = append(typ, p.next().text)
+	}
+	if len(typ) > 0 && p.peek().isOp("(") {
+		if _, err := p.skipParens(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	name := strings.Join(typ, " ")
+	return &castExpr{x: x, typ: name, aff: declAffinity(name)}, nil
+}
+
+// caseExpr parses a CASE expression, after the CASE keyword.
+func (p *parser) caseExpr() (expr, error) {
+	var (
+		x   caseExpr
+		err error
+	)
+	if !p.peek().is("WHEN") {
+		x.x, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for p.accept("WHEN") {
+		var w whenClause
+		w.cond, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		w.then, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+		x.whens = append(x.whens, w)
+	}
+	if len(x.whens) == 0 {
+		return nil, p.errorf("expected WHEN")
+	}
+	if p.accept("ELSE") {
+		x.els, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("END"); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
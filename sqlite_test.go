@@ -7,7 +7,10 @@ package sqlite3
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"sync"
 	"testing"
 
 	"io/ioutil"
@@ -206,3 +209,133 @@ func TestFileOpen(t *testing.T) {
 	}
 
 }
+
+// readSeeker hides the io.ReaderAt interface of the wrapped value.
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+func TestConcurrentReads(t *testing.T) {
+	const fname = "testdata/chrome-history.sqlite"
+
+	dump := func(db *DbFile) (string, error) {
+		var out bytes.Buffer
+		for _, table := range db.Tables() {
+			err := db.VisitTableRecords(table.Name(), func(rowid *int64, rec Record) error {
+				fmt.Fprintf(&out, "%s[%d]: %v\n", table.Name(), *rowid, rec.Values)
+				return nil
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		return out.String(), nil
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Close()
+
+	want, err := dump(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		open func() (*DbFile, error)
+	}{
+		{
+			name: "OpenReaderAt",
+			open: func() (*DbFile, error) {
+				return OpenReaderAt(bytes.NewReader(raw), int64(len(raw)))
+			},
+		},
+		{
+			name: "OpenFrom",
+			open: func() (*DbFile, error) {
+				return OpenFrom(readSeeker{bytes.NewReader(raw)})
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := test.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			const n = 8
+			var wg sync.WaitGroup
+			errs := make([]error, n)
+			outs := make([]string, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					outs[i], errs[i] = dump(db)
+				}(i)
+			}
+			wg.Wait()
+
+			for i := 0; i < n; i++ {
+				if errs[i] != nil {
+					t.Fatalf("goroutine %d: %v", i, errs[i])
+				}
+				if outs[i] != want {
+					t.Fatalf("goroutine %d: invalid dump", i)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenMmap(t *testing.T) {
+	for _, fname := range []string{
+		"testdata/test-2.sqlite",
+		"testdata/chrome-history.sqlite",
+		"testdata/safari-partial.sqlite",
+	} {
+		t.Run(fname, func(t *testing.T) {
+			ref, err := Open(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+
+			db, err := Open(fname, WithMmap())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			for _, table := range ref.Tables() {
+				var want, got []Record
+				err := ref.VisitTableRecords(table.Name(), func(_ *int64, rec Record) error {
+					want = append(want, rec)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = db.VisitTableRecords(table.Name(), func(_ *int64, rec Record) error {
+					got = append(got, rec)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("table %q: records differ", table.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TableStats holds the statistics of a table, collected by the ANALYZE
+// statement of SQLite in the sqlite_stat1 table.
+type TableStats struct {
+	Rows int64 // estimated number of rows of the table
+
+	// PrimaryKey holds the statistics of the primary key of a WITHOUT
+	// ROWID table, if any.
+	PrimaryKey *IndexStats
+}
+
+// IndexStats holds the statistics of an index, collected by the ANALYZE
+// statement of SQLite in the sqlite_stat1 and sqlite_stat4 tables.
+type IndexStats struct {
+	Rows int64 // estimated number of entries of the index
+
+	// AvgEq holds, for each i, the average number of entries having the
+	// same values of the first i+1 indexed columns.
+	AvgEq []int64
+
+	// Unordered reports whether the index may not be used for range
+	// lookups.
+	Unordered bool
+
+	// Samples holds sample keys of the index, in index order, when the
+	// database holds a sqlite_stat4 table.
+	Samples []IndexSample
+}
+
+// IndexSample is a sample key of an index, with the counts of the entries
+// of the index sorting before and with it.
+type IndexSample struct {
+	// Key holds the values of the indexed columns, followed by the rowid
+	// or by the primary key columns of the table.
+	Key []interface{}
+
+	// Eq, Lt and DistinctLt hold, for each i, the number of entries whose
+	// first i+1 values are equal to those of Key, the number of entries
+	// whose first i+1 values sort before those of Key, and the number of
+	// distinct first i+1 values sorting before those of Key.
+	Eq         []int64
+	Lt         []int64
+	DistinctLt []int64
+}
+
+// Stats returns the statistics of the table, or nil if the table was not
+// analyzed.
+func (t *Table) Stats() *TableStats {
+	return t.db.stats[strings.ToLower(t.name)]
+}
+
+// Stats returns the statistics of the index, or nil if the index was not
+// analyzed.
+func (idx *Index) Stats() *IndexStats {
+	return idx.stats
+}
+
+// initStats loads the statistics of the sqlite_stat1 and sqlite_stat4
+// tables, if any. Like SQLite, it ignores the malformed ones and those of
+// unknown tables or indexes.
+func (db *DbFile) initStats() error {
+	if db.Table("sqlite_stat1") == nil {
+		return nil
+	}
+	db.stats = make(map[string]*TableStats)
+	err := db.VisitTableRecords("sqlite_stat1", func(_ *int64, rec Record) error {
+		if len(rec.Values) != 3 {
+			return nil
+		}
+		tbl, _ := rec.Values[0].(string)
+		stat, _ := rec.Values[2].(string)
+		t := db.Table(tbl)
+		if t == nil || stat == "" {
+			return nil
+		}
+		st, ok := parseStat1(stat)
+		if !ok {
+			return nil
+		}
+		ts := db.stats[strings.ToLower(t.name)]
+		if ts == nil {
+			ts = &TableStats{Rows: -1}
+			db.stats[strings.ToLower(t.name)] = ts
+		}
+
+		name, _ := rec.Values[1].(string)
+		switch idx := db.Index(name); {
+		case rec.Values[1] == nil:
+		case strings.EqualFold(name, t.name) && t.withoutRowid:
+			ts.PrimaryKey = st
+		case idx != nil && strings.EqualFold(idx.table, t.name):
+			idx.stats = st
+			if idx.partial {
+				// a partial index only holds some of the rows.
+				return nil
+			}
+		default:
+			return nil
+		}
+		if ts.Rows < 0 {
+			ts.Rows = st.Rows
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name, ts := range db.stats {
+		if ts.Rows < 0 {
+			delete(db.stats, name)
+		}
+	}
+
+	if db.Table("sqlite_stat4") == nil {
+		return nil
+	}
+	return db.VisitTableRecords("sqlite_stat4", func(_ *int64, rec Record) error {
+		if len(rec.Values) != 6 {
+			return nil
+		}
+		tbl, _ := rec.Values[0].(string)
+		name, _ := rec.Values[1].(string)
+		st, keys := db.sampledIndex(tbl, name)
+		if st == nil {
+			return nil
+		}
+		var s IndexSample
+		for i, counts := range []*[]int64{&s.Eq, &s.Lt, &s.DistinctLt} {
+			text, _ := rec.Values[2+i].(string)
+			*counts = parseCounts(text)
+		}
+		sample, ok := rec.Values[5].([]byte)
+		if !ok {
+			return nil
+		}
+		key, err := decodeRecord(sample, db.header.DbEncoding, false, nil)
+		if err != nil {
+			return nil
+		}
+		s.Key = make([]interface{}, len(key.Values))
+		for i, v := range key.Values {
+			s.Key[i] = columnValue(v, affNone)
+		}
+		n := len(s.Key)
+		if n < len(keys) || len(s.Eq) != n || len(s.Lt) != n || len(s.DistinctLt) != n {
+			return nil
+		}
+		i := sort.Search(len(st.Samples), func(i int) bool {
+			return compareKey(keys, st.Samples[i].Key, s.Key[:len(keys)]) > 0
+		})
+		st.Samples = append(st.Samples, IndexSample{})
+		copy(st.Samples[i+1:], st.Samples[i:])
+		st.Samples[i] = s
+		return nil
+	})
+}
+
+// sampledIndex returns the statistics of the index name of the table
+// tbl, and how its keys compare, for loading its samples.
+func (db *DbFile) sampledIndex(tbl, name string) (*IndexStats, []keyColumn) {
+	t := db.Table(tbl)
+	if t == nil {
+		return nil, nil
+	}
+	var (
+		st   *IndexStats
+		cols []indexedColumn
+	)
+	switch idx := db.Index(name); {
+	case strings.EqualFold(name, t.name) && t.withoutRowid:
+		if ts := t.Stats(); ts != nil {
+			st, cols = ts.PrimaryKey, t.pk
+		}
+	case idx != nil && strings.EqualFold(idx.table, t.name):
+		st, cols = idx.stats, idx.cols
+	}
+	if st == nil {
+		return nil, nil
+	}
+	keys, err := t.keyColumns(cols)
+	if err != nil {
+		return nil, nil
+	}
+	return st, keys
+}
+
+// parseStat1 parses the stat column of the sqlite_stat1 table: the number
+// of entries, then the average number of entries per distinct values of
+// the first indexed columns, then options such as "unordered".
+func parseStat1(stat string) (*IndexStats, bool) {
+	fields := strings.Fields(stat)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	st := &IndexStats{}
+	for i, f := range fields {
+		n, err := strconv.ParseInt(f, 10, 64)
+		switch {
+		case err == nil && i == len(st.AvgEq)+1:
+			st.AvgEq = append(st.AvgEq, n)
+		case err == nil && i == 0:
+			st.Rows = n
+		case i == 0:
+			return nil, false
+		case f == "unordered":
+			st.Unordered = true
+		}
+	}
+	return st, true
+}
+
+// parseCounts parses a list of integers of the sqlite_stat4 table.
+func parseCounts(text string) []int64 {
+	fields := strings.Fields(text)
+	counts := make([]int64, len(fields))
+	for i, f := range fields {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil
+		}
+		counts[i] = n
+	}
+	return counts
+}
+
+// avgEq returns the average number of entries having the same values of
+// the first m indexed columns.
+func (st *IndexStats) avgEq(m int) float64 {
+	if m <= len(st.AvgEq) {
+		return float64(st.AvgEq[m-1])
+	}
+	return float64(st.Rows)
+}
+
+// unsampledEq returns the estimated number of entries whose first m
+// values are given ones, not found in the samples: like SQLite, the
+// entries of the sampled values are left out of the average.
+func (st *IndexStats) unsampledEq(m int) float64 {
+	var (
+		last     = st.Samples[len(st.Samples)-1]
+		rows     = last.Lt[m-1] + last.Eq[m-1]
+		distinct = last.DistinctLt[m-1] + 1
+		sumEq    int64
+		sampled  int64
+	)
+	for i, s := range st.Samples {
+		if i == len(st.Samples)-1 || s.DistinctLt[m-1] != st.Samples[i+1].DistinctLt[m-1] {
+			sumEq += s.Eq[m-1]
+			sampled++
+		}
+	}
+	if distinct > sampled && sumEq < rows {
+		return float64(rows-sumEq) / float64(distinct-sampled)
+	}
+	return st.avgEq(m)
+}
+
+// eqEntries returns the estimated number of entries whose first values
+// are those of key, compared like keys, from the samples.
+func (st *IndexStats) eqEntries(keys []keyColumn, key []interface{}) float64 {
+	m := len(key)
+	for _, s := range st.Samples {
+		if compareKey(keys, s.Key, key) == 0 {
+			return float64(s.Eq[m-1])
+		}
+	}
+	return st.unsampledEq(m)
+}
+
+// rank returns the estimated number of entries whose first values sort
+// before key or, with after, before or equal to key, from the samples.
+func (st *IndexStats) rank(keys []keyColumn, key []interface{}, after bool) float64 {
+	m := len(key)
+	if m == 0 {
+		if after {
+			return float64(st.Rows)
+		}
+		return 0
+	}
+	lo, hi := 0.0, float64(st.Rows)
+	for _, s := range st.Samples {
+		switch c := compareKey(keys, s.Key, key); {
+		case c == 0:
+			n := s.Lt[m-1]
+			if after {
+				n += s.Eq[m-1]
+			}
+			return float64(n)
+		case c < 0:
+			if n := float64(s.Lt[m-1] + s.Eq[m-1]); n > lo {
+				lo = n
+			}
+		default:
+			if n := float64(s.Lt[m-1]); n < hi {
+				hi = n
+			}
+		}
+	}
+	return (lo + hi) / 2
+}
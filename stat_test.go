@@ -0,0 +1,237 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	db, err := Open("testdata/stat.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		table string
+		rows  int64
+	}{
+		{"events", 2000},
+		{"tags", 125},
+		{"notes", 10},
+	} {
+		st := db.Table(tc.table).Stats()
+		if st == nil {
+			t.Errorf("%s: no statistics", tc.table)
+			continue
+		}
+		if st.Rows != tc.rows {
+			t.Errorf("%s: got %d rows, want %d", tc.table, st.Rows, tc.rows)
+		}
+	}
+	if pk := db.Table("tags").Stats().PrimaryKey; pk == nil || !reflect.DeepEqual(pk.AvgEq, []int64{3, 1}) {
+		t.Errorf("invalid statistics of the primary key of tags: %+v", pk)
+	}
+
+	st := db.Index("events_kind").Stats()
+	if st == nil {
+		t.Fatal("no statistics for events_kind")
+	}
+	if st.Rows != 2000 || !reflect.DeepEqual(st.AvgEq, []int64{667}) || st.Unordered {
+		t.Errorf("invalid statistics for events_kind: %+v", st)
+	}
+	if len(st.Samples) != 24 {
+		t.Fatalf("got %d samples, want 24", len(st.Samples))
+	}
+	want := IndexSample{
+		Key:        []interface{}{"click", int64(540)},
+		Eq:         []int64{80, 1},
+		Lt:         []int64{20, 41},
+		DistinctLt: []int64{1, 41},
+	}
+	if got := st.Samples[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid first sample:\ngot:  %+v\nwant: %+v", got, want)
+	}
+	if got := st.Samples[23].Key; !reflect.DeepEqual(got, []interface{}{"view", int64(1955)}) {
+		t.Errorf("invalid last sample: %v", got)
+	}
+
+	// events_ts was created after the ANALYZE statement.
+	if st := db.Index("events_ts").Stats(); st != nil {
+		t.Errorf("statistics for events_ts: %+v", st)
+	}
+
+	db2, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	if st := db2.Table("urls").Stats(); st != nil {
+		t.Errorf("statistics for a table not analyzed: %+v", st)
+	}
+}
+
+func TestParseStat1(t *testing.T) {
+	for _, tc := range []struct {
+		stat string
+		want *IndexStats
+	}{
+		{"10", &IndexStats{Rows: 10}},
+		{"100 10 1", &IndexStats{Rows: 100, AvgEq: []int64{10, 1}}},
+		{"100 25 unordered", &IndexStats{Rows: 100, AvgEq: []int64{25}, Unordered: true}},
+		{"100 25 sz=12 noskipscan 3", &IndexStats{Rows: 100, AvgEq: []int64{25}}},
+		{"", nil},
+		{"many 10", nil},
+	} {
+		got, ok := parseStat1(tc.stat)
+		if ok != (tc.want != nil) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %+v, %v, want %+v", tc.stat, got, ok, tc.want)
+		}
+	}
+}
+
+func TestExplainStats(t *testing.T) {
+	db, err := Open("testdata/stat.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 1900 of the 2000 events are views, 80 are clicks and 20 are buys,
+	// of each of 100 users.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select * from events where kind = 'view'`,
+			want:  "SCAN events",
+		},
+		{
+			query: `select * from events where kind = 'buy'`,
+			want:  "SEARCH events USING INDEX events_kind (kind=?)",
+		},
+		{
+			query: `select * from events where kind = 'click'`,
+			want:  "SEARCH events USING INDEX events_kind (kind=?)",
+		},
+		{
+			// the value of the parameter is not known.
+			query: `select * from events where kind = ?`,
+			want:  "SCAN events",
+		},
+		{
+			query: `select * from events where user between 1 and 90`,
+			want:  "SCAN events",
+		},
+		{
+			query: `select * from events where user < 5`,
+			want:  "SEARCH events USING INDEX events_user_ts (user<?)",
+		},
+		{
+			query: `select * from events where user = 7 and ts > 1000000`,
+			want:  "SEARCH events USING INDEX events_user_ts (user=? AND ts>?)",
+		},
+		{
+			// events_ts has no statistics.
+			query: `select * from events where ts > 1119000`,
+			want:  "SCAN events",
+		},
+		{
+			query: `select * from tags where user > 3`,
+			want:  "SEARCH tags USING PRIMARY KEY (user>?)",
+		},
+	} {
+		plan, err := db.Explain(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if got := plan.Nodes[0].Detail; len(plan.Nodes) != 1 || got != tc.want {
+			t.Errorf("%s:\ngot:  %s\nwant: %s", tc.query, plan, tc.want)
+		}
+	}
+}
+
+func TestQueryAnalyzed(t *testing.T) {
+	db, err := Open("testdata/stat.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select count(*), min(id), max(id) from events where kind = 'view'`,
+			want: `1900|1|1999
+`,
+		},
+		{
+			query: `select id, user, ts from events where kind = 'buy' order by id limit 3`,
+			want: `100|100|1006000
+200|100|1012000
+300|100|1018000
+`,
+		},
+		{
+			query: `select count(*) from events where user between 1 and 90`,
+			want: `1800
+`,
+		},
+		{
+			query: `select id, kind from events where user < 3 order by id limit 5`,
+			want: `1|view
+2|view
+101|view
+102|view
+201|view
+`,
+		},
+		{
+			query: `select id from events where user = 7 and ts > 1100000 order by id`,
+			want: `1707
+1807
+1907
+`,
+		},
+		{
+			query: `select count(*) from events where ts > 1119000`,
+			want: `17
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+
+	// the bound values of parameters are used to plan the query.
+	for _, tc := range []struct {
+		kind string
+		want int64
+	}{
+		{"buy", 20},
+		{"view", 2000},
+	} {
+		rows, err := db.Query(`select * from events where kind = ?`, tc.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got := rows.Stats().RowsExamined; got != tc.want {
+			t.Errorf("%s: got %d rows examined, want %d", tc.kind, got, tc.want)
+		}
+	}
+}
@@ -60,7 +60,7 @@ func (st SerialType) String() string {
 		return fmt.Sprintf("StText(%d)", sz)
 	}
 
-	panic("unreachable")
+	return fmt.Sprintf("SerialType(%d)", int(st))
 }
 
 func (st SerialType) IsBlob() bool {
@@ -6,13 +6,27 @@ package sqlite3
 
 import (
 	"reflect"
+	"strings"
 )
 
 // Table is a SQLite table
 type Table struct {
+	db     *DbFile
 	name   string
 	pageid int
 	cols   []Column
+	sql    string
+
+	rowid        int // index of the INTEGER PRIMARY KEY column, or -1
+	withoutRowid bool
+	pk           []indexedColumn // PRIMARY KEY columns
+	keys         []tableKey      // PRIMARY KEY and UNIQUE constraints, in order
+}
+
+// tableKey is a PRIMARY KEY or UNIQUE constraint of a table.
+type tableKey struct {
+	cols []indexedColumn
+	pk   bool
 }
 
 // Name returns the name of the table
@@ -32,10 +46,70 @@ func (t *Table) Columns() []Column {
 	return t.cols
 }
 
+// SQL returns the CREATE TABLE statement of the table
+func (t *Table) SQL() string {
+	return t.sql
+}
+
+// RowidAlias returns the index of the INTEGER PRIMARY KEY column, an
+// alias for the rowid, or -1 if the table has no such column.
+//
+// The value of that column is not stored in the table records: record
+// values at that index are nil and the rowid should be used instead.
+func (t *Table) RowidAlias() int {
+	return t.rowid
+}
+
+// WithoutRowid reports whether the table is a WITHOUT ROWID table
+func (t *Table) WithoutRowid() bool {
+	return t.withoutRowid
+}
+
+// colIndex returns the index of the named column, or -1.
+func (t *Table) colIndex(name string) int {
+	for i := range t.cols {
+		if strings.EqualFold(t.cols[i].name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// recordColumns returns the index of the table column of each value of
+// the records of a WITHOUT ROWID table: the records hold the primary key
+// columns first, followed by the other columns.
+func (t *Table) recordColumns() []int {
+	var (
+		order []int
+		seen  = make(map[int]bool)
+	)
+	for _, pk := range t.pk {
+		i := t.colIndex(pk.name)
+		if i < 0 || seen[i] {
+			continue
+		}
+		seen[i] = true
+		order = append(order, i)
+	}
+	for i := range t.cols {
+		if !seen[i] {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
 // Column describes a column in a SQLite table
 type Column struct {
 	name string
 	typ  reflect.Type
+
+	decl      string      // declared type
+	dflt      interface{} // literal default value
+	collate   string      // collating sequence
+	notNull   bool
+	pk        bool // whether the column is part of the primary key
+	generated bool // whether the column is a generated column
 }
 
 // Name returns the name of the column
@@ -47,3 +121,51 @@ func (col *Column) Name() string {
 func (col *Column) Type() reflect.Type {
 	return col.typ
 }
+
+// DeclType returns the declared type of the column, as written in the
+// CREATE TABLE statement
+func (col *Column) DeclType() string {
+	return col.decl
+}
+
+// Affinity returns the type affinity of the column, derived from its
+// declared type: "INTEGER", "TEXT", "BLOB", "REAL" or "NUMERIC".
+// SQLite stores the integral values of REAL columns as integers, which
+// read back as REAL values.
+func (col *Column) Affinity() string {
+	switch declAffinity(col.decl) {
+	case affInteger:
+		return "INTEGER"
+	case affText:
+		return "TEXT"
+	case affBlob:
+		return "BLOB"
+	case affReal:
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
+// Collation returns the name of the collating sequence of the column, or
+// the empty string for the default BINARY collation
+func (col *Column) Collation() string {
+	return col.collate
+}
+
+// Default returns the default value of the column, if it is a literal,
+// and nil otherwise. Records written before the column was added with
+// ALTER TABLE ADD COLUMN have no value for the column: its default value
+// applies.
+func (col *Column) Default() interface{} {
+	return col.dflt
+}
+
+// NotNull reports whether the column has a NOT NULL constraint
+func (col *Column) NotNull() bool {
+	return col.notNull
+}
+
+// PrimaryKey reports whether the column is part of the primary key
+func (col *Column) PrimaryKey() bool {
+	return col.pk
+}
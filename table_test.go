@@ -0,0 +1,292 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTableParallelScan(t *testing.T) {
+	db, err := Open("testdata/large.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	table := db.Tables()[0]
+
+	var want []int64
+	err = db.VisitTableRecords(table.Name(), func(rowid *int64, rec Record) error {
+		want = append(want, *rowid)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 3000 {
+		t.Fatalf("invalid number of rows: got=%d, want=%d", len(want), 3000)
+	}
+
+	for _, n := range []int{1, 2, 3, 8} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			var (
+				mu  sync.Mutex
+				got []int64
+			)
+			err := table.ParallelScan(n, func(rowid *int64, rec Record) error {
+				mu.Lock()
+				got = append(got, *rowid)
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid rowids")
+			}
+
+			got = got[:0]
+			err = table.ParallelScan(n, func(rowid *int64, rec Record) error {
+				got = append(got, *rowid)
+				return nil
+			}, InRowidOrder())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid ordered rowids")
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		errBoom := fmt.Errorf("boom")
+		for _, opts := range [][]ScanOption{nil, {InRowidOrder()}} {
+			err := table.ParallelScan(4, func(rowid *int64, rec Record) error {
+				if *rowid == 1500 {
+					return errBoom
+				}
+				return nil
+			}, opts...)
+			if err != errBoom {
+				t.Fatalf("invalid error: got=%v, want=%v", err, errBoom)
+			}
+		}
+	})
+}
+
+func TestParallelScanWithoutRowid(t *testing.T) {
+	db, err := Open("testdata/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the primary key b-tree of codes has interior pages, whose cells
+	// hold rows as well.
+	table := db.Table("codes")
+	var want []string
+	err = db.VisitTableRecords("codes", func(rowid *int64, rec Record) error {
+		want = append(want, fmt.Sprint(rec.Values))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 600 {
+		t.Fatalf("invalid number of rows: got=%d, want=%d", len(want), 600)
+	}
+
+	for _, n := range []int{1, 3, 8} {
+		var (
+			mu  sync.Mutex
+			got []string
+		)
+		err := table.ParallelScan(n, func(rowid *int64, rec Record) error {
+			if rowid != nil {
+				t.Errorf("WITHOUT ROWID row with a rowid: %d", *rowid)
+			}
+			mu.Lock()
+			got = append(got, fmt.Sprint(rec.Values))
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		sorted := append([]string(nil), want...)
+		sort.Strings(sorted)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, sorted) {
+			t.Fatalf("n=%d: invalid rows", n)
+		}
+
+		got = got[:0]
+		err = table.ParallelScan(n, func(rowid *int64, rec Record) error {
+			got = append(got, fmt.Sprint(rec.Values))
+			return nil
+		}, InRowidOrder())
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("n=%d: invalid ordered rows", n)
+		}
+	}
+
+	var labels int
+	err = table.ParallelScan(4, func(_ *int64, rec Record) error {
+		if rec.Values[0] != nil || rec.Values[1] != nil || rec.Values[2] == nil {
+			t.Errorf("invalid projected values: %v", rec.Values)
+		}
+		labels++
+		return nil
+	}, Columns("label"), InRowidOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels != 600 {
+		t.Errorf("got %d rows, want 600", labels)
+	}
+}
+
+func TestScanColumns(t *testing.T) {
+	for _, tc := range []struct {
+		fname, table string
+		columns      []string
+	}{
+		{"testdata/blob.sqlite", "files", []string{"name"}},
+		{"testdata/blob.sqlite", "files", []string{"extra", "id"}},
+		{"testdata/blob.sqlite", "files", nil},
+		{"testdata/large.sqlite", "items", []string{"value"}},
+		{"testdata/diff-a.sqlite", "tags", []string{"label"}},
+		{"testdata/diff-a.sqlite", "tags", []string{"lang"}},
+	} {
+		t.Run(fmt.Sprintf("%s/%s/%v", tc.fname, tc.table, tc.columns), func(t *testing.T) {
+			db, err := Open(tc.fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			var want [][]interface{}
+			err = db.VisitTableRecords(tc.table, func(_ *int64, rec Record) error {
+				vs := make([]interface{}, len(rec.Values))
+				for i, v := range rec.Values {
+					if b, ok := v.([]byte); ok {
+						v = append([]byte(nil), b...)
+					}
+					vs[i] = v
+				}
+				want = append(want, vs)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cols := make(map[int]bool)
+			table := db.Table(tc.table)
+			for _, name := range tc.columns {
+				cols[table.colIndex(name)] = true
+			}
+			for _, vs := range want {
+				for i := range vs {
+					if !cols[i] {
+						vs[i] = nil
+					}
+				}
+			}
+
+			var got [][]interface{}
+			err = db.VisitTableRecords(tc.table, func(_ *int64, rec Record) error {
+				if len(rec.Values) != len(rec.Header.Types) {
+					t.Fatalf("got %d values for %d types", len(rec.Values), len(rec.Header.Types))
+				}
+				got = append(got, rec.Values)
+				return nil
+			}, Columns(tc.columns...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid projected values:\ngot = %v\nwant= %v", got, want)
+			}
+		})
+	}
+
+	t.Run("overflow", func(t *testing.T) {
+		raw, err := ioutil.ReadFile("testdata/blob.sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := OpenFrom(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		err = db.VisitTableRecords("files", func(*int64, Record) error { return nil }, Columns("id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := db.Analyze()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := a.Btrees[1]
+		// the pages of the b-tree and page 1 are loaded, but no overflow
+		// page.
+		if got, want := len(db.pager.pages), 1+files.InteriorPages+files.LeafPages; got > want+1 {
+			t.Errorf("loaded %d pages, want %d", got, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db, err := Open("testdata/large.sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		err = db.VisitTableRecords("items", func(*int64, Record) error { return nil }, Columns("nosuchcolumn"))
+		if err == nil {
+			t.Errorf("expected an error for an unknown column")
+		}
+		err = db.Tables()[0].ParallelScan(2, func(*int64, Record) error { return nil }, Columns("nosuchcolumn"))
+		if err == nil {
+			t.Errorf("expected an error for an unknown column")
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		db, err := Open("testdata/large.sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		n := 0
+		err = db.Tables()[0].ParallelScan(4, func(_ *int64, rec Record) error {
+			if rec.Values[1] == nil || rec.Values[3] != nil {
+				return fmt.Errorf("invalid projected values %v", rec.Values)
+			}
+			n++
+			return nil
+		}, Columns("name"), InRowidOrder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3000 {
+			t.Errorf("visited %d rows, want 3000", n)
+		}
+	})
+}
@@ -17,6 +17,29 @@ func min(a, b int) int {
 	return a
 }
 
+// toInt64 converts an integer value decoded from a record to an int64.
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint32:
+		// StInt24 values are sign-extended to 32 bits.
+		return int64(int32(v)), true
+	case int32:
+		return int64(v), true
+	case uint64:
+		// StInt48 values are sign-extended to 64 bits.
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
 func unmarshal(buf []byte, ptr interface{}) (int64, error) {
 	r := bytes.NewReader(buf)
 	max := r.Len()
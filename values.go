@@ -0,0 +1,765 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values handled by queries are nil, int64, float64, string or []byte,
+// the Go counterparts of the NULL, INTEGER, REAL, TEXT and BLOB storage
+// classes.
+
+// affinity is the type affinity of a column or of an expression.
+type affinity byte
+
+const (
+	affNone    affinity = iota // no affinity
+	affBlob                    // BLOB affinity
+	affText                    // TEXT affinity
+	affNumeric                 // NUMERIC affinity
+	affInteger                 // INTEGER affinity
+	affReal                    // REAL affinity
+)
+
+// queryValue converts the Go value v, an argument of a query, to a query
+// value.
+func queryValue(v interface{}) (interface{}, error) {
+	if vr, ok := v.(driver.Valuer); ok {
+		var err error
+		v, err = vr.Value()
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch v := v.(type) {
+	case string, []byte:
+		return v, nil
+	case float32:
+		return queryValue(float64(v))
+	case float64:
+		if math.IsNaN(v) {
+			return nil, nil
+		}
+		return v, nil
+	}
+	st, i, err := serialType(v)
+	if err != nil {
+		return nil, err
+	}
+	if st == StNull {
+		return nil, nil
+	}
+	return i, nil
+}
+
+// declAffinity returns the affinity of a column declared with the type
+// decl, following the rules of the SQLite documentation.
+func declAffinity(decl string) affinity {
+	decl = strings.ToUpper(decl)
+	switch {
+	case strings.Contains(decl, "INT"):
+		return affInteger
+	case strings.Contains(decl, "CHAR"), strings.Contains(decl, "CLOB"), strings.Contains(decl, "TEXT"):
+		return affText
+	case strings.Contains(decl, "BLOB"), decl == "":
+		return affBlob
+	case strings.Contains(decl, "REAL"), strings.Contains(decl, "FLOA"), strings.Contains(decl, "DOUB"):
+		return affReal
+	}
+	return affNumeric
+}
+
+func (aff affinity) isNumeric() bool {
+	return aff >= affNumeric
+}
+
+// columnValue returns the value v decoded from a record, for a column
+// with the affinity aff: integers are converted to int64 and, as SQLite
+// stores integral REAL values as integers, to float64 for REAL columns.
+func columnValue(v interface{}, aff affinity) interface{} {
+	i, ok := toInt64(v)
+	switch {
+	case !ok:
+		return v
+	case aff == affReal:
+		return float64(i)
+	}
+	return i
+}
+
+// storageClass returns the rank of the storage class of v in the sort
+// order of SQLite: NULL, then numbers, then TEXT, then BLOB.
+func storageClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// typeName returns the name of the storage class of v, as returned by
+// the typeof SQL function.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return "integer"
+	case float64:
+		return "real"
+	case string:
+		return "text"
+	}
+	return "blob"
+}
+
+// compareValues compares the values a and b in the sort order of SQLite,
+// comparing TEXT values with their bytes.
+func compareValues(a, b interface{}) int {
+	ca, cb := storageClass(a), storageClass(b)
+	if ca != cb {
+		return ca - cb
+	}
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInts(a, b)
+		case float64:
+			return compareIntFloat(a, b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return -compareIntFloat(b, a)
+		case float64:
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares an integer and a float without losing the
+// precision of integers larger than 2^53.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case f < -9223372036854775808.0:
+		return 1
+	case f >= 9223372036854775808.0:
+		return -1
+	}
+	if c := compareInts(i, int64(f)); c != 0 {
+		return c
+	}
+	switch s := float64(i); {
+	case s < f:
+		return -1
+	case s > f:
+		return 1
+	}
+	return 0
+}
+
+// compareCollated compares the values a and b like compareValues, but
+// compares TEXT values with coll, unless it is nil.
+func compareCollated(a, b interface{}, coll collation) int {
+	if coll != nil {
+		sa, oka := a.(string)
+		sb, okb := b.(string)
+		if oka && okb {
+			return coll(sa, sb)
+		}
+	}
+	return compareValues(a, b)
+}
+
+// compareAffinity returns the affinity applied to the operands of a
+// comparison between values of the affinities a and b: numeric if any of
+// them is numeric, TEXT if the other has no affinity, and none otherwise.
+func compareAffinity(a, b affinity) affinity {
+	switch {
+	case a == affNone:
+		return b
+	case b == affNone:
+		return a
+	case a.isNumeric() || b.isNumeric():
+		return affNumeric
+	}
+	return affBlob
+}
+
+// applyAffinity converts v the way SQLite does before comparing it with
+// the affinity aff: numeric affinities convert TEXT holding a number to
+// that number, and TEXT affinity converts numbers to TEXT.
+func applyAffinity(v interface{}, aff affinity) interface{} {
+	switch {
+	case aff.isNumeric():
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		n, ok := parseNumeric(s)
+		if !ok {
+			return v
+		}
+		if aff == affReal {
+			return toFloat(n)
+		}
+		return n
+	case aff == affText:
+		switch v.(type) {
+		case int64, float64:
+			return textValue(v)
+		}
+	}
+	return v
+}
+
+// parseNumeric parses s, surrounded by optional spaces, as an integer or
+// a real literal. parseNumeric fails if s holds anything else.
+func parseNumeric(s string) (interface{}, bool) {
+	s = strings.TrimSpace(s)
+	v, n := numericPrefix(s)
+	if n == 0 || n != len(s) {
+		return nil, false
+	}
+	return v, true
+}
+
+// numericPrefix parses the longest prefix of s, after leading spaces,
+// that is an integer or a real literal. numericPrefix returns the value,
+// or the integer 0 if there is no such prefix, and the length of the
+// parsed text, spaces included.
+func numericPrefix(s string) (interface{}, int) {
+	i := 0
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	beg := i
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+		digits++
+	}
+	isInt := true
+	if i < len(s) && s[i] == '.' {
+		j := i + 1
+		for j < len(s) && isDigit(s[j]) {
+			j++
+			digits++
+		}
+		if digits > 0 {
+			isInt = false
+			i = j
+		}
+	}
+	if digits == 0 {
+		return int64(0), 0
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			isInt = false
+			i = j
+		}
+	}
+	text := s[beg:i]
+	if isInt {
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return v, i
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil && !isRangeError(err) {
+		return int64(0), 0
+	}
+	return f, i
+}
+
+func isRangeError(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// numericValue converts v to a number, the way SQLite does for the
+// operands of arithmetic operators: TEXT and BLOB values are converted
+// from their longest numeric prefix. NULL is returned unchanged.
+func numericValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		n, _ := numericPrefix(v)
+		return n
+	case []byte:
+		n, _ := numericPrefix(string(v))
+		return n
+	}
+	return v
+}
+
+// toFloat converts v to a float64, the way CAST(v AS REAL) does.
+func toFloat(v interface{}) float64 {
+	switch v := numericValue(v).(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// toInteger converts v to an int64, the way CAST(v AS INTEGER) does:
+// TEXT and BLOB values are converted from their longest integer prefix,
+// ignoring any fraction or exponent.
+func toInteger(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return floatToInt(v)
+	case string:
+		return integerPrefix(v)
+	case []byte:
+		return integerPrefix(string(v))
+	}
+	return 0
+}
+
+// integerPrefix returns the value of the longest prefix of s, after
+// leading spaces, made of an optional sign and digits, saturating at the
+// limits of int64.
+func integerPrefix(s string) int64 {
+	s = strings.TrimLeft(s, " \t\n\v\f\r")
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	var n uint64
+	for i := 0; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		d := uint64(s[i] - '0')
+		if n > (math.MaxInt64+1-d)/10 {
+			n = math.MaxInt64 + 1
+			continue
+		}
+		n = n*10 + d
+	}
+	switch {
+	case neg:
+		return int64(-n)
+	case n > math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(n)
+}
+
+// floatToInt truncates f towards zero, saturating at the limits of
+// int64.
+func floatToInt(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+// textValue converts the non-NULL value v to TEXT.
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatFloat formats f with 15 significant digits, the way SQLite
+// converts REAL values to TEXT.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case f == 0:
+		return "0.0"
+	}
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	mant, exp := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mant, exp = s[:i], s[i:]
+	}
+	if !strings.Contains(mant, ".") {
+		mant += ".0"
+	}
+	return mant + exp
+}
+
+// castValue converts v to the type of the affinity aff, the way
+// CAST(v AS type) does.
+func castValue(v interface{}, aff affinity) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch aff {
+	case affText:
+		return textValue(v)
+	case affBlob:
+		if b, ok := v.([]byte); ok {
+			return b
+		}
+		return []byte(textValue(v))
+	case affInteger:
+		return toInteger(v)
+	case affReal:
+		return toFloat(v)
+	}
+
+	// NUMERIC: TEXT and BLOB values holding an integral real are
+	// converted to integers.
+	switch v.(type) {
+	case int64, float64:
+		return v
+	}
+	n := numericValue(v)
+	if f, ok := n.(float64); ok && f == math.Trunc(f) && f > math.MinInt64 && f < math.MaxInt64 {
+		return int64(f)
+	}
+	return n
+}
+
+// truth returns whether v is true when used as a condition, and whether
+// it is NULL.
+func truth(v interface{}) (ok, null bool) {
+	switch v := numericValue(v).(type) {
+	case nil:
+		return false, true
+	case int64:
+		return v != 0, false
+	case float64:
+		return v != 0, false
+	}
+	return false, false
+}
+
+// boolValue returns the integer value of the boolean b.
+func boolValue(b bool) interface{} {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// arith applies the arithmetic operator op to the numbers a and b.
+// Integer operations overflowing int64 are computed with floats.
+func arith(op string, a, b interface{}) interface{} {
+	if a == nil || b == nil {
+		return nil
+	}
+	a, b = numericValue(a), numericValue(b)
+	if op == "%" {
+		x, y := toInteger(a), toInteger(b)
+		if y == 0 {
+			return nil
+		}
+		if y == -1 {
+			// avoid overflowing with math.MinInt64.
+			y = 1
+		}
+		_, fa := a.(float64)
+		_, fb := b.(float64)
+		if fa || fb {
+			return float64(x % y)
+		}
+		return x % y
+	}
+
+	x, okx := a.(int64)
+	y, oky := b.(int64)
+	if okx && oky {
+		switch op {
+		case "+":
+			if r := x + y; (r > x) == (y > 0) {
+				return r
+			}
+		case "-":
+			if r := x - y; (r < x) == (y > 0) {
+				return r
+			}
+		case "*":
+			if x == 0 || y == 0 {
+				return int64(0)
+			}
+			if r := x * y; r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+				return r
+			}
+		case "/":
+			if y == 0 {
+				return nil
+			}
+			if !(x == math.MinInt64 && y == -1) {
+				return x / y
+			}
+		}
+	}
+
+	fx, fy := toFloat(a), toFloat(b)
+	var r float64
+	switch op {
+	case "+":
+		r = fx + fy
+	case "-":
+		r = fx - fy
+	case "*":
+		r = fx * fy
+	case "/":
+		if fy == 0 {
+			return nil
+		}
+		r = fx / fy
+	}
+	if math.IsNaN(r) {
+		return nil
+	}
+	return r
+}
+
+// bitwise applies the bitwise operator op to the values a and b,
+// converted to integers.
+func bitwise(op string, a, b interface{}) interface{} {
+	if a == nil || b == nil {
+		return nil
+	}
+	x, y := toInteger(a), toInteger(b)
+	switch op {
+	case "&":
+		return x & y
+	case "|":
+		return x | y
+	case "<<":
+		return shiftLeft(x, y)
+	case ">>":
+		if y == math.MinInt64 {
+			y++
+		}
+		return shiftLeft(x, -y)
+	}
+	return nil
+}
+
+// shiftLeft shifts x left by n bits, or right by -n bits if n is
+// negative.
+func shiftLeft(x, n int64) int64 {
+	switch {
+	case n >= 64:
+		return 0
+	case n >= 0:
+		return x << uint(n)
+	case n <= -64:
+		if x < 0 {
+			return -1
+		}
+		return 0
+	}
+	return x >> uint(-n)
+}
+
+// negate returns the negation of the value v, converted to a number.
+func negate(v interface{}) interface{} {
+	switch v := numericValue(v).(type) {
+	case int64:
+		if v == math.MinInt64 {
+			return -float64(v)
+		}
+		return -v
+	case float64:
+		return -v
+	}
+	return nil
+}
+
+// likeMatch reports whether s matches the LIKE pattern, where % matches
+// any sequence of characters and _ any character. ASCII letters match
+// regardless of their case. If esc is not 0, it escapes the following
+// character of the pattern.
+func likeMatch(pattern, s string, esc rune) bool {
+	p := []rune(pattern)
+	r := []rune(s)
+
+	// on a mismatch, backtrack to the last % seen, which then matches
+	// one more character.
+	i, j := 0, 0
+	star, next := -1, 0
+	for j < len(r) {
+		if i < len(p) {
+			switch c := p[i]; {
+			case esc != 0 && c == esc:
+				if i+1 < len(p) && foldASCII(p[i+1]) == foldASCII(r[j]) {
+					i += 2
+					j++
+					continue
+				}
+			case c == '%':
+				i++
+				star, next = i, j
+				continue
+			case c == '_' || foldASCII(c) == foldASCII(r[j]):
+				i++
+				j++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		i, j = star, next
+	}
+	for i < len(p) && p[i] == '%' && p[i] != esc {
+		i++
+	}
+	return i == len(p)
+}
+
+func foldASCII(c rune) rune {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// globMatch reports whether s matches the GLOB pattern, where * matches
+// any sequence of characters, ? any character and [...] any character
+// of a set, with ranges and negation with ^. Matches are case-sensitive.
+func globMatch(pattern, s string) bool {
+	p := []rune(pattern)
+	r := []rune(s)
+
+	i, j := 0, 0
+	star, next := -1, 0
+	for j < len(r) {
+		if i < len(p) {
+			switch p[i] {
+			case '*':
+				i++
+				star, next = i, j
+				continue
+			case '?':
+				i++
+				j++
+				continue
+			case '[':
+				n, ok := matchSet(p[i+1:], r[j])
+				if n < 0 {
+					return false
+				}
+				if ok {
+					i += n + 1
+					j++
+					continue
+				}
+			default:
+				if p[i] == r[j] {
+					i++
+					j++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		i, j = star, next
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// matchSet matches c against the set of a GLOB pattern following its
+// opening bracket. matchSet returns the length of the set, closing
+// bracket included, or -1 if it is not terminated.
+func matchSet(p []rune, c rune) (int, bool) {
+	i := 0
+	invert := false
+	if i < len(p) && p[i] == '^' {
+		invert = true
+		i++
+	}
+	found := false
+	if i < len(p) && p[i] == ']' {
+		found = c == ']'
+		i++
+	}
+	for ; i < len(p) && p[i] != ']'; i++ {
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			if p[i] <= c && c <= p[i+2] {
+				found = true
+			}
+			i += 2
+			continue
+		}
+		if p[i] == c {
+			found = true
+		}
+	}
+	if i == len(p) {
+		return -1, false
+	}
+	return i + 1, found != invert
+}
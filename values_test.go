@@ -0,0 +1,121 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDeclAffinity(t *testing.T) {
+	for _, tc := range []struct {
+		decl string
+		want affinity
+	}{
+		{"INTEGER", affInteger},
+		{"tinyint", affInteger},
+		{"VARCHAR(255)", affText},
+		{"CLOB", affText},
+		{"", affBlob},
+		{"blob", affBlob},
+		{"REAL", affReal},
+		{"double precision", affReal},
+		{"FLOATING POINT", affInteger}, // contains "INT"
+		{"NUMERIC", affNumeric},
+		{"DECIMAL(10,5)", affNumeric},
+		{"BOOLEAN", affNumeric},
+	} {
+		if got := declAffinity(tc.decl); got != tc.want {
+			t.Errorf("%q: got=%d, want=%d", tc.decl, got, tc.want)
+		}
+	}
+}
+
+func TestNumericPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want interface{}
+		n    int
+	}{
+		{"12", int64(12), 2},
+		{" -12abc", int64(-12), 4},
+		{"1.5e3x", 1500.0, 5},
+		{"1e", int64(1), 1},
+		{".5", 0.5, 2},
+		{"5.", 5.0, 2},
+		{"+.", int64(0), 0},
+		{"abc", int64(0), 0},
+		{"9223372036854775808", 9223372036854775808.0, 19},
+		{"1e400", math.Inf(1), 5},
+	} {
+		got, n := numericPrefix(tc.s)
+		if got != tc.want || n != tc.n {
+			t.Errorf("%q: got=%v (%d), want=%v (%d)", tc.s, got, n, tc.want, tc.n)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for _, tc := range []struct {
+		f    float64
+		want string
+	}{
+		{1, "1.0"},
+		{0.1 + 0.2, "0.3"},
+		{-2.5, "-2.5"},
+		{1e20, "1.0e+20"},
+		{2.5e-5, "2.5e-05"},
+		{123456789012345678, "1.23456789012346e+17"},
+		{math.Copysign(0, -1), "0.0"},
+		{math.Inf(-1), "-Inf"},
+	} {
+		if got := formatFloat(tc.f); got != tc.want {
+			t.Errorf("%v: got=%q, want=%q", tc.f, got, tc.want)
+		}
+	}
+}
+
+func TestLikeGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		esc        rune
+		want       bool
+	}{
+		{"abc", "ABC", 0, true},
+		{"a%", "abc", 0, true},
+		{"%c", "abc", 0, true},
+		{"a_c", "abc", 0, true},
+		{"a_c", "ac", 0, false},
+		{"%b%", "abc", 0, true},
+		{"%%_", "", 0, false},
+		{"é", "É", 0, false},
+		{`a\%`, "a%", '\\', true},
+		{`a\%`, "ab", '\\', false},
+		{`a\`, "a", '\\', false},
+	} {
+		if got := likeMatch(tc.pattern, tc.s, tc.esc); got != tc.want {
+			t.Errorf("%q LIKE %q: got=%v, want=%v", tc.s, tc.pattern, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "ABC", false},
+		{"a*", "abc", true},
+		{"a?c", "abc", true},
+		{"[a-c]x", "bx", true},
+		{"[^a-c]x", "bx", false},
+		{"[]]", "]", true},
+		{"[a-]", "-", true},
+		{"[abc", "a", false},
+		{"*[0-9]", "ab1", true},
+	} {
+		if got := globMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("%q GLOB %q: got=%v, want=%v", tc.s, tc.pattern, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// View is a view of a SQLite database: a SELECT statement, whose rows
+// are computed each time the view is read.
+type View struct {
+	db   *DbFile
+	name string
+	sql  string
+	sel  string      // SQL text of the SELECT statement
+	cols []string    // names of the columns, if given by the CREATE VIEW statement
+	stmt *selectStmt // parsed SELECT statement
+	err  error       // error parsing the SELECT statement, if any
+}
+
+// Name returns the name of the view
+func (v *View) Name() string {
+	return v.name
+}
+
+// SQL returns the CREATE VIEW statement of the view
+func (v *View) SQL() string {
+	return v.sql
+}
+
+// Select returns the SQL text of the SELECT statement of the view
+func (v *View) Select() string {
+	return v.sel
+}
+
+// Columns returns the names of the columns of the view, those of the
+// CREATE VIEW statement or else those of the result columns of its
+// SELECT statement.
+func (v *View) Columns() ([]string, error) {
+	d, err := v.compile(nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(d.table.cols))
+	for i, col := range d.table.cols {
+		names[i] = col.name
+	}
+	return names, nil
+}
+
+// Views returns the views of the database, in schema order.
+func (db *DbFile) Views() []View {
+	return db.views
+}
+
+// View returns the view with the given name, or nil if there is no such
+// view.
+func (db *DbFile) View(name string) *View {
+	for i := range db.views {
+		if strings.EqualFold(db.views[i].name, name) {
+			return &db.views[i]
+		}
+	}
+	return nil
+}
+
+// parseCreateView parses a CREATE VIEW statement, as stored in the
+// sqlite_master table.
+//
+// A SELECT statement which cannot be parsed is reported by the err field
+// of the view, when the view is read, like SQLite does.
+func parseCreateView(sql string) (View, error) {
+	var v View
+
+	p, err := newParser(sql)
+	if err != nil {
+		return v, err
+	}
+
+	if err := p.expect("CREATE"); err != nil {
+		return v, err
+	}
+	_ = p.accept("TEMP") || p.accept("TEMPORARY")
+	if err := p.expect("VIEW"); err != nil {
+		return v, err
+	}
+	_ = p.accept("IF", "NOT", "EXISTS")
+	if _, v.name, err = p.qualifiedName(); err != nil {
+		return v, err
+	}
+	if p.peek().isOp("(") {
+		if v.cols, err = p.columnNames(); err != nil {
+			return v, err
+		}
+	}
+	if err := p.expect("AS"); err != nil {
+		return v, err
+	}
+
+	v.sel = strings.TrimSpace(p.src[p.peek().pos:])
+	v.sel = strings.TrimSpace(strings.TrimSuffix(v.sel, ";"))
+	stmt, params, err := parseSelect(v.sel)
+	switch {
+	case err != nil:
+		v.err = err
+	case len(params) > 0:
+		v.err = errors.New("sqlite3: parameters are not allowed in views")
+	default:
+		v.stmt = stmt
+	}
+	return v, nil
+}
+
+// initViews loads the views of the schema.
+func (db *DbFile) initViews() error {
+	for _, obj := range db.schema {
+		if obj.Type != "view" {
+			continue
+		}
+		v, err := parseCreateView(obj.SQL)
+		if err != nil {
+			return fmt.Errorf("sqlite3: invalid definition for view %q: %w", obj.Name, err)
+		}
+		v.db = db
+		v.name = obj.Name
+		v.sql = obj.SQL
+		db.views = append(db.views, v)
+	}
+	return nil
+}
+
+// compile compiles the SELECT statement of the view, read from a query
+// where the common table expressions of with are defined, and returns
+// the derived table of its rows.
+func (v *View) compile(with *withScope) (*derivedTable, error) {
+	if v.err != nil {
+		return nil, fmt.Errorf("sqlite3: invalid view %s: %w", v.name, v.err)
+	}
+	if with.expanding(v) {
+		return nil, fmt.Errorf("sqlite3: view %s is circularly defined", v.name)
+	}
+	d := &derivedTable{
+		name: v.name,
+		def:  &cteDef{name: v.name, cols: v.cols, stmt: v.stmt},
+		with: &withScope{outer: with, view: v},
+	}
+	if err := v.db.compileDerived(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// visitRecords runs the SELECT statement of the view, passing the values
+// of each row, in a Record without header nor body, to f.
+func (v *View) visitRecords(f func(*int64, Record) error, opts []ScanOption) error {
+	d, err := v.compile(nil)
+	if err != nil {
+		return err
+	}
+	o := newScanOptions(opts)
+	var want []bool
+	if o.project {
+		want = make([]bool, len(d.table.cols))
+		for _, name := range o.columns {
+			i := d.table.colIndex(name)
+			if i < 0 {
+				return fmt.Errorf("sqlite3: no such column %q in view %q", name, v.name)
+			}
+			want[i] = true
+		}
+	}
+	return d.plan.run(func(vals []interface{}) error {
+		for i := range want {
+			if !want[i] {
+				vals[i] = nil
+			}
+		}
+		return f(nil, Record{Values: vals})
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestViews(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type view struct {
+		name string
+		cols string
+	}
+	var got []view
+	for _, v := range db.Views() {
+		cols, err := v.Columns()
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name(), err)
+		}
+		got = append(got, view{v.Name(), strings.Join(cols, ",")})
+	}
+	want := []view{
+		{"popular", "url,visits"},
+		{"top_popular", "url,visits"},
+		{"visit_depth", "id,depth"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid views:\ngot:  %v\nwant: %v", got, want)
+	}
+
+	v := db.View("TOP_POPULAR")
+	if v == nil {
+		t.Fatal("missing view top_popular")
+	}
+	if got, want := v.Select(), "SELECT * FROM popular ORDER BY visits DESC, url LIMIT 5"; got != want {
+		t.Errorf("invalid SELECT statement:\ngot:  %q\nwant: %q", got, want)
+	}
+	if !strings.HasPrefix(v.SQL(), "CREATE VIEW top_popular AS") {
+		t.Errorf("invalid SQL: %q", v.SQL())
+	}
+	if db.View("urls") != nil || db.Table("popular") != nil {
+		t.Errorf("tables and views are mixed up")
+	}
+}
+
+func TestQueryView(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select * from top_popular`,
+			want: `https://news.ycombinator.com/page43|22
+https://example.com/page85|16
+https://golang.org/page66|16
+https://news.ycombinator.com/page103|15
+https://sqlite.org/page62|15
+`,
+		},
+		{
+			query: `select url, visits from popular where visits > 15 order by url`,
+			want: `https://example.com/page85|16
+https://golang.org/page66|16
+https://news.ycombinator.com/page43|22
+`,
+		},
+		{
+			query: `select p.url, p.visits, u.id from top_popular p join urls u on u.url = p.url order by u.id`,
+			want: `https://news.ycombinator.com/page43|22|43
+https://sqlite.org/page62|15|62
+https://golang.org/page66|16|66
+https://example.com/page85|16|85
+https://news.ycombinator.com/page103|15|103
+`,
+		},
+		{
+			query: `select depth, count(*) from visit_depth group by depth order by depth`,
+			want: `0|70
+1|20
+2|7
+3|2
+4|1
+`,
+		},
+		{
+			query: `select * from main.popular order by visits desc, url limit 3`,
+			want: `https://news.ycombinator.com/page43|22
+https://example.com/page85|16
+https://golang.org/page66|16
+`,
+		},
+		{
+			query: `select visits, count(*) from popular group by visits order by visits`,
+			want: `11|17
+12|10
+13|5
+14|3
+15|2
+16|2
+22|1
+`,
+		},
+		{
+			query: `with popular(x) as (select 1) select * from popular`,
+			want: `1
+`,
+		},
+		{
+			query: `select v.id, v.depth, visits.visit_time from visit_depth v join visits on visits.id = v.id where v.depth = 3 order by v.id`,
+			want: `18|3|13300000704779725
+44|3|13300002088645814
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestVisitViewRecords(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got []string
+	err = db.VisitTableRecords("top_popular", func(rowid *int64, rec Record) error {
+		if rowid != nil {
+			t.Errorf("view row with a rowid: %d", *rowid)
+		}
+		got = append(got, fmt.Sprint(rec.Values))
+		return nil
+	}, Columns("visits"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"[<nil> 22]", "[<nil> 16]", "[<nil> 16]", "[<nil> 15]", "[<nil> 15]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid records:\ngot:  %q\nwant: %q", got, want)
+	}
+
+	err = db.VisitTableRecords("top_popular", func(*int64, Record) error { return nil }, Columns("nosuchcolumn"))
+	if err == nil {
+		t.Errorf("no error for an unknown column of a view")
+	}
+}
+
+func TestViewErrors(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		want string
+	}{
+		{`CREATE VIEW v AS SELECT * FROM v`, "view v is circularly defined"},
+		{`CREATE VIEW v AS SELECT ?`, "parameters are not allowed in views"},
+		{`CREATE VIEW v(a, b) AS SELECT 1`, "expected 2 columns for 'v' but got 1"},
+	} {
+		v, err := parseCreateView(tc.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sql, err)
+		}
+		db := &DbFile{views: []View{v}}
+		db.views[0].db = db
+		_, err = db.Query("select * from v")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.sql, err, tc.want)
+		}
+	}
+}
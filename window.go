@@ -0,0 +1,757 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// windowDef is a parsed window definition: the OVER clause of a call of
+// a window function, or a window of a WINDOW clause.
+type windowDef struct {
+	base      string // name of the window the definition is based on, if any
+	partition []expr
+	orderBy   []orderingTerm
+	frame     *frameSpec // frame specification, if any
+}
+
+// frameSpec is the frame specification of a window.
+type frameSpec struct {
+	unit       string // "ROWS", "RANGE" or "GROUPS"
+	start, end frameBound
+	exclude    string // "NO OTHERS", "CURRENT ROW", "GROUP" or "TIES"
+}
+
+// boundKind is the kind of a bound of a window frame. The kinds are
+// ordered by the position of the rows they designate.
+type boundKind int
+
+const (
+	frameUnboundedPreceding boundKind = iota
+	framePreceding
+	frameCurrent
+	frameFollowing
+	frameUnboundedFollowing
+)
+
+// frameBound is a bound of a window frame.
+type frameBound struct {
+	kind boundKind
+	x    expr        // offset of a PRECEDING or FOLLOWING bound
+	n    interface{} // value of the offset, once compiled: int64, or float64 for RANGE frames
+}
+
+// defaultFrame is the frame of the windows without frame specification:
+// the rows up to the last peer of the current row.
+var defaultFrame = frameSpec{
+	unit:    "RANGE",
+	start:   frameBound{kind: frameUnboundedPreceding},
+	end:     frameBound{kind: frameCurrent},
+	exclude: "NO OTHERS",
+}
+
+// window is a compiled window definition.
+type window struct {
+	partition []expr
+	colls     []collation // collations of the PARTITION BY terms
+	order     []sortKey
+	frame     frameSpec
+}
+
+// winFunc is a window function.
+type winFunc struct {
+	minArgs, maxArgs int
+
+	// values returns the values of the function for the rows of the
+	// partition p.
+	values func(p *partition) ([]interface{}, error)
+}
+
+// winFuncs holds the built-in window functions, by lower case name.
+// Aggregate functions may be used as window functions as well.
+var winFuncs = map[string]*winFunc{
+	"row_number":   {values: rowNumberFunc},
+	"rank":         {values: rankFunc},
+	"dense_rank":   {values: denseRankFunc},
+	"percent_rank": {values: percentRankFunc},
+	"cume_dist":    {values: cumeDistFunc},
+	"ntile":        {minArgs: 1, maxArgs: 1, values: ntileFunc},
+	"lag":          {minArgs: 1, maxArgs: 3, values: lagFunc(-1)},
+	"lead":         {minArgs: 1, maxArgs: 3, values: lagFunc(1)},
+	"first_value":  {minArgs: 1, maxArgs: 1, values: nthValueFunc(firstRow)},
+	"last_value":   {minArgs: 1, maxArgs: 1, values: nthValueFunc(lastRow)},
+	"nth_value":    {minArgs: 2, maxArgs: 2, values: nthValueFunc(nthRow)},
+}
+
+// lookupWinFunc returns the window function name, or nil.
+func lookupWinFunc(name string) *winFunc {
+	name = strings.ToLower(name)
+	if fn := winFuncs[name]; fn != nil {
+		return fn
+	}
+	agg := aggFuncs[name]
+	if agg == nil {
+		return nil
+	}
+	return &winFunc{minArgs: agg.minArgs, maxArgs: agg.maxArgs, values: aggValues(agg)}
+}
+
+// bindWindow checks the call x of a window function, and binds its
+// arguments and its window. The call is added to the ones of the scope.
+func (s *scope) bindWindow(x *funcExpr) error {
+	if !s.windows {
+		return fmt.Errorf("sqlite3: misuse of window function %s()", x.name)
+	}
+	fn := lookupWinFunc(x.name)
+	switch {
+	case fn == nil && lookupFunc(x.name) != nil:
+		return fmt.Errorf("sqlite3: %s() may not be used as a window function", x.name)
+	case fn == nil:
+		return fmt.Errorf("sqlite3: no such function: %s", x.name)
+	case x.distinct:
+		return errors.New("sqlite3: DISTINCT is not supported for window functions")
+	case x.star && !(strings.EqualFold(x.name, "count") && len(x.args) == 0),
+		len(x.args) < fn.minArgs || len(x.args) > fn.maxArgs:
+		return fmt.Errorf("sqlite3: wrong number of arguments to function %s()", x.name)
+	}
+
+	// window functions may not be nested.
+	s.windows = false
+	defer func() { s.windows = true }()
+	for _, arg := range x.args {
+		if err := s.bind(arg); err != nil {
+			return err
+		}
+	}
+	w, err := s.window(x.over)
+	if err != nil {
+		return err
+	}
+	x.win, x.window, x.slot = fn, w, len(s.wins)
+	s.wins = append(s.wins, x)
+	return nil
+}
+
+// window compiles the window definition def. The windows of the WINDOW
+// clause are compiled once.
+func (s *scope) window(def *windowDef) (*window, error) {
+	plain := def.base != "" && def.partition == nil && def.orderBy == nil && def.frame == nil
+	if plain {
+		if w := s.namedWindows[strings.ToLower(def.base)]; w != nil {
+			return w, nil
+		}
+	}
+	flat, err := s.flattenWindow(def)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &window{partition: flat.partition, frame: defaultFrame}
+	for _, x := range w.partition {
+		if err := s.bind(x); err != nil {
+			return nil, err
+		}
+	}
+	if w.colls, err = exprCollations(w.partition); err != nil {
+		return nil, err
+	}
+	for _, term := range flat.orderBy {
+		if err := s.bind(term.expr); err != nil {
+			return nil, err
+		}
+		key, err := newSortKey(term, term.expr)
+		if err != nil {
+			return nil, err
+		}
+		w.order = append(w.order, key)
+	}
+	if flat.frame != nil {
+		w.frame = *flat.frame
+		for _, b := range []*frameBound{&w.frame.start, &w.frame.end} {
+			if b.x == nil {
+				continue
+			}
+			if w.frame.unit == "RANGE" && len(w.order) != 1 {
+				return nil, errors.New("sqlite3: RANGE with offset PRECEDING/FOLLOWING requires one ORDER BY expression")
+			}
+			which := "starting"
+			if b == &w.frame.end {
+				which = "ending"
+			}
+			if b.n, err = frameOffset(b.x, w.frame.unit, which); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if plain {
+		if s.namedWindows == nil {
+			s.namedWindows = make(map[string]*window)
+		}
+		s.namedWindows[strings.ToLower(def.base)] = w
+	}
+	return w, nil
+}
+
+// flattenWindow returns the window definition def, completed with the
+// clauses of the windows it is based on.
+func (s *scope) flattenWindow(def *windowDef) (*windowDef, error) {
+	if def.base == "" {
+		return def, nil
+	}
+	base := s.windowDefs[strings.ToLower(def.base)]
+	if base == nil {
+		return nil, fmt.Errorf("sqlite3: no such window: %s", def.base)
+	}
+	base, err := s.flattenWindow(base)
+	if err != nil {
+		return nil, err
+	}
+	plain := def.partition == nil && def.orderBy == nil && def.frame == nil
+	switch {
+	case def.partition != nil:
+		return nil, fmt.Errorf("sqlite3: cannot override PARTITION clause of window: %s", def.base)
+	case def.orderBy != nil && base.orderBy != nil:
+		return nil, fmt.Errorf("sqlite3: cannot override ORDER BY clause of window: %s", def.base)
+	case base.frame != nil && !plain:
+		return nil, fmt.Errorf("sqlite3: cannot override frame specification of window: %s", def.base)
+	}
+	flat := *base
+	if def.orderBy != nil {
+		flat.orderBy = def.orderBy
+	}
+	if def.frame != nil {
+		flat.frame = def.frame
+	}
+	return &flat, nil
+}
+
+// frameOffset returns the value of the offset x of a bound of a frame
+// of the given unit: a non-negative integer, or number for RANGE frames.
+func frameOffset(x expr, unit, which string) (interface{}, error) {
+	s := scope{aggErr: aggNested}
+	if err := s.bind(x); err != nil {
+		return nil, err
+	}
+	v, err := x.eval(&env{})
+	if err != nil {
+		return nil, err
+	}
+	switch v := applyAffinity(v, affNumeric).(type) {
+	case int64:
+		if v >= 0 {
+			return v, nil
+		}
+	case float64:
+		if unit == "RANGE" && v >= 0 {
+			return v, nil
+		}
+		if i := floatToInt(v); float64(i) == v && i >= 0 {
+			return i, nil
+		}
+	}
+	if unit == "RANGE" {
+		return nil, fmt.Errorf("sqlite3: frame %s offset must be a non-negative number", which)
+	}
+	return nil, fmt.Errorf("sqlite3: frame %s offset must be a non-negative integer", which)
+}
+
+// windowing computes the values of the window functions of a query over
+// all its rows, once they are known.
+type windowing struct {
+	plan *selectPlan
+	rows []*winRow
+}
+
+// winRow is a row of a query with window functions.
+type winRow struct {
+	row  []interface{} // values of the row, by slot
+	aggs []interface{} // values of the aggregate functions of its group
+	wins []interface{} // values of the window functions
+}
+
+// add adds the row of e to the rows of the query.
+func (w *windowing) add(e *env) error {
+	w.rows = append(w.rows, &winRow{
+		row:  append([]interface{}(nil), e.row...),
+		aggs: append([]interface{}(nil), e.aggs...),
+		wins: make([]interface{}, len(w.plan.wins)),
+	})
+	return nil
+}
+
+// each computes the values of the window functions, and calls f for
+// each row after storing its values in e. Like SQLite, the rows are
+// visited in the order of the window of the first call.
+func (w *windowing) each(e *env, f func() error) error {
+	wins := w.plan.wins
+	for i := len(wins) - 1; i >= 0; i-- {
+		if err := w.compute(e, wins[i]); err != nil {
+			return err
+		}
+	}
+	for _, r := range w.rows {
+		e.row, e.aggs, e.wins = r.row, r.aggs, r.wins
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// winItem is a row of a window, with the values of its PARTITION BY
+// and ORDER BY terms, and of the arguments of the function call.
+type winItem struct {
+	r    *winRow
+	part []interface{}
+	keys []interface{}
+	args []interface{}
+}
+
+// compute computes the values of the window function call x, and sorts
+// the rows in the order of its window.
+func (w *windowing) compute(e *env, x *funcExpr) error {
+	var (
+		win   = x.window
+		items = make([]*winItem, len(w.rows))
+		err   error
+	)
+	for i, r := range w.rows {
+		e.row, e.aggs, e.wins = r.row, r.aggs, r.wins
+		it := &winItem{r: r}
+		if it.part, err = evalAll(e, win.partition); err != nil {
+			return err
+		}
+		if it.keys, err = evalKeys(e, win.order); err != nil {
+			return err
+		}
+		if it.args, err = evalAll(e, x.args); err != nil {
+			return err
+		}
+		items[i] = it
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if c := compareRows(items[i].part, items[j].part, win.colls); c != 0 {
+			return c < 0
+		}
+		return sortLess(win.order, items[i].keys, items[j].keys)
+	})
+
+	for beg := 0; beg < len(items); {
+		end := beg + 1
+		for end < len(items) && compareRows(items[beg].part, items[end].part, win.colls) == 0 {
+			end++
+		}
+		p := newPartition(win, x.coll, items[beg:end])
+		vals, err := x.win.values(p)
+		if err != nil {
+			return err
+		}
+		for i, it := range p.items {
+			it.r.wins[x.slot] = vals[i]
+		}
+		beg = end
+	}
+
+	for i, it := range items {
+		w.rows[i] = it.r
+	}
+	return nil
+}
+
+// partition is a partition of the rows of a window, sorted by the
+// ORDER BY terms of the window.
+type partition struct {
+	win    *window
+	coll   collation // collation of the arguments of the call
+	items  []*winItem
+	peers  []int // peer group of each row
+	groups []int // first row of each peer group, followed by the number of rows
+}
+
+func newPartition(win *window, coll collation, items []*winItem) *partition {
+	p := &partition{win: win, coll: coll, items: items, peers: make([]int, len(items))}
+	for i, it := range items {
+		if i == 0 || !samePeers(win.order, items[i-1].keys, it.keys) {
+			p.groups = append(p.groups, i)
+		}
+		p.peers[i] = len(p.groups) - 1
+	}
+	p.groups = append(p.groups, len(items))
+	return p
+}
+
+// samePeers reports whether the rows with the sort keys a and b are
+// peers: rows whose keys are equal.
+func samePeers(order []sortKey, a, b []interface{}) bool {
+	for i, key := range order {
+		if compareCollated(a[i], b[i], key.coll) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// frame returns the rows of the frame of the i-th row, from a to b
+// excluded, before the exclusions of the frame.
+func (p *partition) frame(i int) (a, b int) {
+	a = p.bound(i, p.win.frame.start, true)
+	b = p.bound(i, p.win.frame.end, false)
+	if b < a {
+		b = a
+	}
+	return a, b
+}
+
+// bound returns the first row of the frame of the i-th row for its
+// start bound bd, or the row following its last row for its end bound.
+func (p *partition) bound(i int, bd frameBound, start bool) int {
+	n := len(p.items)
+	switch bd.kind {
+	case frameUnboundedPreceding:
+		return 0
+	case frameUnboundedFollowing:
+		return n
+	}
+
+	var off int64
+	switch {
+	case bd.kind == frameCurrent:
+	case p.win.frame.unit == "RANGE":
+		return p.rangeBound(i, bd, start)
+	default:
+		off = bd.n.(int64)
+		if off > int64(n) {
+			off = int64(n)
+		}
+		if bd.kind == framePreceding {
+			off = -off
+		}
+	}
+
+	if p.win.frame.unit == "ROWS" {
+		k := i + int(off)
+		if !start {
+			k++
+		}
+		return clampRow(k, n)
+	}
+	// GROUPS, or RANGE with a CURRENT ROW bound.
+	g := p.peers[i] + int(off)
+	if !start {
+		g++
+	}
+	return p.groups[clampRow(g, len(p.groups)-1)]
+}
+
+// clampRow returns k, bounded to [0, n].
+func clampRow(k, n int) int {
+	switch {
+	case k < 0:
+		return 0
+	case k > n:
+		return n
+	}
+	return k
+}
+
+// rangeBound returns the bound of the frame of the i-th row for the
+// PRECEDING or FOLLOWING bound bd of a RANGE frame: the rows whose sort
+// key is within the offset of the bound from the key of the row. The
+// frames of rows with a NULL or non numeric key are their peers.
+func (p *partition) rangeBound(i int, bd frameBound, start bool) int {
+	var (
+		key  = p.win.order[0]
+		v    = p.items[i].keys[0]
+		peer = func() int {
+			g := p.peers[i]
+			if !start {
+				g++
+			}
+			return p.groups[g]
+		}
+	)
+	if storageClass(v) != 1 {
+		return peer()
+	}
+
+	// the keys of the rows from lo to hi are not NULL.
+	lo, hi := 0, len(p.items)
+	for lo < hi && p.items[lo].keys[0] == nil {
+		lo++
+	}
+	for hi > lo && p.items[hi-1].keys[0] == nil {
+		hi--
+	}
+
+	op := "+"
+	if (bd.kind == framePreceding) != key.desc {
+		op = "-"
+	}
+	target := arith(op, v, bd.n)
+	return lo + sort.Search(hi-lo, func(j int) bool {
+		c := compareValues(p.items[lo+j].keys[0], target)
+		if key.desc {
+			c = -c
+		}
+		if start {
+			return c >= 0
+		}
+		return c > 0
+	})
+}
+
+// excluded reports whether the j-th row is excluded from the frame of
+// the i-th row.
+func (p *partition) excluded(i, j int) bool {
+	switch p.win.frame.exclude {
+	case "CURRENT ROW":
+		return i == j
+	case "GROUP":
+		return p.peers[i] == p.peers[j]
+	case "TIES":
+		return i != j && p.peers[i] == p.peers[j]
+	}
+	return false
+}
+
+// aggValues returns the values of the aggregate function fn over the
+// frames of the rows of a partition.
+func aggValues(fn *aggFunc) func(p *partition) ([]interface{}, error) {
+	return func(p *partition) ([]interface{}, error) {
+		var (
+			vals  = make([]interface{}, len(p.items))
+			frame = p.win.frame
+		)
+		if frame.start.kind == frameUnboundedPreceding && frame.exclude == "NO OTHERS" {
+			// the frames only grow: add their new rows.
+			agg := fn.new(p.coll)
+			n := 0
+			for i := range p.items {
+				_, b := p.frame(i)
+				for ; n < b; n++ {
+					if err := agg.step(p.items[n].args); err != nil {
+						return nil, err
+					}
+				}
+				v, err := agg.value()
+				if err != nil {
+					return nil, err
+				}
+				vals[i] = v
+			}
+			return vals, nil
+		}
+
+		for i := range p.items {
+			agg := fn.new(p.coll)
+			a, b := p.frame(i)
+			for j := a; j < b; j++ {
+				if p.excluded(i, j) {
+					continue
+				}
+				if err := agg.step(p.items[j].args); err != nil {
+					return nil, err
+				}
+			}
+			v, err := agg.value()
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		return vals, nil
+	}
+}
+
+// rowNumberFunc is the row_number window function: the number of the
+// row in its partition.
+func rowNumberFunc(p *partition) ([]interface{}, error) {
+	vals := make([]interface{}, len(p.items))
+	for i := range vals {
+		vals[i] = int64(i + 1)
+	}
+	return vals, nil
+}
+
+// rankFunc is the rank window function: the row number of the first
+// peer of the row.
+func rankFunc(p *partition) ([]interface{}, error) {
+	vals := make([]interface{}, len(p.items))
+	for i := range vals {
+		vals[i] = int64(p.groups[p.peers[i]] + 1)
+	}
+	return vals, nil
+}
+
+// denseRankFunc is the dense_rank window function: the number of the
+// peer group of the row.
+func denseRankFunc(p *partition) ([]interface{}, error) {
+	vals := make([]interface{}, len(p.items))
+	for i := range vals {
+		vals[i] = int64(p.peers[i] + 1)
+	}
+	return vals, nil
+}
+
+// percentRankFunc is the percent_rank window function: (rank-1)/(n-1),
+// for a partition of n rows.
+func percentRankFunc(p *partition) ([]interface{}, error) {
+	vals := make([]interface{}, len(p.items))
+	for i := range vals {
+		r := 0.0
+		if n := len(p.items); n > 1 {
+			r = float64(p.groups[p.peers[i]]) / float64(n-1)
+		}
+		vals[i] = r
+	}
+	return vals, nil
+}
+
+// cumeDistFunc is the cume_dist window function: the number of rows up
+// to the last peer of the row, divided by the number of rows.
+func cumeDistFunc(p *partition) ([]interface{}, error) {
+	vals := make([]interface{}, len(p.items))
+	for i := range vals {
+		vals[i] = float64(p.groups[p.peers[i]+1]) / float64(len(p.items))
+	}
+	return vals, nil
+}
+
+// ntileFunc is the ntile window function: the number of the group of
+// the row, when the partition is divided into N groups of as equal
+// sizes as possible, the larger ones first.
+func ntileFunc(p *partition) ([]interface{}, error) {
+	var (
+		n    = int64(len(p.items))
+		vals = make([]interface{}, n)
+	)
+	for i, it := range p.items {
+		v := it.args[0]
+		if v == nil {
+			continue
+		}
+		groups, ok := intArg(v)
+		if !ok || groups <= 0 {
+			return nil, errors.New("sqlite3: argument of ntile must be a positive integer")
+		}
+		var (
+			k     = int64(i)
+			size  = n / groups
+			large = n % groups // number of groups of size+1 rows
+		)
+		switch {
+		case size == 0:
+			vals[i] = k + 1
+		case k < large*(size+1):
+			vals[i] = k/(size+1) + 1
+		default:
+			vals[i] = (k-large*(size+1))/size + large + 1
+		}
+	}
+	return vals, nil
+}
+
+// intArg returns the integer value of the argument v, if it is one.
+func intArg(v interface{}) (int64, bool) {
+	switch v := applyAffinity(v, affNumeric).(type) {
+	case int64:
+		return v, true
+	case float64:
+		if i := floatToInt(v); float64(i) == v {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// lagFunc returns the lag window function, for dir -1, or the lead one,
+// for dir 1: the value of the first argument for the row at the offset
+// given by the second argument, 1 by default, before or after the row,
+// or the value of the third argument if there is no such row.
+func lagFunc(dir int64) func(p *partition) ([]interface{}, error) {
+	return func(p *partition) ([]interface{}, error) {
+		vals := make([]interface{}, len(p.items))
+		for i, it := range p.items {
+			off := int64(1)
+			if len(it.args) > 1 {
+				var ok bool
+				if off, ok = intArg(it.args[1]); !ok {
+					continue
+				}
+			}
+			j := int64(i) + dir*off
+			switch {
+			case j >= 0 && j < int64(len(p.items)):
+				vals[i] = p.items[j].args[0]
+			case len(it.args) > 2:
+				vals[i] = it.args[2]
+			}
+		}
+		return vals, nil
+	}
+}
+
+const (
+	firstRow = 1
+	lastRow  = -1
+	nthRow   = 0
+)
+
+// nthValueFunc returns the first_value window function, for firstRow,
+// the last_value one, for lastRow, or the nth_value one, for nthRow:
+// the value of the first argument for the first, last, or N-th row of
+// the frame, given by the second argument.
+func nthValueFunc(which int) func(p *partition) ([]interface{}, error) {
+	return func(p *partition) ([]interface{}, error) {
+		vals := make([]interface{}, len(p.items))
+		for i, it := range p.items {
+			n := int64(which)
+			if which == nthRow {
+				var ok bool
+				n, ok = intArg(it.args[1])
+				if !ok || n <= 0 {
+					return nil, errors.New("sqlite3: second argument to nth_value must be a positive integer")
+				}
+			}
+			a, b := p.frame(i)
+			if n < 0 {
+				for j := b - 1; j >= a; j-- {
+					if !p.excluded(i, j) {
+						vals[i] = p.items[j].args[0]
+						break
+					}
+				}
+				continue
+			}
+			for j := a; j < b; j++ {
+				if p.excluded(i, j) {
+					continue
+				}
+				if n--; n == 0 {
+					vals[i] = p.items[j].args[0]
+					break
+				}
+			}
+		}
+		return vals, nil
+	}
+}
+
+// firstWindow returns the first call of a window function of the
+// expression x, if any.
+func firstWindow(x expr) *funcExpr {
+	var found *funcExpr
+	walkExpr(x, func(x expr) bool {
+		if f, ok := x.(*funcExpr); ok && f.win != nil {
+			found = f
+		}
+		return found == nil
+	})
+	return found
+}
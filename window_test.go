@@ -0,0 +1,206 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryWindow(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `select id, age, row_number() over (order by age, id) from people where id < 8`,
+			want: `1|7|1
+2|14|2
+3|21|3
+4|28|4
+5|35|5
+6|42|6
+7|49|7
+`,
+		},
+		{
+			query: `select id, active, age % 3, rank() over (partition by active order by age % 3), dense_rank() over (partition by active order by age % 3) from people where id < 16 order by active, age % 3, id`,
+			want: `15|0|0|1|1
+10|0|1|2|2
+5|0|2|3|3
+3|1|0|1|1
+6|1|0|1|1
+9|1|0|1|1
+12|1|0|1|1
+1|1|1|5|2
+4|1|1|5|2
+7|1|1|5|2
+13|1|1|5|2
+2|1|2|9|3
+8|1|2|9|3
+11|1|2|9|3
+14|1|2|9|3
+`,
+		},
+		{
+			query: `select id, age, sum(age) over (order by id), sum(age) over (order by id rows between 1 preceding and 1 following) from people where id < 8`,
+			want: `1|7|7|21
+2|14|21|42
+3|21|42|63
+4|28|70|84
+5|35|105|105
+6|42|147|126
+7|49|196|91
+`,
+		},
+		{
+			query: `select id, age, count(*) over (order by age range between 7 preceding and 14 following) from people where id < 12`,
+			want: `1|7|3
+2|14|4
+3|21|4
+4|28|4
+5|35|4
+6|42|4
+7|49|4
+8|56|4
+9|63|4
+10|70|3
+11|77|2
+`,
+		},
+		{
+			query: `select id, age % 4, count(*) over (order by age % 4 groups between 1 preceding and current row exclude ties) from people where id < 10 order by id`,
+			want: `1|3|3
+2|2|3
+3|1|3
+4|0|1
+5|3|3
+6|2|3
+7|1|3
+8|0|1
+9|3|3
+`,
+		},
+		{
+			query: `select id, lag(age) over (order by id), lead(age, 2, -1) over (order by id) from people where id < 8`,
+			want: `1|NULL|21
+2|7|28
+3|14|35
+4|21|42
+5|28|49
+6|35|-1
+7|42|-1
+`,
+		},
+		{
+			query: `select id, first_value(name) over w, last_value(name) over w, nth_value(name, 2) over w from people where id < 15 window w as (partition by city order by age) order by id`,
+			want: `1|Dave1|Bob0|Bob0
+2|eve1|carol0|carol0
+3|Dave0|Dave0|NULL
+4|eve0|eve0|NULL
+5|Frank0|Frank0|NULL
+6|grace0|grace0|NULL
+7|Dave1|Heidi0|Bob0
+8|eve1|ivan0|carol0
+9|Dave0|Judy0|Judy0
+10|eve0|alice1|alice1
+11|Frank0|Bob1|Bob1
+12|grace0|carol1|carol1
+13|Dave1|Dave1|NULL
+14|eve1|eve1|NULL
+`,
+		},
+		{
+			query: `select id, ntile(4) over (order by id), percent_rank() over (order by age % 5), cume_dist() over (order by age % 5) from people where id < 8 order by id`,
+			want: `1|1|0.333333333333333|0.571428571428571
+2|1|0.833333333333333|1.0
+3|2|0.166666666666667|0.285714285714286
+4|2|0.666666666666667|0.714285714285714
+5|3|0.0|0.142857142857143
+6|3|0.333333333333333|0.571428571428571
+7|4|0.833333333333333|1.0
+`,
+		},
+		{
+			query: `select city, count(*), sum(count(*)) over (order by city) from people group by city`,
+			want: `NULL|50|50
+Berlin|50|100
+Lyon|50|150
+Paris|50|200
+Tokyo|50|250
+paris|50|300
+`,
+		},
+		{
+			query: `select id, group_concat(id) over (partition by active order by id desc) from people where id < 12 order by id`,
+			want: `1|11,9,8,7,6,4,3,2,1
+2|11,9,8,7,6,4,3,2
+3|11,9,8,7,6,4,3
+4|11,9,8,7,6,4
+5|10,5
+6|11,9,8,7,6
+7|11,9,8,7
+8|11,9,8
+9|11,9
+10|10
+11|11
+`,
+		},
+		{
+			query: `select id, age, row_number() over (order by age) r from people where id < 10 order by r desc limit 4`,
+			want: `9|63|9
+8|56|8
+7|49|7
+6|42|6
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQueryWindowErrors(t *testing.T) {
+	db, err := Open("testdata/query.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`select row_number() from people`, "misuse of window function row_number()"},
+		{`select id from people where row_number() over () = 1`, "misuse of window function row_number()"},
+		{`select abs(age) over () from people`, "abs() may not be used as a window function"},
+		{`select count(distinct age) over () from people`, "DISTINCT is not supported for window functions"},
+		{`select sum(age) over w from people`, "no such window: w"},
+		{`select sum(age) over (w order by id) from people window w as (order by age)`, "cannot override ORDER BY clause of window: w"},
+		{`select sum(age) over (range 1 preceding) from people`, "RANGE with offset PRECEDING/FOLLOWING requires one ORDER BY expression"},
+		{`select sum(age) over (rows between 1 following and current row) from people`, "unsupported frame specification"},
+		{`select sum(age) over (rows -1 preceding) from people`, "frame starting offset must be a non-negative integer"},
+		{`select ntile(0) over () from people`, "argument of ntile must be a positive integer"},
+		{`select nth_value(age, 0) over () from people`, "second argument to nth_value must be a positive integer"},
+	} {
+		rows, err := db.Query(tc.query)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
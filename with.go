@@ -0,0 +1,356 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// withScope holds the common table expressions of a WITH clause, and
+// the ones of the enclosing WITH clauses. The scope of the SELECT
+// statement of a view has no common table expressions: those of the
+// query reading the view are not visible from the view.
+type withScope struct {
+	outer *withScope
+	ctes  []*derivedTable
+	view  *View // view whose SELECT statement is compiled, if any
+}
+
+func newWithScope(defs []*cteDef, outer *withScope) *withScope {
+	w := &withScope{outer: outer}
+	for _, def := range defs {
+		w.ctes = append(w.ctes, &derivedTable{name: def.name, def: def, with: w})
+	}
+	return w
+}
+
+// lookup returns the innermost common table expression with the given
+// name, or nil.
+func (w *withScope) lookup(name string) *derivedTable {
+	for ; w != nil && w.view == nil; w = w.outer {
+		for _, d := range w.ctes {
+			if strings.EqualFold(d.name, name) {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// expanding reports whether the SELECT statement of the view v is being
+// compiled, in w or in the scopes enclosing it.
+func (w *withScope) expanding(v *View) bool {
+	for ; w != nil; w = w.outer {
+		if w.view == v {
+			return true
+		}
+	}
+	return false
+}
+
+// derivedTable is a table whose rows are the result of a query: a
+// common table expression. Its rows are computed once, when first
+// scanned.
+type derivedTable struct {
+	name string
+	def  *cteDef
+	with *withScope // WITH clause defining the table
+
+	table     *Table     // columns of the table, once compiled
+	affs      []affinity // affinities of the columns
+	plan      queryPlan  // query computing the rows
+	compiling bool       // whether the query of the table is being compiled
+
+	refs    int           // number of references to the table from the recursive SELECT being compiled
+	current []interface{} // row of a recursive table used by its recursive SELECT statements
+
+	rows [][]interface{} // rows of the table, once computed
+	done bool
+}
+
+// derivedSource returns the source of the FROM clause of a query for
+// the table d, compiling it if needed.
+func (db *DbFile) derivedSource(d *derivedTable, name string, off int) (*source, error) {
+	recursive := false
+	switch {
+	case d.compiling && d.table == nil:
+		return nil, fmt.Errorf("sqlite3: circular reference: %s", d.name)
+	case d.compiling:
+		// a reference from a recursive SELECT statement of the table.
+		d.refs++
+		if d.refs > 1 {
+			return nil, fmt.Errorf("sqlite3: multiple references to recursive table: %s", d.name)
+		}
+		recursive = true
+	case d.table == nil:
+		if err := db.compileDerived(d); err != nil {
+			return nil, err
+		}
+	}
+	src := newSource(d.table, name, off)
+	copy(src.affs, d.affs)
+	src.derived, src.recursive = d, recursive
+	return src, nil
+}
+
+// compileDerived compiles the query of the derived table d.
+func (db *DbFile) compileDerived(d *derivedTable) error {
+	d.compiling = true
+	defer func() { d.compiling = false }()
+
+	stmt := d.def.stmt
+	if d.isRecursive() {
+		return db.compileRecursive(d)
+	}
+	plan, err := db.compileQuery(stmt, d.with)
+	if err != nil {
+		return err
+	}
+	return d.init(plan)
+}
+
+// init sets the columns of the derived table d, whose rows are computed
+// by plan or, for a recursive table, by the initial SELECT plan.
+func (d *derivedTable) init(plan queryPlan) error {
+	var (
+		names   = plan.columns()
+		results = plan.results()
+	)
+	if d.def.cols != nil {
+		switch {
+		case len(d.def.cols) == len(names):
+		case d.with.view != nil:
+			return fmt.Errorf("sqlite3: expected %d columns for '%s' but got %d", len(d.def.cols), d.name, len(names))
+		default:
+			return fmt.Errorf("sqlite3: table %s has %d values for %d columns", d.name, len(names), len(d.def.cols))
+		}
+		names = d.def.cols
+	}
+	t := &Table{name: d.name, rowid: -1, withoutRowid: true}
+	for i, name := range names {
+		coll, _ := exprCollation(results[i])
+		t.cols = append(t.cols, Column{name: name, collate: coll})
+		d.affs = append(d.affs, exprAffinity(results[i]))
+	}
+	d.table, d.plan = t, plan
+	return nil
+}
+
+// isRecursive reports whether the query of the derived table d is a
+// compound SELECT referencing the table from its FROM clauses. Like
+// SQLite, the RECURSIVE keyword of the WITH clause is optional. Views
+// are never recursive.
+func (d *derivedTable) isRecursive() bool {
+	stmt := d.def.stmt
+	if len(stmt.compound) == 0 || d.with.view != nil {
+		return false
+	}
+	for _, core := range stmt.cores() {
+		if d.referencedBy(core) {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedBy reports whether the FROM clause of stmt references the
+// derived table d.
+func (d *derivedTable) referencedBy(stmt *selectStmt) bool {
+	for _, ref := range stmt.from {
+		if ref.schema == "" && strings.EqualFold(ref.name, d.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cores returns the SELECT statements of the compound SELECT stmt,
+// without its WITH, ORDER BY and LIMIT clauses.
+func (stmt *selectStmt) cores() []*selectStmt {
+	first := *stmt
+	first.with, first.compound, first.orderBy, first.limit, first.offset = nil, nil, nil, nil, nil
+	cores := []*selectStmt{&first}
+	for _, term := range stmt.compound {
+		cores = append(cores, term.stmt)
+	}
+	return cores
+}
+
+// recursivePlan is the compiled query of a recursive table: its initial
+// SELECT statements, followed by recursive ones, each using one row of
+// the table.
+type recursivePlan struct {
+	d        *derivedTable
+	initial  queryPlan
+	steps    []*selectPlan // recursive SELECT statements
+	distinct bool          // whether the recursive SELECT statements are combined by UNION
+	colls    []collation   // collations of the result columns, for UNION
+
+	slots  []expr // result columns, as references to the slots of the rows
+	order  []sortKey
+	limit  int64 // maximum number of rows, or -1
+	offset int64 // number of rows skipped
+}
+
+// compileRecursive compiles the query of the recursive table d.
+func (db *DbFile) compileRecursive(d *derivedTable) error {
+	var (
+		stmt  = d.def.stmt
+		cores = stmt.cores()
+		first = len(cores) // first recursive SELECT
+	)
+	for i, core := range cores {
+		if d.referencedBy(core) {
+			first = i
+			break
+		}
+	}
+	if first == 0 {
+		return fmt.Errorf("sqlite3: circular reference: %s", d.name)
+	}
+
+	initial := *stmt
+	initial.with, initial.orderBy, initial.limit, initial.offset = nil, nil, nil, nil
+	initial.compound = stmt.compound[:first-1]
+	plan := &recursivePlan{d: d}
+	var err error
+	if plan.initial, err = db.compileQuery(&initial, d.with); err != nil {
+		return err
+	}
+	if err := d.init(plan.initial); err != nil {
+		return err
+	}
+
+	for i, core := range cores[first:] {
+		switch op := stmt.compound[first+i-1].op; op {
+		case "UNION":
+			plan.distinct = true
+		case "UNION ALL":
+		default:
+			return fmt.Errorf("sqlite3: recursive reference in a %s of table %s", op, d.name)
+		}
+		if core.values != nil || !d.referencedBy(core) {
+			return fmt.Errorf("sqlite3: initial SELECT of table %s must precede its recursive SELECT", d.name)
+		}
+		d.refs = 0
+		step, err := db.compileSelect(core, d.with)
+		if err != nil {
+			return err
+		}
+		if len(step.names) != len(d.table.cols) {
+			return errors.New("sqlite3: SELECTs to the left and right of UNION do not have the same number of result columns")
+		}
+		if step.aggregate || len(step.wins) > 0 {
+			return errors.New("sqlite3: recursive aggregate queries not supported")
+		}
+		plan.steps = append(plan.steps, step)
+	}
+
+	results := plan.initial.results()
+	if plan.colls, err = exprCollations(results); err != nil {
+		return err
+	}
+	plan.slots = resultSlots(plan.initial.columns(), results)
+	if plan.order, err = resultOrder(stmt.orderBy, plan.slots); err != nil {
+		return err
+	}
+	if plan.limit, plan.offset, err = limitOffset(stmt); err != nil {
+		return err
+	}
+	d.plan = plan
+	return nil
+}
+
+func (plan *recursivePlan) columns() []string {
+	return plan.initial.columns()
+}
+
+func (plan *recursivePlan) results() []expr {
+	return plan.initial.results()
+}
+
+// run computes the rows of the recursive table: the rows of its initial
+// SELECT statements are added to a queue, and each row extracted from
+// the queue is a row of the table, given to the recursive SELECT
+// statements to compute the rows added next to the queue. The queue is
+// ordered by the ORDER BY clause of the query, if any.
+func (plan *recursivePlan) run(emit func([]interface{}) error) error {
+	var (
+		d     = plan.d
+		queue [][]interface{}
+		keys  [][]interface{} // sort keys of the rows of the queue
+		seen  *rowSet
+		e     = &env{}
+	)
+	if plan.distinct {
+		seen = &rowSet{colls: plan.colls}
+	}
+	push := func(vals []interface{}) error {
+		if seen != nil && !seen.add(vals) {
+			return nil
+		}
+		e.row = vals
+		k, err := evalKeys(e, plan.order)
+		if err != nil {
+			return err
+		}
+		// rows with equal keys are extracted in the order they are added.
+		i := sort.Search(len(queue), func(i int) bool {
+			return sortLess(plan.order, k, keys[i])
+		})
+		queue = append(queue[:i], append([][]interface{}{vals}, queue[i:]...)...)
+		keys = append(keys[:i], append([][]interface{}{k}, keys[i:]...)...)
+		return nil
+	}
+	if err := plan.initial.run(push); err != nil {
+		return err
+	}
+
+	var n, skip int64 = 0, plan.offset
+	for len(queue) > 0 && n != plan.limit {
+		row := queue[0]
+		queue, keys = queue[1:], keys[1:]
+		if skip > 0 {
+			skip--
+		} else {
+			if err := emit(row); err != nil {
+				return err
+			}
+			n++
+		}
+		d.current = row
+		for _, step := range plan.steps {
+			if err := step.run(push); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan calls f with the values of each row of the derived table or, if
+// current is set, with its current row, used by its recursive SELECT
+// statements.
+func (d *derivedTable) scan(current bool, f func(vals []interface{}) error) error {
+	if current {
+		return f(d.current)
+	}
+	if !d.done {
+		rows, err := collectRows(d.plan)
+		if err != nil {
+			return err
+		}
+		d.rows, d.done = rows, true
+	}
+	for _, row := range d.rows {
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 The go-sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryWith(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// expected results are those of SQLite.
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `with recursive cnt(x) as (select 1 union all select x+1 from cnt where x < 5) select x from cnt`,
+			want: `1
+2
+3
+4
+5
+`,
+		},
+		{
+			query: `with recursive chain(id, depth) as (select id, 0 from visits where from_visit = 0 and id < 50 union all select v.id, depth+1 from visits v join chain on v.from_visit = chain.id) select count(*), max(depth) from chain`,
+			want: `50|4
+`,
+		},
+		{
+			query: `with recursive up(id, depth) as (select 1487, 0 union all select from_visit, depth+1 from visits, up where visits.id = up.id and from_visit <> 0) select * from up`,
+			want: `1487|0
+1486|1
+1485|2
+1484|3
+1483|4
+1482|5
+1481|6
+`,
+		},
+		{
+			query: `with recursive t(n) as (select 1 union all select n+1 from t where n < 5 order by 1 desc limit 3) select * from t`,
+			want: `1
+2
+3
+`,
+		},
+		{
+			query: `with recursive t(n) as (select 1 union select n % 3 + 1 from t) select * from t`,
+			want: `1
+2
+3
+`,
+		},
+		{
+			query: `with n as (select url, count(*) c from visits group by url), top as (select * from n where c > 10) select urls.id, top.c from urls join top on top.url = urls.id order by top.c desc, urls.id limit 5`,
+			want: `43|22
+66|16
+85|16
+62|15
+103|15
+`,
+		},
+		{
+			query: `with a as (select * from b), b(x) as (select 1) select x from a`,
+			want: `1
+`,
+		},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQueryWithErrors(t *testing.T) {
+	db, err := Open("testdata/history.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{`with t(a, b) as (select 1) select * from t`, "table t has 1 values for 2 columns"},
+		{`with t as (select * from t) select * from t`, "circular reference: t"},
+		{`with recursive t(n) as (select 1 union all select n+1 from t, t t2 where n < 3) select * from t`, "multiple references to recursive table: t"},
+		{`with recursive t(n) as (select 1 union all select count(*) from t) select * from t`, "recursive aggregate queries not supported"},
+		{`with t as (select 1 as x) select rowid from t`, "no such column: rowid"},
+	} {
+		_, err := db.Query(tc.query)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}